@@ -3,6 +3,9 @@ package main
 import (
 	"backup-helper/internal/config"
 	"flag"
+	"os"
+	"strconv"
+	"strings"
 )
 
 // ParseFlags parses all command line flags and returns a config.Flags struct
@@ -14,11 +17,32 @@ func ParseFlags() *config.Flags {
 	flag.BoolVar(&flags.AutoYes, "yes", false, "Automatically answer 'yes' to all prompts (non-interactive mode)")
 	flag.BoolVar(&flags.DoDownload, "download", false, "Download backup from TCP stream (listen on port)")
 	flag.BoolVar(&flags.DoPrepare, "prepare", false, "Prepare backup for restore (xtrabackup --prepare)")
+	flag.BoolVar(&flags.DoServe, "serve", false, "Run a persistent receiver daemon: accept authenticated streams and save each to <serve-dir>/<instance>/")
+	flag.StringVar(&flags.Ctl, "ctl", "", "Control a running backup/download: status, limit <rate>, pause, resume or cancel")
+	flag.StringVar(&flags.CtlLimit, "ctl-limit", "", "Rate for --ctl limit, e.g. '50MB/s' ('unlimited', 0 or -1 = unlimited); same as --ctl limit <rate>")
+	flag.StringVar(&flags.CtlSocket, "ctl-socket", "", "Control socket of a running backup/download (default: $TMPDIR/backup-helper-<pid>.sock; 'off' disables it)")
 	flag.BoolVar(&flags.DoCheck, "check", false, "Perform pre-flight validation checks (dependencies, MySQL compatibility, system resources, parameter recommendations)")
+	flag.BoolVar(&flags.ReadStdin, "stdin", false, "Download mode: read the stream from stdin instead of TCP (used by --ssh-tunnel)")
 	flag.StringVar(&flags.DownloadOutput, "output", "", "Output file path for download mode (use '-' for stdout, default: backup_YYYYMMDDHHMMSS.xb)")
 	flag.StringVar(&flags.TargetDir, "target-dir", "", "Directory for extraction (download mode) or backup directory (prepare mode)")
 	flag.StringVar(&flags.EstimatedSizeStr, "estimated-size", "", "Estimated backup size with unit (e.g., '100MB', '1GB', '500KB') or bytes (for progress tracking)")
 	flag.StringVar(&flags.IOLimitStr, "io-limit", "", "IO bandwidth limit with unit (e.g., '100MB/s', '1GB/s', '500KB/s') or bytes per second. Use -1 for unlimited speed")
+	flag.BoolVar(&flags.AdaptiveIO, "adaptive-io-limit", false, "Adapt the stream IO limit to MySQL load (Threads_running, InnoDB pending I/O, replica lag) between --io-limit-min and --io-limit-max")
+	flag.StringVar(&flags.IOLimitMinStr, "io-limit-min", "", "Lowest IO limit for --adaptive-io-limit (e.g. '20MB/s', default: a tenth of --io-limit-max)")
+	flag.StringVar(&flags.IOLimitMaxStr, "io-limit-max", "", "Highest IO limit for --adaptive-io-limit (e.g. '500MB/s', default: --io-limit)")
+	flag.StringVar(&flags.IOSchedule, "io-limit-schedule", "", "Time-of-day IO limits, e.g. 'mon-fri 08:00-20:00=50MB/s; 20:00-08:00=500MB/s' (--io-limit applies outside all windows)")
+	flag.StringVar(&flags.DiskIOLimitStr, "disk-io-limit", "", "Limit xtrabackup reads from the datadir disk (e.g. '100MB/s'), independent of --io-limit")
+	flag.StringVar(&flags.DiskIOMethod, "disk-io-method", "", "How --disk-io-limit is applied: auto (default, cgroup if available else throttle), cgroup (cgroup v2 io.max) or throttle (xtrabackup --throttle)")
+	flag.StringVar(&flags.ProgressFormat, "progress-format", "", "Progress output: text (default) or json (newline-delimited events: phases, xtrabackup stages, progress, summary)")
+	flag.IntVar(&flags.ProgressFd, "progress-fd", 0, "File descriptor for --progress-format=json events (default: 2, stderr)")
+	flag.StringVar(&flags.MetricsAddr, "metrics-addr", "", "Serve Prometheus metrics on http://<addr>/metrics while the command runs (e.g. ':9701')")
+	flag.StringVar(&flags.MetricsTextfile, "metrics-textfile", "", "Write Prometheus metrics to this node_exporter textfile-collector file (*.prom) when the command ends")
+	flag.StringVar(&flags.Notify, "notify", "", "Comma-separated notifiers fired when the command ends: an http(s) URL (JSON webhook), dingtalk:<url>, slack:<url> or command:<command>")
+	flag.StringVar(&flags.NotifyOn, "notify-on", "", "When notifiers fire: failure (default), success or always")
+	flag.Func("hook", "Lifecycle hook <point>=<command> (repeatable); points: pre-backup, post-backup, on-failure, pre-prepare, post-prepare, post-download", func(spec string) error {
+		flags.Hooks = append(flags.Hooks, spec)
+		return nil
+	})
 	flag.StringVar(&flags.UseMemory, "use-memory", "", "Memory to use for prepare operation (e.g., '1G', '512M'). Default: 1G")
 	flag.StringVar(&flags.XtrabackupPath, "xtrabackup-path", "", "Path to xtrabackup binary or directory containing xtrabackup/xbstream (overrides config and environment variable)")
 	flag.StringVar(&flags.DefaultsFile, "defaults-file", "", "Path to MySQL configuration file (my.cnf). If not specified, --defaults-file will not be passed to xtrabackup")
@@ -36,14 +60,76 @@ func ParseFlags() *config.Flags {
 	flag.StringVar(&flags.CompressType, "compress", "__NOT_SET__", "Compression: qp(qpress)/zstd/no, or no value (default: qp). Priority is higher than config file")
 	flag.StringVar(&flags.LangFlag, "lang", "", "Language: zh (Chinese) or en (English), auto-detect if unset")
 	flag.StringVar(&flags.AIDiagnoseFlag, "ai-diagnose", "", "AI diagnosis on backup failure: on/off. If not set, prompt interactively.")
+	flag.StringVar(&flags.AIProvider, "ai-provider", "", "AI diagnosis provider: dashscope, openai (any OpenAI-compatible API) or rules (offline known-error rules only)")
+	flag.StringVar(&flags.AIBaseURL, "ai-base-url", "", "Base URL of an OpenAI-compatible API for AI diagnosis (e.g. 'http://127.0.0.1:11434/v1')")
+	flag.StringVar(&flags.AIModel, "ai-model", "", "Model used for AI diagnosis (default for dashscope: qwen-max-latest)")
+	flag.IntVar(&flags.AITimeout, "ai-timeout", 0, "Timeout of an AI diagnosis request in seconds (default: 60)")
+	flag.IntVar(&flags.AIMaxTokens, "ai-max-tokens", 0, "Maximum length of the AI diagnosis in tokens (default: 1024)")
+	flag.BoolVar(&flags.AIRedactHosts, "ai-redact-hosts", false, "Also mask IP addresses, host names, the OSS endpoint and bucket in the log sent for AI diagnosis (credentials are always masked)")
+	flag.StringVar(&flags.AIRulesFile, "ai-rules-file", "", "JSON file with known error rules added to the built-in table (rules with the same id replace built-in ones)")
+	flag.BoolVar(&flags.AIPreview, "ai-preview", false, "On failure, print the redacted log excerpt AI diagnosis would send, without sending it, and run the offline diagnosis instead (implies --ai-diagnose=on)")
 	flag.BoolVar(&flags.EnableHandshake, "enable-handshake", false, "Enable handshake for TCP streaming (default: false, can be set in config)")
 	flag.StringVar(&flags.StreamKey, "stream-key", "", "Handshake key for TCP streaming (default: empty, can be set in config)")
+	flag.BoolVar(&flags.StreamTLS, "tls", false, "Enable TLS for TCP streaming (listening side is TLS server, connecting side is TLS client)")
+	flag.StringVar(&flags.TLSCert, "tls-cert", "", "TLS certificate file (PEM). Required on the listening side; used as client certificate for mutual TLS on the connecting side")
+	flag.StringVar(&flags.TLSKey, "tls-key", "", "TLS private key file (PEM) for --tls-cert")
+	flag.StringVar(&flags.TLSCA, "tls-ca", "", "TLS CA bundle (PEM) to verify the peer certificate (default: system roots on the connecting side)")
+	flag.StringVar(&flags.TLSServerName, "tls-server-name", "", "Expected server name in the server certificate (default: --stream-host)")
+	flag.BoolVar(&flags.TLSClientAuth, "tls-client-auth", false, "Require clients to present a certificate signed by --tls-ca (mutual TLS, listening side)")
+	flag.BoolVar(&flags.Framed, "framed", false, "Use the framed stream protocol with an end-of-stream trailer (byte count, SHA-256, backup exit status); must be set on both sides")
+	flag.IntVar(&flags.Connections, "stream-connections", 0, "Number of parallel TCP connections for streaming (default: 1); must be the same on both sides")
+	flag.StringVar(&flags.Sinks, "sinks", "", "Fan-out: comma-separated sinks that all receive the same backup, e.g. 'oss,stream://10.0.0.2:9999,file:///backup/' (overrides --mode)")
+	flag.StringVar(&flags.SinkFailure, "sink-failure", "", "Fan-out failure policy: abort (default, one failing sink aborts all) or continue (mark the sink failed, keep the others)")
+	flag.StringVar(&flags.Instance, "instance", "", "Instance name announced to a --serve receiver (default: <hostname>_<mysql port>)")
+	flag.StringVar(&flags.ServeDir, "serve-dir", "", "Root directory for backups received with --serve (default: current directory)")
+	flag.IntVar(&flags.MaxSessions, "max-sessions", 0, "Maximum concurrent sessions with --serve (default: 4)")
 	flag.IntVar(&flags.Timeout, "timeout", 0, "TCP connection timeout in seconds for listening (default: 60, max: 3600)")
 	flag.BoolVar(&flags.UseSSH, "ssh", false, "Use SSH to start receiver on remote host (requires --stream-host)")
 	flag.StringVar(&flags.RemoteOutput, "remote-output", "", "Remote output path when using SSH mode (default: auto-generated)")
+	flag.IntVar(&flags.SSHPort, "ssh-port", 0, "SSH port for --ssh (default: from ssh config, usually 22)")
+	flag.StringVar(&flags.SSHUser, "ssh-user", "", "SSH login user for --ssh (default: from ssh config)")
+	flag.StringVar(&flags.SSHIdentity, "ssh-identity", "", "SSH private key file for --ssh (default: from ssh config)")
+	flag.StringVar(&flags.SSHRemoteBinary, "ssh-remote-binary", "", "Path of backup-helper on the remote host for --ssh (default: backup-helper in PATH)")
+	flag.BoolVar(&flags.SSHTunnel, "ssh-tunnel", false, "With --ssh, send the stream through the SSH connection (remote receiver stdin) instead of a separate TCP port")
+	flag.StringVar(&flags.SSHRemoteArgs, "ssh-remote-args", "", "Extra flags for the remote receiver started by --ssh, e.g. '--target-dir /data/restore'")
 	flag.IntVar(&flags.Parallel, "parallel", 0, "Number of parallel threads for xtrabackup (default: 4)")
 	flag.StringVar(&flags.LogFileName, "log-file", "", "Custom log file name (relative to logDir or absolute path). If not specified, auto-generates backup-helper-{timestamp}.log")
+	flag.StringVar(&flags.LogFormat, "log-format", "", "Log file format: text (default) or json (one object per line with time, level, module, runId, instance, msg and fields)")
+	flag.IntVar(&flags.LogKeep, "log-keep", 0, "Logs of successful runs to keep in logDir (default: 10, -1 = no limit); logs of failed runs are kept 3 times as long unless logRetention.keepFailed is set")
+	flag.IntVar(&flags.LogMaxAgeDays, "log-max-age", 0, "Remove logs of successful runs older than this many days (failed runs: 3 times as long unless logRetention.failedMaxAgeDays is set)")
+	flag.StringVar(&flags.LogMaxSize, "log-max-size", "", "Total size of the logs kept in logDir (e.g. '1GB'); the oldest logs of successful runs are removed first")
 
-	flag.Parse()
+	// Positional arguments (e.g. the rate of --ctl limit 50MB/s) may be followed by flags
+	// and may be negative numbers, which the flag package would take for flags
+	flagArgs, positional := splitArgs(flag.CommandLine, os.Args[1:])
+	flag.CommandLine.Parse(flagArgs)
+	flags.CtlArgs = append(positional, flag.Args()...)
 	return flags
 }
+
+// splitArgs separates positional arguments from flags. A value following a non-boolean
+// flag without "=" belongs to that flag; everything after "--" is positional.
+func splitArgs(fs *flag.FlagSet, args []string) (flagArgs, positional []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return flagArgs, append(positional, args[i+1:]...)
+		}
+		if _, err := strconv.ParseFloat(arg, 64); err == nil || !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+		flagArgs = append(flagArgs, arg)
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") || i+1 == len(args) {
+			continue
+		}
+		if f := fs.Lookup(name); f != nil {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				i++
+				flagArgs = append(flagArgs, args[i])
+			}
+		}
+	}
+	return flagArgs, positional
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("ctl", "", "")
+	fs.String("ctl-socket", "", "")
+	fs.String("io-limit", "", "")
+	fs.Bool("y", false, "")
+
+	tests := []struct {
+		args           []string
+		wantFlags      []string
+		wantPositional []string
+	}{
+		{[]string{"--ctl", "limit", "-1"}, []string{"--ctl", "limit"}, []string{"-1"}},
+		{[]string{"--ctl", "limit", "20MB/s", "--ctl-socket", "/tmp/x.sock"},
+			[]string{"--ctl", "limit", "--ctl-socket", "/tmp/x.sock"}, []string{"20MB/s"}},
+		{[]string{"--ctl=limit", "-y", "0"}, []string{"--ctl=limit", "-y"}, []string{"0"}},
+		{[]string{"--io-limit", "-1", "--ctl", "status"}, []string{"--io-limit", "-1", "--ctl", "status"}, nil},
+		{[]string{"--ctl", "limit", "--", "--odd"}, []string{"--ctl", "limit"}, []string{"--odd"}},
+		{[]string{"--unknown", "value"}, []string{"--unknown"}, []string{"value"}},
+	}
+	for _, tt := range tests {
+		flags, positional := splitArgs(fs, tt.args)
+		if !reflect.DeepEqual(flags, tt.wantFlags) || !reflect.DeepEqual(positional, tt.wantPositional) {
+			t.Errorf("splitArgs(%q) = %q, %q; want %q, %q", tt.args, flags, positional, tt.wantFlags, tt.wantPositional)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"backup-helper/internal/ai"
 	"backup-helper/internal/cmd"
 	"backup-helper/internal/config"
 	"backup-helper/internal/utils"
@@ -40,7 +41,32 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Progress output format (text lines or JSON events)
+	if err := cmd.SetupProgressOutput(cfg, effective, flags); err != nil {
+		i18nlib.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Log file format (text lines or JSON lines)
+	if err := cmd.SetupLogging(cfg); err != nil {
+		i18nlib.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Known error rules (built-in table plus ai.rulesFile)
+	if err := ai.LoadRules(cfg.AI.RulesFile); err != nil {
+		i18nlib.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Route to appropriate command handler
+	if flags.Ctl != "" {
+		if err := cmd.HandleCtl(cfg, flags); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
 	if flags.DoCheck {
 		cmd.HandleCheck(cfg, effective, flags)
 		return
@@ -53,6 +79,13 @@ func main() {
 		return
 	}
 
+	if flags.DoServe {
+		if err := cmd.HandleServe(cfg, effective, flags); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
 	if flags.DoDownload {
 		if err := cmd.HandleDownload(cfg, effective, flags); err != nil {
 			os.Exit(1)
@@ -76,6 +109,6 @@ func main() {
 	}
 
 	// If no command specified, just exit
-	i18nlib.Printf("No command specified. Use --backup, --download, --prepare, or --check\n")
+	i18nlib.Printf("No command specified. Use --backup, --download, --serve, --prepare, --check, or --ctl\n")
 	os.Exit(0)
 }
@@ -2,44 +2,80 @@ package ai
 
 import (
 	"backup-helper/internal/config"
-	"context"
 	"errors"
+	"fmt"
 
 	"github.com/gioco-play/easy-i18n/i18n"
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
 )
 
-// DiagnoseWithAliQwen call qwen-max-latest model to diagnose the log content
+// Diagnosis is the result of Diagnose
+type Diagnosis struct {
+	Text     string
+	Source   string   // The model that answered, or the versions of the offline rules
+	Offline  bool     // Text comes from the offline rules for known error signatures
+	Rules    []string // IDs of the offline rules that matched
+	LLMError error    // Why a configured LLM was not used (nil when it answered or none is configured)
+}
+
+// Payload is what a diagnosis sends to the AI service
+type Payload struct {
+	Destination  string // The model and host, empty when no AI service is configured
+	SystemPrompt string
+	Content      string // The redacted log excerpt
+}
+
+// NewPayload selects the relevant part of the log (ExtractErrorSummary) and redacts credentials,
+// keys and, with ai.redactHosts, host names and IP addresses before anything leaves the machine
+func NewPayload(cfg *config.Config, module string, logContent string) *Payload {
+	payload := &Payload{
+		SystemPrompt: getDiagnosisPrompt(module),
+		Content:      redact(cfg, excerpt(module, logContent), cfg.AI.RedactHosts),
+	}
+	if p := newProvider(cfg); p != nil {
+		payload.Destination = p.describe()
+	}
+	return payload
+}
+
+// Diagnose asks the configured LLM for a diagnosis of the log content, sending only the
+// redacted excerpt of NewPayload. Without an LLM, or when it cannot be reached, it falls back
+// to the offline rules for known error signatures.
 // module: module type (BACKUP, PREPARE, TCP, OSS, DECOMPRESS, EXTRACT, XBSTREAM)
 // logContent: log content to diagnose
-func DiagnoseWithAliQwen(cfg *config.Config, module string, logContent string) (string, error) {
-	if cfg.QwenAPIKey == "" {
-		return "", errors.New("DashScope API Key is not set in config")
+func Diagnose(cfg *config.Config, module string, logContent string) (*Diagnosis, error) {
+	var llmErr error
+	if p := newProvider(cfg); p != nil {
+		payload := NewPayload(cfg, module, logContent)
+		text, err := p.complete(payload.SystemPrompt, payload.Content)
+		if err == nil {
+			return &Diagnosis{Text: text, Source: p.describe()}, nil
+		}
+		llmErr = fmt.Errorf("%s: %v", p.describe(), err)
 	}
-	client := openai.NewClient(
-		option.WithAPIKey(cfg.QwenAPIKey),
-		option.WithBaseURL("https://dashscope.aliyuncs.com/compatible-mode/v1/"),
-	)
-
-	// Get module-specific prompt
-	prompt := getDiagnosisPrompt(module)
-
-	chatCompletion, err := client.Chat.Completions.New(
-		context.TODO(), openai.ChatCompletionNewParams{
-			Messages: openai.F(
-				[]openai.ChatCompletionMessageParamUnion{
-					openai.SystemMessage(prompt),
-					openai.UserMessage(logContent),
-				},
-			),
-			Model: openai.F("qwen-max-latest"),
-		},
-	)
+
+	diagnosis, err := DiagnoseOffline(logContent)
 	if err != nil {
-		return "", err
+		if llmErr != nil {
+			return nil, llmErr
+		}
+		return nil, errors.New("no AI provider is configured and the log matches no known error signature")
+	}
+	diagnosis.LLMError = llmErr
+	return diagnosis, nil
+}
+
+// DiagnoseOffline matches the log content against the rules for known error signatures only
+func DiagnoseOffline(logContent string) (*Diagnosis, error) {
+	matches := matchRules(logContent)
+	if len(matches) == 0 {
+		return nil, errors.New("the log matches no known error signature")
+	}
+	_, source := activeRules()
+	diagnosis := &Diagnosis{Text: formatRuleMatches(matches), Source: source, Offline: true}
+	for _, m := range matches {
+		diagnosis.Rules = append(diagnosis.Rules, m.rule.id)
 	}
-	return chatCompletion.Choices[0].Message.Content, nil
+	return diagnosis, nil
 }
 
 // getDiagnosisPrompt returns module-specific diagnosis prompt
@@ -0,0 +1,126 @@
+package ai
+
+import (
+	"backup-helper/internal/config"
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// DashScope defaults, used when only an API key (ai.apiKey or qwenAPIKey) is configured
+const (
+	dashScopeBaseURL = "https://dashscope.aliyuncs.com/compatible-mode/v1/"
+	dashScopeModel   = "qwen-max-latest"
+)
+
+// Request defaults (AIConfig.Timeout, AIConfig.MaxTokens)
+const (
+	defaultTimeout   = 60 * time.Second
+	defaultMaxTokens = 1024
+)
+
+// provider is an OpenAI-compatible chat completion API
+type provider struct {
+	baseURL   string
+	apiKey    string
+	model     string
+	timeout   time.Duration
+	maxTokens int
+}
+
+// newProvider returns the configured LLM, or nil when the diagnosis uses the offline rules only
+func newProvider(cfg *config.Config) *provider {
+	a := cfg.AI
+	name := a.Provider
+	if name == "" {
+		switch {
+		case a.BaseURL != "":
+			name = config.AIProviderOpenAI
+		case a.APIKey != "" || cfg.QwenAPIKey != "":
+			name = config.AIProviderDashScope
+		default:
+			name = config.AIProviderRules
+		}
+	}
+
+	p := &provider{baseURL: a.BaseURL, apiKey: a.APIKey, model: a.Model, timeout: defaultTimeout, maxTokens: defaultMaxTokens}
+	switch name {
+	case config.AIProviderRules:
+		return nil
+	case config.AIProviderDashScope:
+		if p.apiKey == "" {
+			p.apiKey = cfg.QwenAPIKey
+		}
+		if p.apiKey == "" {
+			return nil
+		}
+		if p.baseURL == "" {
+			p.baseURL = dashScopeBaseURL
+		}
+		if p.model == "" {
+			p.model = dashScopeModel
+		}
+	}
+	if a.Timeout > 0 {
+		p.timeout = time.Duration(a.Timeout) * time.Second
+	}
+	if a.MaxTokens > 0 {
+		p.maxTokens = a.MaxTokens
+	}
+	return p
+}
+
+// describe names the provider for messages, without credentials
+func (p *provider) describe() string {
+	if u, err := url.Parse(p.baseURL); err == nil && u.Host != "" {
+		return p.model + " @ " + u.Host
+	}
+	return p.model
+}
+
+// complete sends the system prompt and the log to the model and returns its answer
+func (p *provider) complete(systemPrompt, content string) (string, error) {
+	// Request paths are resolved against the base URL, which therefore needs its trailing slash
+	baseURL := p.baseURL
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	opts := []option.RequestOption{
+		option.WithBaseURL(baseURL),
+		option.WithMaxRetries(1),
+	}
+	if p.apiKey != "" {
+		opts = append(opts, option.WithAPIKey(p.apiKey))
+	} else {
+		// Local servers need no key; never send OPENAI_API_KEY from the environment to them
+		opts = append(opts, option.WithHeaderDel("Authorization"))
+	}
+	client := openai.NewClient(opts...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	chatCompletion, err := client.Chat.Completions.New(
+		ctx, openai.ChatCompletionNewParams{
+			Messages: openai.F(
+				[]openai.ChatCompletionMessageParamUnion{
+					openai.SystemMessage(systemPrompt),
+					openai.UserMessage(content),
+				},
+			),
+			Model:     openai.F(p.model),
+			MaxTokens: openai.F(int64(p.maxTokens)),
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	if len(chatCompletion.Choices) == 0 || chatCompletion.Choices[0].Message.Content == "" {
+		return "", errors.New("the model returned an empty answer")
+	}
+	return chatCompletion.Choices[0].Message.Content, nil
+}
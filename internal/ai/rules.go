@@ -0,0 +1,231 @@
+package ai
+
+import (
+	"backup-helper/internal/log"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RulesVersion identifies the built-in rule table; it changes whenever rules are added or changed
+const RulesVersion = "2026.10"
+
+// maxRuleMatches limits how many known problems one offline diagnosis reports
+const maxRuleMatches = 3
+
+// rule maps a known error signature in the log to its cause and remediation
+type rule struct {
+	id      string
+	pattern *regexp.Regexp
+	cause   string
+	fix     string
+}
+
+// ruleMatch is a rule that matched, with the first log line that matched it
+type ruleMatch struct {
+	rule rule
+	line string
+}
+
+// builtinRules are checked in order; the more specific signatures come first
+var builtinRules = []rule{
+	{
+		id:      "ftwrl-timeout",
+		pattern: regexp.MustCompile(`(?i)(lock wait timeout exceeded|unable to obtain lock|ftwrl-wait-timeout|waiting for flush tables with read lock.*time)`),
+		cause:   "xtrabackup could not get FLUSH TABLES WITH READ LOCK (or the backup lock) in time; long-running queries or transactions block it",
+		fix:     "Find the blocking sessions in SHOW PROCESSLIST and wait for or kill them; run the backup at a quieter time, or let xtrabackup wait/kill with --ftwrl-wait-timeout, --ftwrl-wait-threshold and --kill-long-queries-timeout",
+	},
+	{
+		id:      "too-many-open-files",
+		pattern: regexp.MustCompile(`(?i)(too many open files|errno: 24\b|os error number 24)`),
+		cause:   "The process ran out of file descriptors (many tables or partitions)",
+		fix:     "Raise the open files limit of the user running backup-helper (ulimit -n, LimitNOFILE for systemd units) to at least the number of .ibd files plus a margin",
+	},
+	{
+		id:      "redo-log-format",
+		pattern: regexp.MustCompile(`(?i)(unsupported redo log format|redo log format .*(not supported|unsupported)|unknown redo log format|this version of percona xtrabackup can only perform backups)`),
+		cause:   "This xtrabackup version cannot read the redo log of the MySQL server",
+		fix:     "Use the Percona XtraBackup series that matches the server (2.4 for MySQL 5.7, 8.0.x at least as new as the MySQL 8.0 server, 8.4 for 8.4) and point --xtrabackup-path at it",
+	},
+	{
+		id:      "no-space",
+		pattern: regexp.MustCompile(`(?i)(no space left on device|errno: 28\b|os error number 28|disk full)`),
+		cause:   "A file system ran out of space",
+		fix:     "Free space or choose another location: the target directory for extraction (about 1x the data size, plus the uncompressed size for qpress), the xtrabackup temporary directory, or the log directory",
+	},
+	{
+		id:      "oss-clock-skew",
+		pattern: regexp.MustCompile(`RequestTimeTooSkewed`),
+		cause:   "OSS rejected the request because the local clock differs too much from the server time",
+		fix:     "Synchronize the clock of this host (chrony/ntpd, timedatectl set-ntp true) and run the upload again",
+	},
+	{
+		id:      "oss-credentials",
+		pattern: regexp.MustCompile(`(InvalidAccessKeyId|SignatureDoesNotMatch|AccessDenied|NoSuchBucket)`),
+		cause:   "OSS rejected the credentials, the permissions or the bucket",
+		fix:     "Check accessKeyId/accessKeySecret, the bucket name and region endpoint, and that the RAM policy allows PutObject and multipart uploads on the bucket",
+	},
+	{
+		id:      "access-denied",
+		pattern: regexp.MustCompile(`(?i)access denied for user`),
+		cause:   "MySQL refused the login or a statement of the backup user",
+		fix:     "Check the password and grant the backup user RELOAD, PROCESS, LOCK TABLES, REPLICATION CLIENT (and BACKUP_ADMIN on MySQL 8.0)",
+	},
+	{
+		id:      "mysql-connect",
+		pattern: regexp.MustCompile(`(?i)can't connect to (local )?mysql server`),
+		cause:   "xtrabackup could not connect to MySQL",
+		fix:     "Check host, port or socket and that mysqld is running; with a local socket, pass --defaults-file or the socket path xtrabackup should use",
+	},
+	{
+		id:      "zstd-corrupt",
+		pattern: regexp.MustCompile(`(?i)(zstd: .*(corrupt|unknown frame descriptor|unsupported format|truncated)|data corruption detected)`),
+		cause:   "zstd could not decompress the data: the input is truncated, corrupt or not zstd-compressed",
+		fix:     "Make sure --compress matches how the backup was taken, and that the transfer completed (use --framed to detect truncated streams)",
+	},
+	{
+		id:      "xbstream-corrupt",
+		pattern: regexp.MustCompile(`(?i)(wrong chunk magic|xbstream: .*(corrupt|failed to read|incorrect chunk))`),
+		cause:   "xbstream could not parse the stream: it is truncated, corrupt, or still compressed",
+		fix:     "Check that the stream was decompressed with the right --compress setting and that the transfer completed; re-transfer the backup if needed",
+	},
+	{
+		id:      "out-of-memory",
+		pattern: regexp.MustCompile(`(?i)(cannot allocate memory|out of memory|failed to allocate)`),
+		cause:   "The system could not provide the memory xtrabackup asked for",
+		fix:     "Lower --use-memory for prepare, or free memory on the host",
+	},
+	{
+		id:      "permission-denied",
+		pattern: regexp.MustCompile(`(?i)permission denied`),
+		cause:   "A file or directory could not be accessed",
+		fix:     "Run backup-helper as a user that can read the MySQL datadir (backup) or write the target and log directories (download, prepare)",
+	},
+	{
+		id:      "connection-lost",
+		pattern: regexp.MustCompile(`(?i)(connection reset by peer|broken pipe|i/o timeout|connection refused)`),
+		cause:   "The network connection failed or was interrupted",
+		fix:     "Check that the receiver is listening and reachable (firewall, port), and the network stability; retry, or lower --io-limit on congested links",
+	},
+}
+
+// rulesFile is a user-supplied rules file (ai.rulesFile). Its rules are checked before the
+// built-in ones and replace built-in rules with the same ID.
+type rulesFile struct {
+	Version string `json:"version"` // Free-form version of the file, shown with its matches
+	Rules   []struct {
+		ID      string `json:"id"`
+		Pattern string `json:"pattern"` // Go regular expression matched against each log line
+		Cause   string `json:"cause"`
+		Fix     string `json:"fix"`
+	} `json:"rules"`
+}
+
+var (
+	rulesMu     sync.Mutex
+	customRules []rule
+	rulesSource = "built-in rules " + RulesVersion
+)
+
+// LoadRules adds the rules of a user-supplied rules file to the built-in table (empty path: built-in only)
+func LoadRules(path string) error {
+	var custom []rule
+	source := "built-in rules " + RulesVersion
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("cannot read rules file: %v", err)
+		}
+		var file rulesFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("invalid rules file %s: %v", path, err)
+		}
+		seen := map[string]bool{}
+		for i, r := range file.Rules {
+			if r.ID == "" || r.Pattern == "" || r.Cause == "" || r.Fix == "" {
+				return fmt.Errorf("rules file %s: rule %d: id, pattern, cause and fix are required", path, i+1)
+			}
+			if seen[r.ID] {
+				return fmt.Errorf("rules file %s: duplicate rule id %q", path, r.ID)
+			}
+			seen[r.ID] = true
+			pattern, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return fmt.Errorf("rules file %s: rule %q: invalid pattern: %v", path, r.ID, err)
+			}
+			custom = append(custom, rule{id: r.ID, pattern: pattern, cause: r.Cause, fix: r.Fix})
+		}
+		source += " + " + path
+		if file.Version != "" {
+			source += " " + file.Version
+		}
+	}
+
+	rulesMu.Lock()
+	customRules = custom
+	rulesSource = source
+	rulesMu.Unlock()
+	return nil
+}
+
+// activeRules returns the user-supplied rules followed by the built-in rules they do not replace,
+// and the versions of both
+func activeRules() ([]rule, string) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	list := append([]rule{}, customRules...)
+	replaced := map[string]bool{}
+	for _, r := range customRules {
+		replaced[r.id] = true
+	}
+	for _, r := range builtinRules {
+		if !replaced[r.id] {
+			list = append(list, r)
+		}
+	}
+	return list, rulesSource
+}
+
+// reportLineRe matches the log lines of backup-helper's own reports on a failure: notifications,
+// hooks (whose output may be anything) and diagnoses. They quote errors rather than cause them.
+var reportLineRe = regexp.MustCompile(`^\[[^\]]*\] \[(NOTIFY|HOOK|AI|DIAGNOSIS)\] `)
+
+// matchRules returns the rules whose signature appears in the log, with the first matching line each;
+// report lines are skipped
+func matchRules(logContent string) []ruleMatch {
+	rules, _ := activeRules()
+	var lines []string
+	for _, line := range log.TextLines(logContent) {
+		if !reportLineRe.MatchString(line) {
+			lines = append(lines, line)
+		}
+	}
+	var matches []ruleMatch
+	for _, r := range rules {
+		for _, line := range lines {
+			if r.pattern.MatchString(line) {
+				matches = append(matches, ruleMatch{rule: r, line: strings.TrimSpace(line)})
+				break
+			}
+		}
+		if len(matches) == maxRuleMatches {
+			break
+		}
+	}
+	return matches
+}
+
+// formatRuleMatches renders matches in the same ERROR/CAUSE/FIX layout the LLM is asked for
+func formatRuleMatches(matches []ruleMatch) string {
+	var b strings.Builder
+	for i, m := range matches {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "ERROR: %s\nCAUSE: %s\nFIX: %s\n", m.line, m.rule.cause, m.rule.fix)
+	}
+	return b.String()
+}
@@ -0,0 +1,117 @@
+package ai
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func ruleIDs(matches []ruleMatch) []string {
+	var ids []string
+	for _, m := range matches {
+		ids = append(ids, m.rule.id)
+	}
+	return ids
+}
+
+func TestMatchRules(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		want []string
+	}{
+		{"none", "[2025-01-01 00:00:00] [BACKUP] Backup completed successfully", nil},
+		{
+			"xtrabackup output",
+			"[2025-01-01 00:00:00] [BACKUP] Starting xtrabackup backup\n" +
+				"2025-01-01T00:00:01.000000+08:00 0 [ERROR] [MY-012592] [InnoDB] Operating system error number 24 in a file operation.\n" +
+				"2025-01-01T00:00:01.000000+08:00 0 [ERROR] [MY-012596] [InnoDB] Error number 24 means 'Too many open files'",
+			[]string{"too-many-open-files"},
+		},
+		{
+			"order of the rule table",
+			"[2025-01-01 00:00:00] [TCP] Failed to connect to 10.0.0.2:9999: connection refused\n" +
+				"[2025-01-01 00:00:00] [BACKUP] xtrabackup: Error: write to stdout: no space left on device",
+			[]string{"no-space", "connection-lost"},
+		},
+		{
+			"at most maxRuleMatches",
+			"Too many open files\nno space left on device\nRequestTimeTooSkewed\nAccess denied for user 'bk'@'localhost'",
+			[]string{"too-many-open-files", "no-space", "oss-clock-skew"},
+		},
+		{
+			"reports of the failure are skipped",
+			"[2025-01-01 00:00:00] [NOTIFY] webhook hooks.example.com notification failed: dial tcp: connection refused\n" +
+				"[2025-01-01 00:00:00] [HOOK] mount: /backup: permission denied\n" +
+				"[2025-01-01 00:00:00] [AI] Diagnosis failed: Access denied for user\n" +
+				"[2025-01-01 00:00:00] [DIAGNOSIS] Known error signatures: no-space (built-in rules)\n" +
+				"[2025-01-01 00:00:00] [OSS] Failed to upload part 3: RequestTimeTooSkewed",
+			[]string{"oss-clock-skew"},
+		},
+		{
+			"JSON log",
+			`{"time":"2025-01-01T00:00:00Z","level":"info","module":"NOTIFY","runId":"r","msg":"command notification failed: broken pipe"}` + "\n" +
+				`{"time":"2025-01-01T00:00:00Z","level":"error","module":"XTRABACKUP","runId":"r","msg":"xtrabackup: error: Can't connect to MySQL server on 'db1'"}`,
+			[]string{"mysql-connect"},
+		},
+	}
+	for _, tt := range tests {
+		if got := ruleIDs(matchRules(tt.log)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: matchRules = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	t.Cleanup(func() { LoadRules("") })
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	errTests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"invalid.json", `{"rules": [`, "invalid rules file"},
+		{"missing.json", `{"rules": [{"id": "x", "pattern": "y", "cause": "z"}]}`, "id, pattern, cause and fix are required"},
+		{"duplicate.json", `{"rules": [{"id": "x", "pattern": "a", "cause": "c", "fix": "f"}, {"id": "x", "pattern": "b", "cause": "c", "fix": "f"}]}`, `duplicate rule id "x"`},
+		{"pattern.json", `{"rules": [{"id": "x", "pattern": "(", "cause": "c", "fix": "f"}]}`, "invalid pattern"},
+	}
+	for _, tt := range errTests {
+		err := LoadRules(write(tt.name, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("LoadRules(%s) error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+	if err := LoadRules(filepath.Join(dir, "absent.json")); err == nil {
+		t.Error("LoadRules of a missing file succeeded")
+	}
+
+	// Custom rules come first and replace the built-in rule with the same ID
+	path := write("rules.json", `{"version": "ops-3", "rules": [
+		{"id": "ceph-full", "pattern": "(?i)ceph.*full", "cause": "The Ceph pool is full", "fix": "Expand the pool"},
+		{"id": "no-space", "pattern": "quota exceeded", "cause": "Quota", "fix": "Raise the quota"}
+	]}`)
+	if err := LoadRules(path); err != nil {
+		t.Fatalf("LoadRules: %v", err)
+	}
+	_, source := activeRules()
+	if want := "built-in rules " + RulesVersion + " + " + path + " ops-3"; source != want {
+		t.Errorf("rules source = %q, want %q", source, want)
+	}
+	logContent := "no space left on device\nCEPH pool is FULL\nDisk quota exceeded"
+	if got, want := ruleIDs(matchRules(logContent)), []string{"ceph-full", "no-space"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("matchRules with custom rules = %v, want %v", got, want)
+	}
+	if m := matchRules(logContent); len(m) == 2 && m[1].line != "Disk quota exceeded" {
+		t.Errorf("no-space matched %q, want the custom pattern to replace the built-in one", m[1].line)
+	}
+}
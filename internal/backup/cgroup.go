@@ -0,0 +1,240 @@
+package backup
+
+import (
+	"backup-helper/internal/log"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// cgroupRoot is where the unified (v2) cgroup hierarchy is mounted
+	cgroupRoot = "/sys/fs/cgroup"
+	// DefaultCgroupParent holds the per-backup cgroups when diskIOCgroup is not set
+	DefaultCgroupParent = cgroupRoot + "/backup-helper"
+	// cgroupPrefix names the per-backup cgroups so stale ones can be removed
+	cgroupPrefix = "xtrabackup-"
+)
+
+// CheckCgroupIO reports whether a cgroup with io.max limits can be created under parent.
+// It does not change anything; a nil error means delegation is available.
+func CheckCgroupIO(parent string) error {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("cgroup v2 is not mounted at %s (cgroup v1 or hybrid mode)", cgroupRoot)
+	}
+	if !hasController(filepath.Join(cgroupRoot, "cgroup.controllers"), "io") {
+		return fmt.Errorf("the io controller is not available in cgroup v2 (it may still be bound to cgroup v1 blkio)")
+	}
+	if _, err := os.Stat(parent); err == nil {
+		// An existing parent must offer the io controller and let us create children
+		if !hasController(filepath.Join(parent, "cgroup.controllers"), "io") {
+			return fmt.Errorf("the io controller is not delegated to %s (enable +io in the subtree_control of its parent)", parent)
+		}
+		if syscall.Access(parent, 2) != nil { // W_OK
+			return fmt.Errorf("no permission to create cgroups under %s", parent)
+		}
+		return nil
+	}
+	// The parent is created on first use: its own parent must allow that and offer io
+	grandparent := filepath.Dir(parent)
+	if syscall.Access(grandparent, 2) != nil {
+		return fmt.Errorf("no permission to create %s", parent)
+	}
+	if !hasController(filepath.Join(grandparent, "cgroup.subtree_control"), "io") && syscall.Access(filepath.Join(grandparent, "cgroup.subtree_control"), 2) != nil {
+		return fmt.Errorf("the io controller is not enabled in %s/cgroup.subtree_control and cannot be enabled", grandparent)
+	}
+	return nil
+}
+
+// BlockDevice returns "MAJ:MIN" of the whole disk that holds path, as io.max expects it
+// (a partition is resolved to its disk; device-mapper and md devices are used as they are)
+func BlockDevice(path string) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return "", err
+	}
+	dev := uint64(st.Dev)
+	major := (dev >> 8) & 0xfff
+	minor := (dev & 0xff) | ((dev >> 12) & 0xfff00)
+	if major == 0 {
+		return "", fmt.Errorf("%s is not on a block device (tmpfs, overlay or network filesystem)", path)
+	}
+	device := fmt.Sprintf("%d:%d", major, minor)
+	sysDir, err := filepath.EvalSymlinks(filepath.Join("/sys/dev/block", device))
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve block device %s: %v", device, err)
+	}
+	if _, err := os.Stat(filepath.Join(sysDir, "partition")); err == nil {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(sysDir), "dev"))
+		if err != nil {
+			return "", fmt.Errorf("cannot find the disk of partition %s: %v", device, err)
+		}
+		device = strings.TrimSpace(string(data))
+	}
+	return device, nil
+}
+
+// IOCgroup is a cgroup v2 that limits the disk reads of the processes put into it
+type IOCgroup struct {
+	dir    string
+	direct bool // Processes can be started inside the cgroup (see Prepare)
+	logCtx *log.LogContext
+}
+
+// NewIOCgroup creates a cgroup under parent limiting reads from device ("MAJ:MIN") to rbps bytes/s
+func NewIOCgroup(parent, device string, rbps int64, logCtx *log.LogContext) (*IOCgroup, error) {
+	if err := CheckCgroupIO(parent); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(parent); err != nil {
+		if err := enableController(filepath.Dir(parent), "io"); err != nil {
+			return nil, err
+		}
+		if err := os.Mkdir(parent, 0755); err != nil && !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create cgroup %s: %v", parent, err)
+		}
+	}
+	// Children of a parent cgroup only get io.max once the parent enables the controller
+	if err := enableController(parent, "io"); err != nil {
+		return nil, err
+	}
+	removeStaleCgroups(parent)
+
+	dir := filepath.Join(parent, fmt.Sprintf("%s%d", cgroupPrefix, os.Getpid()))
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("failed to create cgroup %s: %v", dir, err)
+	}
+	limit := fmt.Sprintf("%s rbps=%d", device, rbps)
+	if err := os.WriteFile(filepath.Join(dir, "io.max"), []byte(limit), 0644); err != nil {
+		os.Remove(dir)
+		return nil, fmt.Errorf("failed to set io.max \"%s\" in %s: %v", limit, dir, err)
+	}
+	if logCtx != nil {
+		logCtx.WriteLog("BACKUP", "Created cgroup %s with io.max \"%s\"", dir, limit)
+	}
+	cg := &IOCgroup{dir: dir, logCtx: logCtx}
+	if err := cg.probeDirectStart(); err != nil {
+		if logCtx != nil {
+			logCtx.WriteLogLevel(log.LevelWarn, "BACKUP", nil, "Processes cannot be started inside cgroup %s (%v), moving them in right after they start", dir, err)
+		}
+	} else {
+		cg.direct = true
+	}
+	return cg, nil
+}
+
+// probeDirectStart checks that processes can be started inside the cgroup (clone3 with
+// CLONE_INTO_CGROUP, Linux 5.7+) by running this program with --version in it
+func (c *IOCgroup) probeDirectStart() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Open(c.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	cmd := exec.Command(exe, "--version")
+	cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(dir.Fd())}
+	return cmd.Run()
+}
+
+// Prepare makes cmd start inside the cgroup, so that none of its reads escape the limit.
+// The returned function must be called after cmd.Start, whether it succeeded or not: it releases
+// the cgroup directory or, where the kernel cannot start a process inside a cgroup, moves the
+// started process into it.
+func (c *IOCgroup) Prepare(cmd *exec.Cmd) func() error {
+	if c.direct {
+		if dir, err := os.Open(c.dir); err == nil {
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+			cmd.SysProcAttr.UseCgroupFD = true
+			cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+			return func() error {
+				dir.Close()
+				if cmd.Process != nil && c.logCtx != nil {
+					c.logCtx.WriteLog("BACKUP", "Started PID %d inside cgroup %s", cmd.Process.Pid, c.dir)
+				}
+				return nil
+			}
+		}
+	}
+	return func() error {
+		if cmd.Process == nil {
+			return nil
+		}
+		return c.Add(cmd.Process.Pid)
+	}
+}
+
+// Add moves a running process into the cgroup
+func (c *IOCgroup) Add(pid int) error {
+	if err := os.WriteFile(filepath.Join(c.dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("failed to move PID %d into cgroup %s: %v", pid, c.dir, err)
+	}
+	if c.logCtx != nil {
+		c.logCtx.WriteLog("BACKUP", "Moved PID %d into cgroup %s", pid, c.dir)
+	}
+	return nil
+}
+
+// RemoveWhenEmpty removes the cgroup in the background once its processes have exited.
+// A cgroup left behind because backup-helper exited first is removed by the next backup.
+func (c *IOCgroup) RemoveWhenEmpty() {
+	go func() {
+		for {
+			data, err := os.ReadFile(filepath.Join(c.dir, "cgroup.events"))
+			if err != nil {
+				return
+			}
+			if strings.Contains(string(data), "populated 0") {
+				os.Remove(c.dir)
+				return
+			}
+			time.Sleep(time.Second)
+		}
+	}()
+}
+
+// removeStaleCgroups removes the empty cgroups of earlier backups
+func removeStaleCgroups(parent string) {
+	entries, _ := os.ReadDir(parent)
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), cgroupPrefix) {
+			os.Remove(filepath.Join(parent, e.Name())) // Fails (and is kept) while processes remain
+		}
+	}
+}
+
+// enableController enables controller for the children of the cgroup dir
+func enableController(dir, controller string) error {
+	control := filepath.Join(dir, "cgroup.subtree_control")
+	if hasController(control, controller) {
+		return nil
+	}
+	if err := os.WriteFile(control, []byte("+"+controller), 0644); err != nil {
+		return fmt.Errorf("failed to enable the %s controller in %s: %v", controller, control, err)
+	}
+	return nil
+}
+
+// hasController reports whether a cgroup.controllers or cgroup.subtree_control file lists controller
+func hasController(file, controller string) bool {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	for _, c := range strings.Fields(string(data)) {
+		if c == controller {
+			return true
+		}
+	}
+	return false
+}
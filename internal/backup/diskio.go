@@ -0,0 +1,112 @@
+package backup
+
+import (
+	"backup-helper/internal/config"
+	"backup-helper/internal/log"
+	"backup-helper/internal/mysql"
+	"backup-helper/internal/utils"
+	"database/sql"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/gioco-play/easy-i18n/i18n"
+)
+
+// Disk IO limit methods (diskIOMethod)
+const (
+	DiskIOMethodAuto     = "auto"     // cgroup when delegation is available, otherwise throttle
+	DiskIOMethodCgroup   = "cgroup"   // cgroup v2 io.max read limit on the datadir device
+	DiskIOMethodThrottle = "throttle" // xtrabackup --throttle
+)
+
+// xtrabackupThrottleChunk is the unit of xtrabackup --throttle: chunks of 10MB per second
+const xtrabackupThrottleChunk = 10 * 1024 * 1024
+
+// CgroupParent returns the cgroup under which xtrabackup cgroups are created (diskIOCgroup,
+// relative paths are below /sys/fs/cgroup)
+func CgroupParent(cfg *config.Config) string {
+	switch {
+	case cfg.DiskIOCgroup == "":
+		return DefaultCgroupParent
+	case filepath.IsAbs(cfg.DiskIOCgroup):
+		return cfg.DiskIOCgroup
+	}
+	return filepath.Join(cgroupRoot, cfg.DiskIOCgroup)
+}
+
+// setupDiskIOLimit prepares the diskIOLimit of xtrabackup: a cgroup to move the process into,
+// or extra xtrabackup arguments. Both are empty when no disk IO limit is set.
+func setupDiskIOLimit(cfg *config.Config, db *sql.DB, logCtx *log.LogContext) (*IOCgroup, []string, error) {
+	if cfg.DiskIOLimit <= 0 {
+		return nil, nil, nil
+	}
+	method := cfg.DiskIOMethod
+	if method == "" {
+		method = DiskIOMethodAuto
+	}
+	if method != DiskIOMethodAuto && method != DiskIOMethodCgroup && method != DiskIOMethodThrottle {
+		return nil, nil, fmt.Errorf("invalid disk IO method '%s' (use auto, cgroup or throttle)", method)
+	}
+
+	if method != DiskIOMethodThrottle {
+		cg, device, err := newDatadirCgroup(cfg, db, logCtx)
+		if err == nil {
+			i18n.Printf("[backup-helper] Disk read limit: %s/s on device %s (cgroup %s)\n", utils.FormatBytes(cfg.DiskIOLimit), device, cg.dir)
+			return cg, nil, nil
+		}
+		logCtx.WriteLog("BACKUP", "cgroup disk IO limit unavailable: %v", err)
+		if method == DiskIOMethodCgroup {
+			return nil, nil, fmt.Errorf("cgroup disk IO limit unavailable: %v", err)
+		}
+		i18n.Printf("Warning: cgroup disk IO limit unavailable (%v), using xtrabackup --throttle\n", err)
+	}
+
+	// Round down so that the limit is not exceeded; below one chunk --throttle cannot honour it
+	chunks := cfg.DiskIOLimit / xtrabackupThrottleChunk
+	if chunks == 0 {
+		if method == DiskIOMethodThrottle {
+			return nil, nil, fmt.Errorf("disk IO limit %s/s is below the 10MB/s that xtrabackup --throttle can enforce; use a higher limit or --disk-io-method=cgroup", utils.FormatBytes(cfg.DiskIOLimit))
+		}
+		chunks = 1
+		i18n.Printf("Warning: xtrabackup --throttle cannot limit reads below 10MB/s, disk reads are limited to 10MB/s instead of %s/s\n", utils.FormatBytes(cfg.DiskIOLimit))
+		logCtx.WriteLog("BACKUP", "Disk IO limit %d bytes/s is below one --throttle chunk, applying 10MB/s", cfg.DiskIOLimit)
+	}
+	i18n.Printf("[backup-helper] Disk read limit: about %s/s with xtrabackup --throttle=%d (10MB chunks per second)\n",
+		utils.FormatBytes(chunks*xtrabackupThrottleChunk), chunks)
+	logCtx.WriteLog("BACKUP", "Disk IO limit %d bytes/s applied as xtrabackup --throttle=%d", cfg.DiskIOLimit, chunks)
+	return nil, []string{fmt.Sprintf("--throttle=%d", chunks)}, nil
+}
+
+// newDatadirCgroup creates the cgroup limiting reads from the disk holding the MySQL datadir
+func newDatadirCgroup(cfg *config.Config, db *sql.DB, logCtx *log.LogContext) (*IOCgroup, string, error) {
+	if db == nil {
+		return nil, "", fmt.Errorf("the datadir device needs a MySQL connection")
+	}
+	datadir, err := mysql.GetDatadirFromMySQL(db)
+	if err != nil {
+		return nil, "", err
+	}
+	device, err := BlockDevice(datadir)
+	if err != nil {
+		return nil, "", err
+	}
+	cg, err := NewIOCgroup(CgroupParent(cfg), device, cfg.DiskIOLimit, logCtx)
+	return cg, device, err
+}
+
+// startInIOCgroup makes the xtrabackup command start inside its disk IO cgroup, if any.
+// The returned function must be called after cmd.Start, whether it succeeded or not.
+func startInIOCgroup(cg *IOCgroup, cmd *exec.Cmd, logCtx *log.LogContext) func() {
+	if cg == nil {
+		return func() {}
+	}
+	started := cg.Prepare(cmd)
+	return func() {
+		if err := started(); err != nil {
+			logCtx.WriteLog("BACKUP", "Disk IO limit not applied: %v", err)
+			i18n.Printf("Warning: disk IO limit not applied: %v\n", err)
+		}
+		cg.RemoveWhenEmpty()
+	}
+}
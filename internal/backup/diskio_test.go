@@ -0,0 +1,49 @@
+package backup
+
+import (
+	"backup-helper/internal/config"
+	"backup-helper/internal/log"
+	"reflect"
+	"testing"
+)
+
+func TestSetupDiskIOLimitThrottle(t *testing.T) {
+	logCtx, err := log.NewLogContext(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logCtx.Close()
+
+	const mb = 1024 * 1024
+	tests := []struct {
+		name    string
+		method  string
+		limit   int64
+		want    []string
+		wantErr bool
+	}{
+		{"no limit", DiskIOMethodThrottle, 0, nil, false},
+		{"exact chunks", DiskIOMethodThrottle, 30 * mb, []string{"--throttle=3"}, false},
+		{"rounded down", DiskIOMethodThrottle, 15 * mb, []string{"--throttle=1"}, false},
+		{"below one chunk", DiskIOMethodThrottle, 1 * mb, nil, true},
+		// Without a MySQL connection auto cannot use a cgroup and falls back to throttle
+		{"auto fallback below one chunk", DiskIOMethodAuto, 1 * mb, []string{"--throttle=1"}, false},
+		{"cgroup unavailable", DiskIOMethodCgroup, 30 * mb, nil, true},
+		{"unknown method", "fast", 30 * mb, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{DiskIOLimit: tt.limit, DiskIOMethod: tt.method}
+			cg, args, err := setupDiskIOLimit(cfg, nil, logCtx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if cg != nil {
+				t.Error("unexpected cgroup")
+			}
+			if !reflect.DeepEqual(args, tt.want) {
+				t.Errorf("args = %q, want %q", args, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package backup
 import (
 	"backup-helper/internal/config"
 	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
 	"backup-helper/internal/utils"
 	"database/sql"
 	"fmt"
@@ -14,10 +15,11 @@ import (
 	"github.com/gioco-play/easy-i18n/i18n"
 )
 
-// RunXtraBackup calls xtrabackup, returns backup data io.Reader, cmd and error
+// RunXtraBackup calls xtrabackup, returns backup data io.Reader, the Run that parses the
+// xtrabackup output and decides success (see Run.Wait), and error
 // db is used to get MySQL config file path and must be a valid MySQL connection
 // logCtx is used to write logs for backup operations
-func RunXtraBackup(cfg *config.Config, db *sql.DB, logCtx *log.LogContext) (io.Reader, *exec.Cmd, error) {
+func RunXtraBackup(cfg *config.Config, db *sql.DB, logCtx *log.LogContext) (io.Reader, *Run, error) {
 	if logCtx == nil {
 		return nil, nil, fmt.Errorf("log context is required")
 	}
@@ -68,6 +70,13 @@ func RunXtraBackup(cfg *config.Config, db *sql.DB, logCtx *log.LogContext) (io.R
 	}
 	args = append(args, fmt.Sprintf("--parallel=%d", parallel))
 
+	// Disk read limit: a cgroup for the xtrabackup process, or its --throttle option
+	ioCgroup, throttleArgs, err := setupDiskIOLimit(cfg, db, logCtx)
+	if err != nil {
+		return nil, nil, err
+	}
+	args = append(args, throttleArgs...)
+
 	// Set ulimit for file descriptors (655360)
 	// Set the limit for current process, child processes will inherit
 	var rlimit syscall.Rlimit
@@ -102,40 +111,67 @@ func RunXtraBackup(cfg *config.Config, db *sql.DB, logCtx *log.LogContext) (io.R
 		xtrabackupCmd := exec.Command(xtrabackupPath, args...)
 		zstdCmd := exec.Command("zstd", "-q", fmt.Sprintf("-T%d", parallel), "-")
 
-		xtrabackupCmd.Stderr = logCtx.GetFile()
-		zstdCmd.Stderr = logCtx.GetFile()
+		parser := newOutputParser(logCtx.CommandWriter("XTRABACKUP"), logCtx)
+		xtrabackupCmd.Stderr = parser
+		zstdCmd.Stderr = logCtx.CommandWriter("ZSTD")
 
-		// Connect pipe
+		// Connect pipe through this process, counting the uncompressed bytes for progress
 		pipe, err := xtrabackupCmd.StdoutPipe()
 		if err != nil {
-			logCtx.WriteLog("BACKUP", "Failed to create pipe: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Failed to create pipe: %v", err)
+			return nil, nil, err
+		}
+		zstdIn, err := zstdCmd.StdinPipe()
+		if err != nil {
+			logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Failed to create pipe: %v", err)
 			return nil, nil, err
 		}
-		zstdCmd.Stdin = pipe
+		run := &Run{Raw: &progress.RawCounter{}, xtrabackup: xtrabackupCmd, parser: parser, logCtx: logCtx, xtrabackupDone: make(chan struct{})}
 
 		// Use zstd command as the main command
 		cmd = zstdCmd
+		run.cmd = cmd
 		cmd.ExtraFiles = append(cmd.ExtraFiles, xtrabackupCmd.ExtraFiles...)
 		cmd.Env = append(cmd.Env, xtrabackupCmd.Env...)
 
 		// Start xtrabackup
-		if err := xtrabackupCmd.Start(); err != nil {
-			logCtx.WriteLog("BACKUP", "Failed to start xtrabackup: %v", err)
+		started := startInIOCgroup(ioCgroup, xtrabackupCmd, logCtx)
+		err = xtrabackupCmd.Start()
+		started()
+		if err != nil {
+			logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Failed to start xtrabackup: %v", err)
 			return nil, nil, err
 		}
 
+		// From here on a failure must not leave xtrabackup running
+		stopXtrabackup := func() {
+			xtrabackupCmd.Process.Kill()
+			xtrabackupCmd.Wait()
+		}
 		stdout, err := cmd.StdoutPipe()
 		if err != nil {
-			logCtx.WriteLog("BACKUP", "Failed to create stdout pipe: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Failed to create stdout pipe: %v", err)
+			stopXtrabackup()
 			return nil, nil, err
 		}
 
 		if err := cmd.Start(); err != nil {
-			logCtx.WriteLog("BACKUP", "Failed to start zstd: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Failed to start zstd: %v", err)
+			stopXtrabackup()
 			return nil, nil, err
 		}
+		go func() {
+			if _, err := io.Copy(zstdIn, run.Raw.Reader(pipe)); err != nil {
+				logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Pipe from xtrabackup to zstd failed: %v", err)
+				pipe.Close() // xtrabackup fails on its next write instead of blocking
+			}
+			// zstd ends only after xtrabackup has exited and its stderr is in the log
+			run.xtrabackupErr = xtrabackupCmd.Wait()
+			close(run.xtrabackupDone)
+			zstdIn.Close()
+		}()
 		logCtx.WriteLog("BACKUP", "xtrabackup and zstd processes started successfully")
-		return stdout, cmd, nil
+		return stdout, run, nil
 	}
 
 	// Non-zstd branch, always assign cmd
@@ -156,20 +192,24 @@ func RunXtraBackup(cfg *config.Config, db *sql.DB, logCtx *log.LogContext) (io.R
 		logCtx.WriteLog("BACKUP", "No compression")
 	}
 	logCtx.WriteLog("BACKUP", "Command: %s", cmdStr)
-	cmd.Stderr = logCtx.GetFile()
+	parser := newOutputParser(logCtx.CommandWriter("XTRABACKUP"), logCtx)
+	cmd.Stderr = parser
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		logCtx.WriteLog("BACKUP", "Failed to create stdout pipe: %v", err)
+		logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Failed to create stdout pipe: %v", err)
 		return nil, nil, err
 	}
 
-	if err := cmd.Start(); err != nil {
-		logCtx.WriteLog("BACKUP", "Failed to start xtrabackup: %v", err)
+	started := startInIOCgroup(ioCgroup, cmd, logCtx)
+	err = cmd.Start()
+	started()
+	if err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Failed to start xtrabackup: %v", err)
 		return nil, nil, err
 	}
 	logCtx.WriteLog("BACKUP", "xtrabackup process started successfully")
-	return stdout, cmd, nil
+	return stdout, &Run{cmd: cmd, parser: parser, logCtx: logCtx}, nil
 }
 
 // RunXtrabackupPrepare executes xtrabackup --prepare on a backup directory
@@ -241,11 +281,11 @@ func RunXtrabackupPrepare(cfg *config.Config, targetDir string, db *sql.DB, logC
 	logCtx.WriteLog("PREPARE", "Starting xtrabackup prepare")
 	logCtx.WriteLog("PREPARE", "Target directory: %s", targetDir)
 	logCtx.WriteLog("PREPARE", "Command: %s", cmdStr)
-	cmd.Stderr = logCtx.GetFile()
-	cmd.Stdout = logCtx.GetFile()
+	cmd.Stderr = logCtx.CommandWriter("XTRABACKUP")
+	cmd.Stdout = logCtx.CommandWriter("XTRABACKUP")
 
 	if err := cmd.Start(); err != nil {
-		logCtx.WriteLog("PREPARE", "Failed to start xtrabackup prepare: %v", err)
+		logCtx.WriteLogLevel(log.LevelError, "PREPARE", nil, "Failed to start xtrabackup prepare: %v", err)
 		return nil, err
 	}
 	logCtx.WriteLog("PREPARE", "xtrabackup prepare process started successfully")
@@ -0,0 +1,246 @@
+package backup
+
+import (
+	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lock types reported in XtrabackupState.LockType
+const (
+	LockFTWRL      = "ftwrl"       // FLUSH TABLES WITH READ LOCK
+	LockBackupLock = "backup_lock" // LOCK TABLES FOR BACKUP (Percona Server backup locks)
+)
+
+// maxStateMessages bounds the warnings and errors kept in XtrabackupState
+const maxStateMessages = 20
+
+// xtrabackupStages maps xtrabackup log messages to the stage they start, in backup order
+var xtrabackupStages = []struct {
+	pattern string
+	stage   string
+}{
+	{"Connecting to MySQL server", "connecting"},
+	{"Executing LOCK INSTANCE FOR BACKUP", "ddl_lock"},
+	{"Generating a list of tablespaces", "scanning_tablespaces"},
+	{"Starting to parse redo log", "redo_log_copying"},
+	{"log scanned up to", "redo_log_copying"},
+	{"Copying ./", "copying_innodb"},
+	{"Executing LOCK TABLES FOR BACKUP", "backup_lock"},
+	{"Acquiring BACKUP LOCKS", "backup_lock"},
+	{"Executing FLUSH TABLES WITH READ LOCK", "ftwrl"},
+	{"Starting to backup non-InnoDB tables and files", "copying_non_innodb"},
+	{"Executing FLUSH NO_WRITE_TO_BINLOG BINARY LOGS", "flushing_binlogs"},
+	{"Executing UNLOCK TABLES", "unlocking"},
+	{"Writing xtrabackup_info", "finalizing"},
+	{"completed OK!", "completed"},
+}
+
+// lockAcquiredRe matches the lines xtrabackup prints once its blocking lock statement has returned:
+// an explicit "acquired" or "... done", or the first step that runs under the lock. Lines of the
+// redo log and copy threads, which keep running while the lock is requested, do not match.
+var lockAcquiredRe = regexp.MustCompile(`(?i)\block(s)? acquired\b|` +
+	`(FLUSH TABLES WITH READ LOCK|LOCK TABLES FOR BACKUP|BACKUP LOCKS)\W*done\b|` +
+	`Starting to backup non-InnoDB tables and files|` +
+	`Executing LOCK BINLOG FOR BACKUP|` +
+	`Executing FLUSH NO_WRITE_TO_BINLOG BINARY LOGS`)
+
+var (
+	lsnScannedRe  = regexp.MustCompile(`log scanned up to \((\d+)\)`)
+	lsnStartRe    = regexp.MustCompile(`Starting to parse redo log at lsn = (\d+)`)
+	lsnCopiedRe   = regexp.MustCompile(`Transaction log of lsn \((\d+)\) to \((\d+)\) was copied`)
+	binlogPosRe   = regexp.MustCompile(`MySQL binlog position: (.+)$`)
+	copyingFileRe = regexp.MustCompile(`(^|\] |\s)Copying \S+ to `)
+)
+
+// XtrabackupState is what has been learned from the xtrabackup output
+type XtrabackupState struct {
+	Stage          string // Last stage reached (see xtrabackupStages)
+	Completed      bool   // xtrabackup reported "completed OK!"
+	FilesCopied    int    // Files xtrabackup started to copy
+	FromLSN        uint64 // Redo log copy start
+	ToLSN          uint64 // Redo log copy end (or the latest scanned LSN while running)
+	BinlogPosition string // As reported by xtrabackup, e.g. "filename 'binlog.000003', position '157'"
+
+	LockType      string // LockFTWRL or LockBackupLock; empty if no blocking lock was taken
+	LockRequested time.Time
+	LockAcquired  time.Time
+	LockReleased  time.Time
+
+	Warnings     []string // Latest warnings (at most maxStateMessages)
+	Errors       []string // Latest errors (at most maxStateMessages)
+	WarningCount int
+	ErrorCount   int
+}
+
+// LockWait returns how long xtrabackup waited for its blocking lock
+func (s XtrabackupState) LockWait() time.Duration {
+	if s.LockAcquired.IsZero() {
+		return 0
+	}
+	return s.LockAcquired.Sub(s.LockRequested)
+}
+
+// LockHeld returns how long the blocking lock was held
+func (s XtrabackupState) LockHeld() time.Duration {
+	if s.LockAcquired.IsZero() || s.LockReleased.IsZero() {
+		return 0
+	}
+	return s.LockReleased.Sub(s.LockAcquired)
+}
+
+// outputParser passes xtrabackup stderr on to the log line by line and keeps an XtrabackupState.
+// Stages and lock changes are reported as progress events and log entries.
+type outputParser struct {
+	w      io.Writer
+	logCtx *log.LogContext
+
+	mu    sync.Mutex
+	line  []byte
+	seen  map[string]bool
+	state XtrabackupState
+}
+
+func newOutputParser(w io.Writer, logCtx *log.LogContext) *outputParser {
+	return &outputParser{w: w, logCtx: logCtx, seen: map[string]bool{}}
+}
+
+// Write writes complete lines to the log, so entries of other modules never land inside one
+func (p *outputParser) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.line = append(p.line, data...)
+	for {
+		i := bytes.IndexByte(p.line, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := p.w.Write(p.line[:i+1]); err != nil {
+			return 0, err
+		}
+		p.parse(string(p.line[:i]))
+		p.line = p.line[i+1:]
+	}
+	return len(data), nil
+}
+
+// finish writes and parses a last line without newline; called once xtrabackup has exited
+func (p *outputParser) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.line) > 0 {
+		p.w.Write(append(p.line, '\n'))
+		p.parse(string(p.line))
+		p.line = nil
+	}
+}
+
+// State returns a copy of the state parsed so far
+func (p *outputParser) State() XtrabackupState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.state
+	s.Warnings = append([]string(nil), s.Warnings...)
+	s.Errors = append([]string(nil), s.Errors...)
+	return s
+}
+
+func (p *outputParser) parse(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	now := time.Now()
+	s := &p.state
+
+	switch {
+	case strings.Contains(line, "Executing FLUSH TABLES WITH READ LOCK"):
+		p.requestLock(LockFTWRL, now)
+	case strings.Contains(line, "Executing LOCK TABLES FOR BACKUP"), strings.Contains(line, "Acquiring BACKUP LOCKS"):
+		p.requestLock(LockBackupLock, now)
+	case strings.Contains(line, "Executing UNLOCK TABLES"), strings.Contains(line, "All tables unlocked"):
+		if !s.LockAcquired.IsZero() && s.LockReleased.IsZero() {
+			s.LockReleased = now
+			p.logCtx.WriteLog("BACKUP", "xtrabackup %s released, held for %s", lockName(s.LockType), s.LockHeld().Round(time.Millisecond))
+			progress.Stage("lock_released", fmt.Sprintf("%s held for %s", lockName(s.LockType), s.LockHeld().Round(time.Millisecond)))
+		}
+	case strings.Contains(line, "completed OK!"):
+		s.Completed = true
+	}
+	// A lock request line may itself report the lock as acquired ("...done")
+	if !s.LockRequested.IsZero() && s.LockAcquired.IsZero() && lockAcquiredRe.MatchString(line) {
+		s.LockAcquired = now
+		p.logCtx.WriteLog("BACKUP", "xtrabackup %s acquired after %s", lockName(s.LockType), s.LockWait().Round(time.Millisecond))
+		progress.Stage("lock_acquired", fmt.Sprintf("%s acquired after %s", lockName(s.LockType), s.LockWait().Round(time.Millisecond)))
+	}
+
+	if m := lsnStartRe.FindStringSubmatch(line); m != nil && s.FromLSN == 0 {
+		s.FromLSN, _ = strconv.ParseUint(m[1], 10, 64)
+	}
+	if m := lsnScannedRe.FindStringSubmatch(line); m != nil {
+		s.ToLSN, _ = strconv.ParseUint(m[1], 10, 64)
+	}
+	if m := lsnCopiedRe.FindStringSubmatch(line); m != nil {
+		s.FromLSN, _ = strconv.ParseUint(m[1], 10, 64)
+		s.ToLSN, _ = strconv.ParseUint(m[2], 10, 64)
+		p.logCtx.WriteLog("BACKUP", "xtrabackup copied redo log from LSN %d to %d", s.FromLSN, s.ToLSN)
+	}
+	if m := binlogPosRe.FindStringSubmatch(line); m != nil {
+		s.BinlogPosition = strings.TrimSpace(m[1])
+	}
+	if copyingFileRe.MatchString(line) && !strings.Contains(line, "Done: Copying") {
+		s.FilesCopied++
+	}
+
+	lower := strings.ToLower(line)
+	switch {
+	case strings.Contains(line, "[ERROR]") || strings.Contains(lower, "xtrabackup: error") || strings.Contains(line, "[FATAL]"):
+		s.ErrorCount++
+		s.Errors = appendBounded(s.Errors, line)
+	case strings.Contains(line, "[Warning]") || strings.Contains(lower, "xtrabackup: warning"):
+		s.WarningCount++
+		s.Warnings = appendBounded(s.Warnings, line)
+	}
+
+	for _, st := range xtrabackupStages {
+		if strings.Contains(line, st.pattern) {
+			if !p.seen[st.stage] {
+				p.seen[st.stage] = true
+				s.Stage = st.stage
+				progress.Stage(st.stage, line)
+			}
+			break
+		}
+	}
+}
+
+func (p *outputParser) requestLock(lockType string, now time.Time) {
+	s := &p.state
+	if !s.LockRequested.IsZero() {
+		return // Only the first blocking lock is timed
+	}
+	s.LockType = lockType
+	s.LockRequested = now
+}
+
+func lockName(lockType string) string {
+	if lockType == LockBackupLock {
+		return "backup lock"
+	}
+	return "FTWRL"
+}
+
+func appendBounded(list []string, line string) []string {
+	list = append(list, line)
+	if len(list) > maxStateMessages {
+		list = list[len(list)-maxStateMessages:]
+	}
+	return list
+}
@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"backup-helper/internal/log"
+	"io"
+	"testing"
+)
+
+func TestOutputParserLock(t *testing.T) {
+	logCtx, err := log.NewLogContext(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logCtx.Close()
+
+	// acquired: whether the lock counts as acquired once the line has been parsed
+	type line struct {
+		text     string
+		acquired bool
+	}
+	tests := []struct {
+		name     string
+		lockType string
+		lines    []line
+	}{
+		{"FTWRL with interleaved copy threads", LockFTWRL, []line{
+			{"2026-10-18T10:00:00.000000-00:00 0 [Note] [MY-011825] [Xtrabackup] Executing FLUSH NO_WRITE_TO_BINLOG TABLES...", false},
+			{"2026-10-18T10:00:00.100000-00:00 0 [Note] [MY-011825] [Xtrabackup] Executing FLUSH TABLES WITH READ LOCK...", false},
+			{"2026-10-18T10:00:00.200000-00:00 1 [Note] [MY-011825] [Xtrabackup] >> log scanned up to (19305132)", false},
+			{"2026-10-18T10:00:00.300000-00:00 2 [Note] [MY-011825] [Xtrabackup] Copying ./db/t1.ibd to <STDOUT>", false},
+			{"2026-10-18T10:00:00.400000-00:00 2 [Note] [MY-011825] [Xtrabackup] Done: Copying ./db/t1.ibd to <STDOUT>", false},
+			{"2026-10-18T10:00:05.000000-00:00 0 [Note] [MY-011825] [Xtrabackup] Starting to backup non-InnoDB tables and files", true},
+			{"2026-10-18T10:00:06.000000-00:00 0 [Note] [MY-011825] [Xtrabackup] Executing UNLOCK TABLES", true},
+		}},
+		{"backup lock", LockBackupLock, []line{
+			{"181018 10:00:00 Executing LOCK TABLES FOR BACKUP...", false},
+			{"181018 10:00:00 >> log scanned up to (19305132)", false},
+			{"181018 10:00:01 [01] Copying ./ibdata1 to /backup/ibdata1", false},
+			{"181018 10:00:02 Executing LOCK BINLOG FOR BACKUP...", true},
+		}},
+		{"explicit done on the request line", LockFTWRL, []line{
+			{"Executing FLUSH TABLES WITH READ LOCK...done", true},
+		}},
+		{"explicit acquired line", LockBackupLock, []line{
+			{"Acquiring BACKUP LOCKS...", false},
+			{"[01] Copying ./mysql.ibd to <STDOUT>", false},
+			{"Backup locks acquired", true},
+		}},
+		{"no lock taken", "", []line{
+			{">> log scanned up to (19305132)", false},
+			{"Starting to backup non-InnoDB tables and files", false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newOutputParser(io.Discard, logCtx)
+			for _, l := range tt.lines {
+				p.Write([]byte(l.text + "\n"))
+				if got := !p.State().LockAcquired.IsZero(); got != l.acquired {
+					t.Fatalf("after %q: acquired = %v, want %v", l.text, got, l.acquired)
+				}
+			}
+			if got := p.State().LockType; got != tt.lockType {
+				t.Errorf("LockType = %q, want %q", got, tt.lockType)
+			}
+		})
+	}
+}
+
+func TestOutputParserState(t *testing.T) {
+	logCtx, err := log.NewLogContext(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logCtx.Close()
+
+	p := newOutputParser(io.Discard, logCtx)
+	// Written in pieces that split lines, and ending without a newline
+	p.Write([]byte("Starting to parse redo log at lsn = 100\n[01] Copying ./ibdata1 to <STDOUT>\n[02] Cop"))
+	p.Write([]byte("ying ./db/t1.ibd to <STDOUT>\n[ERROR] [MY-011825] [Xtrabackup] read failed\n[Warning] slow disk\n"))
+	p.Write([]byte("Transaction log of lsn (100) to (250) was copied.\nMySQL binlog position: filename 'binlog.000003', position '157'\ncompleted OK!"))
+	p.finish()
+
+	s := p.State()
+	if s.FilesCopied != 2 {
+		t.Errorf("FilesCopied = %d, want 2", s.FilesCopied)
+	}
+	if s.FromLSN != 100 || s.ToLSN != 250 {
+		t.Errorf("LSN = %d..%d, want 100..250", s.FromLSN, s.ToLSN)
+	}
+	if s.BinlogPosition != "filename 'binlog.000003', position '157'" {
+		t.Errorf("BinlogPosition = %q", s.BinlogPosition)
+	}
+	if s.ErrorCount != 1 || s.WarningCount != 1 {
+		t.Errorf("errors/warnings = %d/%d, want 1/1", s.ErrorCount, s.WarningCount)
+	}
+	if !s.Completed || s.Stage != "completed" {
+		t.Errorf("Completed = %v, Stage = %q", s.Completed, s.Stage)
+	}
+}
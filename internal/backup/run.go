@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Run is a started xtrabackup backup: its processes, the parsed xtrabackup output and,
+// with zstd compression, the count of uncompressed bytes
+type Run struct {
+	Raw *progress.RawCounter // Uncompressed bytes before zstd (nil without zstd compression)
+
+	cmd        *exec.Cmd // Process whose stdout is the backup stream (zstd when compressing with zstd)
+	xtrabackup *exec.Cmd // xtrabackup itself when it is not cmd (zstd only)
+	parser     *outputParser
+	logCtx     *log.LogContext
+
+	// zstd only: xtrabackup runs beside cmd and is waited for by the pipe goroutine
+	xtrabackupDone chan struct{}
+	xtrabackupErr  error
+
+	waitOnce   sync.Once
+	err        error
+	exitStatus int
+}
+
+// XtrabackupSummary is the parsed xtrabackup result as reported in the backup phase_end event
+type XtrabackupSummary struct {
+	Stage           string  `json:"stage,omitempty"`
+	Completed       bool    `json:"completed"`
+	ExitStatus      int     `json:"exitStatus"`
+	FilesCopied     int     `json:"filesCopied"`
+	FromLSN         uint64  `json:"fromLsn,omitempty"`
+	ToLSN           uint64  `json:"toLsn,omitempty"`
+	BinlogPosition  string  `json:"binlogPosition,omitempty"`
+	LockType        string  `json:"lockType,omitempty"`
+	LockWaitSeconds float64 `json:"lockWaitSeconds,omitempty"`
+	LockHeldSeconds float64 `json:"lockHeldSeconds,omitempty"`
+	Warnings        int     `json:"warnings"`
+	Errors          int     `json:"errors"`
+}
+
+// Wait waits for the backup processes and decides the outcome: xtrabackup must exit with status 0
+// and report "completed OK!", and zstd (if used) must succeed. Later calls return the same result.
+func (r *Run) Wait() error {
+	r.waitOnce.Do(func() {
+		waitErr := r.cmd.Wait()
+		xtrabackupErr := waitErr
+		var zstdErr error
+		if r.xtrabackupDone != nil {
+			<-r.xtrabackupDone
+			xtrabackupErr = r.xtrabackupErr
+			zstdErr = waitErr
+		}
+		// Both waits include copying stderr, so the output is complete now
+		r.parser.finish()
+		state := r.parser.State()
+
+		switch {
+		case xtrabackupErr != nil:
+			r.exitStatus = 1
+			if exitErr, ok := xtrabackupErr.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+				r.exitStatus = exitErr.ExitCode()
+			}
+			r.err = fmt.Errorf("xtrabackup failed: %v", xtrabackupErr)
+		case !state.Completed:
+			r.exitStatus = 1
+			r.err = fmt.Errorf("xtrabackup exited without 'completed OK!'")
+		case zstdErr != nil:
+			r.exitStatus = 1
+			r.err = fmt.Errorf("zstd failed: %v", zstdErr)
+		}
+		r.logResult(state)
+	})
+	return r.err
+}
+
+// Kill stops a backup whose stream is no longer consumed and waits for its processes,
+// so that none of them outlives the failure the caller then reports
+func (r *Run) Kill() {
+	if r.xtrabackup != nil {
+		r.xtrabackup.Process.Kill()
+	}
+	r.cmd.Process.Kill()
+	r.Wait()
+}
+
+// ExitStatus waits for the backup and returns 0 on success, the xtrabackup exit code if it failed with one, else 1
+func (r *Run) ExitStatus() int {
+	r.Wait()
+	return r.exitStatus
+}
+
+// State returns what has been parsed from the xtrabackup output so far
+func (r *Run) State() XtrabackupState {
+	return r.parser.State()
+}
+
+// Summary returns the parsed result for reports; call it after Wait
+func (r *Run) Summary() XtrabackupSummary {
+	s := r.parser.State()
+	return XtrabackupSummary{
+		Stage:           s.Stage,
+		Completed:       s.Completed,
+		ExitStatus:      r.exitStatus,
+		FilesCopied:     s.FilesCopied,
+		FromLSN:         s.FromLSN,
+		ToLSN:           s.ToLSN,
+		BinlogPosition:  s.BinlogPosition,
+		LockType:        s.LockType,
+		LockWaitSeconds: s.LockWait().Seconds(),
+		LockHeldSeconds: s.LockHeld().Seconds(),
+		Warnings:        s.WarningCount,
+		Errors:          s.ErrorCount,
+	}
+}
+
+func (r *Run) logResult(s XtrabackupState) {
+	result, level := "OK", log.LevelInfo
+	if r.err != nil {
+		result, level = r.err.Error(), log.LevelError
+	}
+	r.logCtx.WriteLogLevel(level, "BACKUP", nil, "xtrabackup result: %s (stage %s, %d files copied, LSN %d to %d, %d warnings, %d errors)",
+		result, s.Stage, s.FilesCopied, s.FromLSN, s.ToLSN, s.WarningCount, s.ErrorCount)
+	if s.LockType != "" {
+		r.logCtx.WriteLog("BACKUP", "xtrabackup %s: waited %s, held %s", lockName(s.LockType),
+			s.LockWait().Round(time.Millisecond), s.LockHeld().Round(time.Millisecond))
+	}
+	if s.BinlogPosition != "" {
+		r.logCtx.WriteLog("BACKUP", "xtrabackup binlog position: %s", s.BinlogPosition)
+	}
+}
@@ -1,6 +1,7 @@
 package check
 
 import (
+	"backup-helper/internal/backup"
 	"backup-helper/internal/config"
 	"backup-helper/internal/mysql"
 	"backup-helper/internal/utils"
@@ -466,6 +467,9 @@ func CheckForBackupMode(cfg *config.Config, compressType string, db *sql.DB, str
 		results = append(results, mysqlResults...)
 	}
 
+	// Check whether the datadir disk reads of xtrabackup can be limited
+	results = append(results, CheckDiskIOThrottle(cfg, db)...)
+
 	// Check TCP connectivity if stream-port or stream-host+stream-port is specified
 	if streamPort > 0 {
 		if streamHost != "" {
@@ -483,6 +487,56 @@ func CheckForBackupMode(cfg *config.Config, compressType string, db *sql.DB, str
 	return results
 }
 
+// CheckDiskIOThrottle reports whether --disk-io-limit can use a cgroup v2 io.max limit
+// (cgroup delegation) and which device of the datadir it would limit
+func CheckDiskIOThrottle(cfg *config.Config, db *sql.DB) []CheckResult {
+	var results []CheckResult
+	parent := backup.CgroupParent(cfg)
+	if err := backup.CheckCgroupIO(parent); err != nil {
+		status := "INFO"
+		message := "--disk-io-limit falls back to xtrabackup --throttle"
+		if cfg.DiskIOLimit > 0 && cfg.DiskIOMethod == backup.DiskIOMethodCgroup {
+			status = "ERROR"
+			message = "--disk-io-method=cgroup will fail"
+		} else if cfg.DiskIOLimit > 0 && cfg.DiskIOMethod != backup.DiskIOMethodThrottle {
+			status = "WARNING"
+		}
+		results = append(results, CheckResult{
+			Status:  status,
+			Item:    "Disk IO cgroup delegation",
+			Value:   "not available",
+			Message: fmt.Sprintf("%v; %s", err, message),
+		})
+	} else {
+		results = append(results, CheckResult{
+			Status:  "OK",
+			Item:    "Disk IO cgroup delegation",
+			Value:   "available",
+			Message: fmt.Sprintf("--disk-io-limit can set io.max in cgroups under %s", parent),
+		})
+	}
+
+	if db != nil {
+		if datadir, err := mysql.GetDatadirFromMySQL(db); err == nil {
+			if device, err := backup.BlockDevice(datadir); err != nil {
+				results = append(results, CheckResult{
+					Status:  "INFO",
+					Item:    "Datadir block device",
+					Value:   "unknown",
+					Message: fmt.Sprintf("%v; only xtrabackup --throttle can limit disk reads", err),
+				})
+			} else {
+				results = append(results, CheckResult{
+					Status: "OK",
+					Item:   "Datadir block device",
+					Value:  fmt.Sprintf("%s (%s)", device, datadir),
+				})
+			}
+		}
+	}
+	return results
+}
+
 // CheckForDownloadMode performs checks specific to download mode
 func CheckForDownloadMode(cfg *config.Config, compressType string, targetDir string, streamHost string, streamPort int) []CheckResult {
 	var results []CheckResult
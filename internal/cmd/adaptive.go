@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"backup-helper/internal/config"
+	"backup-helper/internal/log"
+	"backup-helper/internal/mysql"
+	"backup-helper/internal/rate"
+	"database/sql"
+	"fmt"
+)
+
+// Defaults of the adaptive IO limit (see config.Config)
+const (
+	defaultAdaptiveInterval          = 5
+	defaultAdaptiveMaxThreadsRunning = 32
+	defaultAdaptiveMaxPendingIO      = 64
+	defaultAdaptiveMaxReplicaLag     = 60
+)
+
+// newAdaptiveIOLimit returns the load-driven limiter for --adaptive-io-limit, or nil when it is disabled.
+// The caller adds its rate limiters, then calls Start and Stop around the transfer.
+func newAdaptiveIOLimit(cfg *config.Config, db *sql.DB, logCtx *log.LogContext) (*rate.AdaptiveLimiter, error) {
+	if !cfg.AdaptiveIOLimit {
+		return nil, nil
+	}
+	if db == nil {
+		return nil, fmt.Errorf("--adaptive-io-limit needs a MySQL connection")
+	}
+	high := cfg.IOLimitMax
+	if high <= 0 {
+		high = cfg.GetRateLimit()
+	}
+	if high <= 0 {
+		return nil, fmt.Errorf("--adaptive-io-limit needs --io-limit-max when --io-limit is unlimited")
+	}
+	low := cfg.IOLimitMin
+	if low <= 0 {
+		low = high / 10
+	}
+	if low > high {
+		return nil, fmt.Errorf("--io-limit-min is greater than --io-limit-max")
+	}
+
+	thresholds := rate.LoadThresholds{
+		MaxThreadsRunning: adaptiveThreshold(cfg.AdaptiveMaxThreadsRunning, defaultAdaptiveMaxThreadsRunning),
+		MaxPendingIO:      adaptiveThreshold(cfg.AdaptiveMaxPendingIO, defaultAdaptiveMaxPendingIO),
+		MaxReplicaLag:     adaptiveThreshold(cfg.AdaptiveMaxReplicaLag, defaultAdaptiveMaxReplicaLag),
+	}
+	probe := func() (rate.LoadSample, error) {
+		load, err := mysql.GetServerLoad(db)
+		return rate.LoadSample{ThreadsRunning: load.ThreadsRunning, PendingIO: load.PendingIO, ReplicaLag: load.ReplicaLag}, err
+	}
+	return rate.NewAdaptiveLimiter(low, high, thresholds, probe, logCtx), nil
+}
+
+// adaptiveThreshold applies the default to an unset threshold; -1 disables the indicator
+func adaptiveThreshold(value, def int64) int64 {
+	switch {
+	case value == 0:
+		return def
+	case value < 0:
+		return 0
+	}
+	return value
+}
+
+// adaptiveInterval returns the polling interval of the adaptive IO limit in seconds
+func adaptiveInterval(cfg *config.Config) int {
+	if cfg.AdaptiveInterval > 0 {
+		return cfg.AdaptiveInterval
+	}
+	return defaultAdaptiveInterval
+}
@@ -1,12 +1,13 @@
 package cmd
 
 import (
-	"backup-helper/internal/ai"
 	"backup-helper/internal/backup"
 	"backup-helper/internal/check"
 	"backup-helper/internal/config"
+	"backup-helper/internal/control"
 	"backup-helper/internal/log"
 	"backup-helper/internal/mysql"
+	"backup-helper/internal/progress"
 	"backup-helper/internal/rate"
 	"backup-helper/internal/transfer"
 	"backup-helper/internal/utils"
@@ -14,11 +15,9 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"strings"
 	"time"
 
-	"github.com/fatih/color"
 	"github.com/gioco-play/easy-i18n/i18n"
 	"golang.org/x/term"
 )
@@ -55,7 +54,7 @@ func HandleBackup(cfg *config.Config, effective *config.EffectiveValues, flags *
 	}
 	if hasCriticalError {
 		i18n.Printf("\n[ERROR] Pre-flight checks failed. Please fix the errors above before proceeding.\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	i18n.Printf("connect to mysql-server host=%s port=%d user=%s\n", effective.Host, effective.Port, effective.User)
@@ -68,6 +67,14 @@ func HandleBackup(cfg *config.Config, effective *config.EffectiveValues, flags *
 	}
 
 	// Display IO limit after parameter check
+	if cfg.AdaptiveIOLimit {
+		if flags.Mode == "stream" && len(cfg.Sinks) == 0 {
+			i18n.Printf("[backup-helper] Adaptive IO limit enabled: follows MySQL load (Threads_running, InnoDB pending I/O, replica lag)\n")
+		} else {
+			i18n.Printf("Warning: --adaptive-io-limit only applies to stream mode, using the fixed IO limit\n")
+			cfg.AdaptiveIOLimit = false
+		}
+	}
 	if cfg.IOLimit == -1 {
 		i18n.Printf("[backup-helper] Rate limiting disabled (unlimited speed)\n")
 	} else if cfg.IOLimit > 0 {
@@ -75,12 +82,13 @@ func HandleBackup(cfg *config.Config, effective *config.EffectiveValues, flags *
 	} else {
 		i18n.Printf("[backup-helper] IO rate limit set to: %s/s (default)\n", utils.FormatBytes(cfg.GetRateLimit()))
 	}
+	printIOLimitSchedule(cfg, os.Stdout)
 
 	// Check compression dependencies early
 	if effectiveCompressType != "" {
 		if err := check.CheckCompressionDependencies(effectiveCompressType, true, cfg); err != nil {
 			i18n.Printf("Error: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 	}
 
@@ -92,38 +100,69 @@ func HandleBackup(cfg *config.Config, effective *config.EffectiveValues, flags *
 	logCtx, err := log.NewLogContext(cfg.LogDir, cfg.LogFileName)
 	if err != nil {
 		i18n.Printf("Failed to create log context: %v\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 	defer logCtx.Close()
+	logSecretSources(cfg, logCtx)
+	logEvents(logCtx)
+	ctl := startControl(cfg, "backup", logCtx)
+	defer ctl.Close()
 
 	i18n.Printf("[backup-helper] Running xtrabackup...\n")
 	cfg.MysqlHost = effective.Host
 	cfg.MysqlPort = effective.Port
 	cfg.MysqlUser = effective.User
 	cfg.MysqlPassword = password
+	mc := startMetrics(cfg, "backup", "backup", logCtx)
+	defer mc.Stop()
+	notifyMode := flags.Mode
+	if len(cfg.Sinks) > 0 {
+		notifyMode = "fanout"
+	}
+	hookRunner := startHooks(cfg, "backup", notifyMode, logCtx)
+	notifier := startNotify(cfg, "backup", notifyMode, "backup", logCtx)
+	defer notifier.Close()
 	logCtx.WriteLog("BACKUP", "Starting backup operation")
 	logCtx.WriteLog("BACKUP", "MySQL host: %s, port: %d, user: %s", effective.Host, effective.Port, effective.User)
 
 	// Determine objectName suffix and compression param
 	ossObjectName := cfg.ObjectName
-	objectSuffix := ".xb"
 	cfg.CompressType = effectiveCompressType
-	switch effectiveCompressType {
-	case "zstd":
-		objectSuffix = ".xb.zst"
-	case "qp":
-		objectSuffix = "_qp.xb"
-	default:
-		objectSuffix = ".xb"
-	}
+	objectSuffix := backupFileSuffix(effectiveCompressType)
 	timestamp := time.Now().Format("_20060102150405")
 	fullObjectName := ossObjectName + timestamp + objectSuffix
+	if notifyMode != "stream" {
+		notifier.SetObject(fullObjectName)
+		hookRunner.Set("OBJECT", fullObjectName)
+	}
 
-	reader, cmd, err := backup.RunXtraBackup(cfg, db, logCtx)
+	// Fan-out sinks and the stream flags are validated before the pre-backup hook and xtrabackup run
+	var sinks []sinkSpec
+	if len(cfg.Sinks) > 0 {
+		sinks, err = parseSinks(cfg)
+		if err != nil {
+			logCtx.WriteLogLevel(log.LevelError, "FANOUT", nil, "Invalid fan-out configuration: %v", err)
+			i18n.Printf("Fan-out configuration error: %v\n", err)
+			failPhase("backup", err)
+		}
+	} else if err := checkBackupMode(cfg, effective, flags); err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Invalid backup options: %v", err)
+		i18n.Printf("Error: %v\n", err)
+		failPhase("backup", err)
+	}
+
+	if err := hookRunner.Run(config.HookPreBackup); err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "HOOK", nil, "Backup aborted: %v", err)
+		i18n.Printf("Backup aborted: %v\n", err)
+		exit(1)
+	}
+
+	progress.PhaseStart("backup")
+	reader, run, err := backup.RunXtraBackup(cfg, db, logCtx)
 	if err != nil {
-		logCtx.WriteLog("BACKUP", "Failed to start xtrabackup: %v", err)
+		logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Failed to start xtrabackup: %v", err)
 		i18n.Printf("Run xtrabackup error: %v\n", err)
-		os.Exit(1)
+		failPhase("backup", err)
 	}
 
 	// Calculate total size for progress tracking
@@ -147,105 +186,102 @@ func HandleBackup(cfg *config.Config, effective *config.EffectiveValues, flags *
 		}
 	}
 
-	switch flags.Mode {
-	case "oss":
-		err = handleOSSBackup(cfg, fullObjectName, reader, totalSize, logCtx, cmd)
-	case "stream":
-		err = handleStreamBackup(cfg, effective, flags, totalSize, reader, logCtx, cmd)
+	// Pause, resume and cancel requests act on the xtrabackup output
+	if cfg.CompressType == "" {
+		ctl.SetTotal(totalSize)
+	}
+	reader = ctl.Reader(reader)
+
+	switch {
+	case len(sinks) > 0:
+		err = handleFanOutBackup(cfg, effective, sinks, fullObjectName, reader, totalSize, run, ctl, logCtx)
+	case flags.Mode == "oss":
+		err = handleOSSBackup(cfg, fullObjectName, reader, totalSize, run, logCtx)
+	case flags.Mode == "stream":
+		err = handleStreamBackup(cfg, effective, flags, db, totalSize, reader, run, ctl, logCtx)
 	default:
+		// Rejected by checkBackupMode already
 		i18n.Printf("Unknown mode: %s\n", flags.Mode)
-		os.Exit(1)
+		failBackup(run, "backup", fmt.Errorf("unknown mode: %s", flags.Mode))
 	}
 
 	if err != nil {
 		return err
 	}
 
-	// Wait for backup to complete
-	cmd.Wait()
+	// Wait for backup to complete; the outcome comes from the exit status and the parsed xtrabackup output
+	backupErr := run.Wait()
+	progress.PhaseEndDetails("backup", backupErr, run.Summary())
 	logCtx.WriteLog("BACKUP", "xtrabackup process completed")
 
-	// Check backup log
-	logContent, err := os.ReadFile(logCtx.GetFileName())
-	if err != nil {
-		logCtx.WriteLog("BACKUP", "Failed to read log file: %v", err)
-		i18n.Printf("Backup log read error.\n")
-		os.Exit(1)
-	}
-
-	if !strings.Contains(string(logContent), "completed OK!") {
-		logCtx.WriteLog("BACKUP", "Backup failed: no 'completed OK!' found in log")
-		errorSummary := log.ExtractErrorSummary("BACKUP", string(logContent))
-		if errorSummary != "" {
-			i18n.Printf("Backup failed. Error summary:\n%s\n", errorSummary)
+	if backupErr != nil {
+		logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Backup failed: %v", backupErr)
+		logCtx.Flush()
+		logContent, _ := os.ReadFile(logCtx.GetFileName())
+		if state := run.State(); len(state.Errors) > 0 {
+			i18n.Printf("Backup failed: %v. Errors reported by xtrabackup:\n%s\n", backupErr, strings.Join(state.Errors, "\n"))
+		} else if errorSummary := log.ExtractErrorSummary("BACKUP", string(logContent)); errorSummary != "" {
+			i18n.Printf("Backup failed: %v. Error summary:\n%s\n", backupErr, errorSummary)
 		} else {
-			i18n.Printf("Backup failed (no 'completed OK!').\n")
+			i18n.Printf("Backup failed: %v\n", backupErr)
 		}
+		printKnownErrors(logCtx, string(logContent))
 		i18n.Printf("Log file: %s\n", logCtx.GetFileName())
 
 		// Handle AI diagnosis
 		if flags.AIDiagnoseFlag == "on" {
 			if utils.PromptAIDiagnosis(flags.AutoYes) {
-				if cfg.QwenAPIKey == "" {
-					i18n.Printf("Qwen API Key is required for AI diagnosis. Please set it in config.\n")
-					os.Exit(1)
-				}
-				aiSuggestion, err := ai.DiagnoseWithAliQwen(cfg, "BACKUP", string(logContent))
-				if err != nil {
-					i18n.Printf("AI diagnosis failed: %v\n", err)
-				} else {
-					fmt.Print(color.YellowString(i18n.Sprintf("AI diagnosis suggestion:\n")))
-					fmt.Println(color.YellowString(aiSuggestion))
-				}
+				diagnose(cfg, "BACKUP", logCtx, flags.AIPreview)
 			}
 		}
-		os.Exit(1)
+		exit(1)
 	}
 
 	fmt.Print("\n")
 	logCtx.WriteLog("BACKUP", "Backup completed successfully")
+	if err := hookRunner.Run(config.HookPostBackup); err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "HOOK", nil, "Backup failed: %v", err)
+		i18n.Printf("Backup failed: %v\n", err)
+		exit(1)
+	}
 	logCtx.MarkSuccess()
 	i18n.Printf("[backup-helper] Backup and upload completed!\n")
 	i18n.Printf("[backup-helper] Log file: %s\n", logCtx.GetFileName())
 	return nil
 }
 
-func handleOSSBackup(cfg *config.Config, fullObjectName string, reader io.Reader, totalSize int64, logCtx *log.LogContext, cmd *exec.Cmd) error {
+func handleOSSBackup(cfg *config.Config, fullObjectName string, reader io.Reader, totalSize int64, run *backup.Run, logCtx *log.LogContext) error {
 	i18n.Printf("[backup-helper] Uploading to OSS...\n")
 	logCtx.WriteLog("OSS", "Starting OSS upload")
-	isCompressed := cfg.CompressType != ""
-	err := transfer.UploadReaderToOSS(cfg, fullObjectName, reader, totalSize, isCompressed, logCtx)
+	progress.PhaseStart("upload")
+	tracker := progress.NewProgressTrackerWithCompression(totalSize, cfg.CompressType != "")
+	tracker.SetRawCounter(run.Raw)
+	err := transfer.UploadReaderToOSSWithTracker(cfg, fullObjectName, reader, totalSize, tracker, logCtx)
+	tracker.Complete()
 	if err != nil {
-		logCtx.WriteLog("OSS", "OSS upload failed: %v", err)
+		logCtx.WriteLogLevel(log.LevelError, "OSS", nil, "OSS upload failed: %v", err)
 		i18n.Printf("OSS upload error: %v\n", err)
-		if cmd != nil {
-			cmd.Process.Kill()
-		}
-		os.Exit(1)
+		failBackup(run, "upload", err)
 	}
+	progress.PhaseEnd("upload", nil)
 	logCtx.WriteLog("OSS", "OSS upload completed successfully")
 	logCtx.MarkSuccess()
 	return nil
 }
 
-func handleStreamBackup(cfg *config.Config, effective *config.EffectiveValues, flags *config.Flags, totalSize int64, reader io.Reader, logCtx *log.LogContext, cmd *exec.Cmd) error {
-	streamHost := effective.StreamHost
-	if streamHost == "" && cfg.StreamHost != "" {
-		streamHost = cfg.StreamHost
-	}
+func handleStreamBackup(cfg *config.Config, effective *config.EffectiveValues, flags *config.Flags, db *sql.DB, totalSize int64, reader io.Reader, run *backup.Run, ctl *control.Server, logCtx *log.LogContext) error {
+	streamHost := streamHostOf(cfg, effective)
 
 	remoteOutput := effective.RemoteOutput
 	if remoteOutput == "" && cfg.RemoteOutput != "" {
 		remoteOutput = cfg.RemoteOutput
 	}
 
-	// Validate SSH mode requirements
-	if flags.UseSSH && streamHost == "" {
-		i18n.Printf("Error: --ssh requires --stream-host\n")
-		if cmd != nil {
-			cmd.Process.Kill()
-		}
-		os.Exit(1)
+	adaptive, err := newAdaptiveIOLimit(cfg, db, logCtx)
+	if err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "RATE", nil, "Adaptive IO limit error: %v", err)
+		i18n.Printf("Error: %v\n", err)
+		failBackup(run, "stream", err)
 	}
 
 	// handshake priority: command line > config > default
@@ -253,8 +289,25 @@ func handleStreamBackup(cfg *config.Config, effective *config.EffectiveValues, f
 	streamKey := effective.StreamKey
 
 	var writer io.WriteCloser
+	var tracker *progress.ProgressTracker
 	var closer func()
-	var err error
+	var remoteReceiver *transfer.RemoteReceiver // --ssh only; stopped when the stream fails
+
+	tlsConfig, err := streamTLSConfig(cfg, streamHost != "")
+	if err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "TLS configuration error: %v", err)
+		i18n.Printf("TLS configuration error: %v\n", err)
+		failBackup(run, "stream", err)
+	}
+	streamOpts := transfer.StreamOptions{
+		EnableHandshake: enableHandshake,
+		HandshakeKey:    streamKey,
+		CompressType:    cfg.CompressType,
+		Framed:          cfg.StreamFramed,
+		TLSConfig:       tlsConfig,
+		Connections:     cfg.StreamConnections,
+		Instance:        streamInstanceName(cfg),
+	}
 
 	streamPort := effective.StreamPort
 	if streamHost != "" {
@@ -269,75 +322,76 @@ func handleStreamBackup(cfg *config.Config, effective *config.EffectiveValues, f
 				sshPort = cfg.StreamPort
 			}
 
-			remotePort, outputPath, _, sshCleanup, err := transfer.StartRemoteReceiverViaSSH(
-				streamHost, sshPort, remoteOutput, totalSize, enableHandshake, streamKey)
+			receiver, err := transfer.StartRemoteReceiverViaSSH(sshOptions(cfg, streamHost), transfer.RemoteReceiverOptions{
+				StreamPort:      sshPort,
+				RemoteOutput:    remoteOutput,
+				EstimatedSize:   totalSize,
+				EnableHandshake: enableHandshake,
+				HandshakeKey:    streamKey,
+				Framed:          cfg.StreamFramed,
+				Connections:     cfg.StreamConnections,
+				Tunnel:          cfg.SSHTunnel,
+			}, logCtx)
 			if err != nil {
+				logCtx.WriteLogLevel(log.LevelError, "SSH", nil, "Failed to start remote receiver: %v", err)
 				i18n.Printf("SSH receiver error: %v\n", err)
-				if cmd != nil {
-					cmd.Process.Kill()
-				}
-				os.Exit(1)
+				failBackup(run, "stream", err)
 			}
-
-			streamPort = remotePort
-			if sshPort > 0 {
+			remoteReceiver = receiver
+
+			streamPort = receiver.Port
+			if cfg.SSHTunnel {
+				logCtx.WriteLog("SSH", "Remote receiver started (PID %d), streaming through the SSH channel", receiver.PID())
+				i18n.Printf("[backup-helper] Remote receiver started via SSH, streaming through the SSH channel\n")
+			} else if sshPort > 0 {
+				logCtx.WriteLog("SSH", "Remote receiver started (PID %d, port %d)", receiver.PID(), receiver.Port)
 				i18n.Printf("[backup-helper] Remote receiver started on port %d via SSH\n", streamPort)
 			} else {
+				logCtx.WriteLog("SSH", "Remote receiver started (PID %d, port %d)", receiver.PID(), receiver.Port)
 				i18n.Printf("[backup-helper] Remote receiver started on auto-discovered port %d via SSH\n", streamPort)
 			}
 
-			if outputPath != "" {
-				i18n.Printf("[backup-helper] Remote backup will be saved to: %s\n", outputPath)
+			if receiver.OutputPath != "" {
+				i18n.Printf("[backup-helper] Remote backup will be saved to: %s\n", receiver.OutputPath)
 			} else if remoteOutput != "" {
 				i18n.Printf("[backup-helper] Remote backup will be saved to: %s\n", remoteOutput)
 			} else {
 				i18n.Printf("[backup-helper] Remote backup will be saved to: auto-generated path (backup_YYYYMMDDHHMMSS.xb)\n")
 			}
 
-			// Connect to remote receiver
-			isCompressed := cfg.CompressType != ""
-			writer, _, closer, _, err = transfer.StartStreamClient(
-				streamHost, streamPort, enableHandshake, streamKey, totalSize, isCompressed, logCtx)
+			// Connect to remote receiver, or write into its stdin through the SSH channel
+			if cfg.SSHTunnel {
+				writer, tracker, closer, err = receiver.TunnelWriter(totalSize, streamOpts, logCtx)
+			} else {
+				writer, tracker, closer, _, err = transfer.StartStreamClient(
+					streamHost, streamPort, totalSize, streamOpts, logCtx)
+			}
 			if err != nil {
-				sshCleanup()
+				stopRemoteReceiver(remoteReceiver, logCtx)
 				i18n.Printf("Stream client error: %v\n", err)
-				if cmd != nil {
-					cmd.Process.Kill()
-				}
-				os.Exit(1)
+				failBackup(run, "stream", err)
 			}
 
-			// Wrap closer to cleanup SSH process
+			// Wrap closer to make sure the remote receiver does not outlive this process
 			originalCloser := closer
 			closer = func() {
 				if originalCloser != nil {
 					originalCloser()
 				}
-				sshCleanup()
+				stopRemoteReceiver(remoteReceiver, logCtx)
 			}
 		} else {
 			// Normal mode: Direct connection to specified port
+			// checkBackupMode has made sure one of them is set
 			if streamPort == 0 {
-				if cfg.StreamPort > 0 {
-					streamPort = cfg.StreamPort
-				} else {
-					i18n.Printf("Error: --stream-port is required when using --stream-host\n")
-					if cmd != nil {
-						cmd.Process.Kill()
-					}
-					os.Exit(1)
-				}
+				streamPort = cfg.StreamPort
 			}
 
-			isCompressed := cfg.CompressType != ""
-			writer, _, closer, _, err = transfer.StartStreamClient(
-				streamHost, streamPort, enableHandshake, streamKey, totalSize, isCompressed, logCtx)
+			writer, tracker, closer, _, err = transfer.StartStreamClient(
+				streamHost, streamPort, totalSize, streamOpts, logCtx)
 			if err != nil {
 				i18n.Printf("Stream client error: %v\n", err)
-				if cmd != nil {
-					cmd.Process.Kill()
-				}
-				os.Exit(1)
+				failBackup(run, "stream", err)
 			}
 		}
 	} else {
@@ -346,34 +400,153 @@ func handleStreamBackup(cfg *config.Config, effective *config.EffectiveValues, f
 			streamPort = cfg.StreamPort
 		}
 
-		tcpWriter, _, closerFunc, _, _, err := transfer.StartStreamSender(streamPort, enableHandshake, streamKey, totalSize, cfg.CompressType != "", cfg.Timeout, logCtx)
+		tcpWriter, senderTracker, closerFunc, _, _, err := transfer.StartStreamSender(streamPort, totalSize, cfg.Timeout, streamOpts, logCtx)
 		if err != nil {
 			i18n.Printf("Stream server error: %v\n", err)
-			if cmd != nil {
-				cmd.Process.Kill()
-			}
-			os.Exit(1)
+			failBackup(run, "stream", err)
 		}
 		writer = tcpWriter
+		tracker = senderTracker
 		closer = closerFunc
 	}
 	defer closer()
+	tracker.SetRawCounter(run.Raw)
 
 	// Apply rate limiting for stream mode if configured
 	var finalWriter io.WriteCloser = writer
-	rateLimit := cfg.GetRateLimit()
-	if rateLimit > 0 {
-		rateLimitedWriter := rate.NewRateLimitedWriter(writer, rateLimit)
+	if adaptive != nil {
+		// The adaptive limiter sets the limit from MySQL load while the stream runs
+		rateLimitedWriter := rate.NewRateLimitedWriter(writer, adaptive.CurrentLimit())
+		adaptive.Add(rateLimitedWriter)
+		ctl.AddLimiter(adaptive.CapFrom("control request"))
+		adaptive.Start(time.Duration(adaptiveInterval(cfg)) * time.Second)
+		defer adaptive.Stop()
+		// A time-of-day schedule caps the adaptive range
+		if scheduler := startIOLimitSchedule(cfg, logCtx); scheduler != nil {
+			scheduler.Add(adaptive.CapFrom("schedule"))
+			defer scheduler.Stop()
+		}
 		finalWriter = rateLimitedWriter
+	} else {
+		var stopSchedule func()
+		finalWriter, stopSchedule = limitedWriter(cfg, writer, ctl, logCtx)
+		defer stopSchedule()
 	}
 
-	_, err = io.Copy(finalWriter, reader)
+	// Framed protocol: encode data frames and append the trailer once xtrabackup has exited
+	var dst io.Writer = finalWriter
+	var frameWriter *transfer.FrameWriter
+	if cfg.StreamFramed {
+		frameWriter = transfer.NewFrameWriter(finalWriter)
+		dst = frameWriter
+	}
+
+	progress.PhaseStart("stream")
+	_, err = io.Copy(dst, reader)
 	if err != nil {
 		i18n.Printf("TCP stream error: %v\n", err)
-		if cmd != nil {
-			cmd.Process.Kill()
+		stopRemoteReceiver(remoteReceiver, logCtx)
+		failBackup(run, "stream", err)
+	}
+
+	if frameWriter != nil {
+		if err := writeStreamTrailer(frameWriter, run.ExitStatus(), logCtx); err != nil {
+			i18n.Printf("TCP stream error: %v\n", err)
+			stopRemoteReceiver(remoteReceiver, logCtx)
+			failBackup(run, "stream", err)
+		}
+	}
+	// Flush the stream; with --stream-connections this waits for the blocks still in flight
+	if err := writer.Close(); err != nil {
+		i18n.Printf("TCP stream error: %v\n", err)
+		stopRemoteReceiver(remoteReceiver, logCtx)
+		failBackup(run, "stream", err)
+	}
+	if remoteReceiver != nil {
+		// The receiver may still be writing or extracting; only its exit status tells whether the backup arrived
+		if err := remoteReceiver.Wait(remoteReceiverExitTimeout); err != nil {
+			logCtx.WriteLogLevel(log.LevelError, "SSH", nil, "%v", err)
+			i18n.Printf("SSH receiver error: %v\n", err)
+			failBackup(run, "stream", err)
+		}
+		if savedTo := remoteReceiver.SavedTo(); savedTo != "" {
+			i18n.Printf("[backup-helper] Remote receiver completed, backup saved to: %s\n", savedTo)
+			logCtx.WriteLog("SSH", "Remote receiver (PID %d) finished, backup saved to %s", remoteReceiver.PID(), savedTo)
+		} else {
+			logCtx.WriteLog("SSH", "Remote receiver (PID %d) finished", remoteReceiver.PID())
 		}
-		os.Exit(1)
+	}
+	progress.PhaseEnd("stream", nil)
+	return nil
+}
+
+// checkBackupMode checks the --mode of a backup without fan-out sinks and, for stream mode,
+// the combinations of the stream flags; nothing has been started yet
+func checkBackupMode(cfg *config.Config, effective *config.EffectiveValues, flags *config.Flags) error {
+	switch flags.Mode {
+	case "oss":
+		return nil
+	case "stream":
+	default:
+		return fmt.Errorf("unknown mode: %s", flags.Mode)
+	}
+	streamHost := streamHostOf(cfg, effective)
+	switch {
+	case flags.UseSSH && streamHost == "":
+		return fmt.Errorf("--ssh requires --stream-host")
+	case flags.UseSSH && cfg.StreamTLS:
+		return fmt.Errorf("--tls is not supported with --ssh")
+	case cfg.SSHTunnel && (!flags.UseSSH || cfg.StreamConnections > 1):
+		return fmt.Errorf("--ssh-tunnel requires --ssh and does not support --stream-connections")
+	case streamHost != "" && !flags.UseSSH && effective.StreamPort == 0 && cfg.StreamPort <= 0:
+		return fmt.Errorf("--stream-port is required when using --stream-host")
 	}
 	return nil
 }
+
+// streamHostOf returns the receiver a stream backup connects to, empty when it listens
+func streamHostOf(cfg *config.Config, effective *config.EffectiveValues) string {
+	if effective.StreamHost != "" {
+		return effective.StreamHost
+	}
+	return cfg.StreamHost
+}
+
+// failBackup ends a backup that failed in phase after xtrabackup has started: xtrabackup is
+// killed and reaped first, then the failure is reported and the process exits (see failPhase)
+func failBackup(run *backup.Run, phase string, err error) {
+	run.Kill()
+	failPhase(phase, err)
+}
+
+// remoteReceiverExitTimeout bounds how long --ssh waits for the remote receiver after the stream ended
+const remoteReceiverExitTimeout = 10 * time.Minute
+
+// sshOptions returns the SSH settings of cfg for the --ssh receiver on host
+func sshOptions(cfg *config.Config, host string) transfer.SSHOptions {
+	remoteArgs, _ := cfg.SSHRemoteArgList() // checked when the configuration was loaded
+	return transfer.SSHOptions{
+		Host:         host,
+		Port:         cfg.SSHPort,
+		User:         cfg.SSHUser,
+		IdentityFile: cfg.SSHIdentityFile,
+		RemoteBinary: cfg.SSHRemoteBinary,
+		RemoteArgs:   remoteArgs,
+	}
+}
+
+// stopRemoteReceiver terminates the --ssh receiver (nil-safe); only that process is signalled.
+// If the receiver had failed on its own, its last status lines are shown since they usually explain the stream error.
+func stopRemoteReceiver(r *transfer.RemoteReceiver, logCtx *log.LogContext) {
+	if r == nil {
+		return
+	}
+	if err := r.Stop(); err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "SSH", nil, "Failed to stop remote receiver (PID %d): %v", r.PID(), err)
+		return
+	}
+	if err := r.Wait(time.Second); err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "SSH", nil, "%v", err)
+		i18n.Printf("SSH receiver error: %v\n", err)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"backup-helper/internal/utils"
 	"database/sql"
 	"fmt"
-	"os"
 
 	"github.com/gioco-play/easy-i18n/i18n"
 	"golang.org/x/term"
@@ -59,7 +58,7 @@ func HandleCheck(cfg *config.Config, effective *config.EffectiveValues, flags *c
 		i18n.Printf("\n=== %s ===\n", i18n.Sprintf("Check Summary"))
 		if hasCriticalError {
 			i18n.Printf("[ERROR] Critical errors found. Please fix them before proceeding with backup.\n")
-			os.Exit(1)
+			exit(1)
 		} else {
 			i18n.Printf("[OK] Pre-flight checks completed. Backup mode is ready.\n")
 		}
@@ -81,7 +80,7 @@ func HandleCheck(cfg *config.Config, effective *config.EffectiveValues, flags *c
 		i18n.Printf("\n=== %s ===\n", i18n.Sprintf("Check Summary"))
 		if hasCriticalError {
 			i18n.Printf("[ERROR] Critical errors found. Please fix them before proceeding with download.\n")
-			os.Exit(1)
+			exit(1)
 		} else {
 			i18n.Printf("[OK] Pre-flight checks completed. Download mode is ready.\n")
 		}
@@ -103,7 +102,7 @@ func HandleCheck(cfg *config.Config, effective *config.EffectiveValues, flags *c
 		i18n.Printf("\n=== %s ===\n", i18n.Sprintf("Check Summary"))
 		if hasCriticalError {
 			i18n.Printf("[ERROR] Critical errors found. Please fix them before proceeding with prepare.\n")
-			os.Exit(1)
+			exit(1)
 		} else {
 			i18n.Printf("[OK] Pre-flight checks completed. Prepare mode is ready.\n")
 		}
@@ -222,7 +221,7 @@ func HandleCheck(cfg *config.Config, effective *config.EffectiveValues, flags *c
 
 		// Exit with error code if any mode has critical errors
 		if backupHasError || downloadHasError || prepareHasError {
-			os.Exit(1)
+			exit(1)
 		}
 	}
 	return nil
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"backup-helper/internal/ai"
+	"backup-helper/internal/config"
+	"backup-helper/internal/hooks"
+	"backup-helper/internal/log"
+	"backup-helper/internal/metrics"
+	"backup-helper/internal/notify"
+	"backup-helper/internal/progress"
+	"backup-helper/internal/transfer"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/gioco-play/easy-i18n/i18n"
+)
+
+// logSecretSources records where each secret was loaded from (values are never logged)
+func logSecretSources(cfg *config.Config, logCtx *log.LogContext) {
+	for _, s := range cfg.SecretSources {
+		logCtx.WriteLog("CONFIG", "Secret %s loaded from %s", s.Name, s.Source)
+		if s.RunsCommand() {
+			logCtx.WriteLogLevel(log.LevelWarn, "CONFIG", nil,
+				"Warning: secret %s in the config file is read by running a command; if it is a plaintext value starting with cmd:, write it as literal:cmd:...", s.Name)
+		}
+	}
+}
+
+// streamTLSConfig builds the TLS config for a stream connection, nil when TLS is disabled.
+// active is true when this side connects to --stream-host (TLS client), false when it listens (TLS server).
+func streamTLSConfig(cfg *config.Config, active bool) (*tls.Config, error) {
+	if active {
+		return transfer.NewClientTLSConfig(cfg)
+	}
+	return transfer.NewServerTLSConfig(cfg)
+}
+
+// streamInstanceName returns the instance name a sender announces in the handshake,
+// which a --serve receiver uses as directory: cfg.StreamInstance or <hostname>_<mysql port>
+func streamInstanceName(cfg *config.Config) string {
+	if cfg.StreamInstance != "" {
+		return cfg.StreamInstance
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	if cfg.MysqlPort > 0 {
+		return fmt.Sprintf("%s_%d", host, cfg.MysqlPort)
+	}
+	return host
+}
+
+// writeStreamTrailer ends a framed stream with its end-of-stream trailer
+func writeStreamTrailer(frameWriter *transfer.FrameWriter, exitStatus int, logCtx *log.LogContext) error {
+	trailer, err := frameWriter.WriteTrailer(exitStatus)
+	if err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to send end-of-stream trailer: %v", err)
+		return err
+	}
+	logCtx.WriteLog("TCP", "End-of-stream trailer sent: %d bytes, sha256=%s, exit status %d",
+		trailer.TotalBytes, trailer.SHA256, trailer.ExitStatus)
+	return nil
+}
+
+// diagnose prints the diagnosis of a failed run: from the configured LLM, or from the offline
+// rules for known error signatures when no LLM is configured or it cannot be reached.
+// With preview it only shows what would be sent.
+func diagnose(cfg *config.Config, module string, logCtx *log.LogContext, preview bool) {
+	logCtx.Flush()
+	// Callers exit right after the diagnosis
+	defer logCtx.Flush()
+	logContent, _ := os.ReadFile(logCtx.GetFileName())
+	if preview {
+		previewDiagnosis(cfg, module, logCtx, string(logContent))
+		return
+	}
+	if payload := ai.NewPayload(cfg, module, string(logContent)); payload.Destination != "" {
+		logCtx.WriteLog("AI", "Sending %d bytes of redacted log to %s", len(payload.Content), payload.Destination)
+	}
+	diagnosis, err := ai.Diagnose(cfg, module, string(logContent))
+	if err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "AI", nil, "Diagnosis failed: %v", err)
+		i18n.Printf("AI diagnosis failed: %v\n", err)
+		return
+	}
+	if diagnosis.LLMError != nil {
+		logCtx.WriteLog("AI", "Warning: AI service unavailable, using offline rules: %v", diagnosis.LLMError)
+		i18n.Printf("AI service unavailable (%v), using offline diagnosis rules\n", diagnosis.LLMError)
+	}
+	logCtx.WriteLog("AI", "Diagnosis by %s", diagnosis.Source)
+	if diagnosis.Offline {
+		// printKnownErrors has shown the same rules with the error summary
+		i18n.Printf("No AI diagnosis available; see the known issues above\n")
+		return
+	}
+	fmt.Print(color.YellowString(i18n.Sprintf("AI diagnosis suggestion:\n")))
+	fmt.Println(color.YellowString(diagnosis.Text))
+}
+
+// printKnownErrors prints the cause and fix of the known error signatures in the log, if any;
+// it needs no network and follows the error summary of a failed run
+func printKnownErrors(logCtx *log.LogContext, logContent string) {
+	diagnosis, err := ai.DiagnoseOffline(logContent)
+	if err != nil {
+		return
+	}
+	logCtx.WriteLogLevel(log.LevelWarn, "DIAGNOSIS", log.Fields{"rules": diagnosis.Rules},
+		"Known error signatures: %s (%s)", strings.Join(diagnosis.Rules, ", "), diagnosis.Source)
+	fmt.Print(color.YellowString(i18n.Sprintf("Known issues (%s):\n", diagnosis.Source)))
+	fmt.Println(color.YellowString(diagnosis.Text))
+}
+
+// previewDiagnosis prints exactly what the AI diagnosis would send (--ai-preview); nothing leaves the machine
+func previewDiagnosis(cfg *config.Config, module string, logCtx *log.LogContext, logContent string) {
+	payload := ai.NewPayload(cfg, module, logContent)
+	destination := payload.Destination
+	if destination == "" {
+		destination = i18n.Sprintf("(no AI service configured)")
+	}
+	logCtx.WriteLog("AI", "Preview of %d bytes of redacted log for %s, not sent", len(payload.Content), destination)
+	i18n.Printf("AI diagnosis preview, nothing was sent. Destination: %s\n", destination)
+	fmt.Println("----- system prompt -----")
+	fmt.Println(payload.SystemPrompt)
+	fmt.Println("----- log excerpt -----")
+	fmt.Println(payload.Content)
+	fmt.Println("-----")
+}
+
+// startMetrics collects the metrics of command, whose result is the end of mainPhase (nil when metrics are off)
+func startMetrics(cfg *config.Config, command, mainPhase string, logCtx *log.LogContext) *metrics.Collector {
+	return metrics.Start(cfg.MetricsAddr, cfg.MetricsTextfile, streamInstanceName(cfg), command, mainPhase, logCtx)
+}
+
+// startNotify prepares the success/failure notifications of command (nil when no notifier is configured)
+func startNotify(cfg *config.Config, command, mode, mainPhase string, logCtx *log.LogContext) *notify.Notifier {
+	return notify.Start(cfg, streamInstanceName(cfg), command, mode, mainPhase, logCtx)
+}
+
+// startHooks prepares the lifecycle hooks of command (nil when no hook is configured)
+func startHooks(cfg *config.Config, command, mode string, logCtx *log.LogContext) *hooks.Runner {
+	return hooks.Start(cfg, streamInstanceName(cfg), command, mode, logCtx)
+}
+
+// failPhase ends a run that failed in phase: the failed phase reaches notifications, on-failure
+// hooks and metrics like any other failure before the process exits
+func failPhase(phase string, err error) {
+	progress.PhaseEnd(phase, err)
+	exit(1)
+}
+
+// exit ends the process with code once the notifications already sent are delivered and the
+// buffered log entries are written; handlers use it instead of os.Exit, which would lose both
+func exit(code int) {
+	notify.Wait()
+	log.FlushAll()
+	os.Exit(code)
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"backup-helper/internal/config"
+	"backup-helper/internal/control"
+	"backup-helper/internal/log"
+	"backup-helper/internal/rate"
+	"backup-helper/internal/utils"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gioco-play/easy-i18n/i18n"
+)
+
+// startControl exposes the running command on its control socket, or returns nil when ctlSocket is "off".
+// Failing to listen only prints a warning: the transfer itself does not depend on the socket.
+func startControl(cfg *config.Config, command string, logCtx *log.LogContext) *control.Server {
+	path := cfg.CtlSocket
+	if path == "off" {
+		return nil
+	}
+	if path == "" {
+		path = control.DefaultSocketPath()
+	}
+	ctl, err := control.Listen(path, command, func() int64 { return cfg.GetRateLimitAt(time.Now()) }, logCtx)
+	if err != nil {
+		i18n.Fprintf(os.Stderr, "Warning: control socket disabled: %v\n", err)
+		return nil
+	}
+	// A live limit also replaces the configured limit, so schedules and OSS parts keep it
+	ctl.AddLimiter(cfg)
+	i18n.Fprintf(os.Stderr, "[backup-helper] Control socket: %s (use --ctl status|limit|pause|resume|cancel)\n", ctl.Path())
+	return ctl
+}
+
+// HandleCtl sends one --ctl command to a running backup-helper and prints its status
+func HandleCtl(cfg *config.Config, flags *config.Flags) error {
+	command := strings.ToLower(flags.Ctl)
+	args := flags.CtlArgs
+	if flags.CtlLimit != "" {
+		args = append([]string{flags.CtlLimit}, args...)
+	}
+	var limit int64
+	if command == control.CommandLimit {
+		if len(args) != 1 {
+			i18n.Printf("Error: --ctl limit needs one rate, e.g. --ctl limit 50MB/s or --ctl-limit 50MB/s (unlimited, 0 or -1 = unlimited)\n")
+			return fmt.Errorf("missing rate")
+		}
+		parsed, err := parseCtlRate(args[0])
+		if err != nil {
+			i18n.Printf("Error: invalid rate '%s'\n", args[0])
+			return err
+		}
+		limit = parsed
+	} else if len(args) > 0 {
+		i18n.Printf("Error: unexpected argument '%s' for --ctl %s\n", args[0], command)
+		return fmt.Errorf("unexpected argument")
+	}
+
+	path := cfg.CtlSocket
+	if path == "" || path == "off" {
+		sockets := control.FindSockets()
+		switch len(sockets) {
+		case 0:
+			i18n.Printf("Error: no running backup-helper found, use --ctl-socket to name its control socket\n")
+			return fmt.Errorf("no control socket")
+		case 1:
+			path = sockets[0]
+		default:
+			i18n.Printf("Error: several backup-helper processes are running, choose one with --ctl-socket:\n")
+			for _, s := range sockets {
+				fmt.Printf("  %s\n", s)
+			}
+			return fmt.Errorf("ambiguous control socket")
+		}
+	}
+
+	status, err := control.Request(path, command, limit)
+	if err != nil {
+		i18n.Printf("Error: %v\n", err)
+		return err
+	}
+	printControlStatus(status)
+	return nil
+}
+
+// parseCtlRate parses the rate of --ctl limit into bytes per second; 0 is unlimited
+func parseCtlRate(s string) (int64, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "unlimited", "0", "-1":
+		return 0, nil
+	}
+	parsed, err := config.ParseRateLimit(s)
+	if err != nil {
+		return 0, err
+	}
+	if parsed <= 0 {
+		return 0, fmt.Errorf("invalid rate '%s'", s)
+	}
+	return parsed, nil
+}
+
+func printControlStatus(s *control.Status) {
+	i18n.Printf("PID:       %s (%s)\n", strconv.Itoa(s.PID), s.Command)
+	i18n.Printf("State:     %s\n", s.State)
+	if s.Total > 0 {
+		i18n.Printf("Progress:  %s / %s (%.1f%%)\n", utils.FormatBytes(s.Bytes), utils.FormatBytes(s.Total), float64(s.Bytes)*100/float64(s.Total))
+	} else {
+		i18n.Printf("Progress:  %s\n", utils.FormatBytes(s.Bytes))
+	}
+	i18n.Printf("Speed:     %s/s (average)\n", utils.FormatBytes(s.Speed))
+	i18n.Printf("Elapsed:   %s\n", (time.Duration(s.Elapsed) * time.Second).String())
+	i18n.Printf("IO limit:  %s\n", rate.FormatLimit(s.IOLimit))
+}
@@ -1,24 +1,30 @@
 package cmd
 
 import (
-	"backup-helper/internal/ai"
 	"backup-helper/internal/check"
 	"backup-helper/internal/config"
 	"backup-helper/internal/extract"
 	"backup-helper/internal/log"
 	"backup-helper/internal/progress"
-	"backup-helper/internal/rate"
 	"backup-helper/internal/transfer"
 	"backup-helper/internal/utils"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"time"
 
-	"github.com/fatih/color"
 	"github.com/gioco-play/easy-i18n/i18n"
 )
 
+// partialSuffix marks incomplete framed downloads; they are renamed once the trailer verifies
+const partialSuffix = ".partial"
+
+// stagingDirName is the subdirectory of --target-dir a framed extraction is written to;
+// its contents are moved into the target directory once the trailer verifies
+const stagingDirName = ".backup-helper" + partialSuffix
+
 // HandleDownload handles the download command
 func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags *config.Flags) error {
 	// Pre-check for download mode
@@ -34,16 +40,23 @@ func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags
 	}
 	if hasCriticalError {
 		i18n.Printf("\n[ERROR] Pre-flight checks failed. Please fix the errors above before proceeding.\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	// Create log context
 	logCtx, err := log.NewLogContext(cfg.LogDir, cfg.LogFileName)
 	if err != nil {
 		i18n.Printf("Failed to create log context: %v\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 	defer logCtx.Close()
+	logSecretSources(cfg, logCtx)
+	logEvents(logCtx)
+	mc := startMetrics(cfg, "download", "download", logCtx)
+	defer mc.Stop()
+	hookRunner := startHooks(cfg, "download", "stream", logCtx)
+	notifier := startNotify(cfg, "download", "stream", "download", logCtx)
+	defer notifier.Close()
 
 	// Display header (only if not outputting to stdout)
 	outputPath := flags.DownloadOutput
@@ -55,6 +68,13 @@ func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags
 		timestamp := time.Now().Format("20060102150405")
 		outputPath = fmt.Sprintf("backup_%s.xb", timestamp)
 	}
+	if flags.TargetDir != "" {
+		notifier.SetObject(flags.TargetDir)
+		hookRunner.Set("TARGET_DIR", flags.TargetDir)
+	} else if outputPath != "-" {
+		notifier.SetObject(outputPath)
+		hookRunner.Set("OUTPUT", outputPath)
+	}
 
 	if outputPath != "-" {
 		utils.OutputHeader()
@@ -90,6 +110,7 @@ func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags
 		} else {
 			i18n.Fprintf(os.Stderr, "[backup-helper] IO rate limit set to: %s/s (default)\n", utils.FormatBytes(cfg.GetRateLimit()))
 		}
+		printIOLimitSchedule(cfg, os.Stderr)
 	} else {
 		// Output to stdout when saving to file
 		if cfg.IOLimit == -1 {
@@ -99,15 +120,56 @@ func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags
 		} else {
 			i18n.Printf("[backup-helper] IO rate limit set to: %s/s (default)\n", utils.FormatBytes(cfg.GetRateLimit()))
 		}
+		printIOLimitSchedule(cfg, os.Stdout)
+	}
+
+	// The download phase covers waiting for the sender, so every failure from here on is reported
+	progress.PhaseStart("download")
+
+	// TLS: connecting side acts as TLS client, listening side as TLS server
+	tlsConfig, err := streamTLSConfig(cfg, streamHost != "" && streamPort > 0)
+	if err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "TLS configuration error: %v", err)
+		i18n.Fprintf(os.Stderr, "TLS configuration error: %v\n", err)
+		failPhase("download", err)
+	}
+	streamOpts := transfer.StreamOptions{
+		EnableHandshake: enableHandshake,
+		HandshakeKey:    streamKey,
+		CompressType:    downloadCompressType,
+		Framed:          cfg.StreamFramed,
+		TLSConfig:       tlsConfig,
+		Connections:     cfg.StreamConnections,
+	}
+	// A framed download saved as-is can resume from <output>.partial; the sender decides in the handshake
+	if cfg.StreamFramed && enableHandshake && !flags.ReadStdin && flags.TargetDir == "" && outputPath != "-" && downloadCompressType != "zstd" {
+		resume, err := transfer.NewReceiverResumeState(outputPath + partialSuffix)
+		if err != nil {
+			logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Cannot resume from %s: %v", outputPath+partialSuffix, err)
+		} else {
+			streamOpts.Resume = resume
+			if resume.Offset > 0 {
+				logCtx.WriteLog("DOWNLOAD", "Found partial download %s with %d bytes, requesting resume", outputPath+partialSuffix, resume.Offset)
+				i18n.Fprintf(os.Stderr, "[backup-helper] Found partial download %s (%s), requesting resume\n", outputPath+partialSuffix, utils.FormatBytes(resume.Offset))
+			}
+		}
 	}
 
 	// Start TCP receiver or client based on stream-host
-	isCompressed := downloadCompressType != ""
 	var receiver io.ReadCloser
 	var tracker *progress.ProgressTracker
 	var closer func()
 
-	if streamHost != "" && streamPort > 0 {
+	if flags.ReadStdin {
+		// Stdin mode: the sender tunnels the stream through SSH (--ssh-tunnel)
+		logCtx.WriteLog("DOWNLOAD", "Receiving backup stream from stdin")
+		receiver, tracker, closer, err = transfer.StartStdinReceiver(effective.EstimatedSize, streamOpts, logCtx)
+		if err != nil {
+			logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Stdin receiver error: %v", err)
+			i18n.Fprintf(os.Stderr, "Stdin receiver error: %v\n", err)
+			failPhase("download", err)
+		}
+	} else if streamHost != "" && streamPort > 0 {
 		// Active mode: connect to remote server to pull data
 		logCtx.WriteLog("DOWNLOAD", "Connecting to remote server %s:%d to pull data", streamHost, streamPort)
 		if outputPath == "-" {
@@ -115,42 +177,49 @@ func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags
 		} else {
 			i18n.Printf("[backup-helper] Connecting to %s:%d...\n", streamHost, streamPort)
 		}
-		receiver, tracker, closer, _, err = transfer.StartStreamClientReader(streamHost, streamPort, enableHandshake, streamKey, effective.EstimatedSize, isCompressed, logCtx)
+		receiver, tracker, closer, _, err = transfer.StartStreamClientReader(streamHost, streamPort, effective.EstimatedSize, streamOpts, logCtx)
 		if err != nil {
-			logCtx.WriteLog("DOWNLOAD", "Stream client error: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Stream client error: %v", err)
 			if outputPath == "-" {
 				i18n.Fprintf(os.Stderr, "Stream client error: %v\n", err)
 			} else {
 				i18n.Printf("Stream client error: %v\n", err)
 			}
-			os.Exit(1)
+			failPhase("download", err)
 		}
 	} else {
 		// Passive mode: listen locally and wait for connection
 		logCtx.WriteLog("DOWNLOAD", "Starting TCP receiver on port %d", streamPort)
 		var actualPort int
 		var localIP string
-		receiver, tracker, closer, actualPort, localIP, err = transfer.StartStreamReceiver(streamPort, enableHandshake, streamKey, effective.EstimatedSize, isCompressed, cfg.Timeout, logCtx)
+		receiver, tracker, closer, actualPort, localIP, err = transfer.StartStreamReceiver(streamPort, effective.EstimatedSize, cfg.Timeout, streamOpts, logCtx)
 		_ = actualPort // Port info already displayed in StartStreamReceiver
 		_ = localIP    // IP info already displayed in StartStreamReceiver
 		if err != nil {
-			logCtx.WriteLog("DOWNLOAD", "Stream receiver error: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Stream receiver error: %v", err)
 			if outputPath == "-" {
 				i18n.Fprintf(os.Stderr, "Stream receiver error: %v\n", err)
 			} else {
 				i18n.Printf("Stream receiver error: %v\n", err)
 			}
-			os.Exit(1)
+			failPhase("download", err)
 		}
 	}
 	defer closer() // This will call tracker.Complete() internally
 
 	// Apply rate limiting if configured
-	var reader io.Reader = receiver
-	rateLimit := cfg.GetRateLimit()
-	if rateLimit > 0 {
-		rateLimitedReader := rate.NewRateLimitedReader(receiver, rateLimit)
-		reader = rateLimitedReader
+	ctl := startControl(cfg, "download", logCtx)
+	defer ctl.Close()
+	ctl.SetTotal(effective.EstimatedSize)
+	reader, stopSchedule := limitedReader(cfg, receiver, ctl, logCtx)
+	defer stopSchedule()
+	reader = ctl.Reader(reader)
+
+	// Framed protocol: success requires a verified end-of-stream trailer
+	var frameReader *transfer.FrameReader
+	if cfg.StreamFramed {
+		frameReader = transfer.NewFrameReader(reader)
+		reader = frameReader
 	}
 
 	// Determine output destination and handle extraction
@@ -158,7 +227,7 @@ func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags
 		// Extraction mode: decompress (if needed) and extract
 		if outputPath == "-" {
 			i18n.Printf("Error: --target-dir cannot be used with --output -\n")
-			os.Exit(1)
+			failPhase("download", fmt.Errorf("--target-dir cannot be used with --output -"))
 		}
 
 		// Check if target directory exists and is not empty
@@ -166,32 +235,32 @@ func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags
 			if info.IsDir() {
 				empty, err := utils.IsDirEmpty(flags.TargetDir)
 				if err != nil {
-					logCtx.WriteLog("DOWNLOAD", "Failed to check target directory: %v", err)
+					logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Failed to check target directory: %v", err)
 					i18n.Printf("Error: Failed to check target directory: %v\n", err)
-					os.Exit(1)
+					failPhase("download", err)
 				}
 				if !empty {
 					// Directory exists and is not empty, ask user for confirmation
 					if !utils.PromptOverwrite(flags.TargetDir, flags.AutoYes) {
 						logCtx.WriteLog("DOWNLOAD", "User cancelled extraction to non-empty directory: %s", flags.TargetDir)
 						i18n.Printf("Extraction cancelled.\n")
-						os.Exit(0)
+						exit(0)
 					}
 					logCtx.WriteLog("DOWNLOAD", "User confirmed overwrite for directory: %s", flags.TargetDir)
 					i18n.Printf("Clearing target directory...\n")
 					logCtx.WriteLog("DOWNLOAD", "Clearing target directory: %s", flags.TargetDir)
 					if err := utils.ClearDirectory(flags.TargetDir); err != nil {
-						logCtx.WriteLog("DOWNLOAD", "Failed to clear target directory: %v", err)
+						logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Failed to clear target directory: %v", err)
 						i18n.Printf("Error: Failed to clear target directory: %v\n", err)
-						os.Exit(1)
+						failPhase("download", err)
 					}
 					logCtx.WriteLog("DOWNLOAD", "Target directory cleared successfully")
 					i18n.Printf("Target directory cleared. Proceeding with extraction...\n")
 				}
 			} else {
-				logCtx.WriteLog("DOWNLOAD", "Target path exists but is not a directory: %s", flags.TargetDir)
+				logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Target path exists but is not a directory: %s", flags.TargetDir)
 				i18n.Printf("Error: Target path '%s' exists but is not a directory\n", flags.TargetDir)
-				os.Exit(1)
+				failPhase("download", fmt.Errorf("target path %s exists but is not a directory", flags.TargetDir))
 			}
 		}
 
@@ -211,10 +280,29 @@ func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags
 		i18n.Printf("[backup-helper] Extracting to directory: %s\n", flags.TargetDir)
 		logCtx.WriteLog("DOWNLOAD", "Extracting to directory: %s", flags.TargetDir)
 
-		err := extract.ExtractBackupStream(reader, downloadCompressType, flags.TargetDir, outputPath, cfg.Parallel, cfg, logCtx)
+		// Framed streams extract into a staging subdirectory, so that a failed run leaves
+		// only what it wrote apart from the target directory
+		extractDir := flags.TargetDir
+		if frameReader != nil {
+			extractDir = filepath.Join(flags.TargetDir, stagingDirName)
+			os.RemoveAll(extractDir)
+		}
+		err := extract.ExtractBackupStream(reader, downloadCompressType, extractDir, outputPath, cfg.Parallel, cfg, logCtx)
+		if ferr := checkFramedStream(frameReader, logCtx); ferr != nil {
+			err = ferr
+		}
+		if err == nil && extractDir != flags.TargetDir {
+			err = promoteStagingDir(extractDir, flags.TargetDir, logCtx)
+		}
+		progress.PhaseEnd("download", err)
 		if err != nil {
-			logCtx.WriteLog("EXTRACT", "Extraction error: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "EXTRACT", nil, "Extraction error: %v", err)
+			if extractDir != flags.TargetDir {
+				logCtx.WriteLog("DOWNLOAD", "Incomplete extraction kept in %s", extractDir)
+				i18n.Printf("Incomplete extraction kept in: %s\n", extractDir)
+			}
 			// Read log content for error extraction
+			logCtx.Flush()
 			logContent, err2 := os.ReadFile(logCtx.GetFileName())
 			if err2 == nil {
 				errorSummary := log.ExtractErrorSummary("EXTRACT", string(logContent))
@@ -223,6 +311,7 @@ func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags
 				} else {
 					i18n.Printf("Extraction error: %v\n", err)
 				}
+				printKnownErrors(logCtx, string(logContent))
 			} else {
 				i18n.Printf("Extraction error: %v\n", err)
 			}
@@ -231,21 +320,10 @@ func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags
 			// Prompt for AI diagnosis
 			if flags.AIDiagnoseFlag == "on" {
 				if utils.PromptAIDiagnosis(flags.AutoYes) {
-					if cfg.QwenAPIKey == "" {
-						i18n.Printf("Qwen API Key is required for AI diagnosis. Please set it in config.\n")
-						os.Exit(1)
-					}
-					logContent, _ := os.ReadFile(logCtx.GetFileName())
-					aiSuggestion, err := ai.DiagnoseWithAliQwen(cfg, "EXTRACT", string(logContent))
-					if err != nil {
-						i18n.Printf("AI diagnosis failed: %v\n", err)
-					} else {
-						fmt.Print(color.YellowString(i18n.Sprintf("AI diagnosis suggestion:\n")))
-						fmt.Println(color.YellowString(aiSuggestion))
-					}
+					diagnose(cfg, "EXTRACT", logCtx, flags.AIPreview)
 				}
 			}
-			os.Exit(1)
+			exit(1)
 		}
 		i18n.Printf("[backup-helper] Extraction completed to: %s\n", flags.TargetDir)
 		logCtx.WriteLog("DOWNLOAD", "Extraction completed successfully")
@@ -263,9 +341,9 @@ func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags
 			// Decompress zstd stream for piping to xbstream
 			decompressedReader, decompressCmd, err := extract.ExtractBackupStreamToStdout(reader, downloadCompressType, cfg.Parallel, logCtx)
 			if err != nil {
-				logCtx.WriteLog("DECOMPRESS", "Decompression error: %v", err)
+				logCtx.WriteLogLevel(log.LevelError, "DECOMPRESS", nil, "Decompression error: %v", err)
 				i18n.Fprintf(os.Stderr, "Decompression error: %v\n", err)
-				os.Exit(1)
+				failPhase("download", err)
 			}
 			if decompressCmd != nil {
 				defer decompressCmd.Wait()
@@ -277,55 +355,193 @@ func HandleDownload(cfg *config.Config, effective *config.EffectiveValues, flags
 		}
 
 		_, err = io.Copy(os.Stdout, reader)
+		if ferr := checkFramedStream(frameReader, logCtx); ferr != nil {
+			err = ferr
+		}
+		progress.PhaseEnd("download", err)
 		if err != nil {
-			// Check if the error is related to connection interruption
-			errStr := err.Error()
-			if utils.Contains(errStr, "connection closed unexpectedly") || utils.Contains(errStr, "EOF") || utils.Contains(errStr, "broken pipe") {
-				logCtx.WriteLog("TCP", "Connection interrupted during transfer: %v", err)
-				i18n.Fprintf(os.Stderr, "Transfer interrupted: connection closed unexpectedly\n")
-				i18n.Fprintf(os.Stderr, "Error details: %v\n", err)
-			} else {
-				logCtx.WriteLog("DOWNLOAD", "Download error: %v", err)
-				i18n.Fprintf(os.Stderr, "Download error: %v\n", err)
-			}
+			reportStreamError(os.Stderr, err, logCtx)
 			i18n.Fprintf(os.Stderr, "Log file: %s\n", logCtx.GetFileName())
-			os.Exit(1)
+			exit(1)
 		}
 		// Progress tracker will display completion message via closer()
 	} else {
 		// Write to file
 		i18n.Printf("[backup-helper] Receiving backup data and saving to: %s\n", outputPath)
 		logCtx.WriteLog("DOWNLOAD", "Saving backup data to: %s", outputPath)
+
+		// Framed protocol: write to <output>.partial and rename once the trailer verifies
+		saveTo := outputPath
+		if frameReader != nil {
+			saveTo = outputPath + partialSuffix
+		}
 		if downloadCompressType == "zstd" {
-			// Save decompressed zstd stream
-			err := extract.ExtractBackupStream(reader, downloadCompressType, "", outputPath, cfg.Parallel, cfg, logCtx)
+			// Save decompressed zstd stream (decompressed output cannot be resumed, start over)
+			if frameReader != nil {
+				os.Remove(saveTo)
+			}
+			err := extract.ExtractBackupStream(reader, downloadCompressType, "", saveTo, cfg.Parallel, cfg, logCtx)
+			if ferr := checkFramedStream(frameReader, logCtx); ferr != nil {
+				err = ferr
+			}
 			if err != nil {
-				logCtx.WriteLog("EXTRACT", "Save error: %v", err)
-				i18n.Printf("Save error: %v\n", err)
-				os.Exit(1)
+				progress.PhaseEnd("download", err)
+				logCtx.WriteLogLevel(log.LevelError, "EXTRACT", nil, "Save error: %v", err)
+				reportStreamError(os.Stdout, err, logCtx)
+				failPartialDownload(saveTo, frameReader != nil, err, logCtx)
 			}
 		} else {
 			// Save as-is
-			file, err := os.Create(outputPath)
+			file, err := createOutputFile(saveTo, streamOpts.Resume, logCtx)
 			if err != nil {
-				logCtx.WriteLog("DOWNLOAD", "Failed to create output file: %v", err)
+				logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Failed to create output file: %v", err)
 				i18n.Printf("Failed to create output file: %v\n", err)
-				os.Exit(1)
+				failPhase("download", err)
 			}
 			defer file.Close()
 
+			// After a resume the trailer covers the whole file: hash what is already there first
+			if resume := streamOpts.Resume; frameReader != nil && resume != nil && resume.Offset > 0 {
+				if err := frameReader.ResumeFrom(io.NewSectionReader(file, 0, resume.Offset), resume.Offset); err != nil {
+					logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Resume error: %v", err)
+					i18n.Printf("Download error: %v\n", err)
+					failPhase("download", err)
+				}
+			}
+
 			_, err = io.Copy(file, reader)
+			if ferr := checkFramedStream(frameReader, logCtx); ferr != nil {
+				err = ferr
+			}
 			if err != nil {
-				logCtx.WriteLog("DOWNLOAD", "Failed to save backup data: %v", err)
+				progress.PhaseEnd("download", err)
+				logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Failed to save backup data: %v", err)
+				reportStreamError(os.Stdout, err, logCtx)
+				// Keep what was received durable so that the next run can resume from it
+				file.Sync()
+				file.Close()
+				failPartialDownload(saveTo, frameReader != nil, err, logCtx)
+			}
+			if err := file.Close(); err != nil {
+				logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Failed to close output file: %v", err)
+				i18n.Printf("Download error: %v\n", err)
+				failPhase("download", err)
+			}
+		}
+		if saveTo != outputPath {
+			if err := os.Rename(saveTo, outputPath); err != nil {
+				logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Failed to rename %s to %s: %v", saveTo, outputPath, err)
 				i18n.Printf("Download error: %v\n", err)
-				os.Exit(1)
+				failPhase("download", err)
 			}
+			logCtx.WriteLog("DOWNLOAD", "Verified download renamed: %s -> %s", saveTo, outputPath)
 		}
+		progress.PhaseEnd("download", nil)
 		// Progress tracker will display completion message via closer()
 		i18n.Printf("[backup-helper] Download completed! Saved to: %s\n", outputPath)
 		logCtx.WriteLog("DOWNLOAD", "Download completed successfully")
 		logCtx.MarkSuccess()
 		i18n.Printf("[backup-helper] Log file: %s\n", logCtx.GetFileName())
 	}
+
+	if err := hookRunner.Run(config.HookPostDownload); err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "HOOK", nil, "Download failed: %v", err)
+		i18n.Fprintf(os.Stderr, "Download failed: %v\n", err)
+		exit(1)
+	}
+	return nil
+}
+
+// checkFramedStream returns nil when the stream is not framed or its trailer has been verified,
+// otherwise the framing error (truncated, corrupt, or backup failed on the sender)
+func checkFramedStream(frameReader *transfer.FrameReader, logCtx *log.LogContext) error {
+	if frameReader == nil {
+		return nil
+	}
+	if trailer := frameReader.Trailer(); trailer != nil {
+		logCtx.WriteLog("TCP", "End-of-stream trailer verified: %d bytes, sha256=%s", trailer.TotalBytes, trailer.SHA256)
+		return nil
+	}
+	if err := frameReader.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w (received %d bytes)", transfer.ErrStreamTruncated, frameReader.TotalBytes())
+}
+
+// reportStreamError prints a download failure, distinguishing a lost connection,
+// a stream that failed verification and a backup that failed on the sender
+func reportStreamError(w io.Writer, err error, logCtx *log.LogContext) {
+	switch {
+	case errors.Is(err, transfer.ErrSenderFailed):
+		logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Sender reported backup failure: %v", err)
+		i18n.Fprintf(w, "Backup failed on the sender side, received data is incomplete\n")
+	case errors.Is(err, transfer.ErrStreamCorrupt):
+		logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Stream verification failed: %v", err)
+		i18n.Fprintf(w, "Stream verification failed: received data does not match the end-of-stream trailer\n")
+	case transfer.IsConnectionInterrupted(err):
+		logCtx.WriteLog("TCP", "Connection interrupted during transfer: %v", err)
+		i18n.Fprintf(w, "Transfer interrupted: connection closed unexpectedly\n")
+	default:
+		logCtx.WriteLogLevel(log.LevelError, "DOWNLOAD", nil, "Download error: %v", err)
+		i18n.Fprintf(w, "Download error: %v\n", err)
+		return
+	}
+	i18n.Fprintf(w, "Error details: %v\n", err)
+}
+
+// failPartialDownload reports an incomplete download and exits.
+// With the framed protocol the data was written to <output>.partial, so it is never mistaken for a complete backup;
+// a partial file that failed verification is removed, so that the next run starts over.
+func failPartialDownload(savedTo string, framed bool, err error, logCtx *log.LogContext) {
+	if framed && errors.Is(err, transfer.ErrStreamCorrupt) {
+		// Data that failed verification must not be resumed from
+		os.Remove(savedTo)
+		logCtx.WriteLog("DOWNLOAD", "Removed %s: it does not match the data the sender verified", savedTo)
+	} else if framed {
+		logCtx.WriteLog("DOWNLOAD", "Incomplete download kept at %s", savedTo)
+		i18n.Printf("Incomplete download kept at: %s\n", savedTo)
+	}
+	i18n.Printf("Log file: %s\n", logCtx.GetFileName())
+	exit(1)
+}
+
+// createOutputFile opens the download output file. When the handshake agreed on a resume
+// offset, the partial file is truncated to that offset and new data is appended after it.
+func createOutputFile(path string, resume *transfer.ResumeState, logCtx *log.LogContext) (*os.File, error) {
+	if resume == nil || resume.Offset == 0 {
+		return os.Create(path)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(resume.Offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(resume.Offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	logCtx.WriteLog("DOWNLOAD", "Resuming %s at offset %d", path, resume.Offset)
+	return file, nil
+}
+
+// promoteStagingDir moves the verified contents of a staging directory into the target directory
+// and removes the staging directory
+func promoteStagingDir(staging, targetDir string, logCtx *log.LogContext) error {
+	entries, err := os.ReadDir(staging)
+	if err != nil {
+		return fmt.Errorf("failed to read staging directory %s: %v", staging, err)
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(staging, entry.Name()), filepath.Join(targetDir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to move %s into %s: %v", entry.Name(), targetDir, err)
+		}
+	}
+	if err := os.Remove(staging); err != nil {
+		return fmt.Errorf("failed to remove staging directory %s: %v", staging, err)
+	}
+	logCtx.WriteLog("DOWNLOAD", "Verified extraction moved from %s to %s", staging, targetDir)
 	return nil
 }
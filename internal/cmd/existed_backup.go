@@ -5,9 +5,10 @@ import (
 	"backup-helper/internal/config"
 	"backup-helper/internal/log"
 	"backup-helper/internal/mysql"
-	"backup-helper/internal/rate"
+	"backup-helper/internal/progress"
 	"backup-helper/internal/transfer"
 	"backup-helper/internal/utils"
+	"fmt"
 	"io"
 	"os"
 	"time"
@@ -21,9 +22,20 @@ func HandleExistedBackup(cfg *config.Config, effective *config.EffectiveValues,
 	logCtx, err := log.NewLogContext(cfg.LogDir, cfg.LogFileName)
 	if err != nil {
 		i18n.Printf("Failed to create log context: %v\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 	defer logCtx.Close()
+	logSecretSources(cfg, logCtx)
+	logEvents(logCtx)
+	mainPhase := "upload"
+	if flags.Mode == "stream" {
+		mainPhase = "stream"
+	}
+	mc := startMetrics(cfg, "existed-backup", mainPhase, logCtx)
+	defer mc.Stop()
+	startHooks(cfg, "existed-backup", flags.Mode, logCtx) // Only on-failure hooks apply
+	notifier := startNotify(cfg, "existed-backup", flags.Mode, mainPhase, logCtx)
+	defer notifier.Close()
 
 	// upload existed backup file to OSS or stream via TCP
 	logCtx.WriteLog("BACKUP", "Processing existing backup file")
@@ -37,24 +49,27 @@ func HandleExistedBackup(cfg *config.Config, effective *config.EffectiveValues,
 		// Validate data from stdin
 		backupInfo, err2 = backup.ValidateBackupFileFromStdin()
 		if err2 != nil {
+			logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Validation error: %v", err2)
 			i18n.Printf("Validation error: %v\n", err2)
-			os.Exit(1)
+			failPhase(mainPhase, err2)
 		}
 		backup.PrintBackupFileValidationFromStdin(backupInfo)
 	} else {
 		// Validate file
 		backupInfo, err2 = backup.ValidateBackupFile(effective.ExistedBackup)
 		if err2 != nil {
+			logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Validation error: %v", err2)
 			i18n.Printf("Validation error: %v\n", err2)
-			os.Exit(1)
+			failPhase(mainPhase, err2)
 		}
 		backup.PrintBackupFileValidation(effective.ExistedBackup, backupInfo)
 	}
 
 	// Exit if backup file is invalid
 	if !backupInfo.IsValid {
+		logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Cannot proceed with invalid backup file")
 		i18n.Printf("[backup-helper] Cannot proceed with invalid backup file.\n")
-		os.Exit(1)
+		failPhase(mainPhase, fmt.Errorf("invalid backup file"))
 	}
 
 	// Display IO limit after validation
@@ -65,9 +80,11 @@ func HandleExistedBackup(cfg *config.Config, effective *config.EffectiveValues,
 	} else {
 		i18n.Printf("[backup-helper] IO rate limit set to: %s/s (default)\n", utils.FormatBytes(cfg.GetRateLimit()))
 	}
+	printIOLimitSchedule(cfg, os.Stdout)
 
 	// Get reader from existing backup file or stdin
 	var reader io.Reader
+	var sourceFile *os.File // Seekable source, allows resuming framed streams
 	if effective.ExistedBackup == "-" {
 		// Read from stdin (for cat command)
 		reader = os.Stdin
@@ -76,11 +93,13 @@ func HandleExistedBackup(cfg *config.Config, effective *config.EffectiveValues,
 		// Read from file
 		file, err := os.Open(effective.ExistedBackup)
 		if err != nil {
+			logCtx.WriteLogLevel(log.LevelError, "BACKUP", nil, "Open backup file error: %v", err)
 			i18n.Printf("Open backup file error: %v\n", err)
-			os.Exit(1)
+			failPhase(mainPhase, err)
 		}
 		defer file.Close()
 		reader = file
+		sourceFile = file
 		i18n.Printf("[backup-helper] Reading backup data from file: %s\n", effective.ExistedBackup)
 	}
 
@@ -99,6 +118,9 @@ func HandleExistedBackup(cfg *config.Config, effective *config.EffectiveValues,
 	}
 	timestamp := time.Now().Format("_20060102150405")
 	fullObjectName := ossObjectName + timestamp + objectSuffix
+	if flags.Mode == "oss" {
+		notifier.SetObject(fullObjectName)
+	}
 
 	// Calculate total size for existing backup
 	var totalSize int64
@@ -119,15 +141,25 @@ func HandleExistedBackup(cfg *config.Config, effective *config.EffectiveValues,
 		i18n.Printf("[backup-helper] Uploading from stdin, size unknown\n")
 	}
 
+	// Pause, resume and cancel requests act on the backup data read
+	ctl := startControl(cfg, "existed-backup", logCtx)
+	defer ctl.Close()
+	if cfg.CompressType == "" {
+		ctl.SetTotal(totalSize)
+	}
+	reader = ctl.Reader(reader)
+
 	switch flags.Mode {
 	case "oss":
 		i18n.Printf("[backup-helper] Uploading existing backup to OSS...\n")
 		isCompressed := cfg.CompressType != ""
+		progress.PhaseStart("upload")
 		err := transfer.UploadReaderToOSS(cfg, fullObjectName, reader, totalSize, isCompressed, logCtx)
 		if err != nil {
 			i18n.Printf("OSS upload error: %v\n", err)
-			os.Exit(1)
+			failPhase("upload", err)
 		}
+		progress.PhaseEnd("upload", nil)
 		i18n.Printf("[backup-helper] OSS upload completed!\n")
 		logCtx.MarkSuccess()
 	case "stream":
@@ -162,7 +194,7 @@ func HandleExistedBackup(cfg *config.Config, effective *config.EffectiveValues,
 					streamPort = cfg.StreamPort
 				} else {
 					i18n.Printf("Error: --stream-port is required when using --stream-host\n")
-					os.Exit(1)
+					failPhase(mainPhase, fmt.Errorf("--stream-port is required when using --stream-host"))
 				}
 			}
 		}
@@ -175,21 +207,42 @@ func HandleExistedBackup(cfg *config.Config, effective *config.EffectiveValues,
 		var closer func()
 		var err error
 
+		tlsConfig, err := streamTLSConfig(cfg, streamHost != "")
+		if err != nil {
+			logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "TLS configuration error: %v", err)
+			i18n.Printf("TLS configuration error: %v\n", err)
+			failPhase(mainPhase, err)
+		}
+		streamOpts := transfer.StreamOptions{
+			EnableHandshake: enableHandshake,
+			HandshakeKey:    streamKey,
+			CompressType:    cfg.CompressType,
+			Framed:          cfg.StreamFramed,
+			TLSConfig:       tlsConfig,
+			Connections:     cfg.StreamConnections,
+			Instance:        streamInstanceName(cfg),
+		}
+		// A framed stream from a file can continue where the receiver's partial output ends
+		if cfg.StreamFramed && enableHandshake && sourceFile != nil {
+			streamOpts.Resume = &transfer.ResumeState{Source: sourceFile}
+		}
+
+		// The stream phase covers connecting to the receiver, so that a failed connect is reported in it
+		progress.PhaseStart("stream")
 		if streamHost != "" {
 			// Active connection: connect to remote server
 			logCtx.WriteLog("TCP", "Active push mode: connecting to %s:%d", streamHost, streamPort)
-			isCompressed := cfg.CompressType != ""
-			writer, _, closer, _, err = transfer.StartStreamClient(streamHost, streamPort, enableHandshake, streamKey, totalSize, isCompressed, logCtx)
+			writer, _, closer, _, err = transfer.StartStreamClient(streamHost, streamPort, totalSize, streamOpts, logCtx)
 			if err != nil {
 				i18n.Printf("Stream client error: %v\n", err)
-				os.Exit(1)
+				failPhase("stream", err)
 			}
 		} else {
 			// Passive connection: listen locally and wait for connection
-			tcpWriter, _, closerFunc, _, _, err := transfer.StartStreamSender(streamPort, enableHandshake, streamKey, totalSize, cfg.CompressType != "", cfg.Timeout, logCtx)
+			tcpWriter, _, closerFunc, _, _, err := transfer.StartStreamSender(streamPort, totalSize, cfg.Timeout, streamOpts, logCtx)
 			if err != nil {
 				i18n.Printf("Stream server error: %v\n", err)
-				os.Exit(1)
+				failPhase("stream", err)
 			}
 			writer = tcpWriter
 			closer = closerFunc
@@ -197,27 +250,52 @@ func HandleExistedBackup(cfg *config.Config, effective *config.EffectiveValues,
 		defer closer()
 
 		// Apply rate limiting for stream mode if configured
-		var finalWriter io.WriteCloser = writer
-		rateLimit := cfg.GetRateLimit()
-		if rateLimit > 0 {
-			rateLimitedWriter := rate.NewRateLimitedWriter(writer, rateLimit)
-			finalWriter = rateLimitedWriter
-		}
+		finalWriter, stopSchedule := limitedWriter(cfg, writer, ctl, logCtx)
+		defer stopSchedule()
 
 		// Stream the backup data
 		i18n.Printf("[backup-helper] Streaming backup data...\n")
 
-		_, err = io.Copy(finalWriter, reader)
+		// Framed protocol: encode data frames and append the trailer (an existing backup has exit status 0)
+		var dst io.Writer = finalWriter
+		var frameWriter *transfer.FrameWriter
+		if cfg.StreamFramed {
+			frameWriter = transfer.NewFrameWriter(finalWriter)
+			dst = frameWriter
+		}
+
+		// After a resume the trailer still covers the whole file, so the receiver verifies it end to end
+		if resume := streamOpts.Resume; frameWriter != nil && resume != nil && resume.Offset > 0 {
+			if err := frameWriter.ResumeFrom(io.NewSectionReader(sourceFile, 0, resume.Offset), resume.Offset); err != nil {
+				logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Resume error: %v", err)
+				i18n.Printf("TCP stream error: %v\n", err)
+				failPhase("stream", err)
+			}
+		}
+		_, err = io.Copy(dst, reader)
 		if err != nil {
 			i18n.Printf("TCP stream error: %v\n", err)
-			os.Exit(1)
+			failPhase("stream", err)
+		}
+		if frameWriter != nil {
+			if err := writeStreamTrailer(frameWriter, 0, logCtx); err != nil {
+				i18n.Printf("TCP stream error: %v\n", err)
+				failPhase("stream", err)
+			}
+		}
+
+		// Flush the stream; with --stream-connections this waits for the blocks still in flight
+		if err := writer.Close(); err != nil {
+			i18n.Printf("TCP stream error: %v\n", err)
+			failPhase("stream", err)
 		}
+		progress.PhaseEnd("stream", nil)
 
 		i18n.Printf("[backup-helper] Stream completed!\n")
 		logCtx.MarkSuccess()
 	default:
 		i18n.Printf("Unknown mode: %s\n", flags.Mode)
-		os.Exit(1)
+		failPhase(mainPhase, fmt.Errorf("unknown mode: %s", flags.Mode))
 	}
 	return nil
 }
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"backup-helper/internal/backup"
+	"backup-helper/internal/config"
+	"backup-helper/internal/control"
+	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
+	"backup-helper/internal/transfer"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gioco-play/easy-i18n/i18n"
+)
+
+// sinkSpec is one parsed entry of cfg.Sinks
+type sinkSpec struct {
+	Kind string // "oss", "stream" or "file"
+	Host string // stream: remote host
+	Port int    // stream: remote port
+	Path string // file: output file or directory
+}
+
+func (s sinkSpec) String() string {
+	switch s.Kind {
+	case "stream":
+		return "stream " + net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	case "file":
+		return "file " + s.Path
+	}
+	return s.Kind
+}
+
+// target identifies where a sink writes: one OSS object, one receiver or one file path
+func (s sinkSpec) target() string {
+	switch s.Kind {
+	case "stream":
+		return "stream " + net.JoinHostPort(strings.ToLower(s.Host), strconv.Itoa(s.Port))
+	case "file":
+		return "file " + filepath.Clean(s.Path)
+	}
+	return s.Kind
+}
+
+// parseSinks validates the fan-out configuration before the backup starts.
+// Accepted sinks: "oss", "stream://host:port" and "file:///path" (file or directory).
+func parseSinks(cfg *config.Config) ([]sinkSpec, error) {
+	switch cfg.SinkFailurePolicy {
+	case "", transfer.SinkPolicyAbort, transfer.SinkPolicyContinue:
+	default:
+		return nil, fmt.Errorf("invalid sink failure policy %q (expected %s or %s)", cfg.SinkFailurePolicy, transfer.SinkPolicyAbort, transfer.SinkPolicyContinue)
+	}
+
+	var specs []sinkSpec
+	seen := make(map[string]string)
+	for _, raw := range cfg.Sinks {
+		raw = strings.TrimSpace(raw)
+		var spec sinkSpec
+		switch {
+		case raw == "oss":
+			spec = sinkSpec{Kind: "oss"}
+		case strings.HasPrefix(raw, "stream://"):
+			host, portStr, err := net.SplitHostPort(strings.TrimPrefix(raw, "stream://"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid stream sink %q: %v", raw, err)
+			}
+			port, err := strconv.Atoi(portStr)
+			if err != nil || port <= 0 || port > 65535 || host == "" {
+				return nil, fmt.Errorf("invalid stream sink %q: expected stream://host:port", raw)
+			}
+			spec = sinkSpec{Kind: "stream", Host: host, Port: port}
+		case strings.HasPrefix(raw, "file://"):
+			path := strings.TrimPrefix(raw, "file://")
+			if path == "" {
+				return nil, fmt.Errorf("invalid file sink %q: empty path", raw)
+			}
+			spec = sinkSpec{Kind: "file", Path: path}
+		default:
+			return nil, fmt.Errorf("invalid sink %q (expected oss, stream://host:port or file:///path)", raw)
+		}
+		// Two sinks writing the same object, receiver or file would overwrite each other
+		key := spec.target()
+		if prev, ok := seen[key]; ok {
+			return nil, fmt.Errorf("duplicate sink %q (same target as %q)", raw, prev)
+		}
+		seen[key] = raw
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// handleFanOutBackup sends the xtrabackup stream to all configured sinks at once
+func handleFanOutBackup(cfg *config.Config, effective *config.EffectiveValues, specs []sinkSpec, fullObjectName string, reader io.Reader, totalSize int64, run *backup.Run, ctl *control.Server, logCtx *log.LogContext) error {
+	policy := cfg.SinkFailurePolicy
+	if policy == "" {
+		policy = transfer.SinkPolicyAbort
+	}
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = spec.String()
+	}
+	i18n.Printf("[backup-helper] Fan-out to %d sinks (failure policy: %s): %s\n", len(specs), policy, strings.Join(names, ", "))
+	logCtx.WriteLog("FANOUT", "Fan-out to %d sinks (failure policy: %s): %s", len(specs), policy, strings.Join(names, ", "))
+
+	// Framed stream sinks need the backup exit status once the stream has ended (Run waits only once)
+	backupStatus := run.ExitStatus
+
+	sinks := make([]transfer.Sink, len(specs))
+	for i, spec := range specs {
+		spec := spec
+		sink := transfer.Sink{Name: spec.String()}
+		switch spec.Kind {
+		case "oss":
+			sink.Consume = func(r io.Reader) error {
+				return consumeOSSSink(cfg, fullObjectName, r, totalSize, logCtx)
+			}
+		case "stream":
+			sink.Consume = func(r io.Reader) error {
+				return consumeStreamSink(cfg, effective, spec, r, totalSize, backupStatus, ctl, logCtx)
+			}
+		case "file":
+			sink.Consume = func(r io.Reader) error {
+				return consumeFileSink(spec, fullObjectName, r, logCtx)
+			}
+		}
+		sinks[i] = sink
+	}
+
+	tracker := progress.NewProgressTrackerWithCompression(totalSize, cfg.CompressType != "")
+	tracker.SetRawCounter(run.Raw)
+	progress.PhaseStart("fanout")
+	results, err := transfer.FanOut(reader, sinks, policy, tracker, logCtx)
+	tracker.Complete()
+
+	for _, r := range results {
+		speed := int64(0)
+		if r.Duration.Seconds() > 0 {
+			speed = int64(float64(r.Bytes) / r.Duration.Seconds())
+		}
+		if r.Err != nil {
+			i18n.Printf("[backup-helper] Sink %s: FAILED after %s: %v\n", r.Name, progress.FormatBytes(r.Bytes), r.Err)
+			logCtx.WriteLogLevel(log.LevelError, "FANOUT", nil, "Sink %s: FAILED after %s: %v", r.Name, progress.FormatBytes(r.Bytes), r.Err)
+		} else {
+			i18n.Printf("[backup-helper] Sink %s: OK, %s in %s (%s/s)\n", r.Name, progress.FormatBytes(r.Bytes), r.Duration.Round(time.Second), progress.FormatBytes(speed))
+			logCtx.WriteLog("FANOUT", "Sink %s: OK, %s in %s (%s/s)", r.Name, progress.FormatBytes(r.Bytes), r.Duration.Round(time.Second), progress.FormatBytes(speed))
+		}
+	}
+	if err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "FANOUT", nil, "Fan-out failed: %v", err)
+		i18n.Printf("Fan-out error: %v\n", err)
+		failBackup(run, "fanout", err)
+	}
+	progress.PhaseEnd("fanout", nil)
+	return nil
+}
+
+// consumeOSSSink uploads one copy of the stream to OSS
+func consumeOSSSink(cfg *config.Config, fullObjectName string, r io.Reader, totalSize int64, logCtx *log.LogContext) error {
+	tracker := progress.NewProgressTrackerWithCompression(totalSize, cfg.CompressType != "")
+	tracker.SetQuiet(true)
+	return transfer.UploadReaderToOSSWithTracker(cfg, fullObjectName, r, totalSize, tracker, logCtx)
+}
+
+// consumeStreamSink pushes one copy of the stream to a receiver, using the stream settings of cfg
+func consumeStreamSink(cfg *config.Config, effective *config.EffectiveValues, spec sinkSpec, r io.Reader, totalSize int64, backupStatus func() int, ctl *control.Server, logCtx *log.LogContext) error {
+	tlsConfig, err := streamTLSConfig(cfg, true)
+	if err != nil {
+		return fmt.Errorf("TLS configuration error: %v", err)
+	}
+	streamOpts := transfer.StreamOptions{
+		EnableHandshake: effective.EnableHandshake,
+		HandshakeKey:    effective.StreamKey,
+		CompressType:    cfg.CompressType,
+		Framed:          cfg.StreamFramed,
+		TLSConfig:       tlsConfig,
+		Connections:     cfg.StreamConnections,
+		Instance:        streamInstanceName(cfg),
+	}
+	writer, tracker, closer, _, err := transfer.StartStreamClient(spec.Host, spec.Port, totalSize, streamOpts, logCtx)
+	if err != nil {
+		return err
+	}
+	tracker.SetQuiet(true)
+	defer closer()
+
+	limited, stopSchedule := limitedWriter(cfg, writer, ctl, logCtx)
+	defer stopSchedule()
+	var dst io.Writer = limited
+	var frameWriter *transfer.FrameWriter
+	if cfg.StreamFramed {
+		frameWriter = transfer.NewFrameWriter(dst)
+		dst = frameWriter
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		return err
+	}
+	if frameWriter != nil {
+		if err := writeStreamTrailer(frameWriter, backupStatus(), logCtx); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// consumeFileSink saves one copy of the stream to a local file.
+// A directory path gets the OSS object name as file name. Data is written to <file>.partial
+// and renamed once the stream is complete.
+func consumeFileSink(spec sinkSpec, fullObjectName string, r io.Reader, logCtx *log.LogContext) error {
+	path := spec.Path
+	if info, err := os.Stat(path); (err == nil && info.IsDir()) || strings.HasSuffix(path, "/") {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		path = filepath.Join(path, filepath.Base(fullObjectName))
+	}
+	partial := path + partialSuffix
+	file, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	logCtx.WriteLog("FANOUT", "Saving copy to %s", path)
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partial, path)
+}
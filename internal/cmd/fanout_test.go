@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"backup-helper/internal/config"
+	"testing"
+)
+
+func TestParseSinks(t *testing.T) {
+	tests := []struct {
+		sinks   []string
+		wantErr bool
+	}{
+		{[]string{"oss", "stream://10.0.0.2:9999", "file:///backup/"}, false},
+		{[]string{"stream://10.0.0.2:9999", "stream://10.0.0.3:9999", "file:///a.xb", "file:///b.xb"}, false},
+		{[]string{"oss", " oss"}, true},
+		{[]string{"stream://Standby:9999", "stream://standby:9999"}, true},
+		{[]string{"file:///backup", "file:///backup/"}, true},
+		{[]string{"file:///backup/./a.xb", "file:///backup/a.xb"}, true},
+		{[]string{"stream://standby"}, true},
+		{[]string{"s3://bucket"}, true},
+	}
+	for _, tt := range tests {
+		_, err := parseSinks(&config.Config{Sinks: tt.sinks})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSinks(%q) error = %v, want error %v", tt.sinks, err, tt.wantErr)
+		}
+	}
+}
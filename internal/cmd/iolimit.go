@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"backup-helper/internal/config"
+	"backup-helper/internal/control"
+	"backup-helper/internal/log"
+	"backup-helper/internal/rate"
+	"io"
+	"time"
+
+	"github.com/gioco-play/easy-i18n/i18n"
+)
+
+// startIOLimitSchedule starts switching limits along ioLimitSchedule, or returns nil when no schedule is set.
+// The caller adds its rate limiters and calls Stop when the transfer ends.
+func startIOLimitSchedule(cfg *config.Config, logCtx *log.LogContext) *rate.Scheduler {
+	if !cfg.HasIOLimitSchedule() {
+		return nil
+	}
+	scheduler := rate.NewScheduler(cfg.GetRateLimitAt, cfg.ActiveIOLimitWindow, logCtx)
+	scheduler.Start(rate.ScheduleCheckInterval)
+	return scheduler
+}
+
+// limitedWriter applies the IO limit of cfg to w, following ioLimitSchedule when one is set, and lets
+// ctl (may be nil) change it live. w is returned as is when there is nothing to limit.
+// Call stop once the transfer is done.
+func limitedWriter(cfg *config.Config, w io.WriteCloser, ctl *control.Server, logCtx *log.LogContext) (limited io.WriteCloser, stop func()) {
+	if scheduler := startIOLimitSchedule(cfg, logCtx); scheduler != nil {
+		rateLimitedWriter := rate.NewRateLimitedWriter(w, scheduler.CurrentLimit())
+		scheduler.Add(rateLimitedWriter)
+		ctl.AddLimiter(rateLimitedWriter)
+		return rateLimitedWriter, scheduler.Stop
+	}
+	if rateLimit := cfg.GetRateLimit(); rateLimit > 0 || ctl != nil {
+		rateLimitedWriter := rate.NewRateLimitedWriter(w, rateLimit)
+		ctl.AddLimiter(rateLimitedWriter)
+		return rateLimitedWriter, func() {}
+	}
+	return w, func() {}
+}
+
+// limitedReader is limitedWriter for the receiving side
+func limitedReader(cfg *config.Config, r io.Reader, ctl *control.Server, logCtx *log.LogContext) (limited io.Reader, stop func()) {
+	if scheduler := startIOLimitSchedule(cfg, logCtx); scheduler != nil {
+		rateLimitedReader := rate.NewRateLimitedReader(r, scheduler.CurrentLimit())
+		scheduler.Add(rateLimitedReader)
+		ctl.AddLimiter(rateLimitedReader)
+		return rateLimitedReader, scheduler.Stop
+	}
+	if rateLimit := cfg.GetRateLimit(); rateLimit > 0 || ctl != nil {
+		rateLimitedReader := rate.NewRateLimitedReader(r, rateLimit)
+		ctl.AddLimiter(rateLimitedReader)
+		return rateLimitedReader, func() {}
+	}
+	return r, func() {}
+}
+
+// printIOLimitSchedule prints the IO limit schedule, if any, after the fixed IO limit display
+func printIOLimitSchedule(cfg *config.Config, w io.Writer) {
+	if cfg.HasIOLimitSchedule() {
+		i18n.Fprintf(w, "[backup-helper] IO rate limit schedule: %s (now: %s, outside windows: IO rate limit above)\n",
+			cfg.IOLimitSchedule, rate.FormatLimit(cfg.GetRateLimitAt(time.Now())))
+	}
+}
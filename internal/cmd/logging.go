@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"backup-helper/internal/config"
+	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
+	"fmt"
+	"time"
+)
+
+// SetupLogging sets the format of the log files of this process, the instance their JSON entries carry
+// and the retention of the logs of earlier runs
+func SetupLogging(cfg *config.Config) error {
+	r := cfg.LogRetention
+	maxSize, err := config.ParseSize(r.MaxSize)
+	if err != nil {
+		return fmt.Errorf("invalid logRetention.maxSize %q: %v", r.MaxSize, err)
+	}
+	log.SetRetention(log.Retention{
+		Keep:         r.Keep,
+		KeepFailed:   r.KeepFailed,
+		MaxAge:       time.Duration(r.MaxAgeDays) * 24 * time.Hour,
+		FailedMaxAge: time.Duration(r.FailedMaxAgeDays) * 24 * time.Hour,
+		MaxSize:      maxSize,
+		Compress:     r.Compress == nil || *r.Compress,
+		Patterns:     r.Patterns,
+	})
+	return log.SetDefaults(cfg.LogFormat, streamInstanceName(cfg))
+}
+
+// logEvents records phases, transfer summaries and retries in a JSON log as entries with fields,
+// so that log pipelines can index durations and sizes without parsing messages
+func logEvents(logCtx *log.LogContext) {
+	if logCtx.Format() != log.FormatJSON {
+		return
+	}
+	progress.AddListener(func(e progress.Event) {
+		fields := log.Fields{"event": e.Event}
+		if e.Phase != "" {
+			fields["phase"] = e.Phase
+		}
+		switch e.Event {
+		case progress.EventPhaseStart:
+			logCtx.WriteLogFields("PHASE", fields, "Phase %s started", e.Phase)
+		case progress.EventPhaseEnd:
+			fields["status"] = e.Status
+			fields["elapsedSeconds"] = e.Elapsed
+			if e.Details != nil {
+				fields["details"] = e.Details
+			}
+			if e.Error != "" {
+				fields["error"] = e.Error
+				logCtx.WriteLogLevel(log.LevelError, "PHASE", fields, "Phase %s failed after %.1fs: %s", e.Phase, e.Elapsed, e.Error)
+			} else {
+				logCtx.WriteLogFields("PHASE", fields, "Phase %s completed in %.1fs", e.Phase, e.Elapsed)
+			}
+		case progress.EventSummary:
+			fields["direction"] = e.Direction
+			fields["bytes"] = e.Bytes
+			fields["elapsedSeconds"] = e.Elapsed
+			fields["speed"] = e.Speed
+			if e.RawBytes > 0 {
+				fields["rawBytes"] = e.RawBytes
+				fields["compressionRatio"] = e.Ratio
+			}
+			logCtx.WriteLogFields("PROGRESS", fields, "Transferred %s in %.1fs (%s/s)",
+				progress.FormatBytes(e.Bytes), e.Elapsed, progress.FormatBytes(e.Speed))
+		case progress.EventRetry:
+			fields["message"] = e.Message
+			logCtx.WriteLogLevel(log.LevelWarn, "PROGRESS", fields, "Retrying %s: %s", e.Phase, e.Message)
+		}
+	})
+}
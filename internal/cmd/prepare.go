@@ -1,18 +1,16 @@
 package cmd
 
 import (
-	"backup-helper/internal/ai"
 	"backup-helper/internal/backup"
 	"backup-helper/internal/check"
 	"backup-helper/internal/config"
 	"backup-helper/internal/log"
 	"backup-helper/internal/mysql"
+	"backup-helper/internal/progress"
 	"backup-helper/internal/utils"
 	"database/sql"
-	"fmt"
 	"os"
 
-	"github.com/fatih/color"
 	"github.com/gioco-play/easy-i18n/i18n"
 	"golang.org/x/term"
 )
@@ -38,27 +36,36 @@ func HandlePrepare(cfg *config.Config, effective *config.EffectiveValues, flags
 	}
 	if hasCriticalError {
 		i18n.Printf("\n[ERROR] Pre-flight checks failed. Please fix the errors above before proceeding.\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	if flags.TargetDir == "" {
 		i18n.Printf("Error: --target-dir is required for --prepare mode\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	// Check if target directory exists
 	if _, err := os.Stat(flags.TargetDir); os.IsNotExist(err) {
 		i18n.Printf("Error: Backup directory does not exist: %s\n", flags.TargetDir)
-		os.Exit(1)
+		exit(1)
 	}
 
 	// Create log context
 	logCtx, err := log.NewLogContext(cfg.LogDir, cfg.LogFileName)
 	if err != nil {
 		i18n.Printf("Failed to create log context: %v\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 	defer logCtx.Close()
+	logSecretSources(cfg, logCtx)
+	logEvents(logCtx)
+	mc := startMetrics(cfg, "prepare", "prepare", logCtx)
+	defer mc.Stop()
+	hookRunner := startHooks(cfg, "prepare", "", logCtx)
+	hookRunner.Set("TARGET_DIR", flags.TargetDir)
+	notifier := startNotify(cfg, "prepare", "", "prepare", logCtx)
+	defer notifier.Close()
+	notifier.SetObject(flags.TargetDir)
 
 	utils.OutputHeader()
 	i18n.Printf("[backup-helper] Preparing backup in directory: %s\n", flags.TargetDir)
@@ -82,18 +89,28 @@ func HandlePrepare(cfg *config.Config, effective *config.EffectiveValues, flags
 		}
 	}
 
+	if err := hookRunner.Run(config.HookPrePrepare); err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "HOOK", nil, "Prepare aborted: %v", err)
+		i18n.Printf("Prepare aborted: %v\n", err)
+		exit(1)
+	}
+
+	progress.PhaseStart("prepare")
 	cmd, err := backup.RunXtrabackupPrepare(cfg, flags.TargetDir, db, logCtx)
 	if err != nil {
-		logCtx.WriteLog("PREPARE", "Failed to start prepare: %v", err)
+		progress.PhaseEnd("prepare", err)
+		logCtx.WriteLogLevel(log.LevelError, "PREPARE", nil, "Failed to start prepare: %v", err)
 		i18n.Printf("Failed to start prepare: %v\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 
 	// Wait for prepare to complete
 	err = cmd.Wait()
+	progress.PhaseEnd("prepare", err)
 	if err != nil {
-		logCtx.WriteLog("PREPARE", "Prepare failed: %v", err)
+		logCtx.WriteLogLevel(log.LevelError, "PREPARE", nil, "Prepare failed: %v", err)
 		// Read log content for error extraction
+		logCtx.Flush()
 		logContent, err2 := os.ReadFile(logCtx.GetFileName())
 		if err2 == nil {
 			errorSummary := log.ExtractErrorSummary("PREPARE", string(logContent))
@@ -102,6 +119,7 @@ func HandlePrepare(cfg *config.Config, effective *config.EffectiveValues, flags
 			} else {
 				i18n.Printf("Prepare failed: %v\n", err)
 			}
+			printKnownErrors(logCtx, string(logContent))
 		} else {
 			i18n.Printf("Prepare failed: %v\n", err)
 		}
@@ -112,18 +130,7 @@ func HandlePrepare(cfg *config.Config, effective *config.EffectiveValues, flags
 		case "on":
 			// When --ai-diagnose=on, ask user (unless -y is set)
 			if utils.PromptAIDiagnosis(flags.AutoYes) {
-				if cfg.QwenAPIKey == "" {
-					i18n.Printf("Qwen API Key is required for AI diagnosis. Please set it in config.\n")
-					os.Exit(1)
-				}
-				logContent, _ := os.ReadFile(logCtx.GetFileName())
-				aiSuggestion, err := ai.DiagnoseWithAliQwen(cfg, "PREPARE", string(logContent))
-				if err != nil {
-					i18n.Printf("AI diagnosis failed: %v\n", err)
-				} else {
-					fmt.Print(color.YellowString(i18n.Sprintf("AI diagnosis suggestion:\n")))
-					fmt.Println(color.YellowString(aiSuggestion))
-				}
+				diagnose(cfg, "PREPARE", logCtx, flags.AIPreview)
 			}
 		case "off":
 			// do nothing, skip ai diagnose
@@ -131,10 +138,15 @@ func HandlePrepare(cfg *config.Config, effective *config.EffectiveValues, flags
 			// Default: off (skip AI diagnosis to avoid interrupting user workflow)
 			// do nothing
 		}
-		os.Exit(1)
+		exit(1)
 	}
 
 	logCtx.WriteLog("PREPARE", "Prepare completed successfully")
+	if err := hookRunner.Run(config.HookPostPrepare); err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "HOOK", nil, "Prepare failed: %v", err)
+		i18n.Printf("Prepare failed: %v\n", err)
+		exit(1)
+	}
 	logCtx.MarkSuccess()
 	i18n.Printf("[backup-helper] Prepare completed successfully!\n")
 	i18n.Printf("[backup-helper] Backup is ready for restore in: %s\n", flags.TargetDir)
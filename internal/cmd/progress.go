@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"backup-helper/internal/config"
+	"backup-helper/internal/progress"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SetupProgressOutput switches progress reporting to JSON events when progressFormat is json.
+// Events go to progressFd (default: stderr), which the caller must have opened for writing.
+// It must not be stdout when --download --output - writes the backup data there.
+func SetupProgressOutput(cfg *config.Config, effective *config.EffectiveValues, flags *config.Flags) error {
+	if err := progress.ValidateFormat(cfg.ProgressFormat); err != nil {
+		return err
+	}
+	if cfg.ProgressFormat != progress.FormatJSON {
+		return nil
+	}
+
+	var w io.Writer
+	switch cfg.ProgressFd {
+	case 0, 2:
+		w = os.Stderr
+	case 1:
+		w = os.Stdout
+	default:
+		f := os.NewFile(uintptr(cfg.ProgressFd), "progress-fd")
+		if f == nil {
+			return fmt.Errorf("invalid progress file descriptor %d", cfg.ProgressFd)
+		}
+		if _, err := f.Stat(); err != nil {
+			return fmt.Errorf("progress file descriptor %d is not open: %v", cfg.ProgressFd, err)
+		}
+		w = f
+	}
+	if flags.DoDownload && effective.DownloadOutput == "-" && sameFile(w, os.Stdout) {
+		return fmt.Errorf("--progress-fd %d is stdout, where --output - writes the backup data; use stderr or another descriptor", cfg.ProgressFd)
+	}
+	progress.EnableJSONEvents(w)
+	return nil
+}
+
+// sameFile reports whether w is the file f, also through a duplicated descriptor
+func sameFile(w io.Writer, f *os.File) bool {
+	wf, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	if wf == f {
+		return true
+	}
+	wi, err := wf.Stat()
+	if err != nil {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && os.SameFile(wi, fi)
+}
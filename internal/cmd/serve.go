@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"backup-helper/internal/config"
+	"backup-helper/internal/log"
+	"backup-helper/internal/transfer"
+	"backup-helper/internal/utils"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gioco-play/easy-i18n/i18n"
+)
+
+// defaultServeMaxSessions is the concurrency limit of --serve when serveMaxSessions is not set
+const defaultServeMaxSessions = 4
+
+// HandleServe runs the persistent receiver daemon (--serve).
+// Each authenticated sender is saved to <serveDir>/<instance>/backup_<timestamp>_<session>.xb
+// next to a log file of the same name; the daemon log only records sessions and errors.
+func HandleServe(cfg *config.Config, effective *config.EffectiveValues, flags *config.Flags) error {
+	logCtx, err := log.NewLogContext(cfg.LogDir, cfg.LogFileName)
+	if err != nil {
+		i18n.Printf("Failed to create log context: %v\n", err)
+		exit(1)
+	}
+	defer logCtx.Close()
+	logSecretSources(cfg, logCtx)
+	utils.OutputHeaderToStderr()
+
+	if !effective.EnableHandshake || effective.StreamKey == "" {
+		i18n.Fprintf(os.Stderr, "Error: --serve requires --enable-handshake and --stream-key to authenticate senders\n")
+		exit(1)
+	}
+
+	serveDir := cfg.ServeDir
+	if serveDir == "" {
+		serveDir = "."
+	}
+	if err := os.MkdirAll(serveDir, 0755); err != nil {
+		i18n.Fprintf(os.Stderr, "Error: cannot create serve directory %s: %v\n", serveDir, err)
+		exit(1)
+	}
+	maxSessions := cfg.ServeMaxSessions
+	if maxSessions <= 0 {
+		maxSessions = defaultServeMaxSessions
+	}
+
+	tlsConfig, err := streamTLSConfig(cfg, false)
+	if err != nil {
+		logCtx.WriteLogLevel(log.LevelError, "SERVE", nil, "TLS configuration error: %v", err)
+		i18n.Fprintf(os.Stderr, "TLS configuration error: %v\n", err)
+		exit(1)
+	}
+	streamOpts := transfer.StreamOptions{
+		EnableHandshake: true,
+		HandshakeKey:    effective.StreamKey,
+		Framed:          cfg.StreamFramed,
+		TLSConfig:       tlsConfig,
+		IdleTimeout:     time.Duration(cfg.Timeout) * time.Second,
+	}
+
+	streamPort := effective.StreamPort
+	if streamPort == 0 && cfg.StreamPort > 0 {
+		streamPort = cfg.StreamPort
+	}
+	if streamPort == 0 {
+		i18n.Fprintf(os.Stderr, "Error: --serve requires --stream-port\n")
+		exit(1)
+	}
+
+	i18n.Fprintf(os.Stderr, "[backup-helper] Saving received backups under %s\n", serveDir)
+	logCtx.WriteLog("SERVE", "Saving received backups under %s", serveDir)
+
+	err = transfer.ServeStreams(streamPort, maxSessions, streamOpts, func(s *transfer.StreamSession) {
+		receiveSession(cfg, serveDir, s, logCtx)
+	}, logCtx)
+	if err != nil {
+		i18n.Fprintf(os.Stderr, "Stream server error: %v\n", err)
+		exit(1)
+	}
+	return nil
+}
+
+// receiveSession saves one incoming stream to the directory of its instance
+func receiveSession(cfg *config.Config, serveDir string, s *transfer.StreamSession, logCtx *log.LogContext) {
+	instance := sanitizeInstanceName(s.Peer.Instance)
+	dir := filepath.Join(serveDir, instance)
+	base := fmt.Sprintf("backup_%s_%d", time.Now().Format("20060102150405"), s.ID)
+	outputPath := filepath.Join(dir, base+backupFileSuffix(s.Peer.CompressType))
+	remote := s.Conn.RemoteAddr()
+
+	fail := func(sessionLog *log.LogContext, format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		i18n.Fprintf(os.Stderr, "[backup-helper] Session #%d (%s) failed: %s\n", s.ID, instance, msg)
+		logCtx.WriteLogLevel(log.LevelError, "SERVE", nil, "Session #%d from %s (%s) failed: %s", s.ID, remote, instance, msg)
+		if sessionLog != nil {
+			sessionLog.WriteLogLevel(log.LevelError, "SERVE", nil, "Session failed: %s", msg)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fail(nil, "cannot create %s: %v", dir, err)
+		return
+	}
+	// Retention must not touch the backup directory: the session logs stay next to their backups
+	sessionLog, err := log.NewLogContextWithoutRetention(dir, base+".log")
+	if err != nil {
+		fail(nil, "cannot create session log: %v", err)
+		return
+	}
+	defer sessionLog.Close()
+	sessionLog.SetInstance(instance)
+
+	i18n.Fprintf(os.Stderr, "[backup-helper] Session #%d from %s: instance %s -> %s\n", s.ID, remote, instance, outputPath)
+	logCtx.WriteLog("SERVE", "Session #%d from %s: instance %s -> %s (log: %s)", s.ID, remote, instance, outputPath, sessionLog.GetFileName())
+	sessionLog.WriteLog("SERVE", "Session #%d from %s: instance=%q compress=%q framed=%v tls=%v size=%d",
+		s.ID, remote, s.Peer.Instance, s.Peer.CompressType, s.Effective.Framed, s.Effective.TLS, s.Peer.EstimatedSize)
+	sessionLog.WriteLog("SERVE", "Saving to %s", outputPath)
+
+	reader, stopSchedule := limitedReader(cfg, s.Conn, nil, sessionLog)
+	defer stopSchedule()
+	var frameReader *transfer.FrameReader
+	if s.Effective.Framed {
+		frameReader = transfer.NewFrameReader(reader)
+		reader = frameReader
+	}
+
+	partial := outputPath + partialSuffix
+	file, err := os.Create(partial)
+	if err != nil {
+		fail(sessionLog, "cannot create %s: %v", partial, err)
+		return
+	}
+	start := time.Now()
+	n, err := io.Copy(file, reader)
+	if ferr := checkFramedStream(frameReader, sessionLog); ferr != nil {
+		err = ferr
+	}
+	if serr := file.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fail(sessionLog, "%v (received %s, kept as %s)", err, utils.FormatBytes(n), partial)
+		return
+	}
+	if err := os.Rename(partial, outputPath); err != nil {
+		fail(sessionLog, "cannot rename %s: %v", partial, err)
+		return
+	}
+
+	duration := time.Since(start)
+	summary := fmt.Sprintf("%s in %s", utils.FormatBytes(n), duration.Round(time.Second))
+	i18n.Fprintf(os.Stderr, "[backup-helper] Session #%d (%s) completed: %s, saved to %s\n", s.ID, instance, summary, outputPath)
+	logCtx.WriteLog("SERVE", "Session #%d from %s (%s) completed: %s, saved to %s", s.ID, remote, instance, summary, outputPath)
+	sessionLog.WriteLog("SERVE", "Transfer completed: %s, saved to %s", summary, outputPath)
+	sessionLog.MarkSuccess()
+}
+
+// sanitizeInstanceName turns the sender's instance name into a single safe directory name
+func sanitizeInstanceName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+	if strings.Trim(name, ".") == "" {
+		return "unknown"
+	}
+	return name
+}
+
+// backupFileSuffix returns the file suffix for a stream with the given compression, as used for OSS objects
+func backupFileSuffix(compressType string) string {
+	switch compressType {
+	case "zstd":
+		return ".xb.zst"
+	case "qp":
+		return "_qp.xb"
+	}
+	return ".xb"
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AI diagnosis providers (AIConfig.Provider)
+const (
+	AIProviderDashScope = "dashscope" // Alibaba Cloud DashScope (Qwen), the default when an API key is set
+	AIProviderOpenAI    = "openai"    // Any OpenAI-compatible API: OpenAI, vLLM, Ollama, LocalAI, ...
+	AIProviderRules     = "rules"     // No LLM: offline rules matching known error signatures only
+)
+
+// AIConfig selects the LLM used by --ai-diagnose. Without a usable provider, or when it cannot be
+// reached, the diagnosis falls back to offline rules for known error signatures.
+type AIConfig struct {
+	Provider  string `json:"provider"`  // dashscope, openai or rules (default: openai with baseURL, dashscope with an API key, else rules)
+	BaseURL   string `json:"baseURL"`   // API base URL, e.g. http://127.0.0.1:11434/v1 (default for dashscope: the DashScope compatible-mode URL)
+	APIKey    string `json:"apiKey"`    // API key (default for dashscope: qwenAPIKey); may be a secret reference; optional for local servers
+	Model     string `json:"model"`     // Model name (default for dashscope: qwen-max-latest)
+	Timeout   int    `json:"timeout"`   // Seconds per request (default: 60)
+	MaxTokens int    `json:"maxTokens"` // Upper limit of the answer length in tokens (default: 1024)
+
+	// Credentials and keys are always redacted from the log excerpt that is sent;
+	// RedactHosts also masks IP addresses, host names, the OSS endpoint and bucket
+	RedactHosts bool `json:"redactHosts"`
+
+	// Known error rules added to the built-in table, see ai.LoadRules
+	RulesFile string `json:"rulesFile"`
+}
+
+// ValidateAI checks the AI diagnosis settings
+func (c *Config) ValidateAI() error {
+	a := c.AI
+	switch a.Provider {
+	case "", AIProviderDashScope, AIProviderRules:
+	case AIProviderOpenAI:
+		if a.BaseURL == "" {
+			return fmt.Errorf("ai: provider openai requires baseURL")
+		}
+		if a.Model == "" {
+			return fmt.Errorf("ai: provider openai requires model")
+		}
+	default:
+		return fmt.Errorf("ai: unknown provider %q (expected dashscope, openai or rules)", a.Provider)
+	}
+	if a.Provider == "" && a.BaseURL != "" && a.Model == "" {
+		return fmt.Errorf("ai: model is required with baseURL")
+	}
+	if a.BaseURL != "" && !strings.HasPrefix(a.BaseURL, "http://") && !strings.HasPrefix(a.BaseURL, "https://") {
+		return fmt.Errorf("ai: baseURL %q must be an http(s) URL", a.BaseURL)
+	}
+	if a.Timeout < 0 || a.MaxTokens < 0 {
+		return fmt.Errorf("ai: timeout and maxTokens must not be negative")
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"os"
+	"sync/atomic"
 )
 
 // Version represents MySQL version information
@@ -44,7 +45,86 @@ type Config struct {
 	UseMemory       string  `json:"useMemory"`
 	XtrabackupPath  string  `json:"xtrabackupPath"`
 	DefaultsFile    string  `json:"defaultsFile"`
-	Timeout         int     `json:"timeout"` // TCP connection timeout in seconds (default: 60, max: 3600)
+	Timeout         int     `json:"timeout"`      // TCP connection timeout in seconds (default: 60, max: 3600)
+	StreamFramed    bool    `json:"streamFramed"` // Framed stream protocol with end-of-stream trailer (both sides must enable it)
+
+	// Parallel TCP connections per stream (default: 1, both sides must use the same value)
+	StreamConnections int `json:"streamConnections"`
+
+	// Fan-out: send one backup to several sinks at once ("oss", "stream://host:port", "file:///path")
+	Sinks             []string `json:"sinks"`
+	SinkFailurePolicy string   `json:"sinkFailurePolicy"` // "abort" (default): one failing sink aborts all; "continue": mark it failed
+
+	// Stream server (--serve): backups are saved to <serveDir>/<instance>/ with one log per session
+	StreamInstance   string `json:"streamInstance"`   // Instance name a sender announces (default: <hostname>_<mysql port>)
+	ServeDir         string `json:"serveDir"`         // Root directory of received backups (default: current directory)
+	ServeMaxSessions int    `json:"serveMaxSessions"` // Maximum concurrent sessions (default: 4)
+
+	// SSH mode (--ssh): how to log in and which receiver to start on the remote host
+	SSHPort         int    `json:"sshPort"`         // SSH port (default: ssh config / 22)
+	SSHUser         string `json:"sshUser"`         // SSH login user (default: ssh config / current user)
+	SSHIdentityFile string `json:"sshIdentityFile"` // SSH private key file (default: ssh config)
+	SSHRemoteBinary string `json:"sshRemoteBinary"` // backup-helper path on the remote host (default: backup-helper in PATH)
+	SSHRemoteArgs   string `json:"sshRemoteArgs"`   // Extra flags for the remote receiver, split like a shell command line, e.g. "--target-dir '/data/my restore'"
+	SSHTunnel       bool   `json:"sshTunnel"`       // Carry the stream over the SSH channel instead of a TCP port
+
+	// Adaptive IO limit: the stream rate limit follows MySQL load between ioLimitMin and ioLimitMax (bytes/s)
+	AdaptiveIOLimit           bool  `json:"adaptiveIOLimit"`
+	IOLimitMin                int64 `json:"ioLimitMin"`                // Default: ioLimitMax / 10
+	IOLimitMax                int64 `json:"ioLimitMax"`                // Default: ioLimit (200MB/s if not set)
+	AdaptiveInterval          int   `json:"adaptiveInterval"`          // Seconds between load checks (default: 5)
+	AdaptiveMaxThreadsRunning int64 `json:"adaptiveMaxThreadsRunning"` // Lower the limit above this Threads_running (default: 32, -1 = ignore)
+	AdaptiveMaxPendingIO      int64 `json:"adaptiveMaxPendingIO"`      // Lower the limit above this InnoDB pending I/O (default: 64, -1 = ignore)
+	AdaptiveMaxReplicaLag     int64 `json:"adaptiveMaxReplicaLag"`     // Lower the limit above this replica lag in seconds (default: 60, -1 = ignore)
+
+	// Time-of-day IO limit, e.g. "mon-fri 08:00-20:00=50MB/s; 20:00-08:00=500MB/s" (see parseIOLimitSchedule).
+	// Outside all windows ioLimit applies; running transfers switch limits as windows change.
+	IOLimitSchedule string          `json:"ioLimitSchedule"`
+	ioLimitWindows  []ioLimitWindow // Parsed IOLimitSchedule (filled by MergeFlags)
+	ioLimitOverride atomic.Int64    // Limit set at runtime through UpdateRateLimit, stored +1 (0 = not set)
+
+	// Disk read limit of xtrabackup on the datadir (bytes/s), independent of the network ioLimit
+	DiskIOLimit  int64  `json:"diskIOLimit"`
+	DiskIOMethod string `json:"diskIOMethod"` // "auto" (default): cgroup if available, else throttle; "cgroup": io.max; "throttle": xtrabackup --throttle
+	DiskIOCgroup string `json:"diskIOCgroup"` // Parent cgroup, absolute or below /sys/fs/cgroup (default: backup-helper)
+
+	// Control socket of a running backup/download (default: $TMPDIR/backup-helper-<pid>.sock, "off" disables it)
+	CtlSocket string `json:"ctlSocket"`
+
+	// Progress output: "text" (default) or "json" newline-delimited events written to progressFd (default: 2, stderr)
+	ProgressFormat string `json:"progressFormat"`
+	ProgressFd     int    `json:"progressFd"`
+
+	// Metrics: HTTP /metrics endpoint served during a run, and node_exporter textfile written when the run ends
+	MetricsAddr     string `json:"metricsAddr"`     // e.g. ":9701" or "127.0.0.1:9701"
+	MetricsTextfile string `json:"metricsTextfile"` // e.g. /var/lib/node_exporter/textfile/backup_helper.prom
+
+	// Notifications when a run succeeds or fails (webhook, DingTalk, Slack or a local command)
+	Notify   []NotifyConfig `json:"notify"`
+	NotifyOn string         `json:"notifyOn"` // Default for notifiers without "on": failure (default), success or always
+
+	// Lifecycle hooks: commands run before/after backup, prepare and download, and when a run fails
+	Hooks []HookConfig `json:"hooks"`
+
+	// Log file format: "text" (default) or "json" lines with level, module, runId, instance and fields
+	LogFormat string `json:"logFormat"`
+
+	// Retention of the logs of earlier runs: count, age and total size limits, gzip compression
+	LogRetention LogRetentionConfig `json:"logRetention"`
+
+	// AI diagnosis provider (DashScope, any OpenAI-compatible API, or offline rules only)
+	AI AIConfig `json:"ai"`
+
+	// TLS for TCP streaming (the listening side acts as TLS server, the connecting side as TLS client)
+	StreamTLS           bool   `json:"streamTLS"`
+	StreamTLSCert       string `json:"streamTLSCert"`       // PEM certificate (server cert when listening, client cert for mutual TLS when connecting)
+	StreamTLSKey        string `json:"streamTLSKey"`        // PEM private key for StreamTLSCert
+	StreamTLSCA         string `json:"streamTLSCA"`         // PEM CA bundle used to verify the peer
+	StreamTLSServerName string `json:"streamTLSServerName"` // Expected server name in the server certificate (default: stream host)
+	StreamTLSClientAuth bool   `json:"streamTLSClientAuth"` // Listening side requires a client certificate signed by StreamTLSCA
+
+	// SecretSources records where each secret was resolved from (filled by ResolveSecrets, not loaded from JSON)
+	SecretSources []SecretSource `json:"-"`
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -117,6 +197,11 @@ func LoadAndMergeConfig(flags *Flags) (*Config, *EffectiveValues, error) {
 		cfg.SetDefaults()
 	}
 
+	// Resolve secret references (env:, file:, mycnf:, cmd:) before merging
+	if err := ResolveSecrets(cfg, flags); err != nil {
+		return nil, nil, err
+	}
+
 	// Merge flags with config
 	return MergeFlags(cfg, flags)
 }
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Hook points (HookConfig.Point)
+const (
+	HookPreBackup    = "pre-backup"    // Before xtrabackup starts
+	HookPostBackup   = "post-backup"   // After a successful backup and transfer
+	HookOnFailure    = "on-failure"    // When any phase of the run fails
+	HookPrePrepare   = "pre-prepare"   // Before xtrabackup --prepare
+	HookPostPrepare  = "post-prepare"  // After a successful prepare
+	HookPostDownload = "post-download" // After a successful download or extraction
+)
+
+// What a failing hook does (HookConfig.OnError)
+const (
+	HookFail = "fail" // The run fails (default for pre-* hooks)
+	HookWarn = "warn" // A warning is logged and the run goes on (default for other hooks)
+)
+
+// HookConfig is a command run at a point of the backup lifecycle
+type HookConfig struct {
+	Point   string `json:"point"`   // pre-backup, post-backup, on-failure, pre-prepare, post-prepare or post-download
+	Command string `json:"command"` // Run with "sh -c"; BACKUP_HELPER_* environment variables describe the run
+	Timeout int    `json:"timeout"` // Seconds (default: 300)
+	OnError string `json:"onError"` // fail or warn (default: fail for pre-* hooks, warn otherwise; on-failure hooks always warn)
+}
+
+// ParseHookSpec parses a --hook entry: <point>=<command>
+func ParseHookSpec(spec string) (HookConfig, error) {
+	point, command, found := strings.Cut(spec, "=")
+	if !found || strings.TrimSpace(command) == "" {
+		return HookConfig{}, fmt.Errorf("invalid hook %q (expected <point>=<command>)", spec)
+	}
+	return HookConfig{Point: strings.TrimSpace(point), Command: command}, nil
+}
+
+// ValidateHooks checks the configured hooks
+func (c *Config) ValidateHooks() error {
+	for i, h := range c.Hooks {
+		switch h.Point {
+		case HookPreBackup, HookPostBackup, HookOnFailure, HookPrePrepare, HookPostPrepare, HookPostDownload:
+		default:
+			return fmt.Errorf("hooks[%d]: unknown point %q (expected pre-backup, post-backup, on-failure, pre-prepare, post-prepare or post-download)", i, h.Point)
+		}
+		if h.Command == "" {
+			return fmt.Errorf("hooks[%d]: command is required", i)
+		}
+		switch h.OnError {
+		case "", HookFail, HookWarn:
+		default:
+			return fmt.Errorf("hooks[%d]: invalid onError %q (expected fail or warn)", i, h.OnError)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// LogRetentionConfig controls which log files of earlier runs are kept in the log directory.
+// Logs of failed runs are limited separately, so they survive longer than routine successes.
+type LogRetentionConfig struct {
+	Keep             int      `json:"keep"`             // Logs of successful runs to keep (default: 10, -1 = no limit)
+	KeepFailed       int      `json:"keepFailed"`       // Logs of failed runs to keep (default: 3 × keep, -1 = no limit)
+	MaxAgeDays       int      `json:"maxAgeDays"`       // Remove logs of successful runs older than this (default: 0 = no limit)
+	FailedMaxAgeDays int      `json:"failedMaxAgeDays"` // Remove logs of failed runs older than this (default: 3 × maxAgeDays)
+	MaxSize          string   `json:"maxSize"`          // Total size of the kept logs, e.g. "1GB"; the oldest successful runs go first
+	Compress         *bool    `json:"compress"`         // gzip logs of earlier runs (default: true)
+	Patterns         []string `json:"patterns"`         // Globs of custom log file names to manage too, e.g. "nightly-*.log"
+}
+
+// ValidateLogRetention checks the log retention settings
+func (c *Config) ValidateLogRetention() error {
+	r := c.LogRetention
+	if r.Keep < -1 || r.KeepFailed < -1 {
+		return fmt.Errorf("logRetention: keep and keepFailed must be -1 (no limit), 0 (default) or a positive count")
+	}
+	if r.MaxAgeDays < 0 || r.FailedMaxAgeDays < 0 {
+		return fmt.Errorf("logRetention: maxAgeDays and failedMaxAgeDays must not be negative")
+	}
+	if _, err := ParseSize(r.MaxSize); err != nil {
+		return fmt.Errorf("logRetention: invalid maxSize %q: %v", r.MaxSize, err)
+	}
+	for _, p := range r.Patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("logRetention: invalid pattern %q: %v", p, err)
+		}
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ type Flags struct {
 	DoBackup         bool
 	DoDownload       bool
 	DoPrepare        bool
+	DoServe          bool
 	DoCheck          bool
 	ConfigPath       string
 	Host             string
@@ -45,6 +46,55 @@ type Flags struct {
 	LogFileName      string
 	Timeout          int
 	ShowVersion      bool
+	StreamTLS        bool
+	TLSCert          string
+	TLSKey           string
+	TLSCA            string
+	TLSServerName    string
+	TLSClientAuth    bool
+	Framed           bool
+	Connections      int
+	Sinks            string
+	SinkFailure      string
+	Instance         string
+	ServeDir         string
+	MaxSessions      int
+	SSHPort          int
+	SSHUser          string
+	SSHIdentity      string
+	SSHRemoteBinary  string
+	SSHRemoteArgs    string
+	SSHTunnel        bool
+	ReadStdin        bool
+	AdaptiveIO       bool
+	IOLimitMinStr    string
+	IOLimitMaxStr    string
+	IOSchedule       string
+	Ctl              string
+	CtlArgs          []string
+	CtlLimit         string
+	CtlSocket        string
+	DiskIOLimitStr   string
+	DiskIOMethod     string
+	ProgressFormat   string
+	ProgressFd       int
+	MetricsAddr      string
+	MetricsTextfile  string
+	Notify           string
+	NotifyOn         string
+	Hooks            []string
+	LogFormat        string
+	LogKeep          int
+	LogMaxAgeDays    int
+	LogMaxSize       string
+	AIProvider       string
+	AIBaseURL        string
+	AIModel          string
+	AITimeout        int
+	AIMaxTokens      int
+	AIRedactHosts    bool
+	AIPreview        bool
+	AIRulesFile      string
 }
 
 // MergeFlags merges command line flags with config file values
@@ -85,6 +135,78 @@ func MergeFlags(cfg *Config, flags *Flags) (*Config, *EffectiveValues, error) {
 		effectiveCompressType = ""
 	}
 
+	// TLS settings for TCP streaming (command-line flag overrides config)
+	if flags.StreamTLS {
+		cfg.StreamTLS = true
+	}
+	if flags.TLSCert != "" {
+		cfg.StreamTLSCert = flags.TLSCert
+	}
+	if flags.TLSKey != "" {
+		cfg.StreamTLSKey = flags.TLSKey
+	}
+	if flags.TLSCA != "" {
+		cfg.StreamTLSCA = flags.TLSCA
+	}
+	if flags.TLSServerName != "" {
+		cfg.StreamTLSServerName = flags.TLSServerName
+	}
+	if flags.TLSClientAuth {
+		cfg.StreamTLSClientAuth = true
+	}
+
+	// Framed stream protocol (command-line flag overrides config)
+	if flags.Framed {
+		cfg.StreamFramed = true
+	}
+
+	// Parallel stream connections (command-line flag overrides config)
+	if flags.Connections > 0 {
+		cfg.StreamConnections = flags.Connections
+	}
+
+	// Fan-out sinks (command-line flag overrides config)
+	if flags.Sinks != "" {
+		cfg.Sinks = strings.Split(flags.Sinks, ",")
+	}
+	if flags.SinkFailure != "" {
+		cfg.SinkFailurePolicy = flags.SinkFailure
+	}
+
+	// Stream server (--serve) and the instance name senders announce to it (command-line flag overrides config)
+	if flags.Instance != "" {
+		cfg.StreamInstance = flags.Instance
+	}
+	if flags.ServeDir != "" {
+		cfg.ServeDir = flags.ServeDir
+	}
+	if flags.MaxSessions > 0 {
+		cfg.ServeMaxSessions = flags.MaxSessions
+	}
+
+	// SSH options (command-line flag overrides config)
+	if flags.SSHPort > 0 {
+		cfg.SSHPort = flags.SSHPort
+	}
+	if flags.SSHUser != "" {
+		cfg.SSHUser = flags.SSHUser
+	}
+	if flags.SSHIdentity != "" {
+		cfg.SSHIdentityFile = flags.SSHIdentity
+	}
+	if flags.SSHRemoteBinary != "" {
+		cfg.SSHRemoteBinary = flags.SSHRemoteBinary
+	}
+	if flags.SSHRemoteArgs != "" {
+		cfg.SSHRemoteArgs = flags.SSHRemoteArgs
+	}
+	if _, err := cfg.SSHRemoteArgList(); err != nil {
+		return nil, nil, err
+	}
+	if flags.SSHTunnel {
+		cfg.SSHTunnel = true
+	}
+
 	if flags.ExistedBackup == "" && cfg.ExistedBackup != "" {
 		flags.ExistedBackup = cfg.ExistedBackup
 	}
@@ -109,6 +231,55 @@ func MergeFlags(cfg *Config, flags *Flags) (*Config, *EffectiveValues, error) {
 		cfg.LogFileName = flags.LogFileName
 	}
 
+	// Log format (command-line flag overrides config)
+	if flags.LogFormat != "" {
+		cfg.LogFormat = flags.LogFormat
+	}
+
+	// Log retention (command-line flag overrides config)
+	if flags.LogKeep != 0 {
+		cfg.LogRetention.Keep = flags.LogKeep
+	}
+	if flags.LogMaxAgeDays > 0 {
+		cfg.LogRetention.MaxAgeDays = flags.LogMaxAgeDays
+	}
+	if flags.LogMaxSize != "" {
+		cfg.LogRetention.MaxSize = flags.LogMaxSize
+	}
+	if err := cfg.ValidateLogRetention(); err != nil {
+		return nil, nil, err
+	}
+
+	// AI diagnosis provider (command-line flag overrides config)
+	if flags.AIProvider != "" {
+		cfg.AI.Provider = flags.AIProvider
+	}
+	if flags.AIBaseURL != "" {
+		cfg.AI.BaseURL = flags.AIBaseURL
+	}
+	if flags.AIModel != "" {
+		cfg.AI.Model = flags.AIModel
+	}
+	if flags.AITimeout > 0 {
+		cfg.AI.Timeout = flags.AITimeout
+	}
+	if flags.AIMaxTokens > 0 {
+		cfg.AI.MaxTokens = flags.AIMaxTokens
+	}
+	if flags.AIRedactHosts {
+		cfg.AI.RedactHosts = true
+	}
+	if flags.AIRulesFile != "" {
+		cfg.AI.RulesFile = flags.AIRulesFile
+	}
+	// --ai-preview implies the diagnosis, but only shows what would be sent
+	if flags.AIPreview && flags.AIDiagnoseFlag == "" {
+		flags.AIDiagnoseFlag = "on"
+	}
+	if err := cfg.ValidateAI(); err != nil {
+		return nil, nil, err
+	}
+
 	// Parse estimatedSize from command line or config
 	var estimatedSize int64
 	if flags.EstimatedSizeStr != "" {
@@ -131,6 +302,101 @@ func MergeFlags(cfg *Config, flags *Flags) (*Config, *EffectiveValues, error) {
 		cfg.IOLimit = parsedLimit
 	}
 
+	// Adaptive IO limit (command-line flag overrides config)
+	if flags.AdaptiveIO {
+		cfg.AdaptiveIOLimit = true
+	}
+	if flags.IOLimitMinStr != "" {
+		parsedLimit, err := ParseRateLimit(flags.IOLimitMinStr)
+		if err != nil {
+			i18n.Printf("Error parsing --io-limit-min '%s': %v\n", flags.IOLimitMinStr, err)
+			return nil, nil, err
+		}
+		cfg.IOLimitMin = parsedLimit
+	}
+	if flags.IOLimitMaxStr != "" {
+		parsedLimit, err := ParseRateLimit(flags.IOLimitMaxStr)
+		if err != nil {
+			i18n.Printf("Error parsing --io-limit-max '%s': %v\n", flags.IOLimitMaxStr, err)
+			return nil, nil, err
+		}
+		cfg.IOLimitMax = parsedLimit
+	}
+
+	// IO limit schedule (command-line flag overrides config)
+	if flags.IOSchedule != "" {
+		cfg.IOLimitSchedule = flags.IOSchedule
+	}
+	windows, err := parseIOLimitSchedule(cfg.IOLimitSchedule)
+	if err != nil {
+		i18n.Printf("Error parsing IO limit schedule '%s': %v\n", cfg.IOLimitSchedule, err)
+		return nil, nil, err
+	}
+	cfg.ioLimitWindows = windows
+
+	// Disk IO limit (command-line flag overrides config)
+	if flags.DiskIOLimitStr != "" {
+		parsedLimit, err := ParseRateLimit(flags.DiskIOLimitStr)
+		if err != nil {
+			i18n.Printf("Error parsing --disk-io-limit '%s': %v\n", flags.DiskIOLimitStr, err)
+			return nil, nil, err
+		}
+		cfg.DiskIOLimit = parsedLimit
+	}
+	if flags.DiskIOMethod != "" {
+		cfg.DiskIOMethod = flags.DiskIOMethod
+	}
+
+	// Control socket (command-line flag overrides config)
+	if flags.CtlSocket != "" {
+		cfg.CtlSocket = flags.CtlSocket
+	}
+
+	// Progress output (command-line flag overrides config)
+	if flags.ProgressFormat != "" {
+		cfg.ProgressFormat = flags.ProgressFormat
+	}
+	if flags.ProgressFd > 0 {
+		cfg.ProgressFd = flags.ProgressFd
+	}
+
+	// Metrics (command-line flag overrides config)
+	if flags.MetricsAddr != "" {
+		cfg.MetricsAddr = flags.MetricsAddr
+	}
+	if flags.MetricsTextfile != "" {
+		cfg.MetricsTextfile = flags.MetricsTextfile
+	}
+
+	// Notifiers (command-line notifiers are added to the configured ones, --notify-on overrides config)
+	if flags.Notify != "" {
+		for _, spec := range strings.Split(flags.Notify, ",") {
+			n, err := ParseNotifySpec(spec)
+			if err != nil {
+				return nil, nil, err
+			}
+			cfg.Notify = append(cfg.Notify, n)
+		}
+	}
+	if flags.NotifyOn != "" {
+		cfg.NotifyOn = flags.NotifyOn
+	}
+	if err := cfg.ValidateNotify(); err != nil {
+		return nil, nil, err
+	}
+
+	// Lifecycle hooks (command-line hooks run after the configured ones)
+	for _, spec := range flags.Hooks {
+		h, err := ParseHookSpec(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.Hooks = append(cfg.Hooks, h)
+	}
+	if err := cfg.ValidateHooks(); err != nil {
+		return nil, nil, err
+	}
+
 	// Parse parallel from command line or config
 	if flags.Parallel > 0 {
 		cfg.Parallel = flags.Parallel
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Notifier types (NotifyConfig.Type)
+const (
+	NotifyWebhook  = "webhook"  // POST the JSON payload as is
+	NotifyDingTalk = "dingtalk" // DingTalk robot markdown message
+	NotifySlack    = "slack"    // Slack incoming webhook (also Mattermost, Rocket.Chat, ...)
+	NotifyCommand  = "command"  // Run a local command with the JSON payload on stdin
+)
+
+// When a notifier fires (NotifyConfig.On, notifyOn)
+const (
+	NotifyOnFailure = "failure"
+	NotifyOnSuccess = "success"
+	NotifyOnAlways  = "always"
+)
+
+// NotifyConfig is one notifier fired when a run succeeds or fails
+type NotifyConfig struct {
+	Type    string            `json:"type"`    // webhook (default), dingtalk, slack or command
+	URL     string            `json:"url"`     // Webhook URL; may be a secret reference (env:, file:, cmd:)
+	Secret  string            `json:"secret"`  // DingTalk signing secret (optional); may be a secret reference
+	Command string            `json:"command"` // command: run with "sh -c"
+	Headers map[string]string `json:"headers"` // webhook: extra HTTP headers
+	On      string            `json:"on"`      // failure, success or always (default: notifyOn)
+	Timeout int               `json:"timeout"` // Seconds per attempt (default: 10)
+	Retries int               `json:"retries"` // Retries after a failed attempt (default: 2)
+}
+
+// ParseNotifySpec parses a --notify entry: an http(s) URL (generic webhook),
+// dingtalk:<url>, slack:<url>, webhook:<url> or command:<shell command>
+func ParseNotifySpec(spec string) (NotifyConfig, error) {
+	spec = strings.TrimSpace(spec)
+	kind, rest, found := strings.Cut(spec, ":")
+	switch {
+	case found && (kind == NotifyDingTalk || kind == NotifySlack || kind == NotifyWebhook):
+		if rest == "" {
+			return NotifyConfig{}, fmt.Errorf("invalid notifier %q: empty URL", spec)
+		}
+		return NotifyConfig{Type: kind, URL: rest}, nil
+	case found && kind == NotifyCommand:
+		if rest == "" {
+			return NotifyConfig{}, fmt.Errorf("invalid notifier %q: empty command", spec)
+		}
+		return NotifyConfig{Type: NotifyCommand, Command: rest}, nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return NotifyConfig{Type: NotifyWebhook, URL: spec}, nil
+	}
+	return NotifyConfig{}, fmt.Errorf("invalid notifier %q (expected an http(s) URL, dingtalk:<url>, slack:<url> or command:<command>)", spec)
+}
+
+// ValidateNotify checks the configured notifiers and the notifyOn default
+func (c *Config) ValidateNotify() error {
+	if err := validateNotifyOn(c.NotifyOn); err != nil {
+		return fmt.Errorf("invalid notifyOn: %v", err)
+	}
+	for i, n := range c.Notify {
+		switch n.Type {
+		case "", NotifyWebhook, NotifyDingTalk, NotifySlack:
+			if n.URL == "" {
+				return fmt.Errorf("notify[%d]: url is required", i)
+			}
+		case NotifyCommand:
+			if n.Command == "" {
+				return fmt.Errorf("notify[%d]: command is required", i)
+			}
+		default:
+			return fmt.Errorf("notify[%d]: unknown type %q (expected webhook, dingtalk, slack or command)", i, n.Type)
+		}
+		if err := validateNotifyOn(n.On); err != nil {
+			return fmt.Errorf("notify[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func validateNotifyOn(on string) error {
+	switch on {
+	case "", NotifyOnFailure, NotifyOnSuccess, NotifyOnAlways:
+		return nil
+	}
+	return fmt.Errorf("%q is not failure, success or always", on)
+}
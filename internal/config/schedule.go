@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ioLimitWindow is one parsed entry of Config.IOLimitSchedule
+type ioLimitWindow struct {
+	days       [7]bool // Indexed by time.Weekday: days the window starts on
+	start, end int     // Minutes since midnight; end <= start wraps past midnight
+	limit      int64   // Bytes per second; 0 = unlimited
+	spec       string
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseIOLimitSchedule parses a schedule such as "mon-fri 08:00-20:00=50MB/s; 20:00-08:00=500MB/s".
+// Entries are separated by ';', each is "[days ]HH:MM-HH:MM=limit". days is "*" or a comma list of
+// days and day ranges ("mon-fri", "sat,sun"), default every day. A window whose end is not after its
+// start runs past midnight; its days are the days it starts on. limit is -1 for unlimited.
+func parseIOLimitSchedule(spec string) ([]ioLimitWindow, error) {
+	var windows []ioLimitWindow
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		w, err := parseIOLimitWindow(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IO limit schedule entry '%s': %v", entry, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseIOLimitWindow(entry string) (ioLimitWindow, error) {
+	w := ioLimitWindow{spec: entry}
+	times, limitStr, ok := strings.Cut(entry, "=")
+	if !ok {
+		return w, fmt.Errorf("missing '=limit'")
+	}
+	limit, err := ParseRateLimit(strings.TrimSpace(limitStr))
+	if err != nil {
+		return w, err
+	}
+	switch {
+	case limit == -1:
+		w.limit = 0
+	case limit > 0:
+		w.limit = limit
+	default:
+		return w, fmt.Errorf("limit must be positive or -1 (unlimited)")
+	}
+
+	days := "*"
+	fields := strings.Fields(times)
+	switch len(fields) {
+	case 1:
+	case 2:
+		days = fields[0]
+	default:
+		return w, fmt.Errorf("expected '[days ]HH:MM-HH:MM'")
+	}
+	if w.days, err = parseWeekdays(days); err != nil {
+		return w, err
+	}
+
+	startStr, endStr, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return w, fmt.Errorf("expected 'HH:MM-HH:MM'")
+	}
+	if w.start, err = parseClock(startStr); err != nil {
+		return w, err
+	}
+	if w.end, err = parseClock(endStr); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+// parseWeekdays parses "*" or a comma list of days and day ranges
+func parseWeekdays(s string) ([7]bool, error) {
+	var days [7]bool
+	if s == "*" {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdayNames[from]
+		if !ok {
+			return days, fmt.Errorf("unknown day '%s'", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdayNames[to]; !ok {
+				return days, fmt.Errorf("unknown day '%s'", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses HH:MM (00:00 - 24:00) into minutes since midnight
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time '%s' (expected HH:MM)", s)
+	}
+	return h*60 + m, nil
+}
+
+// contains reports whether t falls into the window
+func (w ioLimitWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	if w.end > w.start {
+		return w.days[today] && minute >= w.start && minute < w.end
+	}
+	// Wraps past midnight (or covers the whole day when start == end)
+	return (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end)
+}
+
+// GetRateLimitAt returns the rate limit in effect at t: a limit set through UpdateRateLimit,
+// else the limit of the first ioLimitSchedule window containing t, otherwise GetRateLimit. 0 means unlimited.
+func (c *Config) GetRateLimitAt(t time.Time) int64 {
+	if override := c.ioLimitOverride.Load(); override > 0 {
+		return override - 1
+	}
+	for _, w := range c.ioLimitWindows {
+		if w.contains(t) {
+			return w.limit
+		}
+	}
+	return c.GetRateLimit()
+}
+
+// HasIOLimitSchedule reports whether a time-of-day IO limit schedule is configured
+func (c *Config) HasIOLimitSchedule() bool {
+	return len(c.ioLimitWindows) > 0
+}
+
+// ActiveIOLimitWindow describes the schedule window in effect at t, or "" outside all windows
+func (c *Config) ActiveIOLimitWindow(t time.Time) string {
+	for _, w := range c.ioLimitWindows {
+		if w.contains(t) {
+			return w.spec
+		}
+	}
+	return ""
+}
+
+// UpdateRateLimit overrides the configured IO limit and schedule for the rest of the run
+// (e.g. from a --ctl limit request). 0 means unlimited.
+func (c *Config) UpdateRateLimit(newLimit int64) {
+	c.ioLimitOverride.Store(newLimit + 1)
+}
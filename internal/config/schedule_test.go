@@ -0,0 +1,108 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"-1", -1, false},
+		{"1024", 1024, false},
+		{"100MB/s", 100 * 1024 * 1024, false},
+		{"100mb/s", 100 * 1024 * 1024, false},
+		{" 1.5 GB/s ", 1536 * 1024 * 1024, false},
+		{"500K", 500 * 1024, false},
+		{"2TB", 2 * 1024 * 1024 * 1024 * 1024, false},
+		{"MB/s", 0, true},
+		{"10XB/s", 0, true},
+		{"1.2.3MB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRateLimit(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRateLimit(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRateLimit(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseIOLimitSchedule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		windows int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"mon-fri 08:00-20:00=50MB/s; 20:00-08:00=500MB/s", 2, false},
+		{"sat,sun 00:00-24:00=-1;", 1, false},
+		{"fri-mon 22:00-06:00=10MB/s", 1, false},
+		{"08:00-20:00", 0, true},
+		{"08:00-20:00=0", 0, true},
+		{"08:00-20:00=fast", 0, true},
+		{"xyz 08:00-20:00=1MB/s", 0, true},
+		{"mon 8-20=1MB/s", 0, true},
+		{"25:00-26:00=1MB/s", 0, true},
+		{"08:60-09:00=1MB/s", 0, true},
+		{"mon fri 08:00-09:00=1MB/s", 0, true},
+	}
+	for _, tt := range tests {
+		windows, err := parseIOLimitSchedule(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseIOLimitSchedule(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if len(windows) != tt.windows {
+			t.Errorf("parseIOLimitSchedule(%q) = %d windows, want %d", tt.spec, len(windows), tt.windows)
+		}
+	}
+}
+
+func TestGetRateLimitAt(t *testing.T) {
+	windows, err := parseIOLimitSchedule("mon-fri 08:00-20:00=50MB/s; fri 22:00-06:00=-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{IOLimit: 100 * 1024 * 1024, ioLimitWindows: windows}
+	// 2026-10-16 is a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want int64
+	}{
+		{"weekday window", at(16, 8, 0), 50 * 1024 * 1024},
+		{"window end is exclusive", at(16, 20, 0), 100 * 1024 * 1024},
+		{"unlimited window", at(16, 23, 30), 0},
+		{"past midnight on the next day", at(17, 5, 59), 0},
+		{"after the overnight window", at(17, 6, 0), 100 * 1024 * 1024},
+		{"weekend outside windows", at(18, 12, 0), 100 * 1024 * 1024},
+	}
+	for _, tt := range tests {
+		if got := cfg.GetRateLimitAt(tt.t); got != tt.want {
+			t.Errorf("%s: GetRateLimitAt = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// A limit set at runtime overrides the schedule, 0 meaning unlimited
+	cfg.UpdateRateLimit(0)
+	if got := cfg.GetRateLimitAt(at(16, 8, 0)); got != 0 {
+		t.Errorf("after UpdateRateLimit(0): GetRateLimitAt = %d, want 0", got)
+	}
+	cfg.UpdateRateLimit(1024)
+	if got := cfg.GetRateLimitAt(at(16, 8, 0)); got != 1024 {
+		t.Errorf("after UpdateRateLimit(1024): GetRateLimitAt = %d, want 1024", got)
+	}
+}
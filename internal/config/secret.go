@@ -0,0 +1,189 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Secret reference prefixes supported in config values and secret flags:
+//   - env:NAME            read from environment variable NAME
+//   - file:/path          read from file (e.g. Kubernetes/Docker secrets), trailing newline trimmed
+//   - mycnf: or mycnf:/path  read "password" from the [client] section (default: ~/.my.cnf)
+//   - cmd:command args    run command via "sh -c" and use its trimmed stdout
+//   - literal:value       the plaintext value, for secrets that start with one of these prefixes
+//
+// Any other value is treated as a plaintext secret.
+const (
+	secretPrefixEnv     = "env:"
+	secretPrefixFile    = "file:"
+	secretPrefixMyCnf   = "mycnf:"
+	secretPrefixCmd     = "cmd:"
+	secretPrefixLiteral = "literal:"
+)
+
+// secretCommandTimeout limits how long a cmd: secret source may run
+const secretCommandTimeout = 30 * time.Second
+
+// SecretSource records where a resolved secret came from (never the value itself)
+type SecretSource struct {
+	Name   string // config key or flag name, e.g. "mysqlPassword"
+	Source string // e.g. "env:MYSQL_PWD", "file:/run/secrets/oss", "plaintext (config)"
+	Origin string // "config" or "command line"
+}
+
+// RunsCommand reports whether a secret of the config file was read by running a command. Before
+// secret references, such a value was a plaintext secret; it must now be written as literal:cmd:...
+func (s SecretSource) RunsCommand() bool {
+	return s.Origin == "config" && strings.HasPrefix(s.Source, secretPrefixCmd)
+}
+
+// ResolveSecret resolves a secret reference and returns the secret value and a
+// description of its source. origin describes where the raw value was set
+// ("config" or "command line") and is used for plaintext values.
+func ResolveSecret(value string, origin string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(value, secretPrefixLiteral):
+		return strings.TrimPrefix(value, secretPrefixLiteral), fmt.Sprintf("plaintext (%s)", origin), nil
+
+	case strings.HasPrefix(value, secretPrefixEnv):
+		name := strings.TrimPrefix(value, secretPrefixEnv)
+		if name == "" {
+			return "", "", fmt.Errorf("empty environment variable name in %q", value)
+		}
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, value, nil
+
+	case strings.HasPrefix(value, secretPrefixFile):
+		path := strings.TrimPrefix(value, secretPrefixFile)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read secret file %s: %v", path, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), value, nil
+
+	case strings.HasPrefix(value, secretPrefixMyCnf):
+		path := strings.TrimPrefix(value, secretPrefixMyCnf)
+		if path == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", "", fmt.Errorf("failed to locate home directory for ~/.my.cnf: %v", err)
+			}
+			path = filepath.Join(home, ".my.cnf")
+		}
+		secret, err := readMyCnfClientPassword(path)
+		if err != nil {
+			return "", "", err
+		}
+		return secret, secretPrefixMyCnf + path + " [client]", nil
+
+	case strings.HasPrefix(value, secretPrefixCmd):
+		command := strings.TrimSpace(strings.TrimPrefix(value, secretPrefixCmd))
+		if command == "" {
+			return "", "", fmt.Errorf("empty command in %q", value)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+		defer cancel()
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", "", fmt.Errorf("secret command failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		// Only the executable name is reported as source, arguments may carry tokens
+		fields := strings.Fields(command)
+		return strings.TrimRight(string(out), "\r\n"), secretPrefixCmd + fields[0], nil
+	}
+
+	return value, fmt.Sprintf("plaintext (%s)", origin), nil
+}
+
+// readMyCnfClientPassword reads the password option from the [client] section of a MySQL option file
+func readMyCnfClientPassword(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	inClientSection := false
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+
+		// Skip comments and empty lines
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		// Check for section headers
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inClientSection = strings.Trim(line, "[]") == "client"
+			continue
+		}
+
+		if !inClientSection {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "password" {
+			return unquoteOptionValue(strings.TrimSpace(parts[1])), nil
+		}
+	}
+
+	return "", fmt.Errorf("no password found in [client] section of %s", path)
+}
+
+// unquoteOptionValue removes one pair of matching quotes around an option file value;
+// unbalanced quotes are part of the value
+func unquoteOptionValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// ResolveSecrets resolves secret references in config fields and secret flags in place
+// and records the source of every non-empty secret in cfg.SecretSources
+func ResolveSecrets(cfg *Config, flags *Flags) error {
+	type secretField struct {
+		name   string
+		origin string
+		value  *string
+	}
+	fields := []secretField{
+		{"mysqlPassword", "config", &cfg.MysqlPassword},
+		{"accessKeySecret", "config", &cfg.AccessKeySecret},
+		{"streamKey", "config", &cfg.StreamKey},
+		{"qwenAPIKey", "config", &cfg.QwenAPIKey},
+		{"ai.apiKey", "config", &cfg.AI.APIKey},
+		{"--password", "command line", &flags.Password},
+		{"--stream-key", "command line", &flags.StreamKey},
+	}
+	for i := range cfg.Notify {
+		fields = append(fields,
+			secretField{fmt.Sprintf("notify[%d].url", i), "config", &cfg.Notify[i].URL},
+			secretField{fmt.Sprintf("notify[%d].secret", i), "config", &cfg.Notify[i].Secret})
+	}
+
+	cfg.SecretSources = nil
+	for _, f := range fields {
+		if *f.value == "" {
+			continue
+		}
+		secret, source, err := ResolveSecret(*f.value, f.origin)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %v", f.name, err)
+		}
+		*f.value = secret
+		cfg.SecretSources = append(cfg.SecretSources, SecretSource{Name: f.name, Source: source, Origin: f.origin})
+	}
+	return nil
+}
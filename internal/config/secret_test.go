@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BACKUP_HELPER_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		value      string
+		wantSecret string
+		wantSource string
+		wantErr    bool
+	}{
+		{"plain", "plain", "plaintext (config)", false},
+		{"env:BACKUP_HELPER_TEST_SECRET", "from-env", "env:BACKUP_HELPER_TEST_SECRET", false},
+		{"env:BACKUP_HELPER_TEST_UNSET", "", "", true},
+		{"env:", "", "", true},
+		{"file:" + secretFile, "from-file", "file:" + secretFile, false},
+		{"file:" + filepath.Join(dir, "missing"), "", "", true},
+		{"cmd:echo from-cmd", "from-cmd", "cmd:echo", false},
+		{"cmd:exit 3", "", "", true},
+		{"literal:env:not-a-reference", "env:not-a-reference", "plaintext (config)", false},
+		{"literal:", "", "plaintext (config)", false},
+	}
+	for _, tt := range tests {
+		secret, source, err := ResolveSecret(tt.value, "config")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ResolveSecret(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if secret != tt.wantSecret || source != tt.wantSource {
+			t.Errorf("ResolveSecret(%q) = %q, %q; want %q, %q", tt.value, secret, source, tt.wantSecret, tt.wantSource)
+		}
+	}
+}
+
+func TestReadMyCnfClientPassword(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "[client]\npassword=abc\n", "abc", false},
+		{"double quotes", "[client]\npassword = \"a b\"\n", "a b", false},
+		{"single quotes", "[client]\npassword='x\"y'\n", "x\"y", false},
+		{"unbalanced quote kept", "[client]\npassword=abc'\n", "abc'", false},
+		{"mismatched quotes kept", "[client]\npassword=\"abc'\n", "\"abc'", false},
+		{"other section ignored", "[mysqld]\npassword=server\n[client]\nuser=root\npassword=client\n", "client", false},
+		{"no client password", "[mysqld]\npassword=server\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "my.cnf")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readMyCnfClientPassword(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSecretsRunsCommand(t *testing.T) {
+	cfg := &Config{MysqlPassword: "cmd:echo from-cmd", AccessKeySecret: "literal:cmd:plain", StreamKey: "plain"}
+	flags := &Flags{Password: "cmd:echo from-flag"}
+	if err := ResolveSecrets(cfg, flags); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MysqlPassword != "from-cmd" || cfg.AccessKeySecret != "cmd:plain" || flags.Password != "from-flag" {
+		t.Errorf("resolved %q, %q, %q", cfg.MysqlPassword, cfg.AccessKeySecret, flags.Password)
+	}
+	want := map[string]bool{"mysqlPassword": true, "accessKeySecret": false, "streamKey": false, "--password": false}
+	for _, s := range cfg.SecretSources {
+		if s.RunsCommand() != want[s.Name] {
+			t.Errorf("%s (%s, %s): RunsCommand = %v, want %v", s.Name, s.Source, s.Origin, s.RunsCommand(), want[s.Name])
+		}
+		delete(want, s.Name)
+	}
+	if len(want) > 0 {
+		t.Errorf("no source recorded for %v", want)
+	}
+}
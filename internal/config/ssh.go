@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SSHRemoteArgList splits sshRemoteArgs into arguments the way a POSIX shell does, so that
+// quoted values such as --target-dir '/data/my backups' stay one argument
+func (c *Config) SSHRemoteArgList() ([]string, error) {
+	args, err := splitShellWords(c.SSHRemoteArgs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sshRemoteArgs %q: %v", c.SSHRemoteArgs, err)
+	}
+	return args, nil
+}
+
+// splitShellWords splits s at unquoted whitespace. Single quotes keep everything literally,
+// double quotes keep everything except backslash escapes of ", \, $ and `, and a backslash
+// outside quotes escapes the next character. Variables and globs are not expanded.
+func splitShellWords(s string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune // 0, '\'' or '"'
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"  --compress zstd ", []string{"--compress", "zstd"}, false},
+		{"--target-dir '/data/my backups'", []string{"--target-dir", "/data/my backups"}, false},
+		{`--target-dir "/data/my backups"`, []string{"--target-dir", "/data/my backups"}, false},
+		{`--target-dir /data/my\ backups`, []string{"--target-dir", "/data/my backups"}, false},
+		{`--output="a \"b\" \c"`, []string{"--output=a \"b\" \\c"}, false},
+		{`'it'\''s' ""`, []string{"it's", ""}, false},
+		{"'$HOME' *", []string{"$HOME", "*"}, false},
+		{"--target-dir '/data", nil, true},
+		{`--target-dir "/data`, nil, true},
+		{`--target-dir /data\`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitShellWords(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitShellWords(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitShellWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Commands accepted by Request
+const (
+	CommandStatus = "status"
+	CommandLimit  = "limit"
+	CommandPause  = "pause"
+	CommandResume = "resume"
+	CommandCancel = "cancel"
+)
+
+// clientTimeout bounds one control request
+const clientTimeout = 10 * time.Second
+
+// Request sends command to the control socket at path and returns the status reported after it.
+// limit (bytes/s, 0 = unlimited) is only used by CommandLimit.
+func Request(path, command string, limit int64) (*Status, error) {
+	client := &http.Client{
+		Timeout: clientTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	var resp *http.Response
+	var err error
+	switch command {
+	case CommandStatus:
+		resp, err = client.Get("http://backup-helper/status")
+	case CommandLimit:
+		resp, err = client.PostForm("http://backup-helper/limit", url.Values{"limit": {strconv.FormatInt(limit, 10)}})
+	case CommandPause, CommandResume, CommandCancel:
+		resp, err = client.Post("http://backup-helper/"+command, "text/plain", nil)
+	default:
+		return nil, fmt.Errorf("unknown control command '%s' (use status, limit, pause, resume or cancel)", command)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach control socket %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("control request failed: %s", strings.TrimSpace(string(body)))
+	}
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("invalid control response: %v", err)
+	}
+	return &status, nil
+}
+
+// socketNamePattern matches the names DefaultSocketPath gives to control sockets
+var socketNamePattern = regexp.MustCompile(`^backup-helper-\d+\.sock$`)
+
+// FindSockets returns the control sockets of running processes in the default location.
+// Sockets of this user that nobody listens on any more (left by a process that exited early) are removed.
+func FindSockets() []string {
+	matches, _ := filepath.Glob(filepath.Join(os.TempDir(), "backup-helper-*.sock"))
+	var live []string
+	for _, path := range matches {
+		if !socketNamePattern.MatchString(filepath.Base(path)) {
+			continue
+		}
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err != nil {
+			if staleSocket(path, err) {
+				os.Remove(path)
+			}
+			continue
+		}
+		conn.Close()
+		live = append(live, path)
+	}
+	return live
+}
+
+// staleSocket reports whether path is a Unix socket of the current user that refused
+// the connection attempt that failed with dialErr, i.e. whose process is gone
+func staleSocket(path string, dialErr error) bool {
+	if !errors.Is(dialErr, syscall.ECONNREFUSED) {
+		return false
+	}
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(stat.Uid) == os.Getuid()
+}
@@ -0,0 +1,308 @@
+package control
+
+import (
+	"backup-helper/internal/log"
+	"backup-helper/internal/rate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrCancelled is returned by gated readers after a cancel request
+var ErrCancelled = errors.New("cancelled by control request")
+
+// Status is the state of a running transfer as reported by the control socket
+type Status struct {
+	PID     int     `json:"pid"`
+	Command string  `json:"command"` // backup, existed-backup or download
+	State   string  `json:"state"`   // running, paused or cancelled
+	Bytes   int64   `json:"bytes"`   // Bytes passed through the gate so far
+	Total   int64   `json:"total"`   // Expected bytes; 0 = unknown
+	Elapsed float64 `json:"elapsedSeconds"`
+	Speed   int64   `json:"speed"`   // Average bytes/s since the start
+	IOLimit int64   `json:"ioLimit"` // Bytes/s; 0 = unlimited
+}
+
+// Server exposes a running transfer on a Unix socket: status, live IO limit, pause/resume and cancel.
+// Data must flow through Reader for pause, cancel and byte counts to work.
+type Server struct {
+	path         string
+	command      string
+	currentLimit func() int64
+	logCtx       *log.LogContext
+	listener     net.Listener
+	httpServer   *http.Server
+	start        time.Time
+	bytes        atomic.Int64
+	total        atomic.Int64
+
+	mu        sync.Mutex
+	limiters  []rate.Limiter
+	limit     int64 // Limit set through the socket; -1 = none yet
+	paused    bool
+	resumed   chan struct{} // Closed on resume or cancel
+	cancelled bool
+}
+
+// DefaultSocketPath returns the control socket path of this process
+func DefaultSocketPath() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("backup-helper-%d.sock", os.Getpid()))
+}
+
+// Listen starts the control server on the Unix socket at path. currentLimit reports the
+// configured IO limit for status requests until a limit is set through the socket.
+func Listen(path, command string, currentLimit func() int64, logCtx *log.LogContext) (*Server, error) {
+	if _, err := os.Stat(path); err == nil {
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control socket %s is in use by another process", path)
+		}
+		if staleSocket(path, err) {
+			os.Remove(path) // Left behind by a process that exited without cleanup
+		}
+	}
+	// Only the owner (and root) may control the transfer. The socket is created with these
+	// permissions, so nobody else can connect between its creation and a chmod.
+	oldUmask := syscall.Umask(0177)
+	listener, err := net.Listen("unix", path)
+	syscall.Umask(oldUmask)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket %s: %v", path, err)
+	}
+
+	s := &Server{
+		path:         path,
+		command:      command,
+		currentLimit: currentLimit,
+		logCtx:       logCtx,
+		listener:     listener,
+		start:        time.Now(),
+		limit:        -1,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/limit", s.handleLimit)
+	mux.HandleFunc("/pause", s.handlePause)
+	mux.HandleFunc("/resume", s.handleResume)
+	mux.HandleFunc("/cancel", s.handleCancel)
+	s.httpServer = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go s.httpServer.Serve(listener)
+	s.writeLog("Control socket listening on %s", path)
+	return s, nil
+}
+
+// Path returns the socket path; "" for a nil server
+func (s *Server) Path() string {
+	if s == nil {
+		return ""
+	}
+	return s.path
+}
+
+// SetTotal sets the expected number of bytes reported by status
+func (s *Server) SetTotal(total int64) {
+	if s != nil {
+		s.total.Store(total)
+	}
+}
+
+// AddLimiter lets limit requests reach l. A limit already set through the socket is applied at once.
+func (s *Server) AddLimiter(l rate.Limiter) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limiters = append(s.limiters, l)
+	if s.limit >= 0 {
+		l.UpdateRateLimit(s.limit)
+	}
+}
+
+// Reader gates r: reads block while paused and fail with ErrCancelled after a cancel request.
+// A nil server returns r unchanged.
+func (s *Server) Reader(r io.Reader) io.Reader {
+	if s == nil {
+		return r
+	}
+	return &gateReader{s: s, r: r}
+}
+
+// Cancelled reports whether a cancel request was received
+func (s *Server) Cancelled() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cancelled
+}
+
+// Close stops the server and removes the socket
+func (s *Server) Close() {
+	if s == nil {
+		return
+	}
+	s.httpServer.Close()
+	os.Remove(s.path)
+}
+
+type gateReader struct {
+	s *Server
+	r io.Reader
+}
+
+func (g *gateReader) Read(p []byte) (int, error) {
+	if err := g.s.wait(); err != nil {
+		return 0, err
+	}
+	n, err := g.r.Read(p)
+	g.s.bytes.Add(int64(n))
+	return n, err
+}
+
+// wait blocks while the transfer is paused
+func (s *Server) wait() error {
+	s.mu.Lock()
+	for {
+		if s.cancelled {
+			s.mu.Unlock()
+			return ErrCancelled
+		}
+		if !s.paused {
+			s.mu.Unlock()
+			return nil
+		}
+		resumed := s.resumed
+		s.mu.Unlock()
+		<-resumed
+		s.mu.Lock()
+	}
+}
+
+func (s *Server) status() Status {
+	s.mu.Lock()
+	state := "running"
+	switch {
+	case s.cancelled:
+		state = "cancelled"
+	case s.paused:
+		state = "paused"
+	}
+	limit := s.limit
+	s.mu.Unlock()
+	if limit < 0 {
+		limit = s.currentLimit()
+	}
+
+	elapsed := time.Since(s.start).Seconds()
+	bytes := s.bytes.Load()
+	var speed int64
+	if elapsed > 0 {
+		speed = int64(float64(bytes) / elapsed)
+	}
+	return Status{
+		PID:     os.Getpid(),
+		Command: s.command,
+		State:   state,
+		Bytes:   bytes,
+		Total:   s.total.Load(),
+		Elapsed: elapsed,
+		Speed:   speed,
+		IOLimit: limit,
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.reply(w)
+}
+
+func (s *Server) handleLimit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, err := strconv.ParseInt(r.FormValue("limit"), 10, 64)
+	if err != nil || limit < 0 {
+		http.Error(w, "limit must be bytes per second (0 = unlimited)", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.limit = limit
+	for _, l := range s.limiters {
+		l.UpdateRateLimit(limit)
+	}
+	s.mu.Unlock()
+	s.writeLog("IO limit set to %s by control request", rate.FormatLimit(limit))
+	s.reply(w)
+}
+
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	if !s.paused && !s.cancelled {
+		s.paused = true
+		s.resumed = make(chan struct{})
+		s.writeLog("Transfer paused by control request")
+	}
+	s.mu.Unlock()
+	s.reply(w)
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	if s.paused {
+		s.paused = false
+		close(s.resumed)
+		s.writeLog("Transfer resumed by control request")
+	}
+	s.mu.Unlock()
+	s.reply(w)
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	if !s.cancelled {
+		s.cancelled = true
+		if s.paused {
+			s.paused = false
+			close(s.resumed)
+		}
+		s.writeLog("Transfer cancelled by control request")
+	}
+	s.mu.Unlock()
+	s.reply(w)
+}
+
+func (s *Server) reply(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.status())
+}
+
+func (s *Server) writeLog(format string, args ...interface{}) {
+	if s.logCtx != nil {
+		s.logCtx.WriteLog("CTL", format, args...)
+	}
+}
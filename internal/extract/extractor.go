@@ -45,7 +45,7 @@ func ExtractBackupStream(reader io.Reader, compressType string, targetDir string
 		_, err = io.Copy(file, reader)
 		if err != nil {
 			if logCtx != nil {
-				logCtx.WriteLog("EXTRACT", "Failed to save stream: %v", err)
+				logCtx.WriteLogLevel(log.LevelError, "EXTRACT", nil, "Failed to save stream: %v", err)
 				// Check if it's a connection error
 				errStr := err.Error()
 				if strings.Contains(strings.ToLower(errStr), "eof") || strings.Contains(strings.ToLower(errStr), "broken pipe") || strings.Contains(strings.ToLower(errStr), "connection") {
@@ -88,8 +88,8 @@ func saveZstdDecompressed(reader io.Reader, outputPath string, parallel int, log
 	zstdCmd := exec.Command("zstd", "-d", fmt.Sprintf("-T%d", parallel), "-o", outputPath)
 	zstdCmd.Stdin = reader
 	if logCtx != nil {
-		zstdCmd.Stderr = logCtx.GetFile()
-		zstdCmd.Stdout = logCtx.GetFile()
+		zstdCmd.Stderr = logCtx.CommandWriter("ZSTD")
+		zstdCmd.Stdout = logCtx.CommandWriter("ZSTD")
 	} else {
 		zstdCmd.Stderr = os.Stderr
 		zstdCmd.Stdout = os.Stderr
@@ -97,7 +97,7 @@ func saveZstdDecompressed(reader io.Reader, outputPath string, parallel int, log
 
 	err := zstdCmd.Run()
 	if err != nil && logCtx != nil {
-		logCtx.WriteLog("DECOMPRESS", "zstd decompression failed: %v", err)
+		logCtx.WriteLogLevel(log.LevelError, "DECOMPRESS", nil, "zstd decompression failed: %v", err)
 	} else if logCtx != nil {
 		logCtx.WriteLog("DECOMPRESS", "zstd decompression completed successfully")
 	}
@@ -135,7 +135,7 @@ func extractZstdStream(reader io.Reader, targetDir string, parallel int, cfg *co
 	zstdCmd := exec.Command("zstd", "-d", fmt.Sprintf("-T%d", parallel), "-")
 	zstdCmd.Stdin = reader
 	if logCtx != nil {
-		zstdCmd.Stderr = logCtx.GetFile()
+		zstdCmd.Stderr = logCtx.CommandWriter("ZSTD")
 	} else {
 		zstdCmd.Stderr = os.Stderr
 	}
@@ -143,8 +143,8 @@ func extractZstdStream(reader io.Reader, targetDir string, parallel int, cfg *co
 	xbstreamCmd := exec.Command(xbstreamPath, "-x", fmt.Sprintf("--parallel=%d", parallel), "-C", targetDir)
 	xbstreamCmd.Stdin, _ = zstdCmd.StdoutPipe()
 	if logCtx != nil {
-		xbstreamCmd.Stderr = logCtx.GetFile()
-		xbstreamCmd.Stdout = logCtx.GetFile()
+		xbstreamCmd.Stderr = logCtx.CommandWriter("XBSTREAM")
+		xbstreamCmd.Stdout = logCtx.CommandWriter("XBSTREAM")
 	} else {
 		xbstreamCmd.Stderr = os.Stderr
 		xbstreamCmd.Stdout = os.Stderr
@@ -152,7 +152,7 @@ func extractZstdStream(reader io.Reader, targetDir string, parallel int, cfg *co
 
 	if err := zstdCmd.Start(); err != nil {
 		if logCtx != nil {
-			logCtx.WriteLog("DECOMPRESS", "Failed to start zstd: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "DECOMPRESS", nil, "Failed to start zstd: %v", err)
 		}
 		return fmt.Errorf("failed to start zstd decompression: %v", err)
 	}
@@ -160,7 +160,7 @@ func extractZstdStream(reader io.Reader, targetDir string, parallel int, cfg *co
 	if err := xbstreamCmd.Start(); err != nil {
 		zstdCmd.Process.Kill()
 		if logCtx != nil {
-			logCtx.WriteLog("XBSTREAM", "Failed to start xbstream: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "XBSTREAM", nil, "Failed to start xbstream: %v", err)
 		}
 		return fmt.Errorf("failed to start xbstream extraction: %v", err)
 	}
@@ -172,7 +172,7 @@ func extractZstdStream(reader io.Reader, targetDir string, parallel int, cfg *co
 	// Check if zstd failed due to connection error
 	if zstdErr != nil {
 		if logCtx != nil {
-			logCtx.WriteLog("DECOMPRESS", "zstd decompression failed: %v", zstdErr)
+			logCtx.WriteLogLevel(log.LevelError, "DECOMPRESS", nil, "zstd decompression failed: %v", zstdErr)
 		}
 		// Check if it's a connection error (broken pipe or EOF unexpectedly)
 		errStr := zstdErr.Error()
@@ -203,7 +203,7 @@ func extractZstdStream(reader io.Reader, targetDir string, parallel int, cfg *co
 	// Check if xbstream failed due to connection error
 	if xbstreamErr != nil {
 		if logCtx != nil {
-			logCtx.WriteLog("XBSTREAM", "xbstream extraction failed: %v", xbstreamErr)
+			logCtx.WriteLogLevel(log.LevelError, "XBSTREAM", nil, "xbstream extraction failed: %v", xbstreamErr)
 		}
 		// Check if it's a connection error (broken pipe or EOF unexpectedly)
 		errStr := xbstreamErr.Error()
@@ -271,7 +271,7 @@ func extractQpressStream(reader io.Reader, targetDir string, outputPath string,
 	if err != nil {
 		os.Remove(outputPath)
 		if logCtx != nil {
-			logCtx.WriteLog("EXTRACT", "Failed to save compressed stream: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "EXTRACT", nil, "Failed to save compressed stream: %v", err)
 			// Check if it's a connection error
 			errStr := err.Error()
 			if strings.Contains(strings.ToLower(errStr), "eof") || strings.Contains(strings.ToLower(errStr), "broken pipe") || strings.Contains(strings.ToLower(errStr), "connection") {
@@ -305,8 +305,8 @@ func extractQpressStream(reader io.Reader, targetDir string, outputPath string,
 	xbstreamCmd := exec.Command(xbstreamPath, "-x", fmt.Sprintf("--parallel=%d", parallel), "-C", targetDir)
 	xbstreamCmd.Stdin = extractFile
 	if logCtx != nil {
-		xbstreamCmd.Stderr = logCtx.GetFile()
-		xbstreamCmd.Stdout = logCtx.GetFile()
+		xbstreamCmd.Stderr = logCtx.CommandWriter("XBSTREAM")
+		xbstreamCmd.Stdout = logCtx.CommandWriter("XBSTREAM")
 	} else {
 		xbstreamCmd.Stderr = os.Stderr
 		xbstreamCmd.Stdout = os.Stderr
@@ -315,7 +315,7 @@ func extractQpressStream(reader io.Reader, targetDir string, outputPath string,
 	if err := xbstreamCmd.Run(); err != nil {
 		os.Remove(outputPath)
 		if logCtx != nil {
-			logCtx.WriteLog("XBSTREAM", "xbstream extraction failed: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "XBSTREAM", nil, "xbstream extraction failed: %v", err)
 		}
 		return fmt.Errorf("xbstream extraction failed: %v", err)
 	}
@@ -328,8 +328,8 @@ func extractQpressStream(reader io.Reader, targetDir string, outputPath string,
 	// Step 3: Decompress extracted files using xtrabackup --decompress
 	xtrabackupCmd := exec.Command(xtrabackupPath, "--decompress", fmt.Sprintf("--parallel=%d", parallel), "--target-dir", targetDir)
 	if logCtx != nil {
-		xtrabackupCmd.Stderr = logCtx.GetFile()
-		xtrabackupCmd.Stdout = logCtx.GetFile()
+		xtrabackupCmd.Stderr = logCtx.CommandWriter("XTRABACKUP")
+		xtrabackupCmd.Stdout = logCtx.CommandWriter("XTRABACKUP")
 	} else {
 		xtrabackupCmd.Stderr = os.Stderr
 		xtrabackupCmd.Stdout = os.Stderr
@@ -338,7 +338,7 @@ func extractQpressStream(reader io.Reader, targetDir string, outputPath string,
 	if err := xtrabackupCmd.Run(); err != nil {
 		os.Remove(outputPath)
 		if logCtx != nil {
-			logCtx.WriteLog("DECOMPRESS", "xtrabackup decompression failed: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "DECOMPRESS", nil, "xtrabackup decompression failed: %v", err)
 		}
 		return fmt.Errorf("xtrabackup decompression failed: %v", err)
 	}
@@ -378,8 +378,8 @@ func extractXbstream(reader io.Reader, targetDir string, parallel int, cfg *conf
 	xbstreamCmd := exec.Command(xbstreamPath, "-x", fmt.Sprintf("--parallel=%d", parallel), "-C", targetDir)
 	xbstreamCmd.Stdin = reader
 	if logCtx != nil {
-		xbstreamCmd.Stderr = logCtx.GetFile()
-		xbstreamCmd.Stdout = logCtx.GetFile()
+		xbstreamCmd.Stderr = logCtx.CommandWriter("XBSTREAM")
+		xbstreamCmd.Stdout = logCtx.CommandWriter("XBSTREAM")
 	} else {
 		xbstreamCmd.Stderr = os.Stderr
 		xbstreamCmd.Stdout = os.Stderr
@@ -388,7 +388,7 @@ func extractXbstream(reader io.Reader, targetDir string, parallel int, cfg *conf
 	err = xbstreamCmd.Run()
 	if err != nil {
 		if logCtx != nil {
-			logCtx.WriteLog("XBSTREAM", "xbstream extraction failed: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "XBSTREAM", nil, "xbstream extraction failed: %v", err)
 		}
 		// Check if it's a connection error (broken pipe or EOF unexpectedly)
 		errStr := err.Error()
@@ -442,7 +442,7 @@ func ExtractBackupStreamToStdout(reader io.Reader, compressType string, parallel
 		zstdCmd := exec.Command("zstd", "-d", fmt.Sprintf("-T%d", parallel), "-")
 		zstdCmd.Stdin = reader
 		if logCtx != nil {
-			zstdCmd.Stderr = logCtx.GetFile()
+			zstdCmd.Stderr = logCtx.CommandWriter("ZSTD")
 		} else {
 			zstdCmd.Stderr = os.Stderr
 		}
@@ -454,7 +454,7 @@ func ExtractBackupStreamToStdout(reader io.Reader, compressType string, parallel
 
 		if err := zstdCmd.Start(); err != nil {
 			if logCtx != nil {
-				logCtx.WriteLog("DECOMPRESS", "Failed to start zstd: %v", err)
+				logCtx.WriteLogLevel(log.LevelError, "DECOMPRESS", nil, "Failed to start zstd: %v", err)
 			}
 			return nil, nil, fmt.Errorf("failed to start zstd decompression: %v", err)
 		}
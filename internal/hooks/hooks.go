@@ -0,0 +1,210 @@
+package hooks
+
+import (
+	"backup-helper/internal/config"
+	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gioco-play/easy-i18n/i18n"
+)
+
+// defaultTimeout limits a hook without its own timeout
+const defaultTimeout = 300 * time.Second
+
+// envPrefix starts the names of the environment variables that describe the run
+const envPrefix = "BACKUP_HELPER_"
+
+// Result is the outcome of one hook, reported in the phase_end event of its point
+type Result struct {
+	Command  string  `json:"command"`
+	ExitCode int     `json:"exitCode"`
+	Seconds  float64 `json:"seconds"`
+	Error    string  `json:"error,omitempty"`
+	Warning  bool    `json:"warning,omitempty"` // Failed, but the hook is warn-only
+}
+
+// Runner runs the lifecycle hooks of one run. Each hook point runs as a phase of the run,
+// so a failing fail-the-run hook is reported like any failed phase.
+type Runner struct {
+	hooks  []config.HookConfig
+	logCtx *log.LogContext
+
+	mu         sync.Mutex
+	env        map[string]string // Without envPrefix
+	failureRan bool
+}
+
+// Start prepares the hooks of a run of command; on-failure hooks run when any phase fails.
+// It returns nil (a no-op runner) when no hook is configured.
+func Start(cfg *config.Config, instance, command, mode string, logCtx *log.LogContext) *Runner {
+	if len(cfg.Hooks) == 0 {
+		return nil
+	}
+	r := &Runner{
+		hooks:  cfg.Hooks,
+		logCtx: logCtx,
+		env: map[string]string{
+			"COMMAND":    command,
+			"MODE":       mode,
+			"INSTANCE":   instance,
+			"MYSQL_HOST": cfg.MysqlHost,
+			"MYSQL_PORT": strconv.Itoa(cfg.MysqlPort),
+			"LOG_FILE":   logCtx.GetFileName(),
+			"RUN_ID":     logCtx.RunID(),
+			"PID":        strconv.Itoa(os.Getpid()),
+			"START_TIME": time.Now().Format(time.RFC3339),
+		},
+	}
+	for _, h := range cfg.Hooks {
+		if h.Point == config.HookOnFailure {
+			progress.AddListener(r.handle)
+			break
+		}
+	}
+	return r
+}
+
+// Set sets BACKUP_HELPER_<name> for the hooks that run from now on (nil-safe)
+func (r *Runner) Set(name, value string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.env[name] = value
+	r.mu.Unlock()
+}
+
+// Run runs the hooks of point in order. It returns an error when a fail-the-run hook fails;
+// the hooks after it are skipped. Warn-only hooks only log a warning (nil-safe).
+func (r *Runner) Run(point string) error {
+	if r == nil || !r.has(point) {
+		return nil
+	}
+	if strings.HasPrefix(point, "post-") {
+		r.Set("STATUS", "success")
+	}
+	progress.PhaseStart(point)
+	var results []Result
+	var runErr error
+	for _, h := range r.hooks {
+		if h.Point != point {
+			continue
+		}
+		result := r.exec(h)
+		if result.Error != "" && policy(h) == config.HookFail {
+			results = append(results, result)
+			runErr = fmt.Errorf("%s hook %q failed: %s", point, h.Command, result.Error)
+			break
+		}
+		if result.Error != "" {
+			result.Warning = true
+			r.logCtx.WriteLog("HOOK", "Warning: %s hook %q failed: %s (warn-only, continuing)", point, h.Command, result.Error)
+			i18n.Fprintf(os.Stderr, "Warning: %s hook failed: %s\n", point, result.Error)
+		}
+		results = append(results, result)
+	}
+	progress.PhaseEndDetails(point, runErr, results)
+	return runErr
+}
+
+// handle runs the on-failure hooks once, when the first phase fails
+func (r *Runner) handle(e progress.Event) {
+	if e.Event != progress.EventPhaseEnd || e.Status == "ok" || e.Phase == config.HookOnFailure {
+		return
+	}
+	r.mu.Lock()
+	if r.failureRan {
+		r.mu.Unlock()
+		return
+	}
+	r.failureRan = true
+	r.env["STATUS"] = "failure"
+	r.env["FAILED_PHASE"] = e.Phase
+	r.env["ERROR"] = e.Error
+	r.mu.Unlock()
+	r.Run(config.HookOnFailure)
+}
+
+func (r *Runner) has(point string) bool {
+	for _, h := range r.hooks {
+		if h.Point == point {
+			return true
+		}
+	}
+	return false
+}
+
+// exec runs one hook with the run environment and logs its output
+func (r *Runner) exec(h config.HookConfig) Result {
+	timeout := defaultTimeout
+	if h.Timeout > 0 {
+		timeout = time.Duration(h.Timeout) * time.Second
+	}
+	r.mu.Lock()
+	r.env["HOOK"] = h.Point
+	env := os.Environ()
+	names := make([]string, 0, len(r.env))
+	for name := range r.env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, envPrefix+name+"="+r.env[name])
+	}
+	r.mu.Unlock()
+
+	r.logCtx.WriteLog("HOOK", "Running %s hook: %s (timeout %s)", h.Point, h.Command, timeout)
+	i18n.Fprintf(os.Stderr, "[backup-helper] Running %s hook: %s\n", h.Point, h.Command)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.Env = env
+	// On timeout kill the whole process group, not only the shell
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = 5 * time.Second
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+	r.logCtx.WriteCommandOutput("HOOK", output)
+
+	result := Result{Command: h.Command, Seconds: time.Since(start).Seconds()}
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	case err != nil:
+		result.Error = err.Error()
+	}
+	if result.Error == "" {
+		r.logCtx.WriteLog("HOOK", "%s hook completed in %.1fs", h.Point, result.Seconds)
+	} else {
+		r.logCtx.WriteLogLevel(log.LevelError, "HOOK", nil, "%s hook failed after %.1fs: %s", h.Point, result.Seconds, result.Error)
+	}
+	return result
+}
+
+// policy returns what a failure of h does: pre-* hooks fail the run by default, on-failure hooks never do
+func policy(h config.HookConfig) string {
+	switch {
+	case h.Point == config.HookOnFailure:
+		return config.HookWarn
+	case h.OnError != "":
+		return h.OnError
+	case strings.HasPrefix(h.Point, "pre-"):
+		return config.HookFail
+	}
+	return config.HookWarn
+}
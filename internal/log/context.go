@@ -3,10 +3,12 @@ package log
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -29,45 +31,40 @@ func ensureLogsDir(logDir string) error {
 	return nil
 }
 
-// cleanOldLogs removes old log files, keeping only the most recent ones
-func cleanOldLogs(logDir string, keep int) error {
-	pattern := filepath.Join(logDir, "backup-helper-*.log")
-	files, err := filepath.Glob(pattern)
-	if err != nil {
-		return fmt.Errorf("failed to glob log files: %v", err)
-	}
+// flushInterval is how often buffered log entries are written to the file.
+// Warnings and errors are written at once, since a failing command may exit right after.
+const flushInterval = time.Second
 
-	if len(files) <= keep {
-		return nil
-	}
+// openLogs are the log contexts not closed yet, for FlushAll
+var (
+	openLogsMu sync.Mutex
+	openLogs   = map[*LogContext]bool{}
+)
 
-	// sort by modification time, keep the latest files
-	sort.Slice(files, func(i, j int) bool {
-		infoI, _ := os.Stat(files[i])
-		infoJ, _ := os.Stat(files[j])
-		if infoI == nil || infoJ == nil {
-			return false
-		}
-		return infoI.ModTime().After(infoJ.ModTime())
-	})
-
-	// remove old files
-	for _, f := range files[:len(files)-keep] {
-		if err := os.Remove(f); err != nil {
-			// log removal failure but don't interrupt the process
-			fmt.Printf("Warning: failed to remove old log file %s: %v\n", f, err)
-		}
+// FlushAll writes the buffered entries of all open logs to their files. Commands call it right
+// before os.Exit, which skips the deferred Close.
+func FlushAll() {
+	openLogsMu.Lock()
+	defer openLogsMu.Unlock()
+	for lc := range openLogs {
+		lc.Flush()
 	}
-
-	return nil
 }
 
 // LogContext manages unified log file for all operations
 type LogContext struct {
-	logFile     *os.File
 	logFileName string
 	logDir      string
+	format      string // FormatText or FormatJSON
+	runID       string
+
+	mu          sync.Mutex
+	logFile     *os.File
+	w           *bufio.Writer
+	instance    string
 	completedOK bool // Flag to mark if operation completed successfully
+	writers     []*commandWriter
+	stopFlush   chan struct{}
 }
 
 // NewLogContext creates a new log context with backup-helper-{timestamp}.log
@@ -80,6 +77,16 @@ type LogContext struct {
 // If logFileName is an absolute path and logDir is also specified, logDir will be ignored
 // and a warning will be printed (if verbose logging is enabled)
 func NewLogContext(logDir string, logFileName string) (*LogContext, error) {
+	return newLogContext(logDir, logFileName, true)
+}
+
+// NewLogContextWithoutRetention creates a log context like NewLogContext but leaves the logs
+// of earlier runs alone. Used for logs written next to data, e.g. the per-session logs of --serve.
+func NewLogContextWithoutRetention(logDir string, logFileName string) (*LogContext, error) {
+	return newLogContext(logDir, logFileName, false)
+}
+
+func newLogContext(logDir string, logFileName string, prune bool) (*LogContext, error) {
 	var finalLogFileName string
 	originalLogDir := logDir // Store original for conflict detection
 
@@ -113,7 +120,6 @@ func NewLogContext(logDir string, logFileName string) (*LogContext, error) {
 		if err := ensureLogsDir(logDir); err != nil {
 			return nil, err
 		}
-		cleanOldLogs(logDir, 10)
 		timestamp := time.Now().Format("20060102150405")
 		finalLogFileName = filepath.Join(logDir, fmt.Sprintf("backup-helper-%s.log", timestamp))
 	}
@@ -122,52 +128,95 @@ func NewLogContext(logDir string, logFileName string) (*LogContext, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create log file: %v", err)
 	}
+	// Held while the log is open, so that the retention of other runs leaves it alone
+	syscall.Flock(int(logFile.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
 
+	format, instance := defaults()
 	ctx := &LogContext{
 		logFile:     logFile,
+		w:           bufio.NewWriterSize(logFile, 64*1024),
 		logFileName: finalLogFileName,
 		logDir:      logDir,
+		format:      format,
+		runID:       newRunID(),
+		instance:    instance,
 		completedOK: false, // Default to false, will be set to true on successful completion
+		stopFlush:   make(chan struct{}),
 	}
+	go ctx.flushLoop()
+	openLogsMu.Lock()
+	openLogs[ctx] = true
+	openLogsMu.Unlock()
 
 	// Write initial header
 	timestampFormatted := time.Now().Format("2006-01-02 15:04:05")
 	ctx.WriteLog("SYSTEM", "=== MySQL Backup Helper Log Started ===")
 	ctx.WriteLog("SYSTEM", "Timestamp: %s", timestampFormatted)
+	ctx.WriteLog("SYSTEM", "Run ID: %s", ctx.runID)
+
+	if !prune {
+		return ctx, nil
+	}
+	// Remove and compress the logs of earlier runs
+	removed, compressed, err := retention().prune(logDir, finalLogFileName)
+	if removed > 0 || compressed > 0 {
+		ctx.WriteLog("SYSTEM", "Log retention: removed %d and compressed %d earlier logs in %s", removed, compressed, logDir)
+	}
+	if err != nil {
+		ctx.WriteLog("SYSTEM", "Warning: log retention: %v", err)
+	}
 
 	return ctx, nil
 }
 
-// WriteLog writes a log entry with [MODULE] prefix and timestamp
+// WriteLog writes a log entry with [MODULE] prefix and timestamp. JSON entries are info unless the
+// message starts with "Warning:" or "Error:"; use WriteLogLevel for other warnings and errors.
 func (lc *LogContext) WriteLog(module string, format string, args ...interface{}) {
-	if lc.logFile == nil {
-		return
-	}
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
 	message := fmt.Sprintf(format, args...)
-	logEntry := fmt.Sprintf("[%s] [%s] %s\n", timestamp, module, message)
-	lc.logFile.WriteString(logEntry)
-	lc.logFile.Sync()
+	lc.write(messageLevel(message), module, message, nil)
+}
+
+// WriteLogLevel writes a log entry with an explicit level (LevelInfo, LevelWarn or LevelError)
+// and optional fields (see WriteLogFields)
+func (lc *LogContext) WriteLogLevel(level string, module string, fields Fields, format string, args ...interface{}) {
+	lc.write(level, module, fmt.Sprintf(format, args...), fields)
+}
+
+// WriteLogFields writes a log entry with structured fields, at the level of WriteLog. Fields become keys
+// of JSON entries; text lines only carry the message, which should therefore be complete on its own.
+func (lc *LogContext) WriteLogFields(module string, fields Fields, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	lc.write(messageLevel(message), module, message, fields)
 }
 
 // WriteCommandOutput writes command stderr/stdout to log
 func (lc *LogContext) WriteCommandOutput(module string, data []byte) {
-	if lc.logFile == nil || len(data) == 0 {
+	if len(data) == 0 {
 		return
 	}
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
 	scanner := bufio.NewScanner(strings.NewReader(string(data)))
 	for scanner.Scan() {
-		line := scanner.Text()
-		logEntry := fmt.Sprintf("[%s] [%s] %s\n", timestamp, module, line)
-		lc.logFile.WriteString(logEntry)
+		lc.write(messageLevel(scanner.Text()), module, scanner.Text(), nil)
 	}
-	lc.logFile.Sync()
 }
 
-// GetFile returns the underlying file for direct writing (e.g., redirecting command output)
-func (lc *LogContext) GetFile() *os.File {
-	return lc.logFile
+// CommandWriter returns a writer for the stdout/stderr of a command. Text logs get the output as is,
+// JSON logs one entry per line with the given module.
+func (lc *LogContext) CommandWriter(module string) io.Writer {
+	cw := &commandWriter{lc: lc, module: module}
+	lc.mu.Lock()
+	lc.writers = append(lc.writers, cw)
+	lc.mu.Unlock()
+	return cw
+}
+
+// Flush writes buffered entries to the log file, e.g. before the file is read
+func (lc *LogContext) Flush() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.w != nil {
+		lc.w.Flush()
+	}
 }
 
 // GetFileName returns the log file path
@@ -175,23 +224,101 @@ func (lc *LogContext) GetFileName() string {
 	return lc.logFileName
 }
 
+// RunID returns the ID of this run, written to every JSON entry and the text log header,
+// so that metrics and notifications can be linked to the log
+func (lc *LogContext) RunID() string {
+	return lc.runID
+}
+
+// Format returns the format of the log file (FormatText or FormatJSON)
+func (lc *LogContext) Format() string {
+	return lc.format
+}
+
+// SetInstance sets the instance written to JSON entries (default: set by SetDefaults)
+func (lc *LogContext) SetInstance(instance string) {
+	lc.mu.Lock()
+	lc.instance = instance
+	lc.mu.Unlock()
+}
+
 // MarkSuccess marks the operation as successfully completed
 // This will cause "completed OK!" to be written to the log before "Log Ended"
 func (lc *LogContext) MarkSuccess() {
+	lc.mu.Lock()
 	lc.completedOK = true
+	lc.mu.Unlock()
 }
 
 // Close closes the log file
 // If MarkSuccess() was called, it will write "completed OK!" before "Log Ended"
 func (lc *LogContext) Close() {
-	if lc.logFile != nil {
-		timestamp := time.Now().Format("2006-01-02 15:04:05")
-		if lc.completedOK {
-			lc.logFile.WriteString(fmt.Sprintf("[%s] [SYSTEM] completed OK!\n", timestamp))
+	lc.mu.Lock()
+	if lc.w == nil {
+		lc.mu.Unlock()
+		return
+	}
+	close(lc.stopFlush)
+	writers := lc.writers
+	completedOK := lc.completedOK
+	lc.mu.Unlock()
+	openLogsMu.Lock()
+	delete(openLogs, lc)
+	openLogsMu.Unlock()
+
+	for _, cw := range writers {
+		cw.flushLine()
+	}
+	if completedOK {
+		lc.write(LevelInfo, "SYSTEM", "completed OK!", nil)
+	}
+	lc.write(LevelInfo, "SYSTEM", "=== MySQL Backup Helper Log Ended ===", nil)
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.w.Flush()
+	lc.logFile.Sync()
+	lc.logFile.Close()
+	lc.w = nil
+	lc.logFile = nil
+}
+
+// write formats one entry into the buffer; warnings and errors are flushed at once
+func (lc *LogContext) write(level, module, message string, fields Fields) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.w == nil {
+		return
+	}
+	if lc.format == FormatJSON {
+		lc.w.Write(jsonEntry(time.Now(), level, module, lc.runID, lc.instance, message, fields))
+	} else {
+		lc.w.WriteString(textEntry(time.Now(), module, message))
+	}
+	if level != LevelInfo {
+		lc.w.Flush()
+	}
+}
+
+// writeRaw writes command output as is (text format)
+func (lc *LogContext) writeRaw(data []byte) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.w != nil {
+		lc.w.Write(data)
+	}
+}
+
+func (lc *LogContext) flushLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			lc.Flush()
+		case <-lc.stopFlush:
+			return
 		}
-		lc.logFile.WriteString(fmt.Sprintf("[%s] [SYSTEM] === MySQL Backup Helper Log Ended ===\n", timestamp))
-		lc.logFile.Close()
-		lc.logFile = nil
 	}
 }
 
@@ -201,7 +328,7 @@ func ExtractErrorSummary(module string, logContent string) string {
 		return ""
 	}
 
-	lines := strings.Split(logContent, "\n")
+	lines := TextLines(logContent)
 	errorLines := []string{}
 
 	switch module {
@@ -0,0 +1,213 @@
+package log
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log file formats (--log-format)
+const (
+	FormatText = "text" // [time] [MODULE] message lines (default)
+	FormatJSON = "json" // One JSON object per line, for Loki, ELK and similar
+)
+
+// Levels of JSON entries
+const (
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Fields are structured values of a log entry
+type Fields map[string]interface{}
+
+var (
+	defaultsMu      sync.Mutex
+	defaultFormat   = FormatText
+	defaultInstance string
+)
+
+// ValidateFormat checks a --log-format value
+func ValidateFormat(format string) error {
+	switch format {
+	case "", FormatText, FormatJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid log format %q (expected text or json)", format)
+}
+
+// SetDefaults sets the format and instance of log contexts created from now on
+func SetDefaults(format, instance string) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	if format == "" {
+		format = FormatText
+	}
+	defaultsMu.Lock()
+	defaultFormat = format
+	defaultInstance = instance
+	defaultsMu.Unlock()
+	return nil
+}
+
+func defaults() (string, string) {
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+	return defaultFormat, defaultInstance
+}
+
+// newRunID returns a unique, time-ordered run ID such as 20250101-120000-1a2b3c4d
+func newRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// levelTagRe matches the severity a message declares: a leading "Error:" or "Warning:", also after
+// the source of a command output line ("xtrabackup: error: ...", "InnoDB: Warning: ..."), or the
+// [ERROR] / [Warning] tag of xtrabackup 8.0 lines ("2024-01-02T03:04:05.123456+08:00 0 [ERROR] [MY-011825] ...")
+var levelTagRe = regexp.MustCompile(`(?i)^(?:[\w.-]+:\s*)?(error|warning|fatal error)\s*:|^(?:\S+\s+\d+\s+)?\[(error|warning)\]`)
+
+// messageLevel returns the level a message or command output line declares, info if none;
+// call sites that know better use WriteLogLevel
+func messageLevel(message string) string {
+	m := levelTagRe.FindStringSubmatch(message)
+	if m == nil {
+		return LevelInfo
+	}
+	if strings.EqualFold(m[1]+m[2], "warning") {
+		return LevelWarn
+	}
+	return LevelError
+}
+
+// textEntry formats a [time] [MODULE] message line
+func textEntry(t time.Time, module, message string) string {
+	return fmt.Sprintf("[%s] [%s] %s\n", t.Format("2006-01-02 15:04:05"), module, message)
+}
+
+// jsonEntry formats one JSON line. The fixed keys come first; fields cannot override them.
+func jsonEntry(t time.Time, level, module, runID, instance, message string, fields Fields) []byte {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	writeJSONField(&b, "time", t.Format(time.RFC3339Nano), true)
+	writeJSONField(&b, "level", level, false)
+	writeJSONField(&b, "module", module, false)
+	writeJSONField(&b, "runId", runID, false)
+	if instance != "" {
+		writeJSONField(&b, "instance", instance, false)
+	}
+	writeJSONField(&b, "msg", message, false)
+	for _, k := range sortedKeys(fields) {
+		switch k {
+		case "time", "level", "module", "runId", "instance", "msg":
+			continue
+		}
+		writeJSONField(&b, k, fields[k], false)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func writeJSONField(b *bytes.Buffer, key string, value interface{}, first bool) {
+	if !first {
+		b.WriteByte(',')
+	}
+	b.Write(marshalJSON(key))
+	b.WriteByte(':')
+	b.Write(marshalJSON(value))
+}
+
+// marshalJSON encodes v without escaping <, > and & (command output is full of them);
+// values that cannot be encoded are written as strings
+func marshalJSON(v interface{}) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		buf.Reset()
+		enc.Encode(fmt.Sprint(v))
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// TextLines splits a log into lines, rendering JSON entries as text lines so that
+// summaries read the same in both formats
+func TextLines(content string) []string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var entry map[string]interface{}
+		if json.Unmarshal([]byte(line), &entry) != nil {
+			continue
+		}
+		t, _ := time.Parse(time.RFC3339Nano, fmt.Sprint(entry["time"]))
+		lines[i] = strings.TrimSuffix(textEntry(t.Local(), fmt.Sprint(entry["module"]), fmt.Sprint(entry["msg"])), "\n")
+	}
+	return lines
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// commandWriter receives the stdout/stderr of a command (LogContext.CommandWriter)
+type commandWriter struct {
+	lc     *LogContext
+	module string
+
+	mu      sync.Mutex
+	partial []byte // JSON format: incomplete last line
+}
+
+func (cw *commandWriter) Write(p []byte) (int, error) {
+	if cw.lc.format != FormatJSON {
+		cw.lc.writeRaw(p)
+		return len(p), nil
+	}
+	cw.mu.Lock()
+	cw.partial = append(cw.partial, p...)
+	var lines []string
+	for {
+		i := bytes.IndexByte(cw.partial, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, strings.TrimRight(string(cw.partial[:i]), "\r"))
+		cw.partial = cw.partial[i+1:]
+	}
+	cw.mu.Unlock()
+	for _, line := range lines {
+		if line != "" {
+			cw.lc.write(messageLevel(line), cw.module, line, nil)
+		}
+	}
+	return len(p), nil
+}
+
+// flushLine writes an incomplete last line when the log is closed
+func (cw *commandWriter) flushLine() {
+	cw.mu.Lock()
+	line := strings.TrimRight(string(cw.partial), "\r\n")
+	cw.partial = nil
+	cw.mu.Unlock()
+	if line != "" && cw.lc.format == FormatJSON {
+		cw.lc.write(messageLevel(line), cw.module, line, nil)
+	}
+}
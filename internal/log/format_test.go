@@ -0,0 +1,31 @@
+package log
+
+import "testing"
+
+func TestMessageLevel(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"xtrabackup result: OK (stage completed, 120 files copied, LSN 1 to 2, 0 warnings, 0 errors)", LevelInfo},
+		{"Backup completed successfully", LevelInfo},
+		{"Fan-out to 2 sinks (failure policy: abort): oss, file:///backup", LevelInfo},
+		{"InnoDB: Operating system error number 2 in a file operation.", LevelInfo},
+		{"Warning: log retention: permission denied", LevelWarn},
+		{"Error: cannot open /backup", LevelError},
+		{"xtrabackup: error: log block numbers mismatch", LevelError},
+		{"xtrabackup: Error: cannot open ./xtrabackup_checkpoints", LevelError},
+		{"InnoDB: Warning: io_setup() failed with EAGAIN", LevelWarn},
+		{"FATAL ERROR: 2024-01-02 03:04:05 failed to execute query", LevelError},
+		{"2024-01-02T03:04:05.123456+08:00 0 [ERROR] [MY-011825] [Xtrabackup] failed to open the target stream", LevelError},
+		{"2024-01-02T03:04:05.123456+08:00 0 [Warning] [MY-011825] [Xtrabackup] Unknown option", LevelWarn},
+		{"2024-01-02T03:04:05.123456+08:00 0 [Note] [MY-011825] [Xtrabackup] Connecting to MySQL server host: localhost", LevelInfo},
+		{"[ERROR] target-dir does not exist", LevelError},
+		{"Copying ./ibdata1 to <STDOUT>", LevelInfo},
+	}
+	for _, tt := range tests {
+		if got := messageLevel(tt.message); got != tt.want {
+			t.Errorf("messageLevel(%q) = %s, want %s", tt.message, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultPattern matches the auto-generated log file names
+const defaultPattern = "backup-helper-*.log"
+
+// Values of the gzip header comment that records the result of a compressed log's run
+const (
+	gzipCommentSuccess = "backup-helper run=success"
+	gzipCommentFailure = "backup-helper run=failure"
+)
+
+// statusTail is how much of the end of a log is read to find out whether its run succeeded
+const statusTail = 64 * 1024
+
+// Retention decides which logs of earlier runs NewLogContext keeps in the log directory.
+// Zero counts and ages mean the defaults; logs of failed runs are kept 3 times as long by default.
+type Retention struct {
+	Keep         int           // Logs of successful runs (default: 10, -1 = no limit)
+	KeepFailed   int           // Logs of failed runs (default: 3 × Keep, -1 = no limit)
+	MaxAge       time.Duration // Age limit of logs of successful runs (0 = none)
+	FailedMaxAge time.Duration // Age limit of logs of failed runs (default: 3 × MaxAge)
+	MaxSize      int64         // Total size of the kept logs (0 = no limit)
+	Compress     bool          // gzip the logs that are kept
+	Patterns     []string      // Custom log file name globs, relative to the log directory or absolute
+}
+
+var (
+	retentionMu      sync.Mutex
+	defaultRetention = Retention{Compress: true}
+)
+
+// SetRetention sets the retention applied by log contexts created from now on
+func SetRetention(r Retention) {
+	retentionMu.Lock()
+	defaultRetention = r
+	retentionMu.Unlock()
+}
+
+func retention() Retention {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	return defaultRetention
+}
+
+// oldLog is a log file of an earlier run, locked while the retention handles it
+type oldLog struct {
+	path   string
+	size   int64
+	mtime  time.Time
+	failed bool
+	gz     bool
+	file   *os.File
+}
+
+// limits returns the count and age limits with the defaults applied
+func (r Retention) limits() (keep, keepFailed int, maxAge, failedMaxAge time.Duration) {
+	keep, keepFailed = r.Keep, r.KeepFailed
+	if keep == 0 {
+		keep = 10
+	}
+	if keepFailed == 0 {
+		keepFailed = keep * 3
+		if keep < 0 {
+			keepFailed = -1
+		}
+	}
+	maxAge, failedMaxAge = r.MaxAge, r.FailedMaxAge
+	if failedMaxAge == 0 {
+		failedMaxAge = maxAge * 3
+	}
+	return keep, keepFailed, maxAge, failedMaxAge
+}
+
+// prune removes the logs in dir that the retention does not keep and compresses the others.
+// current and logs still open in another process are left alone.
+func (r Retention) prune(dir, current string) (removed, compressed int, err error) {
+	logs, err := r.collect(dir, current)
+	defer func() {
+		for _, l := range logs {
+			l.file.Close()
+		}
+	}()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var errs []string
+	remove := func(l *oldLog) {
+		if err := os.Remove(l.path); err != nil {
+			errs = append(errs, err.Error())
+			return
+		}
+		l.path = ""
+		removed++
+	}
+
+	// Count and age limits, newest first
+	sort.Slice(logs, func(i, j int) bool { return logs[i].mtime.After(logs[j].mtime) })
+	keep, keepFailed, maxAge, failedMaxAge := r.limits()
+	var okCount, failedCount int
+	now := time.Now()
+	for _, l := range logs {
+		limit, age, n := keep, maxAge, &okCount
+		if l.failed {
+			limit, age, n = keepFailed, failedMaxAge, &failedCount
+		}
+		if (limit >= 0 && *n >= limit) || (age > 0 && now.Sub(l.mtime) > age) {
+			remove(l)
+			continue
+		}
+		*n++
+	}
+
+	// Size limit: oldest successful runs first, then oldest failed runs
+	if r.MaxSize > 0 {
+		var total int64
+		for _, l := range logs {
+			if l.path != "" {
+				total += l.size
+			}
+		}
+		for _, failed := range []bool{false, true} {
+			for i := len(logs) - 1; i >= 0 && total > r.MaxSize; i-- {
+				if l := logs[i]; l.path != "" && l.failed == failed {
+					total -= l.size
+					remove(l)
+				}
+			}
+		}
+	}
+
+	if r.Compress {
+		for _, l := range logs {
+			if l.path == "" || l.gz {
+				continue
+			}
+			if err := compressLog(l); err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			compressed++
+		}
+	}
+
+	if len(errs) > 0 {
+		err = fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return removed, compressed, err
+}
+
+// collect finds and locks the logs of earlier runs matching the patterns
+func (r Retention) collect(dir, current string) ([]*oldLog, error) {
+	var logs []*oldLog
+	seen := map[string]bool{current: true}
+	for _, pattern := range append([]string{defaultPattern}, r.Patterns...) {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		for _, p := range []string{pattern, pattern + ".gz"} {
+			matches, err := filepath.Glob(p)
+			if err != nil {
+				return logs, fmt.Errorf("invalid log pattern %q: %v", p, err)
+			}
+			for _, path := range matches {
+				if seen[path] {
+					continue
+				}
+				seen[path] = true
+				if l := openOldLog(path); l != nil {
+					logs = append(logs, l)
+				}
+			}
+		}
+	}
+	return logs, nil
+}
+
+// openOldLog opens and locks a log; it returns nil for directories and for logs that
+// another process still writes (NewLogContext holds a shared lock on its file)
+func openOldLog(path string) *oldLog {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		return nil
+	}
+	if syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) != nil {
+		f.Close()
+		return nil
+	}
+	l := &oldLog{path: path, size: info.Size(), mtime: info.ModTime(), gz: strings.HasSuffix(path, ".gz"), file: f}
+	if l.gz {
+		l.failed = gzipFailed(f)
+	} else {
+		l.failed = !succeeded(f, info.Size())
+	}
+	return l
+}
+
+// succeeded reports whether the log ends with the "completed OK!" of a successful run
+func succeeded(f *os.File, size int64) bool {
+	offset := size - statusTail
+	if offset < 0 {
+		offset = 0
+	}
+	data := make([]byte, size-offset)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return false
+	}
+	for _, line := range TextLines(string(data)) {
+		if strings.HasSuffix(line, "] [SYSTEM] completed OK!") {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipFailed reads the result recorded in the header of a compressed log; foreign files count as failed
+func gzipFailed(f *os.File) bool {
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return true
+	}
+	defer zr.Close()
+	return zr.Header.Comment != gzipCommentSuccess
+}
+
+// compressLog replaces a log with <log>.gz, keeping its modification time and recording its result
+func compressLog(l *oldLog) error {
+	target := l.path + ".gz"
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("cannot compress %s: %s exists", l.path, target)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), "."+filepath.Base(target)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := gzip.NewWriter(tmp)
+	zw.Name = filepath.Base(l.path)
+	zw.ModTime = l.mtime
+	zw.Comment = gzipCommentSuccess
+	if l.failed {
+		zw.Comment = gzipCommentFailure
+	}
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := io.Copy(zw, l.file); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot compress %s: %v", l.path, err)
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot compress %s: %v", l.path, err)
+	}
+	tmp.Chmod(0644)
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	os.Chtimes(tmp.Name(), l.mtime, l.mtime)
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+	return os.Remove(l.path)
+}
@@ -0,0 +1,336 @@
+package metrics
+
+import (
+	"backup-helper/internal/backup"
+	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric names. last_* gauges describe the latest run; *_total counters accumulate over runs in the textfile.
+const (
+	metricLastRun         = "backup_helper_last_run_timestamp_seconds"
+	metricLastRunInfo     = "backup_helper_last_run_info"
+	metricLastRunSuccess  = "backup_helper_last_run_success"
+	metricLastSuccess     = "backup_helper_last_success_timestamp_seconds"
+	metricLastDuration    = "backup_helper_last_duration_seconds"
+	metricLastBytes       = "backup_helper_last_bytes_transferred"
+	metricLastRatio       = "backup_helper_last_compression_ratio"
+	metricLastLockWait    = "backup_helper_last_lock_wait_seconds"
+	metricLastLockHeld    = "backup_helper_last_lock_held_seconds"
+	metricUploadRetries   = "backup_helper_upload_retries_total"
+	metricFailures        = "backup_helper_failures_total"
+	metricRunning         = "backup_helper_running"
+	metricTransferBytes   = "backup_helper_transfer_bytes"
+	metricTransferTotal   = "backup_helper_transfer_total_bytes"
+	metricTransferSpeed   = "backup_helper_transfer_speed_bytes"
+	metricTransferPercent = "backup_helper_transfer_progress_ratio"
+	metricElapsed         = "backup_helper_run_elapsed_seconds"
+)
+
+// metricInfo holds the HELP text and TYPE of each metric
+var metricInfo = map[string][2]string{
+	metricLastRun:         {"Unix time the latest run ended", "gauge"},
+	metricLastRunInfo:     {"Always 1; run_id is the runId of the latest run in its log", "gauge"},
+	metricLastRunSuccess:  {"1 if the latest run succeeded, 0 if it failed", "gauge"},
+	metricLastSuccess:     {"Unix time of the latest successful run", "gauge"},
+	metricLastDuration:    {"Duration of the latest run in seconds", "gauge"},
+	metricLastBytes:       {"Bytes transferred by the latest run", "gauge"},
+	metricLastRatio:       {"Compression ratio (uncompressed / sent) of the latest run", "gauge"},
+	metricLastLockWait:    {"Seconds xtrabackup waited for FTWRL or the backup lock in the latest run", "gauge"},
+	metricLastLockHeld:    {"Seconds xtrabackup held FTWRL or the backup lock in the latest run", "gauge"},
+	metricUploadRetries:   {"OSS part uploads retried", "counter"},
+	metricFailures:        {"Failed runs by the phase that failed", "counter"},
+	metricRunning:         {"1 while a run is in progress", "gauge"},
+	metricTransferBytes:   {"Bytes transferred so far by the running transfer", "gauge"},
+	metricTransferTotal:   {"Expected bytes of the running transfer (0 = unknown)", "gauge"},
+	metricTransferSpeed:   {"Current speed of the running transfer in bytes/s", "gauge"},
+	metricTransferPercent: {"Progress of the running transfer (0-1) when the total is known", "gauge"},
+	metricElapsed:         {"Seconds since the run started", "gauge"},
+}
+
+// Collector turns the progress events of one run into Prometheus metrics, served on /metrics
+// while the run lasts and written to a node_exporter textfile once the run has a result
+type Collector struct {
+	command   string
+	mainPhase string // Phase whose end is the result of the run
+	textfile  string
+	labels    string
+	runID     string
+	start     time.Time
+	logCtx    *log.LogContext
+	previous  map[string]float64 // Textfile content at start, to carry counters and the last success over
+	server    *http.Server
+	writeMu   sync.Mutex // Serialises textfile updates of this run
+
+	mu        sync.Mutex
+	bytes     int64 // Finished transfers
+	rawBytes  int64
+	live      progress.Event
+	counted   counters // This run
+	written   counters // Part of counted already added to the textfile
+	lockWait  float64
+	lockHeld  float64
+	hasLock   bool
+	finished  bool
+	succeeded bool
+	end       time.Time
+}
+
+// Start collects the metrics of a run of command whose result is the end of mainPhase.
+// It returns nil (a no-op collector) when neither addr nor textfile is set.
+func Start(addr, textfile, instance, command, mainPhase string, logCtx *log.LogContext) *Collector {
+	if addr == "" && textfile == "" {
+		return nil
+	}
+	c := &Collector{
+		command:   command,
+		mainPhase: mainPhase,
+		textfile:  textfile,
+		labels:    fmt.Sprintf(`instance="%s",command="%s"`, escapeLabel(instance), escapeLabel(command)),
+		start:     time.Now(),
+		logCtx:    logCtx,
+		previous:  map[string]float64{},
+		counted:   counters{failures: map[string]int64{}},
+		written:   counters{failures: map[string]int64{}},
+	}
+	if logCtx != nil {
+		c.runID = logCtx.RunID()
+	}
+	if textfile != "" {
+		previous, err := readTextfile(textfile)
+		if err != nil {
+			c.writeLog("Cannot read metrics textfile %s, counters start from zero: %v", textfile, err)
+		}
+		c.previous = previous
+	}
+	progress.AddListener(c.handle)
+
+	if addr != "" {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			c.writeLog("Metrics endpoint disabled: %v", err)
+		} else {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+				w.Write([]byte(format(c.series(c.previous, counters{}, true))))
+			})
+			c.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+			go c.server.Serve(listener)
+			c.writeLog("Serving metrics on http://%s/metrics", listener.Addr())
+		}
+	}
+	return c
+}
+
+// Stop closes the metrics endpoint and rewrites the textfile with the summaries of transfers
+// that completed after the run had its result (nil-safe)
+func (c *Collector) Stop() {
+	if c == nil {
+		return
+	}
+	if c.server != nil {
+		c.server.Close()
+	}
+	c.mu.Lock()
+	finished := c.finished
+	c.mu.Unlock()
+	if finished {
+		c.writeTextfile()
+	}
+}
+
+func (c *Collector) handle(e progress.Event) {
+	c.mu.Lock()
+	var finish bool
+	switch e.Event {
+	case progress.EventProgress:
+		c.live = e
+	case progress.EventSummary:
+		c.bytes += e.Bytes
+		c.rawBytes += e.RawBytes
+		c.live = progress.Event{}
+	case progress.EventRetry:
+		if e.Phase == "upload" {
+			c.counted.retries++
+		}
+	case progress.EventPhaseEnd:
+		if summary, ok := e.Details.(backup.XtrabackupSummary); ok && summary.LockType != "" {
+			c.hasLock = true
+			c.lockWait = summary.LockWaitSeconds
+			c.lockHeld = summary.LockHeldSeconds
+		}
+		if e.Status != "ok" {
+			c.counted.failures[e.Phase]++
+			c.succeeded = false
+			finish = true
+		} else if e.Phase == c.mainPhase && len(c.counted.failures) == 0 {
+			c.succeeded = true
+			finish = true
+		}
+		if finish {
+			c.finished = true
+			c.end = time.Now()
+		}
+	}
+	c.mu.Unlock()
+
+	if finish {
+		c.writeTextfile()
+	}
+}
+
+// writeTextfile merges this run into the textfile as it is now, so that the series and
+// counters written by other runs in the meantime are kept
+func (c *Collector) writeTextfile() {
+	if c.textfile == "" {
+		return
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	var counted counters
+	err := updateTextfile(c.textfile, func(current map[string]float64) map[string]float64 {
+		c.mu.Lock()
+		counted = c.counted.clone()
+		written := c.written
+		c.mu.Unlock()
+		return c.series(current, written, false)
+	})
+	if err != nil {
+		c.writeLog("Failed to write metrics textfile %s: %v", c.textfile, err)
+		return
+	}
+	c.mu.Lock()
+	c.written = counted
+	c.mu.Unlock()
+	c.writeLog("Metrics written to %s", c.textfile)
+}
+
+// counters are the counts of a run that accumulate in the *_total metrics
+type counters struct {
+	retries  int64
+	failures map[string]int64 // By phase
+}
+
+func (n counters) clone() counters {
+	out := counters{retries: n.retries, failures: map[string]int64{}}
+	for phase, v := range n.failures {
+		out.failures[phase] = v
+	}
+	return out
+}
+
+// series returns all samples by series name on top of base. Counters add what this run
+// counted beyond written, the part already in base. live adds the gauges of the running transfer.
+func (c *Collector) series(base map[string]float64, written counters, live bool) map[string]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := map[string]float64{}
+	for k, v := range base {
+		out[k] = v
+	}
+	key := func(name string) string { return name + "{" + c.labels + "}" }
+
+	// Counters continue from the textfile
+	out[key(metricUploadRetries)] = base[key(metricUploadRetries)] + float64(c.counted.retries-written.retries)
+	for phase, n := range c.counted.failures {
+		k := metricFailures + "{" + c.labels + `,reason="` + escapeLabel(phase) + `"}`
+		out[k] = base[k] + float64(n-written.failures[phase])
+	}
+
+	if c.runID != "" && (c.finished || live) {
+		// One run_id per instance and command: the one of this run replaces the previous one
+		for k := range out {
+			if strings.HasPrefix(k, metricLastRunInfo+"{"+c.labels+",") {
+				delete(out, k)
+			}
+		}
+		out[metricLastRunInfo+"{"+c.labels+`,run_id="`+escapeLabel(c.runID)+`"}`] = 1
+	}
+
+	if c.finished {
+		out[key(metricLastRun)] = float64(c.end.Unix())
+		out[key(metricLastDuration)] = c.end.Sub(c.start).Seconds()
+		// A transfer may report its summary after the phase ended, so its last progress counts too
+		bytes, rawBytes := c.bytes+c.live.Bytes, c.rawBytes+c.live.RawBytes
+		out[key(metricLastBytes)] = float64(bytes)
+		if c.succeeded {
+			out[key(metricLastRunSuccess)] = 1
+			out[key(metricLastSuccess)] = float64(c.end.Unix())
+		} else {
+			out[key(metricLastRunSuccess)] = 0
+		}
+		if rawBytes > 0 && bytes > 0 {
+			out[key(metricLastRatio)] = float64(rawBytes) / float64(bytes)
+		}
+		if c.hasLock {
+			out[key(metricLastLockWait)] = c.lockWait
+			out[key(metricLastLockHeld)] = c.lockHeld
+		}
+	}
+
+	if live {
+		running := 1.0
+		if c.finished {
+			running = 0
+		}
+		out[key(metricRunning)] = running
+		out[key(metricElapsed)] = time.Since(c.start).Seconds()
+		out[key(metricTransferBytes)] = float64(c.bytes + c.live.Bytes)
+		out[key(metricTransferTotal)] = float64(c.live.Total)
+		out[key(metricTransferSpeed)] = float64(c.live.Speed)
+		if c.live.Percent > 0 {
+			out[key(metricTransferPercent)] = c.live.Percent / 100
+		}
+	}
+	return out
+}
+
+func (c *Collector) writeLog(format string, args ...interface{}) {
+	if c.logCtx != nil {
+		c.logCtx.WriteLog("METRICS", format, args...)
+	}
+}
+
+// format renders samples in the Prometheus text exposition format, grouped by metric name
+func format(samples map[string]float64) string {
+	byName := map[string][]string{}
+	for series := range samples {
+		name := series
+		if i := strings.IndexByte(series, '{'); i >= 0 {
+			name = series[:i]
+		}
+		byName[name] = append(byName[name], series)
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		if info, ok := metricInfo[name]; ok {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, info[0], name, info[1])
+		}
+		list := byName[name]
+		sort.Strings(list)
+		for _, series := range list {
+			fmt.Fprintf(&b, "%s %s\n", series, strconv.FormatFloat(samples[series], 'f', -1, 64))
+		}
+	}
+	return b.String()
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
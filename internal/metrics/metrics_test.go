@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"backup-helper/internal/progress"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// A run rejected by its configuration reports the failed main phase without having started it
+func TestValidationFailureCounted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.prom")
+	c := Start("", path, "db1", "backup", "backup", nil)
+	progress.PhaseEnd("backup", errors.New("--stream-port is required when using --stream-host"))
+	c.Stop()
+
+	samples, err := readTextfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := `{instance="db1",command="backup"}`
+	if got := samples[`backup_helper_failures_total{instance="db1",command="backup",reason="backup"}`]; got != 1 {
+		t.Errorf("failures_total = %v, want 1", got)
+	}
+	if got, ok := samples[metricLastRunSuccess+labels]; !ok || got != 0 {
+		t.Errorf("last_run_success = %v (present %v), want 0", got, ok)
+	}
+	if got := samples[metricLastRun+labels]; got <= 0 {
+		t.Errorf("last_run_timestamp_seconds = %v, want the time of the failure", got)
+	}
+	if _, ok := samples[metricLastSuccess+labels]; ok {
+		t.Errorf("last_success_timestamp_seconds written for a failed run")
+	}
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// readTextfile returns the samples of a textfile written earlier; a missing file is empty
+func readTextfile(path string) (map[string]float64, error) {
+	samples := map[string]float64{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return samples, nil
+	}
+	if err != nil {
+		return samples, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i <= 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			continue
+		}
+		samples[line[:i]] = value
+	}
+	return samples, scanner.Err()
+}
+
+// updateTextfile rewrites the textfile with update applied to its current samples. An exclusive
+// lock on <path>.lock (not read by node_exporter) serialises the runs sharing the textfile,
+// so that none of them overwrites what another has just written.
+func updateTextfile(path string, update func(current map[string]float64) map[string]float64) error {
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	current, err := readTextfile(path)
+	if err != nil {
+		return err
+	}
+	return writeTextfile(path, update(current))
+}
+
+// writeTextfile replaces the textfile atomically, so node_exporter never reads a partial file.
+// The temporary name does not end in .prom and is ignored by the collector.
+func writeTextfile(path string, samples map[string]float64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(format(samples)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
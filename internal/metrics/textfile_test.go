@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"backup-helper/internal/progress"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestTextfileSharedByRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.prom")
+	failed := progress.Event{Event: progress.EventPhaseEnd, Phase: "stream", Status: "failed"}
+	retry := progress.Event{Event: progress.EventRetry, Phase: "upload"}
+
+	// Two runs of different instances start before either has written the textfile
+	a := Start("", path, "db1", "backup", "backup", nil)
+	b := Start("", path, "db2", "backup", "backup", nil)
+	a.handle(retry)
+	a.handle(failed)
+	b.handle(failed)
+	// Stop rewrites the textfile; the counters of a must not be added twice
+	a.Stop()
+	b.Stop()
+
+	// A later run of db1 continues its counters
+	c := Start("", path, "db1", "backup", "backup", nil)
+	c.handle(failed)
+	c.Stop()
+
+	samples, err := readTextfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{
+		`backup_helper_failures_total{instance="db1",command="backup",reason="stream"}`: 2,
+		`backup_helper_failures_total{instance="db2",command="backup",reason="stream"}`: 1,
+		`backup_helper_upload_retries_total{instance="db1",command="backup"}`:           1,
+		`backup_helper_upload_retries_total{instance="db2",command="backup"}`:           0,
+		`backup_helper_last_run_success{instance="db1",command="backup"}`:               0,
+		`backup_helper_last_run_success{instance="db2",command="backup"}`:               0,
+	}
+	for series, value := range want {
+		if got, ok := samples[series]; !ok || got != value {
+			t.Errorf("%s = %v (present %v), want %v", series, got, ok, value)
+		}
+	}
+}
+
+func TestTextfileConcurrentUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.prom")
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := updateTextfile(path, func(current map[string]float64) map[string]float64 {
+				current["counter"]++
+				return current
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	samples, err := readTextfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if samples["counter"] != writers {
+		t.Errorf("counter = %v after %d updates", samples["counter"], writers)
+	}
+}
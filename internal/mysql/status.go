@@ -0,0 +1,85 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ServerLoad is a snapshot of the server load indicators used by adaptive rate limiting
+type ServerLoad struct {
+	ThreadsRunning int64 // Threads_running
+	PendingIO      int64 // InnoDB pending reads, writes and fsyncs
+	ReplicaLag     int64 // Seconds behind the source; -1 if not a replica or the lag is unknown
+}
+
+// pendingIOStatus are the status counters summed into ServerLoad.PendingIO
+var pendingIOStatus = []string{
+	"Innodb_data_pending_reads",
+	"Innodb_data_pending_writes",
+	"Innodb_data_pending_fsyncs",
+	"Innodb_os_log_pending_fsyncs",
+}
+
+// GetServerLoad reads the current load indicators from the server
+func GetServerLoad(db *sql.DB) (ServerLoad, error) {
+	load := ServerLoad{ReplicaLag: -1}
+
+	names := append([]string{"Threads_running"}, pendingIOStatus...)
+	rows, err := db.Query("SHOW GLOBAL STATUS WHERE Variable_name IN ('" + strings.Join(names, "','") + "')")
+	if err != nil {
+		return load, fmt.Errorf("failed to read global status: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return load, fmt.Errorf("failed to read global status: %v", err)
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		if strings.EqualFold(name, "Threads_running") {
+			load.ThreadsRunning = n
+		} else {
+			load.PendingIO += n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return load, fmt.Errorf("failed to read global status: %v", err)
+	}
+
+	load.ReplicaLag = getReplicaLag(db)
+	return load, nil
+}
+
+// getReplicaLag returns Seconds_Behind_Source (Seconds_Behind_Master before 8.0.22),
+// or -1 if the server is not a replica, replication is stopped or the user may not read it
+func getReplicaLag(db *sql.DB) int64 {
+	for _, query := range []string{"SHOW REPLICA STATUS", "SHOW SLAVE STATUS"} {
+		rows, err := db.Query(query)
+		if err != nil {
+			continue // Older servers do not know SHOW REPLICA STATUS
+		}
+		lag := int64(-1)
+		columns, _ := rows.Columns()
+		if rows.Next() {
+			values := make([]sql.NullString, len(columns))
+			dest := make([]interface{}, len(columns))
+			for i := range values {
+				dest[i] = &values[i]
+			}
+			if err := rows.Scan(dest...); err == nil {
+				for i, column := range columns {
+					if (column == "Seconds_Behind_Source" || column == "Seconds_Behind_Master") && values[i].Valid {
+						if n, err := strconv.ParseInt(values[i].String, 10, 64); err == nil {
+							lag = n
+						}
+					}
+				}
+			}
+		}
+		rows.Close()
+		return lag
+	}
+	return -1
+}
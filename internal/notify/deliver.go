@@ -0,0 +1,206 @@
+package notify
+
+import (
+	"backup-helper/internal/config"
+	"backup-helper/internal/log"
+	"backup-helper/internal/utils"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of NotifyConfig.Timeout and NotifyConfig.Retries
+const (
+	defaultTimeout = 10 * time.Second
+	defaultRetries = 2
+)
+
+// deliver sends p to one notifier, retrying failed attempts with a growing delay until ctx is done
+func deliver(ctx context.Context, cfg config.NotifyConfig, p Payload, logCtx *log.LogContext) error {
+	timeout := defaultTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	retries := defaultRetries
+	if cfg.Retries > 0 {
+		retries = cfg.Retries
+	}
+
+	var err error
+	for attempt := 1; attempt <= retries+1; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		if cfg.Type == config.NotifyCommand {
+			err = runCommand(attemptCtx, cfg, p)
+		} else {
+			err = post(attemptCtx, cfg, p)
+		}
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt <= retries {
+			logCtx.WriteLogLevel(log.LevelWarn, "NOTIFY", nil, "%s notification failed (attempt %d/%d), retrying: %v", describe(cfg), attempt, retries+1, err)
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return fmt.Errorf("%v (gave up after %s)", err, deliveryDeadline)
+			}
+		}
+	}
+	return err
+}
+
+// post sends the payload, or a chat message built from it, to a webhook
+func post(ctx context.Context, cfg config.NotifyConfig, p Payload) error {
+	target := cfg.URL
+	var body interface{} = p
+	switch cfg.Type {
+	case config.NotifyDingTalk:
+		body = map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"title": title(p), "text": "### " + title(p) + "\n\n" + strings.Join(details(p, "- **%s**: %s"), "\n") + errorBlock(p, "\n\n```\n%s\n```")},
+		}
+		if cfg.Secret != "" {
+			target = signDingTalk(target, cfg.Secret, time.Now())
+		}
+	case config.NotifySlack:
+		body = map[string]string{
+			"text": "*" + title(p) + "*\n" + strings.Join(details(p, "• *%s*: %s"), "\n") + errorBlock(p, "\n```%s```"),
+		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	// DingTalk reports errors with HTTP 200 and a non-zero errcode
+	if cfg.Type == config.NotifyDingTalk {
+		var result struct {
+			ErrCode int    `json:"errcode"`
+			ErrMsg  string `json:"errmsg"`
+		}
+		if json.Unmarshal(respBody, &result) == nil && result.ErrCode != 0 {
+			return fmt.Errorf("DingTalk error %d: %s", result.ErrCode, result.ErrMsg)
+		}
+	}
+	return nil
+}
+
+// runCommand runs a command hook with the JSON payload on stdin
+func runCommand(ctx context.Context, cfg config.NotifyConfig, p Payload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", cfg.Command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"BACKUP_HELPER_STATUS="+p.Status,
+		"BACKUP_HELPER_RUN_ID="+p.RunID,
+		"BACKUP_HELPER_INSTANCE="+p.Instance,
+		"BACKUP_HELPER_COMMAND="+p.Command,
+		"BACKUP_HELPER_OBJECT="+p.ObjectName,
+	)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out")
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// signDingTalk adds the timestamp and signature DingTalk robots with "sign" security require
+func signDingTalk(target, secret string, now time.Time) string {
+	timestamp := strconv.FormatInt(now.UnixMilli(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + secret))
+	sign := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	sep := "?"
+	if strings.Contains(target, "?") {
+		sep = "&"
+	}
+	return target + sep + "timestamp=" + timestamp + "&sign=" + sign
+}
+
+func title(p Payload) string {
+	result := "succeeded"
+	if p.Status == "failure" {
+		result = "FAILED"
+	}
+	return fmt.Sprintf("backup-helper %s %s on %s", p.Command, result, p.Instance)
+}
+
+// details formats the payload fields of a chat message, one per line
+func details(p Payload, lineFormat string) []string {
+	fields := [][2]string{
+		{"Host", p.Host},
+		{"Run ID", p.RunID},
+		{"Mode", p.Mode},
+		{"Object", p.ObjectName},
+		{"Size", utils.FormatBytes(p.Size)},
+		{"Duration", time.Duration(p.DurationSeconds * float64(time.Second)).String()},
+		{"Failed phase", p.FailedPhase},
+		{"Error", p.Error},
+		{"Log file", p.LogFile},
+	}
+	var lines []string
+	for _, f := range fields {
+		if f[1] != "" {
+			lines = append(lines, fmt.Sprintf(lineFormat, f[0], f[1]))
+		}
+	}
+	return lines
+}
+
+func errorBlock(p Payload, blockFormat string) string {
+	if p.ErrorSummary == "" {
+		return ""
+	}
+	return fmt.Sprintf(blockFormat, p.ErrorSummary)
+}
+
+// describe names a notifier for the log without its URL, which may contain a token
+func describe(cfg config.NotifyConfig) string {
+	if cfg.Type == config.NotifyCommand {
+		return "command"
+	}
+	kind := cfg.Type
+	if kind == "" {
+		kind = config.NotifyWebhook
+	}
+	if u, err := url.Parse(cfg.URL); err == nil && u.Host != "" {
+		return kind + " " + u.Host
+	}
+	return kind
+}
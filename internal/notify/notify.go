@@ -0,0 +1,207 @@
+package notify
+
+import (
+	"backup-helper/internal/config"
+	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// Payload is the JSON document sent to webhooks and passed to command hooks on stdin
+type Payload struct {
+	Status          string  `json:"status"` // success or failure
+	RunID           string  `json:"runId"`  // runId of the log entries of this run
+	Instance        string  `json:"instance"`
+	Host            string  `json:"host"`
+	Command         string  `json:"command"` // backup, existed-backup, download or prepare
+	Mode            string  `json:"mode,omitempty"`
+	ObjectName      string  `json:"objectName,omitempty"` // OSS object, output file or target directory
+	Size            int64   `json:"size"`                 // Bytes transferred
+	StartTime       string  `json:"startTime"`
+	EndTime         string  `json:"endTime"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	FailedPhase     string  `json:"failedPhase,omitempty"`
+	Error           string  `json:"error,omitempty"`
+	ErrorSummary    string  `json:"errorSummary,omitempty"` // Relevant log lines (log.ExtractErrorSummary)
+	LogFile         string  `json:"logFile,omitempty"`
+}
+
+// errorSummaryModules maps a failed phase to the log module whose errors summarize it
+var errorSummaryModules = map[string]string{
+	"backup":   "BACKUP",
+	"upload":   "OSS",
+	"stream":   "TCP",
+	"fanout":   "TCP",
+	"download": "TCP",
+	"extract":  "EXTRACT",
+	"prepare":  "PREPARE",
+}
+
+// deliveryDeadline bounds the delivery of one result to all notifiers, retries included
+const deliveryDeadline = time.Minute
+
+// pending tracks the deliveries still running, which Wait lets finish before the process exits
+var pending sync.WaitGroup
+
+// Notifier sends the result of one run to the configured notifiers. A failure is sent in the
+// background as soon as a phase fails; a success is sent by Close, once transfers are complete.
+type Notifier struct {
+	notifiers []config.NotifyConfig
+	mainPhase string // Phase whose successful end is the success of the run
+	logCtx    *log.LogContext
+	start     time.Time
+
+	mu      sync.Mutex
+	payload Payload
+	bytes   int64
+	live    int64 // Bytes of a transfer that has not reported its summary yet
+	result  string
+	sent    bool
+}
+
+// Start watches the progress events of a run of command whose result is the end of mainPhase.
+// It returns nil (a no-op notifier) when no notifier is configured.
+func Start(cfg *config.Config, instance, command, mode, mainPhase string, logCtx *log.LogContext) *Notifier {
+	var notifiers []config.NotifyConfig
+	for _, n := range cfg.Notify {
+		if n.On == "" {
+			n.On = cfg.NotifyOn
+		}
+		if n.On == "" {
+			n.On = config.NotifyOnFailure
+		}
+		notifiers = append(notifiers, n)
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+	host, _ := os.Hostname()
+	n := &Notifier{
+		notifiers: notifiers,
+		mainPhase: mainPhase,
+		logCtx:    logCtx,
+		start:     time.Now(),
+		payload: Payload{
+			Instance: instance,
+			Host:     host,
+			Command:  command,
+			Mode:     mode,
+			RunID:    logCtx.RunID(),
+			LogFile:  logCtx.GetFileName(),
+		},
+	}
+	progress.AddListener(n.handle)
+	return n
+}
+
+// SetObject sets the object name reported in the payload (nil-safe)
+func (n *Notifier) SetObject(name string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	n.payload.ObjectName = name
+	n.mu.Unlock()
+}
+
+// Close sends the success notification once the run succeeded and waits for the deliveries (nil-safe)
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	send := n.result == "success" && !n.sent
+	n.mu.Unlock()
+	if send {
+		n.send()
+	}
+	Wait()
+}
+
+// Wait blocks until the notifications sent so far are delivered, or their deadline has passed.
+// Commands call it before exiting, so that a failure notification is not cut off.
+func Wait() {
+	pending.Wait()
+}
+
+func (n *Notifier) handle(e progress.Event) {
+	n.mu.Lock()
+	var failed bool
+	switch e.Event {
+	case progress.EventProgress:
+		n.live = e.Bytes
+	case progress.EventSummary:
+		n.bytes += e.Bytes
+		n.live = 0
+	case progress.EventPhaseEnd:
+		switch {
+		case e.Status != "ok" && n.result != "failure":
+			n.result = "failure"
+			n.payload.FailedPhase = e.Phase
+			n.payload.Error = e.Error
+			failed = true
+		case e.Status == "ok" && e.Phase == n.mainPhase && n.result == "":
+			n.result = "success"
+		}
+	}
+	n.mu.Unlock()
+
+	if failed {
+		n.send()
+	}
+}
+
+// send starts delivering the payload to every notifier whose "on" matches the result,
+// all of them in parallel and within deliveryDeadline
+func (n *Notifier) send() {
+	n.mu.Lock()
+	if n.sent {
+		n.mu.Unlock()
+		return
+	}
+	n.sent = true
+	end := time.Now()
+	p := n.payload
+	p.Status = n.result
+	p.Size = n.bytes + n.live
+	p.StartTime = n.start.Format(time.RFC3339)
+	p.EndTime = end.Format(time.RFC3339)
+	p.DurationSeconds = end.Sub(n.start).Seconds()
+	n.mu.Unlock()
+
+	n.logCtx.Flush()
+	if p.Status == "failure" {
+		if logContent, err := os.ReadFile(p.LogFile); err == nil {
+			module := errorSummaryModules[p.FailedPhase]
+			p.ErrorSummary = log.ExtractErrorSummary(module, string(logContent))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryDeadline)
+	var deliveries sync.WaitGroup
+	for _, cfg := range n.notifiers {
+		if cfg.On != config.NotifyOnAlways && cfg.On != p.Status {
+			continue
+		}
+		deliveries.Add(1)
+		pending.Add(1)
+		go func(cfg config.NotifyConfig) {
+			defer pending.Done()
+			defer deliveries.Done()
+			if err := deliver(ctx, cfg, p, n.logCtx); err != nil {
+				n.logCtx.WriteLogLevel(log.LevelWarn, "NOTIFY", nil, "%s notification failed: %v", describe(cfg), err)
+			} else {
+				n.logCtx.WriteLog("NOTIFY", "%s notification sent (%s)", describe(cfg), p.Status)
+			}
+		}(cfg)
+	}
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		deliveries.Wait()
+		cancel()
+	}()
+}
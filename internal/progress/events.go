@@ -0,0 +1,149 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress output formats (progressFormat)
+const (
+	FormatText = "text" // Human-readable "\rProgress: ..." lines (default)
+	FormatJSON = "json" // Newline-delimited JSON events
+)
+
+// Event types written in JSON progress mode
+const (
+	EventPhaseStart = "phase_start" // A phase of the command begins (backup, upload, download, extract, prepare, ...)
+	EventPhaseEnd   = "phase_end"   // A phase ends; Status is "ok" or "failed"
+	EventStage      = "stage"       // xtrabackup reached a stage (copying InnoDB, FTWRL, log copying, ...)
+	EventProgress   = "progress"    // Periodic transfer progress (every 500ms)
+	EventSummary    = "summary"     // Final statistics of a transfer
+	EventRetry      = "retry"       // A failed step is retried (e.g. an OSS part upload); Message tells which
+)
+
+// Event is one line of the JSON progress stream. Fields that do not apply are omitted.
+type Event struct {
+	Time    string `json:"time"`
+	Event   string `json:"event"`
+	Phase   string `json:"phase,omitempty"`
+	Stage   string `json:"stage,omitempty"`
+	Message string `json:"message,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	Direction string  `json:"direction,omitempty"` // upload or download
+	Bytes     int64   `json:"bytes,omitempty"`     // Bytes transferred
+	RawBytes  int64   `json:"rawBytes,omitempty"`  // Uncompressed bytes (zstd only)
+	Total     int64   `json:"total,omitempty"`     // Expected bytes (uncompressed when RawBytes is set)
+	Percent   float64 `json:"percent,omitempty"`
+	Speed     int64   `json:"speed,omitempty"`      // Bytes/s, smoothed (average in summaries)
+	ETA       float64 `json:"etaSeconds,omitempty"` // Seconds left; omitted when unknown
+	Ratio     float64 `json:"compressionRatio,omitempty"`
+	Elapsed   float64 `json:"elapsedSeconds,omitempty"`
+
+	Details interface{} `json:"details,omitempty"` // Phase result, e.g. the parsed xtrabackup summary
+}
+
+var (
+	eventMu     sync.Mutex
+	eventOut    io.Writer // nil = text progress
+	listeners   []func(Event)
+	phaseStarts = map[string]time.Time{}
+)
+
+// EnableJSONEvents switches progress reporting of the whole process to JSON events written to w.
+// Trackers then stop printing progress lines.
+func EnableJSONEvents(w io.Writer) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	eventOut = w
+}
+
+// JSONEvents reports whether progress is reported as JSON events
+func JSONEvents() bool {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	return eventOut != nil
+}
+
+// AddListener calls fn with every event, whether or not JSON events are written (e.g. for metrics)
+func AddListener(fn func(Event)) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	listeners = append(listeners, fn)
+}
+
+// Emit passes e to the listeners and, when JSON events are enabled, writes it as one JSON line
+func Emit(e Event) {
+	if e.Time == "" {
+		e.Time = time.Now().Format(time.RFC3339Nano)
+	}
+	eventMu.Lock()
+	notify := listeners
+	if eventOut != nil {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false) // xtrabackup messages contain <STDOUT> and >> markers
+		if err := enc.Encode(e); err == nil {
+			eventOut.Write(buf.Bytes())
+		}
+	}
+	eventMu.Unlock()
+	for _, fn := range notify {
+		fn(e)
+	}
+}
+
+// Retry reports that a failed step of phase is retried
+func Retry(phase, message string) {
+	Emit(Event{Event: EventRetry, Phase: phase, Message: message})
+}
+
+// PhaseStart reports the start of a phase of the running command
+func PhaseStart(phase string) {
+	eventMu.Lock()
+	phaseStarts[phase] = time.Now()
+	eventMu.Unlock()
+	Emit(Event{Event: EventPhaseStart, Phase: phase})
+}
+
+// PhaseEnd reports the end of a phase; a non-nil err marks it failed
+func PhaseEnd(phase string, err error) {
+	PhaseEndDetails(phase, err, nil)
+}
+
+// PhaseEndDetails is PhaseEnd with the result of the phase attached as details
+func PhaseEndDetails(phase string, err error, details interface{}) {
+	eventMu.Lock()
+	start, ok := phaseStarts[phase]
+	delete(phaseStarts, phase)
+	eventMu.Unlock()
+
+	e := Event{Event: EventPhaseEnd, Phase: phase, Status: "ok", Details: details}
+	if ok {
+		e.Elapsed = time.Since(start).Seconds()
+	}
+	if err != nil {
+		e.Status = "failed"
+		e.Error = err.Error()
+	}
+	Emit(e)
+}
+
+// Stage reports that xtrabackup reached a stage, with the log line that shows it
+func Stage(stage, message string) {
+	Emit(Event{Event: EventStage, Phase: "backup", Stage: stage, Message: message})
+}
+
+// ValidateFormat checks a progressFormat value ("" means text)
+func ValidateFormat(format string) error {
+	switch format {
+	case "", FormatText, FormatJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid progress format '%s' (use text or json)", format)
+}
@@ -24,6 +24,49 @@ type ProgressTracker struct {
 	mode           string // "upload" or "download"
 	outputToStderr bool   // If true, output progress to stderr instead of stdout
 	isCompressed   bool   // If true, don't show percentage (compression changes size)
+	quiet          bool   // If true, only count bytes (progress is reported elsewhere, e.g. fan-out)
+
+	// Uncompressed bytes counted before compression; with it a compressed transfer shows
+	// percentage, ETA and compression ratio against totalBytes
+	raw     *RawCounter
+	lastRaw int64
+
+	speed    float64 // Smoothed transfer speed (bytes/s)
+	rawSpeed float64 // Smoothed speed of the uncompressed bytes (bytes/s)
+}
+
+// speedSmoothing is the weight of the newest 500ms sample in the displayed speed
+const speedSmoothing = 0.3
+
+// RawCounter counts the uncompressed bytes of a stream before it is compressed
+type RawCounter struct {
+	n int64
+}
+
+// Add counts n more uncompressed bytes
+func (c *RawCounter) Add(n int64) {
+	atomic.AddInt64(&c.n, n)
+}
+
+// Bytes returns the uncompressed bytes counted so far
+func (c *RawCounter) Bytes() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// Reader returns r counting every byte read from it
+func (c *RawCounter) Reader(r io.Reader) io.Reader {
+	return &rawCountingReader{r: r, c: c}
+}
+
+type rawCountingReader struct {
+	r io.Reader
+	c *RawCounter
+}
+
+func (cr *rawCountingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.c.Add(int64(n))
+	return n, err
 }
 
 // NewProgressTracker creates a new progress tracker
@@ -66,6 +109,23 @@ func (pt *ProgressTracker) SetOutputToStderr(outputToStderr bool) {
 	pt.outputToStderr = outputToStderr
 }
 
+// SetQuiet disables console output; bytes are still counted
+func (pt *ProgressTracker) SetQuiet(quiet bool) {
+	pt.quiet = quiet
+}
+
+// SetTotalBytes sets the expected total size, e.g. when it is learned from the stream handshake.
+// Must be called before the first Update.
+func (pt *ProgressTracker) SetTotalBytes(totalBytes int64) {
+	pt.totalBytes = totalBytes
+}
+
+// SetRawCounter measures progress on uncompressed bytes counted before compression (nil = not available).
+// Must be called before the first Update.
+func (pt *ProgressTracker) SetRawCounter(raw *RawCounter) {
+	pt.raw = raw
+}
+
 // Update updates the uploaded bytes and displays progress
 func (pt *ProgressTracker) Update(bytes int64) {
 	// Start timer on first data transfer
@@ -82,7 +142,14 @@ func (pt *ProgressTracker) Update(bytes int64) {
 // Complete marks the transfer as complete and displays final statistics
 func (pt *ProgressTracker) Complete() {
 	pt.isComplete = true
+	if pt.quiet {
+		return
+	}
 	totalBytes := atomic.LoadInt64(&pt.uploadedBytes)
+	pt.emitSummary(totalBytes)
+	if JSONEvents() {
+		return
+	}
 
 	// Use stderr if outputToStderr is true, otherwise use stdout (via fmt.Print/i18n.Printf)
 	outputWriter := os.Stderr
@@ -117,14 +184,34 @@ func (pt *ProgressTracker) Complete() {
 		i18n.Fprintf(outputWriter, "[backup-helper] Upload completed!\n")
 		i18n.Fprintf(outputWriter, "  Total uploaded: %s\n", FormatBytes(totalBytes))
 	}
+	if pt.raw != nil && totalBytes > 0 {
+		raw := pt.raw.Bytes()
+		i18n.Fprintf(outputWriter, "  Uncompressed: %s (compression ratio %.2fx)\n", FormatBytes(raw), float64(raw)/float64(totalBytes))
+	}
 	i18n.Fprintf(outputWriter, "  Duration: %s\n", formatDuration(duration))
 	i18n.Fprintf(outputWriter, "  Average speed: %s/s\n", FormatBytes(int64(avgSpeed)))
 }
 
+// emitSummary reports the final statistics as a JSON event
+func (pt *ProgressTracker) emitSummary(totalBytes int64) {
+	e := Event{Event: EventSummary, Direction: pt.mode, Bytes: totalBytes, Total: pt.totalBytes}
+	if !pt.startTime.IsZero() {
+		e.Elapsed = time.Since(pt.startTime).Seconds()
+		if e.Elapsed > 0 {
+			e.Speed = int64(float64(totalBytes) / e.Elapsed)
+		}
+	}
+	if pt.raw != nil && totalBytes > 0 {
+		e.RawBytes = pt.raw.Bytes()
+		e.Ratio = float64(e.RawBytes) / float64(totalBytes)
+	}
+	Emit(e)
+}
+
 // displayProgress displays current progress
 func (pt *ProgressTracker) displayProgress() {
 	// Don't display if startTime hasn't been set yet
-	if pt.startTime.IsZero() {
+	if pt.quiet || pt.startTime.IsZero() {
 		return
 	}
 
@@ -136,29 +223,72 @@ func (pt *ProgressTracker) displayProgress() {
 		return
 	}
 
-	// Calculate speed based on last interval
-	duration := now.Sub(pt.lastUpdate)
-	bytesDiff := uploaded - pt.lastBytes
-	speed := float64(bytesDiff) / duration.Seconds()
+	// Smooth the speed of the last interval so the display does not jump
+	interval := now.Sub(pt.lastUpdate).Seconds()
+	pt.speed = smoothSpeed(pt.speed, float64(uploaded-pt.lastBytes)/interval)
+	elapsed := formatDuration(now.Sub(pt.startTime))
+	tapped := pt.raw != nil && pt.totalBytes > 0
+	var raw int64
+	if tapped {
+		raw = pt.raw.Bytes()
+		pt.rawSpeed = smoothSpeed(pt.rawSpeed, float64(raw-pt.lastRaw)/interval)
+		pt.lastRaw = raw
+	}
+
+	// The same numbers as a progress event, for listeners and JSON mode
+	e := Event{Event: EventProgress, Direction: pt.mode, Bytes: uploaded, Total: pt.totalBytes, Speed: int64(pt.speed), Elapsed: now.Sub(pt.startTime).Seconds()}
+	if tapped {
+		e.RawBytes = raw
+		e.Percent = percentOf(raw, pt.totalBytes)
+		e.ETA = etaSeconds(pt.totalBytes-raw, pt.rawSpeed)
+		if uploaded > 0 {
+			e.Ratio = float64(raw) / float64(uploaded)
+		}
+	} else if pt.totalBytes > 0 && !pt.isCompressed {
+		e.Percent = percentOf(uploaded, pt.totalBytes)
+		e.ETA = etaSeconds(pt.totalBytes-uploaded, pt.speed)
+	}
+	Emit(e)
+	if JSONEvents() {
+		pt.lastUpdate = now
+		pt.lastBytes = uploaded
+		return
+	}
 
 	// Display progress
 	var progressLine string
-	if pt.totalBytes > 0 && !pt.isCompressed {
+	if tapped {
+		// Compressed stream tapped before compression: progress of the uncompressed data
+		ratio := 0.0
+		if uploaded > 0 {
+			ratio = float64(raw) / float64(uploaded)
+		}
+		progressLine = fmt.Sprintf("\rProgress: %s / %s (%.1f%%) - sent %s (%.2fx) - %s/s - ETA: %s - Duration: %s",
+			FormatBytes(raw),
+			FormatBytes(pt.totalBytes),
+			percentOf(raw, pt.totalBytes),
+			FormatBytes(uploaded),
+			ratio,
+			FormatBytes(int64(pt.speed)),
+			eta(pt.totalBytes-raw, pt.rawSpeed),
+			elapsed,
+		)
+	} else if pt.totalBytes > 0 && !pt.isCompressed {
 		// Show percentage only when not compressed
-		percentage := float64(uploaded) * 100.0 / float64(pt.totalBytes)
-		progressLine = fmt.Sprintf("\rProgress: %s / %s (%.1f%%) - %s/s - Duration: %s",
+		progressLine = fmt.Sprintf("\rProgress: %s / %s (%.1f%%) - %s/s - ETA: %s - Duration: %s",
 			FormatBytes(uploaded),
 			FormatBytes(pt.totalBytes),
-			percentage,
-			FormatBytes(int64(speed)),
-			formatDuration(now.Sub(pt.startTime)),
+			percentOf(uploaded, pt.totalBytes),
+			FormatBytes(int64(pt.speed)),
+			eta(pt.totalBytes-uploaded, pt.speed),
+			elapsed,
 		)
 	} else {
 		// Unknown total size or compressed - don't show percentage
 		progressLine = fmt.Sprintf("\rProgress: %s - %s/s - Duration: %s",
 			FormatBytes(uploaded),
-			FormatBytes(int64(speed)),
-			formatDuration(now.Sub(pt.startTime)),
+			FormatBytes(int64(pt.speed)),
+			elapsed,
 		)
 	}
 
@@ -173,6 +303,40 @@ func (pt *ProgressTracker) displayProgress() {
 	pt.lastBytes = uploaded
 }
 
+// smoothSpeed blends a new speed sample into the previous smoothed value
+func smoothSpeed(prev, sample float64) float64 {
+	if prev == 0 {
+		return sample
+	}
+	return prev + speedSmoothing*(sample-prev)
+}
+
+// percentOf returns done/total in percent, capped at 100 (the total is an estimate)
+func percentOf(done, total int64) float64 {
+	p := float64(done) * 100.0 / float64(total)
+	if p > 100 {
+		p = 100
+	}
+	return p
+}
+
+// etaSeconds returns the seconds left for remaining bytes at speed, 0 when it cannot be estimated
+func etaSeconds(remaining int64, speed float64) float64 {
+	if remaining <= 0 || speed <= 0 {
+		return 0
+	}
+	return float64(remaining) / speed
+}
+
+// eta formats the time left for remaining bytes at speed, "--" when it cannot be estimated
+func eta(remaining int64, speed float64) string {
+	seconds := etaSeconds(remaining, speed)
+	if seconds == 0 {
+		return "--"
+	}
+	return formatDuration(time.Duration(seconds * float64(time.Second)))
+}
+
 // ProgressReader wraps an io.Reader to track progress
 type ProgressReader struct {
 	reader  io.Reader
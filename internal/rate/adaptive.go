@@ -0,0 +1,249 @@
+package rate
+
+import (
+	"backup-helper/internal/log"
+	"backup-helper/internal/utils"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limiter is a rate limiter whose limit can be changed while data flows
+type Limiter interface {
+	UpdateRateLimit(newLimit int64)
+}
+
+// LoadSample is one reading of the database server load
+type LoadSample struct {
+	ThreadsRunning int64
+	PendingIO      int64
+	ReplicaLag     int64 // Seconds; < 0 = not a replica / unknown
+}
+
+func (s LoadSample) String() string {
+	lag := "n/a"
+	if s.ReplicaLag >= 0 {
+		lag = fmt.Sprintf("%ds", s.ReplicaLag)
+	}
+	return fmt.Sprintf("threads_running=%d pending_io=%d replica_lag=%s", s.ThreadsRunning, s.PendingIO, lag)
+}
+
+// LoadThresholds are the load levels above which the limit is lowered (0 = indicator ignored).
+// The limit is raised again only while every indicator is at or below half of its threshold.
+type LoadThresholds struct {
+	MaxThreadsRunning int64
+	MaxPendingIO      int64
+	MaxReplicaLag     int64 // Seconds
+}
+
+// AdaptiveLimiter polls the server load and moves the limit of its limiters between min and max:
+// it halves the limit when the server is overloaded and raises it by a tenth of the range when it is idle.
+// A Scheduler or a control request may cap the range through CapFrom.
+type AdaptiveLimiter struct {
+	min, max   int64 // Configured range
+	thresholds LoadThresholds
+	probe      func() (LoadSample, error)
+	logCtx     *log.LogContext
+
+	mu       sync.Mutex
+	current  int64
+	ceiling  int64 // Cap on max set through CapFrom; 0 = none
+	limiters []Limiter
+	lastErr  string
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewAdaptiveLimiter creates an adaptive limiter starting at max. probe reads the server load.
+func NewAdaptiveLimiter(min, max int64, thresholds LoadThresholds, probe func() (LoadSample, error), logCtx *log.LogContext) *AdaptiveLimiter {
+	if min > max {
+		min = max
+	}
+	return &AdaptiveLimiter{
+		min:        min,
+		max:        max,
+		thresholds: thresholds,
+		probe:      probe,
+		logCtx:     logCtx,
+		current:    max,
+	}
+}
+
+// Add puts a limiter under adaptive control and sets it to the current limit
+func (a *AdaptiveLimiter) Add(l Limiter) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.limiters = append(a.limiters, l)
+	l.UpdateRateLimit(a.current)
+}
+
+// CurrentLimit returns the limit currently applied
+func (a *AdaptiveLimiter) CurrentLimit() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.current
+}
+
+// capSource is a Limiter through which one source caps an AdaptiveLimiter
+type capSource struct {
+	a      *AdaptiveLimiter
+	source string
+}
+
+func (c capSource) UpdateRateLimit(limit int64) {
+	c.a.setCeiling(limit, c.source)
+}
+
+// CapFrom returns a Limiter that caps the adaptive range at its limit (0 removes the cap), so a
+// time-of-day schedule or a control request and load-driven adjustment can be combined.
+// source names the origin of the cap in the log, e.g. "schedule".
+func (a *AdaptiveLimiter) CapFrom(source string) Limiter {
+	return capSource{a: a, source: source}
+}
+
+// setCeiling caps the adaptive range at limit (0 = no cap)
+func (a *AdaptiveLimiter) setCeiling(limit int64, source string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if limit == a.ceiling {
+		return
+	}
+	a.ceiling = limit
+	low, high := a.bounds()
+	next := a.current
+	if next > high {
+		next = high
+	}
+	if next < low {
+		next = low
+	}
+	if a.ceiling > 0 {
+		a.writeLog("Adaptive IO limit capped at %s/s by %s", utils.FormatBytes(high), source)
+	} else {
+		a.writeLog("Adaptive IO limit cap removed by %s (max %s/s)", source, utils.FormatBytes(high))
+	}
+	a.apply(next)
+}
+
+// bounds returns the range in effect after the cap
+func (a *AdaptiveLimiter) bounds() (low, high int64) {
+	low, high = a.min, a.max
+	if a.ceiling > 0 && a.ceiling < high {
+		high = a.ceiling
+	}
+	if low > high {
+		low = high
+	}
+	return low, high
+}
+
+// apply sets the limit of all limiters; the caller holds mu
+func (a *AdaptiveLimiter) apply(next int64) {
+	if next == a.current {
+		return
+	}
+	a.current = next
+	for _, l := range a.limiters {
+		l.UpdateRateLimit(next)
+	}
+}
+
+// Start polls the load every interval until Stop is called
+func (a *AdaptiveLimiter) Start(interval time.Duration) {
+	a.stop = make(chan struct{})
+	a.done = make(chan struct{})
+	a.writeLog("Adaptive IO limit between %s/s and %s/s, polling every %s (thresholds: threads_running=%d pending_io=%d replica_lag=%ds)",
+		utils.FormatBytes(a.min), utils.FormatBytes(a.max), interval, a.thresholds.MaxThreadsRunning, a.thresholds.MaxPendingIO, a.thresholds.MaxReplicaLag)
+	go func() {
+		defer close(a.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.poll()
+			case <-a.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends polling; the limiters keep their last limit
+func (a *AdaptiveLimiter) Stop() {
+	if a.stop == nil {
+		return
+	}
+	close(a.stop)
+	<-a.done
+	a.stop = nil
+}
+
+// poll reads the load once and adjusts the limit
+func (a *AdaptiveLimiter) poll() {
+	sample, err := a.probe()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err != nil {
+		// Keep the current limit; log each distinct error once
+		if err.Error() != a.lastErr {
+			a.lastErr = err.Error()
+			a.writeLog("Load probe failed, keeping IO limit at %s/s: %v", utils.FormatBytes(a.current), err)
+		}
+		return
+	}
+	a.lastErr = ""
+
+	low, high := a.bounds()
+	next := a.current
+	if reason := a.overloaded(sample); reason != "" {
+		next = a.current / 2
+		if next < low {
+			next = low
+		}
+		if next != a.current {
+			a.writeLog("IO limit lowered %s/s -> %s/s (%s; %s)", utils.FormatBytes(a.current), utils.FormatBytes(next), reason, sample)
+		}
+	} else if a.idle(sample) {
+		step := (high - low) / 10
+		if step < 1 {
+			step = 1
+		}
+		next = a.current + step
+		if next > high {
+			next = high
+		}
+		if next != a.current {
+			a.writeLog("IO limit raised %s/s -> %s/s (%s)", utils.FormatBytes(a.current), utils.FormatBytes(next), sample)
+		}
+	}
+	a.apply(next)
+}
+
+// overloaded returns why the sample exceeds a threshold, or "" if it does not
+func (a *AdaptiveLimiter) overloaded(s LoadSample) string {
+	t := a.thresholds
+	switch {
+	case t.MaxThreadsRunning > 0 && s.ThreadsRunning > t.MaxThreadsRunning:
+		return fmt.Sprintf("threads_running %d > %d", s.ThreadsRunning, t.MaxThreadsRunning)
+	case t.MaxPendingIO > 0 && s.PendingIO > t.MaxPendingIO:
+		return fmt.Sprintf("pending_io %d > %d", s.PendingIO, t.MaxPendingIO)
+	case t.MaxReplicaLag > 0 && s.ReplicaLag > t.MaxReplicaLag:
+		return fmt.Sprintf("replica_lag %ds > %ds", s.ReplicaLag, t.MaxReplicaLag)
+	}
+	return ""
+}
+
+// idle reports whether every indicator is at or below half of its threshold
+func (a *AdaptiveLimiter) idle(s LoadSample) bool {
+	t := a.thresholds
+	return (t.MaxThreadsRunning <= 0 || s.ThreadsRunning <= t.MaxThreadsRunning/2) &&
+		(t.MaxPendingIO <= 0 || s.PendingIO <= t.MaxPendingIO/2) &&
+		(t.MaxReplicaLag <= 0 || s.ReplicaLag <= t.MaxReplicaLag/2)
+}
+
+func (a *AdaptiveLimiter) writeLog(format string, args ...interface{}) {
+	if a.logCtx != nil {
+		a.logCtx.WriteLog("RATE", format, args...)
+	}
+}
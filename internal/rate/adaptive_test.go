@@ -0,0 +1,92 @@
+package rate
+
+import (
+	"backup-helper/internal/log"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fixedLimiter records the last limit it was set to
+type fixedLimiter struct{ limit int64 }
+
+func (f *fixedLimiter) UpdateRateLimit(limit int64) { f.limit = limit }
+
+func TestAdaptivePoll(t *testing.T) {
+	var sample LoadSample
+	var probeErr error
+	a := NewAdaptiveLimiter(10, 100, LoadThresholds{MaxThreadsRunning: 10, MaxReplicaLag: 60},
+		func() (LoadSample, error) { return sample, probeErr }, nil)
+	l := &fixedLimiter{}
+	a.Add(l)
+	if l.limit != 100 {
+		t.Fatalf("initial limit = %d, want the maximum 100", l.limit)
+	}
+
+	tests := []struct {
+		name   string
+		sample LoadSample
+		err    error
+		want   int64
+	}{
+		{"overloaded halves", LoadSample{ThreadsRunning: 20, ReplicaLag: -1}, nil, 50},
+		{"replica lag halves", LoadSample{ThreadsRunning: 1, ReplicaLag: 120}, nil, 25},
+		{"not below min", LoadSample{ThreadsRunning: 20, ReplicaLag: -1}, nil, 12},
+		{"not below min", LoadSample{ThreadsRunning: 20, ReplicaLag: -1}, nil, 10},
+		{"between half and threshold keeps", LoadSample{ThreadsRunning: 8, ReplicaLag: -1}, nil, 10},
+		{"idle raises by a tenth of the range", LoadSample{ThreadsRunning: 5, ReplicaLag: 30}, nil, 19},
+		{"probe error keeps", LoadSample{}, errors.New("connection lost"), 19},
+		{"idle again", LoadSample{ThreadsRunning: 0, ReplicaLag: -1}, nil, 28},
+	}
+	for _, tt := range tests {
+		sample, probeErr = tt.sample, tt.err
+		a.poll()
+		if l.limit != tt.want || a.CurrentLimit() != tt.want {
+			t.Errorf("%s: limit = %d (current %d), want %d", tt.name, l.limit, a.CurrentLimit(), tt.want)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		a.poll()
+	}
+	if l.limit != 100 {
+		t.Errorf("limit after idle polls = %d, want the maximum 100", l.limit)
+	}
+}
+
+func TestAdaptiveCapFrom(t *testing.T) {
+	logCtx, err := log.NewLogContext(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logCtx.Close()
+
+	a := NewAdaptiveLimiter(10, 100, LoadThresholds{}, nil, logCtx)
+	l := &fixedLimiter{}
+	a.Add(l)
+
+	tests := []struct {
+		source string
+		cap    int64
+		want   int64
+		logged string
+	}{
+		{"schedule", 50, 50, "Adaptive IO limit capped at 50 B/s by schedule"},
+		{"control request", 5, 5, "Adaptive IO limit capped at 5 B/s by control request"}, // below min
+		{"control request", 0, 10, "Adaptive IO limit cap removed by control request (max 100 B/s)"},
+	}
+	for _, tt := range tests {
+		a.CapFrom(tt.source).UpdateRateLimit(tt.cap)
+		if l.limit != tt.want {
+			t.Errorf("cap %d from %s: limit = %d, want %d", tt.cap, tt.source, l.limit, tt.want)
+		}
+		logCtx.Flush()
+		content, err := os.ReadFile(logCtx.GetFileName())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), tt.logged) {
+			t.Errorf("cap %d from %s: log does not contain %q:\n%s", tt.cap, tt.source, tt.logged, content)
+		}
+	}
+}
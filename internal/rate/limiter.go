@@ -62,6 +62,12 @@ func (rlw *RateLimitedWriter) Write(p []byte) (n int, err error) {
 	totalWritten := 0
 	for totalWritten < len(p) {
 		rlw.mu.Lock()
+		// The limit may be changed (e.g. by a control request) while a write waits for tokens
+		if rateLimit = rlw.rateLimit; rateLimit <= 0 {
+			rlw.mu.Unlock()
+			written, writeErr := rlw.writer.Write(p[totalWritten:])
+			return totalWritten + written, writeErr
+		}
 		now := time.Now()
 
 		// Refill tokens based on elapsed time
@@ -154,6 +160,25 @@ func NewRateLimitedReader(reader io.Reader, rateLimit int64) *RateLimitedReader
 	}
 }
 
+// UpdateRateLimit updates the rate limit dynamically
+func (rlr *RateLimitedReader) UpdateRateLimit(newLimit int64) {
+	rlr.mu.Lock()
+	rlr.rateLimit = newLimit
+	rlr.capacity = float64(newLimit) * 2
+	// Adjust tokens proportionally if needed
+	if rlr.tokens > rlr.capacity {
+		rlr.tokens = rlr.capacity
+	}
+	rlr.mu.Unlock()
+}
+
+// GetCurrentLimit returns the current rate limit
+func (rlr *RateLimitedReader) GetCurrentLimit() int64 {
+	rlr.mu.Lock()
+	defer rlr.mu.Unlock()
+	return rlr.rateLimit
+}
+
 // Read implements io.Reader with rate limiting
 func (rlr *RateLimitedReader) Read(p []byte) (n int, err error) {
 	rlr.mu.Lock()
@@ -168,6 +193,12 @@ func (rlr *RateLimitedReader) Read(p []byte) (n int, err error) {
 	totalRead := 0
 	for totalRead < len(p) {
 		rlr.mu.Lock()
+		// The limit may be changed (e.g. by a control request) while a read waits for tokens
+		if rateLimit = rlr.rateLimit; rateLimit <= 0 {
+			rlr.mu.Unlock()
+			read, readErr := rlr.reader.Read(p[totalRead:])
+			return totalRead + read, readErr
+		}
 		now := time.Now()
 
 		// Refill tokens based on elapsed time
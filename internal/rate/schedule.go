@@ -0,0 +1,118 @@
+package rate
+
+import (
+	"backup-helper/internal/log"
+	"backup-helper/internal/utils"
+	"sync"
+	"time"
+)
+
+// ScheduleCheckInterval is how often a Scheduler looks at the clock
+const ScheduleCheckInterval = 30 * time.Second
+
+// Scheduler switches its limiters to the time-of-day limit returned by limitAt whenever it changes
+type Scheduler struct {
+	limitAt  func(time.Time) int64 // Limit at a point in time; 0 = unlimited
+	describe func(time.Time) string
+	logCtx   *log.LogContext
+
+	mu       sync.Mutex
+	current  int64
+	limiters []Limiter
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewScheduler creates a scheduler starting at limitAt(now). describe names the active
+// schedule window for logging ("" = outside all windows) and may be nil.
+func NewScheduler(limitAt func(time.Time) int64, describe func(time.Time) string, logCtx *log.LogContext) *Scheduler {
+	return &Scheduler{
+		limitAt:  limitAt,
+		describe: describe,
+		logCtx:   logCtx,
+		current:  limitAt(time.Now()),
+	}
+}
+
+// Add puts a limiter under schedule control and sets it to the current limit
+func (s *Scheduler) Add(l Limiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limiters = append(s.limiters, l)
+	l.UpdateRateLimit(s.current)
+}
+
+// CurrentLimit returns the limit currently applied (0 = unlimited)
+func (s *Scheduler) CurrentLimit() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// Start checks the schedule every interval until Stop is called
+func (s *Scheduler) Start(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	s.writeLog("IO limit schedule active, current limit %s (%s)", FormatLimit(s.CurrentLimit()), s.window(time.Now()))
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.check(now)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends schedule checks; the limiters keep their last limit
+func (s *Scheduler) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+// check applies the limit in effect at now if it differs from the current one
+func (s *Scheduler) check(now time.Time) {
+	next := s.limitAt(now)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if next == s.current {
+		return
+	}
+	s.writeLog("IO limit switched %s -> %s (%s)", FormatLimit(s.current), FormatLimit(next), s.window(now))
+	s.current = next
+	for _, l := range s.limiters {
+		l.UpdateRateLimit(next)
+	}
+}
+
+func (s *Scheduler) window(t time.Time) string {
+	if s.describe != nil {
+		if w := s.describe(t); w != "" {
+			return "window " + w
+		}
+	}
+	return "outside schedule windows"
+}
+
+func (s *Scheduler) writeLog(format string, args ...interface{}) {
+	if s.logCtx != nil {
+		s.logCtx.WriteLog("RATE", format, args...)
+	}
+}
+
+// FormatLimit formats a rate limit for messages; 0 is unlimited
+func FormatLimit(limit int64) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return utils.FormatBytes(limit) + "/s"
+}
@@ -0,0 +1,199 @@
+package transfer
+
+import (
+	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fan-out failure policies
+const (
+	SinkPolicyAbort    = "abort"    // A failing sink aborts all sinks (default)
+	SinkPolicyContinue = "continue" // A failing sink is marked failed, the others keep going
+)
+
+const (
+	fanOutBufferSize   = 1024 * 1024
+	sinkReportInterval = 10 * time.Second
+)
+
+// Sink consumes one copy of a fanned-out stream
+type Sink struct {
+	Name string
+	// Consume reads the stream until io.EOF and returns nil only if the copy is complete
+	Consume func(r io.Reader) error
+}
+
+// SinkResult is the outcome of one sink
+type SinkResult struct {
+	Name     string
+	Bytes    int64
+	Duration time.Duration
+	Err      error
+}
+
+// fanOutSink is the pump side of one sink
+type fanOutSink struct {
+	sink     Sink
+	pipe     *io.PipeWriter
+	bytes    int64 // atomic: bytes accepted by the sink
+	state    int32 // atomic: 0 = running, 1 = failed
+	failed   error // pump only
+	reported bool  // pump only
+	done     chan struct{}
+	result   SinkResult
+}
+
+// FanOut copies src to all sinks concurrently, each sink reading from its own pipe.
+// Sinks advance in lockstep, so the slowest sink sets the pace. With SinkPolicyAbort the first
+// failing sink aborts the others and FanOut returns its error; with SinkPolicyContinue a failing
+// sink is dropped and FanOut only fails when every sink failed. A src error aborts all sinks.
+// tracker (may be nil) counts the bytes read from src.
+func FanOut(src io.Reader, sinks []Sink, policy string, tracker *progress.ProgressTracker, logCtx *log.LogContext) ([]SinkResult, error) {
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("no sinks configured")
+	}
+	start := time.Now()
+	fs := make([]*fanOutSink, len(sinks))
+	for i, sink := range sinks {
+		pr, pw := io.Pipe()
+		f := &fanOutSink{sink: sink, pipe: pw, done: make(chan struct{})}
+		fs[i] = f
+		go func() {
+			defer close(f.done)
+			err := f.sink.Consume(pr)
+			if err == nil {
+				// A sink that stops before the end of the stream has not got a complete copy
+				if _, rerr := pr.Read(make([]byte, 1)); rerr != io.EOF {
+					err = fmt.Errorf("sink stopped reading before the end of the stream")
+				}
+			}
+			pr.CloseWithError(err)
+			f.result = SinkResult{Name: f.sink.Name, Bytes: atomic.LoadInt64(&f.bytes), Duration: time.Since(start), Err: err}
+		}()
+	}
+
+	stopReport := make(chan struct{})
+	if logCtx != nil {
+		go reportSinks(fs, stopReport, logCtx)
+	}
+
+	err := pumpFanOut(src, fs, policy, tracker, logCtx)
+
+	// End all pipes: EOF for a complete stream, otherwise the error that stopped the fan-out
+	for _, f := range fs {
+		if err != nil {
+			f.pipe.CloseWithError(err)
+		} else {
+			f.pipe.Close()
+		}
+	}
+	results := make([]SinkResult, len(fs))
+	failed := 0
+	for i, f := range fs {
+		<-f.done
+		results[i] = f.result
+		if results[i].Err == nil && f.failed != nil {
+			results[i].Err = f.failed
+		}
+		if results[i].Err != nil {
+			failed++
+		}
+	}
+	close(stopReport)
+
+	if err != nil {
+		return results, err
+	}
+	for _, r := range results {
+		if r.Err != nil && policy != SinkPolicyContinue {
+			return results, fmt.Errorf("sink %s failed: %v", r.Name, r.Err)
+		}
+	}
+	if failed == len(results) {
+		return results, fmt.Errorf("all %d sinks failed", failed)
+	}
+	return results, nil
+}
+
+// pumpFanOut reads src and writes every chunk to all running sinks in parallel
+func pumpFanOut(src io.Reader, fs []*fanOutSink, policy string, tracker *progress.ProgressTracker, logCtx *log.LogContext) error {
+	buf := make([]byte, fanOutBufferSize)
+	var wg sync.WaitGroup
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if tracker != nil {
+				tracker.Update(int64(n))
+			}
+			chunk := buf[:n]
+			for _, f := range fs {
+				if f.failed != nil {
+					continue
+				}
+				wg.Add(1)
+				go func(f *fanOutSink) {
+					defer wg.Done()
+					if _, err := f.pipe.Write(chunk); err != nil {
+						f.failed = err
+						return
+					}
+					atomic.AddInt64(&f.bytes, int64(len(chunk)))
+				}(f)
+			}
+			wg.Wait()
+
+			running := 0
+			for _, f := range fs {
+				if f.failed == nil {
+					running++
+					continue
+				}
+				if f.reported {
+					continue
+				}
+				f.reported = true
+				atomic.StoreInt32(&f.state, 1)
+				if logCtx != nil {
+					logCtx.WriteLogLevel(log.LevelError, "FANOUT", nil, "Sink %s failed after %s: %v", f.sink.Name, progress.FormatBytes(atomic.LoadInt64(&f.bytes)), f.failed)
+				}
+				if policy != SinkPolicyContinue {
+					return fmt.Errorf("sink %s failed: %v", f.sink.Name, f.failed)
+				}
+			}
+			if running == 0 {
+				return fmt.Errorf("all %d sinks failed", len(fs))
+			}
+		}
+		if rerr == io.EOF {
+			return nil
+		}
+		if rerr != nil {
+			return fmt.Errorf("failed to read backup stream: %v", rerr)
+		}
+	}
+}
+
+// reportSinks logs the bytes delivered to each sink until stop is closed
+func reportSinks(fs []*fanOutSink, stop chan struct{}, logCtx *log.LogContext) {
+	ticker := time.NewTicker(sinkReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, f := range fs {
+				status := "running"
+				if atomic.LoadInt32(&f.state) == 1 {
+					status = "failed"
+				}
+				logCtx.WriteLog("FANOUT", "Sink %s: %s delivered (%s)", f.sink.Name, progress.FormatBytes(atomic.LoadInt64(&f.bytes)), status)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
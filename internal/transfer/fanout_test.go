@@ -0,0 +1,85 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// collectSink stores everything it reads
+func collectSink(name string, buf *bytes.Buffer) Sink {
+	return Sink{Name: name, Consume: func(r io.Reader) error {
+		_, err := io.Copy(buf, r)
+		return err
+	}}
+}
+
+// failingSink reads limit bytes, then fails
+func failingSink(name string, limit int64) Sink {
+	return Sink{Name: name, Consume: func(r io.Reader) error {
+		io.CopyN(io.Discard, r, limit)
+		return errors.New("disk full")
+	}}
+}
+
+func fanOutData() []byte {
+	data := make([]byte, 5*fanOutBufferSize+777)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func TestFanOutIdenticalCopies(t *testing.T) {
+	data := fanOutData()
+	bufs := make([]bytes.Buffer, 3)
+	sinks := []Sink{collectSink("a", &bufs[0]), collectSink("b", &bufs[1]), collectSink("c", &bufs[2])}
+
+	results, err := FanOut(bytes.NewReader(data), sinks, SinkPolicyAbort, nil, nil)
+	if err != nil {
+		t.Fatalf("FanOut: %v", err)
+	}
+	for i, r := range results {
+		if r.Err != nil || r.Bytes != int64(len(data)) {
+			t.Errorf("sink %s: %d bytes, error %v, want %d bytes", r.Name, r.Bytes, r.Err, len(data))
+		}
+		if !bytes.Equal(bufs[i].Bytes(), data) {
+			t.Errorf("sink %s received %d bytes differing from the %d sent", r.Name, bufs[i].Len(), len(data))
+		}
+	}
+}
+
+func TestFanOutFailingSink(t *testing.T) {
+	data := fanOutData()
+
+	// abort: the failure stops the whole fan-out
+	var ok bytes.Buffer
+	results, err := FanOut(bytes.NewReader(data), []Sink{collectSink("ok", &ok), failingSink("bad", fanOutBufferSize)}, SinkPolicyAbort, nil, nil)
+	if err == nil {
+		t.Fatal("FanOut with the abort policy and a failing sink returned nil")
+	}
+	if results[0].Err == nil || ok.Len() == len(data) {
+		t.Errorf("abort policy: sink ok got %d of %d bytes with error %v, want an aborted copy", ok.Len(), len(data), results[0].Err)
+	}
+	if results[1].Err == nil {
+		t.Error("abort policy: failing sink has no error")
+	}
+
+	// continue: the other sinks still get a complete copy
+	ok.Reset()
+	results, err = FanOut(bytes.NewReader(data), []Sink{collectSink("ok", &ok), failingSink("bad", fanOutBufferSize)}, SinkPolicyContinue, nil, nil)
+	if err != nil {
+		t.Fatalf("FanOut with the continue policy: %v", err)
+	}
+	if results[0].Err != nil || !bytes.Equal(ok.Bytes(), data) {
+		t.Errorf("continue policy: sink ok got %d of %d bytes with error %v, want a complete copy", ok.Len(), len(data), results[0].Err)
+	}
+	if results[1].Err == nil {
+		t.Error("continue policy: failing sink has no error")
+	}
+
+	// continue: FanOut fails once every sink has failed
+	if _, err := FanOut(bytes.NewReader(data), []Sink{failingSink("bad1", 0), failingSink("bad2", 10)}, SinkPolicyContinue, nil, nil); err == nil {
+		t.Error("FanOut with the continue policy and only failing sinks returned nil")
+	}
+}
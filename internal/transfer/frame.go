@@ -0,0 +1,256 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"syscall"
+)
+
+// Framed stream protocol
+//
+// With --framed, backup data is sent as a sequence of frames:
+//
+//	+---------+----------------+---------+
+//	| type 1B | length 4B (BE) | payload |
+//	+---------+----------------+---------+
+//
+// Data frames carry at most maxFramePayload bytes. The stream ends with exactly one
+// trailer frame whose payload is a JSON StreamTrailer. A receiver only reports success
+// after the trailer has been received and verified, so a dropped connection can never
+// be mistaken for a complete backup. A resumed stream's trailer covers the whole backup,
+// including the bytes the receiver had before the resume (see ResumeFrom).
+const (
+	frameData    byte = 1
+	frameTrailer byte = 2
+
+	frameHeaderSize   = 5
+	maxFramePayload   = 1024 * 1024
+	maxTrailerPayload = 64 * 1024
+)
+
+var (
+	// ErrStreamTruncated means the stream ended before the end-of-stream trailer
+	ErrStreamTruncated = errors.New("stream truncated: end-of-stream trailer not received")
+	// ErrStreamCorrupt means the trailer did not match the received data
+	ErrStreamCorrupt = errors.New("stream verification failed")
+	// ErrSenderFailed means the trailer reported a failed backup on the sender side
+	ErrSenderFailed = errors.New("sender reported backup failure")
+)
+
+// StreamTrailer is the last frame of a framed stream
+type StreamTrailer struct {
+	TotalBytes int64  `json:"totalBytes"` // Number of payload bytes, including a resumed prefix
+	SHA256     string `json:"sha256"`     // Hex SHA-256 of all payload bytes, including a resumed prefix
+	ExitStatus int    `json:"exitStatus"` // Backup exit status on the sender (0 = success)
+}
+
+// FrameWriter encodes written data as data frames and appends the trailer on WriteTrailer
+type FrameWriter struct {
+	w     io.Writer
+	hash  hash.Hash
+	total int64
+	buf   []byte
+}
+
+// NewFrameWriter creates a FrameWriter on top of w
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w: w, hash: sha256.New()}
+}
+
+// Write splits p into data frames
+func (fw *FrameWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > maxFramePayload {
+			n = maxFramePayload
+		}
+		if err := fw.writeFrame(frameData, p[:n]); err != nil {
+			return written, err
+		}
+		fw.hash.Write(p[:n])
+		fw.total += int64(n)
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// ResumeFrom adds the offset bytes before the resume point, read from prefix (the source),
+// to the byte count and hash, so that the trailer covers the whole backup. Call it before Write.
+func (fw *FrameWriter) ResumeFrom(prefix io.Reader, offset int64) error {
+	if err := hashPrefix(fw.hash, prefix, offset); err != nil {
+		return err
+	}
+	fw.total = offset
+	return nil
+}
+
+// WriteTrailer writes the end-of-stream trailer with the given backup exit status.
+// No data may be written afterwards.
+func (fw *FrameWriter) WriteTrailer(exitStatus int) (*StreamTrailer, error) {
+	trailer := &StreamTrailer{
+		TotalBytes: fw.total,
+		SHA256:     hex.EncodeToString(fw.hash.Sum(nil)),
+		ExitStatus: exitStatus,
+	}
+	data, err := json.Marshal(trailer)
+	if err != nil {
+		return nil, err
+	}
+	return trailer, fw.writeFrame(frameTrailer, data)
+}
+
+func (fw *FrameWriter) writeFrame(frameType byte, payload []byte) error {
+	fw.buf = append(fw.buf[:0], frameType, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(fw.buf[1:frameHeaderSize], uint32(len(payload)))
+	fw.buf = append(fw.buf, payload...)
+	_, err := fw.w.Write(fw.buf)
+	return err
+}
+
+// FrameReader decodes a framed stream. It returns io.EOF only after the trailer
+// has been verified; otherwise it returns ErrStreamTruncated, ErrStreamCorrupt or ErrSenderFailed.
+type FrameReader struct {
+	r         io.Reader
+	hash      hash.Hash
+	total     int64
+	remaining int64
+	trailer   *StreamTrailer
+	err       error
+}
+
+// NewFrameReader creates a FrameReader on top of r
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: r, hash: sha256.New()}
+}
+
+// Read returns payload bytes of data frames
+func (fr *FrameReader) Read(p []byte) (int, error) {
+	if fr.err != nil {
+		return 0, fr.err
+	}
+	for fr.remaining == 0 {
+		var header [frameHeaderSize]byte
+		if _, err := io.ReadFull(fr.r, header[:]); err != nil {
+			fr.err = fr.truncated(err)
+			return 0, fr.err
+		}
+		length := int64(binary.BigEndian.Uint32(header[1:]))
+		switch header[0] {
+		case frameData:
+			if length > maxFramePayload {
+				fr.err = fmt.Errorf("%w: data frame too large (%d bytes)", ErrStreamCorrupt, length)
+				return 0, fr.err
+			}
+			fr.remaining = length
+		case frameTrailer:
+			fr.err = fr.readTrailer(length)
+			return 0, fr.err
+		default:
+			fr.err = fmt.Errorf("%w: unknown frame type %d", ErrStreamCorrupt, header[0])
+			return 0, fr.err
+		}
+	}
+
+	if int64(len(p)) > fr.remaining {
+		p = p[:fr.remaining]
+	}
+	n, err := fr.r.Read(p)
+	fr.hash.Write(p[:n])
+	fr.total += int64(n)
+	fr.remaining -= int64(n)
+	if err != nil {
+		// Any error inside a data frame (including EOF) means the stream is incomplete
+		fr.err = fr.truncated(err)
+	}
+	return n, nil
+}
+
+// ResumeFrom adds the offset bytes already received before the resume point, read from prefix
+// (the partial output), to the byte count and hash, so that the trailer verifies the whole
+// output end to end. Call it before Read.
+func (fr *FrameReader) ResumeFrom(prefix io.Reader, offset int64) error {
+	if err := hashPrefix(fr.hash, prefix, offset); err != nil {
+		return err
+	}
+	fr.total = offset
+	return nil
+}
+
+// hashPrefix hashes exactly offset bytes of prefix
+func hashPrefix(h hash.Hash, prefix io.Reader, offset int64) error {
+	if n, err := io.CopyN(h, prefix, offset); err != nil {
+		return fmt.Errorf("failed to read the %d bytes before the resume point (got %d): %v", offset, n, err)
+	}
+	return nil
+}
+
+// Trailer returns the verified trailer, or nil if it has not been received
+func (fr *FrameReader) Trailer() *StreamTrailer {
+	if fr.err != io.EOF {
+		return nil
+	}
+	return fr.trailer
+}
+
+// Err returns the terminal state of the reader: nil while reading, io.EOF once the
+// trailer has been verified, or the error that ended the stream
+func (fr *FrameReader) Err() error {
+	return fr.err
+}
+
+// TotalBytes returns the number of payload bytes received so far
+func (fr *FrameReader) TotalBytes() int64 {
+	return fr.total
+}
+
+func (fr *FrameReader) truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w (received %d bytes)", ErrStreamTruncated, fr.total)
+	}
+	return fmt.Errorf("%w (received %d bytes): %v", ErrStreamTruncated, fr.total, err)
+}
+
+func (fr *FrameReader) readTrailer(length int64) error {
+	if length > maxTrailerPayload {
+		return fmt.Errorf("%w: trailer too large (%d bytes)", ErrStreamCorrupt, length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(fr.r, data); err != nil {
+		return fr.truncated(err)
+	}
+	var trailer StreamTrailer
+	if err := json.Unmarshal(data, &trailer); err != nil {
+		return fmt.Errorf("%w: invalid trailer: %v", ErrStreamCorrupt, err)
+	}
+	fr.trailer = &trailer
+
+	if trailer.TotalBytes != fr.total {
+		return fmt.Errorf("%w: trailer reports %d bytes, received %d bytes", ErrStreamCorrupt, trailer.TotalBytes, fr.total)
+	}
+	if sum := hex.EncodeToString(fr.hash.Sum(nil)); sum != trailer.SHA256 {
+		return fmt.Errorf("%w: SHA-256 mismatch (trailer %s, received %s)", ErrStreamCorrupt, trailer.SHA256, sum)
+	}
+	if trailer.ExitStatus != 0 {
+		return fmt.Errorf("%w (exit status %d)", ErrSenderFailed, trailer.ExitStatus)
+	}
+	return io.EOF
+}
+
+// IsConnectionInterrupted reports whether err means the stream connection was lost
+// before the transfer finished (truncated framed stream, lost parallel connection,
+// reset or broken connection).
+func IsConnectionInterrupted(err error) bool {
+	return errors.Is(err, ErrStreamTruncated) ||
+		errors.Is(err, ErrConnectionLost) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
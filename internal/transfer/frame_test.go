@@ -0,0 +1,139 @@
+package transfer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// framedStream encodes data in frames, optionally without the trailer
+func framedStream(t *testing.T, data []byte, exitStatus int, trailer bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	fw := NewFrameWriter(&buf)
+	if _, err := fw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if trailer {
+		if _, err := fw.WriteTrailer(exitStatus); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, maxFramePayload, maxFramePayload + 1, 3*maxFramePayload + 17} {
+		data := bytes.Repeat([]byte("0123456789abcdef"), size/16+1)[:size]
+		fr := NewFrameReader(bytes.NewReader(framedStream(t, data, 0, true)))
+		got, err := io.ReadAll(fr)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("size %d: payload differs", size)
+		}
+		if fr.Err() != io.EOF || fr.Trailer() == nil || fr.Trailer().TotalBytes != int64(size) {
+			t.Fatalf("size %d: trailer not verified: err=%v trailer=%+v", size, fr.Err(), fr.Trailer())
+		}
+	}
+}
+
+func TestFrameReaderErrors(t *testing.T) {
+	data := []byte("backup data")
+	valid := framedStream(t, data, 0, true)
+
+	corrupted := append([]byte{}, valid...)
+	corrupted[frameHeaderSize] ^= 0xff // first payload byte
+
+	oversized := make([]byte, frameHeaderSize)
+	oversized[0] = frameData
+	binary.BigEndian.PutUint32(oversized[1:], maxFramePayload+1)
+
+	tests := []struct {
+		name   string
+		stream []byte
+		want   error
+	}{
+		{"no trailer", framedStream(t, data, 0, false), ErrStreamTruncated},
+		{"cut in data frame", valid[:frameHeaderSize+3], ErrStreamTruncated},
+		{"cut in trailer", valid[:len(valid)-2], ErrStreamTruncated},
+		{"empty", nil, ErrStreamTruncated},
+		{"payload changed", corrupted, ErrStreamCorrupt},
+		{"oversized frame", oversized, ErrStreamCorrupt},
+		{"unknown frame type", []byte{9, 0, 0, 0, 0}, ErrStreamCorrupt},
+		{"sender failed", framedStream(t, data, 1, true), ErrSenderFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fr := NewFrameReader(bytes.NewReader(tt.stream))
+			_, err := io.ReadAll(fr)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			if fr.Trailer() != nil {
+				t.Error("Trailer() returned an unverified trailer")
+			}
+			if tt.want == ErrStreamTruncated && !IsConnectionInterrupted(err) {
+				t.Error("truncated stream not reported as interrupted connection")
+			}
+		})
+	}
+}
+
+func TestFrameResume(t *testing.T) {
+	data := bytes.Repeat([]byte("resumable backup "), 100000)
+	offset := int64(len(data) / 3)
+
+	// The sender sends only the suffix, but its trailer covers the whole backup
+	var buf bytes.Buffer
+	fw := NewFrameWriter(&buf)
+	if err := fw.ResumeFrom(bytes.NewReader(data), offset); err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data[offset:])
+	trailer, err := fw.WriteTrailer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trailer.TotalBytes != int64(len(data)) {
+		t.Fatalf("trailer covers %d bytes, want %d", trailer.TotalBytes, len(data))
+	}
+	stream := buf.Bytes()
+
+	tests := []struct {
+		name    string
+		partial []byte
+		want    error
+	}{
+		{"same prefix", data[:offset], io.EOF},
+		{"prefix changed", append([]byte("X"), data[1:offset]...), ErrStreamCorrupt},
+		{"prefix too short", data[:offset-1], errPrefix},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fr := NewFrameReader(bytes.NewReader(stream))
+			if err := fr.ResumeFrom(bytes.NewReader(tt.partial), offset); err != nil {
+				if tt.want != errPrefix {
+					t.Fatalf("ResumeFrom: %v", err)
+				}
+				return
+			}
+			if tt.want == errPrefix {
+				t.Fatal("ResumeFrom accepted a short prefix")
+			}
+			suffix, _ := io.ReadAll(fr)
+			if !errors.Is(fr.Err(), tt.want) {
+				t.Fatalf("error = %v, want %v", fr.Err(), tt.want)
+			}
+			if tt.want == io.EOF && !bytes.Equal(suffix, data[offset:]) {
+				t.Error("suffix differs")
+			}
+		})
+	}
+}
+
+// errPrefix marks test cases where ResumeFrom itself must fail
+var errPrefix = errors.New("prefix error")
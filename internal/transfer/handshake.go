@@ -0,0 +1,314 @@
+package transfer
+
+import (
+	"backup-helper/internal/log"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Stream handshake protocol (version 3)
+//
+// The listening side (server) always challenges and the connecting side (client) answers,
+// regardless of which side sends the backup data:
+//
+//	server -> client: {"version":3,"nonce":<server nonce>,"info":<server info>}
+//	client -> server: {"version":3,"nonce":<client nonce>,"mac":<client MAC>,"info":<client info>}
+//	server -> client: {"version":3,"status":"ok","mac":<server MAC>,"info":<effective server info>}
+//	               or {"version":3,"status":"error","error":"..."}
+//
+// Each message is a single JSON line. The "info" object carries the stream parameters
+// of each side (role, compression, encryption, framing, estimated size) so that a mismatched
+// peer is rejected before any backup data is transferred. The MACs authenticate these
+// parameters as well as the nonces (see computeMAC), so they cannot be changed on the way.
+const (
+	handshakeVersion    = 3
+	handshakeTimeout    = 10 * time.Second
+	handshakeNonceSize  = 32
+	handshakeMaxLineLen = 64 * 1024
+
+	RoleSender   = "sender"
+	RoleReceiver = "receiver"
+)
+
+// StreamInfo describes one side of a stream, exchanged during the handshake
+type StreamInfo struct {
+	Role          string `json:"role"`     // RoleSender or RoleReceiver
+	CompressType  string `json:"compress"` // "zstd", "qp" or "" (none / store as-is)
+	TLS           bool   `json:"tls"`      // Whether the connection is encrypted with TLS
+	Framed        bool   `json:"framed"`   // Whether data is sent with the framed protocol
+	EstimatedSize int64  `json:"size"`     // Estimated backup size in bytes (0 = unknown)
+
+	// Multi-connection streams: every connection runs the handshake with the same connection
+	// count, and all connections of one stream carry the session ID chosen by the connecting side
+	Connections int    `json:"connections,omitempty"`
+	Session     string `json:"session,omitempty"`
+
+	// Instance names the backed-up instance (sender only); a --serve receiver files backups by it
+	Instance string `json:"instance,omitempty"`
+
+	// Resume (framed streams only): the receiver requests ResumeOffset with the hash of the
+	// bytes before it, the sender answers with the offset it accepted (0 = from the beginning)
+	ResumeOffset     int64  `json:"resumeOffset,omitempty"`
+	ResumeTailSHA256 string `json:"resumeTail,omitempty"`
+}
+
+// handshakeMessage is a single handshake line
+type handshakeMessage struct {
+	Version int        `json:"version"`
+	Nonce   string     `json:"nonce,omitempty"`
+	MAC     string     `json:"mac,omitempty"`
+	Status  string     `json:"status,omitempty"`
+	Error   string     `json:"error,omitempty"`
+	Info    StreamInfo `json:"info"`
+}
+
+// newNonce returns a random hex-encoded nonce
+func newNonce() (string, error) {
+	b := make([]byte, handshakeNonceSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MAC labels: the side that computes a MAC, so that one side's MAC cannot be reflected as the other's
+const (
+	macLabelClient = "backup-helper client"
+	macLabelServer = "backup-helper server"
+)
+
+// computeMAC returns hex(HMAC-SHA256(key, label, nonce, first, second)): the peer's nonce and
+// the stream parameters of both messages of the exchange, in their canonical JSON encoding.
+// The client MAC covers the server's challenge info and the client's info; the server MAC
+// covers the client's info and the server's effective info.
+func computeMAC(key, label, nonce string, first, second StreamInfo) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	for _, part := range [][]byte{[]byte(label), []byte(nonce), canonicalInfo(first), canonicalInfo(second)} {
+		// Length-prefixed, so that no two different inputs produce the same byte sequence
+		fmt.Fprintf(mac, "%d:", len(part))
+		mac.Write(part)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// canonicalInfo encodes stream parameters for the MAC; struct fields encode in a fixed order
+func canonicalInfo(info StreamInfo) []byte {
+	data, _ := json.Marshal(info)
+	return data
+}
+
+// verifyMAC checks a hex-encoded MAC in constant time
+func verifyMAC(key, label, nonce string, first, second StreamInfo, macHex string) bool {
+	expected, _ := hex.DecodeString(computeMAC(key, label, nonce, first, second))
+	got, err := hex.DecodeString(macHex)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, got)
+}
+
+// writeHandshake writes one handshake message as a JSON line
+func writeHandshake(conn net.Conn, msg handshakeMessage) error {
+	msg.Version = handshakeVersion
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(data, '\n'))
+	return err
+}
+
+// readHandshake reads one JSON line from conn.
+// It reads byte by byte so that no stream data following the handshake is consumed.
+func readHandshake(conn net.Conn) (handshakeMessage, error) {
+	var msg handshakeMessage
+	line := make([]byte, 0, 512)
+	b := make([]byte, 1)
+	for {
+		n, err := conn.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+			if len(line) > handshakeMaxLineLen {
+				return msg, fmt.Errorf("handshake message too long")
+			}
+		}
+		if err != nil {
+			return msg, err
+		}
+	}
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return msg, fmt.Errorf("invalid handshake message (peer may use an older protocol version): %v", err)
+	}
+	if msg.Version != handshakeVersion {
+		return msg, fmt.Errorf("unsupported handshake protocol version %d (expected %d)", msg.Version, handshakeVersion)
+	}
+	return msg, nil
+}
+
+// negotiateStream checks that the local and peer stream parameters are compatible
+// and returns the effective parameters for the local side.
+// A receiver without a compression type accepts any compression (data is stored as-is);
+// otherwise both sides must agree on compression, encryption and framing.
+func negotiateStream(local StreamInfo, peer StreamInfo) (StreamInfo, error) {
+	if local.Role == peer.Role {
+		return local, fmt.Errorf("both sides are %ss", local.Role)
+	}
+	sender, receiver := local, peer
+	if local.Role == RoleReceiver {
+		sender, receiver = peer, local
+	}
+	if receiver.CompressType != "" && receiver.CompressType != sender.CompressType {
+		return local, fmt.Errorf("compression mismatch: sender=%q receiver=%q", sender.CompressType, receiver.CompressType)
+	}
+	if local.TLS != peer.TLS {
+		return local, fmt.Errorf("encryption mismatch: local tls=%v peer tls=%v", local.TLS, peer.TLS)
+	}
+	if local.Framed != peer.Framed {
+		return local, fmt.Errorf("framing mismatch: sender framed=%v receiver framed=%v (use --framed on both sides)", sender.Framed, receiver.Framed)
+	}
+	if connectionCount(local) != connectionCount(peer) {
+		return local, fmt.Errorf("connection count mismatch: sender=%d receiver=%d (use the same --stream-connections on both sides)",
+			connectionCount(sender), connectionCount(receiver))
+	}
+	if local.Session != "" && peer.Session != "" && local.Session != peer.Session {
+		return local, fmt.Errorf("connection belongs to another stream session")
+	}
+
+	effective := local
+	// The listening side joins the session of the first connection
+	if effective.Session == "" {
+		effective.Session = peer.Session
+	}
+	// The receiver adopts the sender's estimated size when it has none of its own
+	if local.Role == RoleReceiver && local.EstimatedSize == 0 {
+		effective.EstimatedSize = peer.EstimatedSize
+	}
+	return effective, nil
+}
+
+// connectionCount returns the number of connections of a stream (peers that do not send it use one)
+func connectionCount(info StreamInfo) int {
+	if info.Connections < 1 {
+		return 1
+	}
+	return info.Connections
+}
+
+// serverHandshake runs the listening side of the handshake on an accepted connection.
+// Returns the effective local stream parameters on success, including the agreed resume offset,
+// and the parameters announced by the peer. admit, if set, runs once the peer is authenticated;
+// an error from it rejects the peer with that error as the reason.
+func serverHandshake(conn net.Conn, opts StreamOptions, local StreamInfo, admit func() error, logCtx *log.LogContext) (StreamInfo, StreamInfo, error) {
+	key := opts.HandshakeKey
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	nonce, err := newNonce()
+	if err != nil {
+		return local, StreamInfo{}, fmt.Errorf("failed to generate handshake nonce: %v", err)
+	}
+	if err := writeHandshake(conn, handshakeMessage{Nonce: nonce, Info: local}); err != nil {
+		return local, StreamInfo{}, fmt.Errorf("failed to send handshake challenge: %v", err)
+	}
+
+	reply, err := readHandshake(conn)
+	if err != nil {
+		return local, StreamInfo{}, fmt.Errorf("failed to read handshake response: %v", err)
+	}
+	// The client MAC covers the challenge info as the client received it, so a changed challenge fails too
+	if !verifyMAC(key, macLabelClient, nonce, local, reply.Info, reply.MAC) {
+		writeHandshake(conn, handshakeMessage{Status: "error", Error: "authentication failed", Info: local})
+		return local, StreamInfo{}, fmt.Errorf("authentication failed: invalid handshake MAC")
+	}
+	effective, err := negotiateStream(local, reply.Info)
+	if err != nil {
+		writeHandshake(conn, handshakeMessage{Status: "error", Error: err.Error(), Info: local})
+		return local, reply.Info, fmt.Errorf("stream negotiation failed: %v", err)
+	}
+	if admit != nil {
+		if err := admit(); err != nil {
+			writeHandshake(conn, handshakeMessage{Status: "error", Error: err.Error(), Info: local})
+			return local, reply.Info, err
+		}
+	}
+	// The sender decides on the resume offset; a receiver adopts the sender's decision
+	if local.Role == RoleSender {
+		effective.ResumeOffset = opts.Resume.accept(reply.Info, logCtx)
+	} else {
+		effective.ResumeOffset = reply.Info.ResumeOffset
+	}
+
+	mac := computeMAC(key, macLabelServer, reply.Nonce, reply.Info, effective)
+	if err := writeHandshake(conn, handshakeMessage{Status: "ok", MAC: mac, Info: effective}); err != nil {
+		return local, StreamInfo{}, fmt.Errorf("failed to send handshake result: %v", err)
+	}
+	if logCtx != nil {
+		logCtx.WriteLog("TCP", "Handshake negotiated: protocol v%d, peer role=%s compress=%q tls=%v framed=%v size=%d",
+			handshakeVersion, reply.Info.Role, reply.Info.CompressType, reply.Info.TLS, reply.Info.Framed, reply.Info.EstimatedSize)
+	}
+	return effective, reply.Info, nil
+}
+
+// clientHandshake runs the connecting side of the handshake on a dialed connection.
+// The server is authenticated as well, so a client never streams to or from a peer
+// that does not know the key. Returns the effective local stream parameters on success,
+// including the agreed resume offset.
+func clientHandshake(conn net.Conn, opts StreamOptions, local StreamInfo, logCtx *log.LogContext) (StreamInfo, error) {
+	key := opts.HandshakeKey
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	challenge, err := readHandshake(conn)
+	if err != nil {
+		return local, fmt.Errorf("failed to receive handshake challenge: %v", err)
+	}
+	if challenge.Status == "error" {
+		return local, fmt.Errorf("handshake rejected by server: %s", challenge.Error)
+	}
+	// A sender answers the receiver's resume request in its response
+	if local.Role == RoleSender {
+		local.ResumeOffset = opts.Resume.accept(challenge.Info, logCtx)
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return local, fmt.Errorf("failed to generate handshake nonce: %v", err)
+	}
+	mac := computeMAC(key, macLabelClient, challenge.Nonce, challenge.Info, local)
+	if err := writeHandshake(conn, handshakeMessage{Nonce: nonce, MAC: mac, Info: local}); err != nil {
+		return local, fmt.Errorf("failed to send handshake response: %v", err)
+	}
+
+	result, err := readHandshake(conn)
+	if err != nil {
+		return local, fmt.Errorf("failed to receive handshake result: %v", err)
+	}
+	if result.Status != "ok" {
+		return local, fmt.Errorf("handshake rejected by server: %s", result.Error)
+	}
+	if !verifyMAC(key, macLabelServer, nonce, local, result.Info, result.MAC) {
+		return local, fmt.Errorf("server failed to authenticate: invalid handshake MAC")
+	}
+	// The result carries the server's effective parameters, including its resume decision
+	effective, err := negotiateStream(local, result.Info)
+	if err != nil {
+		return local, fmt.Errorf("stream negotiation failed: %v", err)
+	}
+	if local.Role == RoleReceiver {
+		effective.ResumeOffset = result.Info.ResumeOffset
+	}
+	if logCtx != nil {
+		logCtx.WriteLog("TCP", "Handshake negotiated: protocol v%d, peer role=%s compress=%q tls=%v framed=%v size=%d",
+			handshakeVersion, result.Info.Role, result.Info.CompressType, result.Info.TLS, result.Info.Framed, result.Info.EstimatedSize)
+	}
+	return effective, nil
+}
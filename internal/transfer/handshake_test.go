@@ -0,0 +1,182 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestComputeMAC(t *testing.T) {
+	info := StreamInfo{Role: RoleSender, CompressType: "zstd", EstimatedSize: 100}
+	peer := StreamInfo{Role: RoleReceiver}
+	base := computeMAC("key", macLabelClient, "nonce", peer, info)
+
+	changed := info
+	changed.CompressType = ""
+	resumed := info
+	resumed.ResumeOffset = 1
+	tests := []struct {
+		name string
+		mac  string
+	}{
+		{"other key", computeMAC("other", macLabelClient, "nonce", peer, info)},
+		{"other label", computeMAC("key", macLabelServer, "nonce", peer, info)},
+		{"other nonce", computeMAC("key", macLabelClient, "nonce2", peer, info)},
+		{"changed info", computeMAC("key", macLabelClient, "nonce", peer, changed)},
+		{"changed resume offset", computeMAC("key", macLabelClient, "nonce", peer, resumed)},
+		{"swapped infos", computeMAC("key", macLabelClient, "nonce", info, peer)},
+	}
+	for _, tt := range tests {
+		if tt.mac == base {
+			t.Errorf("%s: MAC unchanged", tt.name)
+		}
+	}
+	if !verifyMAC("key", macLabelClient, "nonce", peer, info, base) {
+		t.Error("verifyMAC rejected a valid MAC")
+	}
+	if verifyMAC("key", macLabelClient, "nonce", peer, info, "not hex") {
+		t.Error("verifyMAC accepted an invalid MAC")
+	}
+}
+
+// runHandshake runs both sides over a pipe; tamper, if set, may rewrite each line on the way
+func runHandshake(t *testing.T, serverKey, clientKey string, tamper func(fromServer bool, msg *handshakeMessage)) (serverErr, clientErr error) {
+	t.Helper()
+	server, serverPeer := net.Pipe()
+	client, clientPeer := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	// Relay between serverPeer and clientPeer, one JSON line at a time
+	relay := func(from, to net.Conn, fromServer bool) {
+		r := bufio.NewReader(from)
+		for {
+			line, err := r.ReadBytes('\n')
+			if err != nil {
+				to.Close()
+				return
+			}
+			if tamper != nil {
+				var msg handshakeMessage
+				if json.Unmarshal(line, &msg) == nil {
+					tamper(fromServer, &msg)
+					line, _ = json.Marshal(msg)
+					line = append(line, '\n')
+				}
+			}
+			if _, err := to.Write(line); err != nil {
+				return
+			}
+		}
+	}
+	go relay(serverPeer, clientPeer, true)
+	go relay(clientPeer, serverPeer, false)
+
+	done := make(chan error, 1)
+	go func() {
+		receiver := StreamOptions{HandshakeKey: serverKey, CompressType: "zstd"}
+		_, _, err := serverHandshake(server, receiver, receiver.streamInfo(RoleReceiver, 0), nil, nil)
+		if err != nil {
+			server.Close()
+		}
+		done <- err
+	}()
+	sender := StreamOptions{HandshakeKey: clientKey, CompressType: "zstd"}
+	_, clientErr = clientHandshake(client, sender, sender.streamInfo(RoleSender, 1000), nil)
+	if clientErr != nil {
+		client.Close()
+	}
+	return <-done, clientErr
+}
+
+func TestHandshake(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverKey  string
+		clientKey  string
+		tamper     func(fromServer bool, msg *handshakeMessage)
+		wantServer string // expected substring of the server error, "" = success
+		wantClient string
+	}{
+		{name: "same key"},
+		{name: "wrong key", serverKey: "a", clientKey: "b",
+			wantServer: "authentication failed", wantClient: "authentication failed"},
+		{name: "sender info changed", tamper: func(fromServer bool, msg *handshakeMessage) {
+			if !fromServer {
+				msg.Info.EstimatedSize = 1
+			}
+		}, wantServer: "authentication failed", wantClient: "authentication failed"},
+		{name: "challenge info changed", tamper: func(fromServer bool, msg *handshakeMessage) {
+			if fromServer && msg.Nonce != "" {
+				msg.Info.CompressType = ""
+			}
+		}, wantServer: "authentication failed", wantClient: "authentication failed"},
+		{name: "result info changed", tamper: func(fromServer bool, msg *handshakeMessage) {
+			if fromServer && msg.Status == "ok" {
+				msg.Info.ResumeOffset = 42
+			}
+		}, wantClient: "server failed to authenticate"},
+		{name: "role reflected", tamper: func(fromServer bool, msg *handshakeMessage) {
+			if fromServer && msg.Nonce != "" {
+				msg.Info.Role = RoleSender
+			}
+		}, wantServer: "authentication failed", wantClient: "authentication failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverKey, clientKey := tt.serverKey, tt.clientKey
+			if serverKey == "" {
+				serverKey, clientKey = "secret", "secret"
+			}
+			serverErr, clientErr := runHandshake(t, serverKey, clientKey, tt.tamper)
+			check := func(side string, err error, want string) {
+				switch {
+				case want == "" && err != nil:
+					t.Errorf("%s: unexpected error %v", side, err)
+				case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+					t.Errorf("%s: error %v, want %q", side, err, want)
+				}
+			}
+			check("server", serverErr, tt.wantServer)
+			check("client", clientErr, tt.wantClient)
+		})
+	}
+}
+
+func TestHandshakeAdmit(t *testing.T) {
+	for _, key := range []string{"secret", "wrong"} {
+		server, client := net.Pipe()
+		admitted := false
+		done := make(chan error, 1)
+		go func() {
+			receiver := StreamOptions{HandshakeKey: "secret"}
+			_, _, err := serverHandshake(server, receiver, receiver.streamInfo(RoleReceiver, 0), func() error {
+				admitted = true
+				return errServerBusy
+			}, nil)
+			server.Close()
+			done <- err
+		}()
+		sender := StreamOptions{HandshakeKey: key}
+		_, clientErr := clientHandshake(client, sender, sender.streamInfo(RoleSender, 0), nil)
+		client.Close()
+		serverErr := <-done
+
+		if key == "wrong" {
+			// An unauthenticated client must never reach admission
+			if admitted {
+				t.Error("admit ran for a client with the wrong key")
+			}
+			continue
+		}
+		if !admitted || !errors.Is(serverErr, errServerBusy) {
+			t.Errorf("server error = %v, admitted = %v", serverErr, admitted)
+		}
+		if clientErr == nil || !strings.Contains(clientErr.Error(), "server busy") {
+			t.Errorf("client error = %v, want server busy", clientErr)
+		}
+	}
+}
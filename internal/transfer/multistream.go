@@ -0,0 +1,515 @@
+package transfer
+
+import (
+	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Multi-connection streaming (--stream-connections N)
+//
+// The byte stream is cut into blocks of at most streamBlockSize bytes. Each block gets a
+// sequence number and is sent on whichever connection is free:
+//
+//	+-------------+----------------+---------+
+//	| seq 8B (BE) | length 4B (BE) | payload |
+//	+-------------+----------------+---------+
+//
+// Every connection ends with an end marker (length 0, seq = total number of blocks).
+// The receiver reorders blocks by sequence number, so the reassembled stream is identical
+// to the single-connection stream (including the framed protocol, if enabled).
+const (
+	streamBlockSize       = 1024 * 1024
+	streamBlockHeaderSize = 12
+	// blocksPerConnWindow bounds the out-of-order blocks buffered per connection on the receiver
+	blocksPerConnWindow = 4
+	// connProgressInterval is how often per-connection progress is written to the log
+	connProgressInterval = 10 * time.Second
+)
+
+// ErrConnectionLost means one of the stream connections closed before its end marker
+var ErrConnectionLost = errors.New("stream connection lost")
+
+// acceptStreamConns accepts opts.Connections connections on ln, running TLS and the handshake
+// on each. With the handshake enabled all connections must belong to the same session, and
+// the resume point is negotiated on the first connection only.
+func acceptStreamConns(ln net.Listener, role string, totalSize int64, opts StreamOptions, tracker *progress.ProgressTracker, logCtx *log.LogContext) ([]net.Conn, error) {
+	n := opts.Connections
+	local := opts.streamInfo(role, totalSize)
+	fmt.Fprintf(os.Stderr, "[backup-helper] Waiting for %d stream connections...\n", n)
+
+	var conns []net.Conn
+	for len(conns) < n {
+		conn, err := ln.Accept()
+		if err != nil {
+			closeConns(conns)
+			if logCtx != nil {
+				logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to accept connection %d/%d: %v", len(conns)+1, n, err)
+			}
+			return nil, fmt.Errorf("failed to accept connection %d/%d: %v", len(conns)+1, n, err)
+		}
+		conn, err = tlsServerConn(conn, opts.TLSConfig, logCtx)
+		if err != nil {
+			continue
+		}
+
+		if opts.EnableHandshake {
+			connOpts := opts
+			if len(conns) > 0 {
+				connOpts.Resume = nil
+			}
+			effective, _, err := serverHandshake(conn, connOpts, local, nil, logCtx)
+			if err != nil {
+				// Reject this client and keep waiting for a valid one
+				fmt.Fprintf(os.Stderr, "[backup-helper] Handshake with %s failed: %v\n", conn.RemoteAddr(), err)
+				if logCtx != nil {
+					logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Handshake with %s failed: %v", conn.RemoteAddr(), err)
+				}
+				conn.Close()
+				continue
+			}
+			if len(conns) == 0 {
+				if role == RoleReceiver {
+					tracker.SetTotalBytes(effective.EstimatedSize)
+					totalSize = effective.EstimatedSize
+				}
+				if err := applyResume(opts, local, effective.ResumeOffset, tracker, totalSize, logCtx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				// Later connections must join the session of the first one
+				local.Session = effective.Session
+				local.ResumeOffset = 0
+				local.ResumeTailSHA256 = ""
+			}
+		}
+
+		conns = append(conns, conn)
+		fmt.Fprintf(os.Stderr, "[backup-helper] Stream connection %d/%d established from %s\n", len(conns), n, conn.RemoteAddr())
+		if logCtx != nil {
+			logCtx.WriteLog("TCP", "Stream connection %d/%d established from %s", len(conns), n, conn.RemoteAddr())
+		}
+	}
+	return conns, nil
+}
+
+// dialStreamConns opens opts.Connections connections to addr, running TLS and the handshake on each.
+// All connections carry the same session ID; the resume point is negotiated on the first one.
+func dialStreamConns(host string, addr string, role string, totalSize int64, opts StreamOptions, tracker *progress.ProgressTracker, logCtx *log.LogContext) ([]net.Conn, error) {
+	n := opts.Connections
+	local := opts.streamInfo(role, totalSize)
+	session, err := newNonce()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %v", err)
+	}
+	local.Session = session[:16]
+
+	var conns []net.Conn
+	for i := 0; i < n; i++ {
+		conn, err := net.DialTimeout("tcp", addr, 30*time.Second)
+		if err != nil {
+			closeConns(conns)
+			if logCtx != nil {
+				logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to open connection %d/%d to %s: %v", i+1, n, addr, err)
+			}
+			return nil, fmt.Errorf("failed to open connection %d/%d to %s: %v", i+1, n, addr, err)
+		}
+		conn, err = tlsClientConn(conn, host, opts.TLSConfig, logCtx)
+		if err != nil {
+			closeConns(conns)
+			return nil, err
+		}
+
+		if opts.EnableHandshake {
+			connOpts := opts
+			if i > 0 {
+				connOpts.Resume = nil
+			}
+			effective, err := clientHandshake(conn, connOpts, local, logCtx)
+			if err != nil {
+				conn.Close()
+				closeConns(conns)
+				if logCtx != nil {
+					logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Handshake on connection %d/%d with %s failed: %v", i+1, n, addr, err)
+				}
+				return nil, fmt.Errorf("handshake on connection %d/%d with %s failed: %v", i+1, n, addr, err)
+			}
+			if i == 0 {
+				if role == RoleReceiver {
+					tracker.SetTotalBytes(effective.EstimatedSize)
+					totalSize = effective.EstimatedSize
+				}
+				if err := applyResume(opts, local, effective.ResumeOffset, tracker, totalSize, logCtx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				local.ResumeOffset = 0
+				local.ResumeTailSHA256 = ""
+			}
+		}
+
+		conns = append(conns, conn)
+		fmt.Fprintf(os.Stderr, "[backup-helper] Stream connection %d/%d established to %s\n", i+1, n, addr)
+		if logCtx != nil {
+			logCtx.WriteLog("TCP", "Stream connection %d/%d established to %s", i+1, n, addr)
+		}
+	}
+	return conns, nil
+}
+
+func closeConns(conns []net.Conn) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// connProgress tracks the bytes moved by each connection and reports them periodically
+type connProgress struct {
+	verb   string // "sent" or "received"
+	bytes  []int64
+	start  time.Time
+	done   chan struct{}
+	once   sync.Once
+	logCtx *log.LogContext
+}
+
+func newConnProgress(n int, verb string, logCtx *log.LogContext) *connProgress {
+	cp := &connProgress{verb: verb, bytes: make([]int64, n), start: time.Now(), done: make(chan struct{}), logCtx: logCtx}
+	if logCtx != nil {
+		go func() {
+			ticker := time.NewTicker(connProgressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					cp.report(false)
+				case <-cp.done:
+					return
+				}
+			}
+		}()
+	}
+	return cp
+}
+
+func (cp *connProgress) add(i int, n int) {
+	atomic.AddInt64(&cp.bytes[i], int64(n))
+}
+
+// report writes one line per connection to the log (and to stderr for the final summary)
+func (cp *connProgress) report(final bool) {
+	elapsed := time.Since(cp.start).Seconds()
+	for i := range cp.bytes {
+		b := atomic.LoadInt64(&cp.bytes[i])
+		speed := int64(0)
+		if elapsed > 0 {
+			speed = int64(float64(b) / elapsed)
+		}
+		line := fmt.Sprintf("Connection %d/%d: %s %s (%s/s)", i+1, len(cp.bytes), progress.FormatBytes(b), cp.verb, progress.FormatBytes(speed))
+		if cp.logCtx != nil {
+			cp.logCtx.WriteLog("TCP", "%s", line)
+		}
+		if final {
+			fmt.Fprintf(os.Stderr, "[backup-helper] %s\n", line)
+		}
+	}
+}
+
+// stop ends periodic reporting and prints the per-connection summary
+func (cp *connProgress) stop() {
+	cp.once.Do(func() {
+		close(cp.done)
+		cp.report(true)
+	})
+}
+
+type streamBlock struct {
+	seq  uint64
+	data []byte
+}
+
+// blockWriter spreads written data over several connections as sequence-numbered blocks
+type blockWriter struct {
+	conns    []net.Conn
+	queue    chan streamBlock
+	seq      uint64
+	wg       sync.WaitGroup
+	tracker  *progress.ProgressTracker
+	progress *connProgress
+	failed   chan struct{}
+	failOnce sync.Once
+	err      error
+	closed   bool
+	closeErr error
+}
+
+func newBlockWriter(conns []net.Conn, tracker *progress.ProgressTracker, logCtx *log.LogContext) *blockWriter {
+	bw := &blockWriter{
+		conns:    conns,
+		queue:    make(chan streamBlock, len(conns)),
+		tracker:  tracker,
+		progress: newConnProgress(len(conns), "sent", logCtx),
+		failed:   make(chan struct{}),
+	}
+	for i := range conns {
+		bw.wg.Add(1)
+		go bw.send(i)
+	}
+	return bw
+}
+
+func (bw *blockWriter) fail(err error) {
+	bw.failOnce.Do(func() {
+		bw.err = err
+		close(bw.failed)
+	})
+}
+
+// send writes queued blocks to connection i, then its end marker
+func (bw *blockWriter) send(i int) {
+	defer bw.wg.Done()
+	conn := bw.conns[i]
+	header := make([]byte, streamBlockHeaderSize)
+	for block := range bw.queue {
+		select {
+		case <-bw.failed:
+			continue // drain the queue after a failure
+		default:
+		}
+		binary.BigEndian.PutUint64(header[0:8], block.seq)
+		binary.BigEndian.PutUint32(header[8:12], uint32(len(block.data)))
+		if _, err := conn.Write(header); err != nil {
+			bw.fail(fmt.Errorf("connection %d/%d: %v", i+1, len(bw.conns), err))
+			continue
+		}
+		if _, err := conn.Write(block.data); err != nil {
+			bw.fail(fmt.Errorf("connection %d/%d: %v", i+1, len(bw.conns), err))
+			continue
+		}
+		bw.progress.add(i, len(block.data))
+	}
+	select {
+	case <-bw.failed:
+		return
+	default:
+	}
+	// End marker carries the total number of blocks (the queue is closed, so seq is final)
+	binary.BigEndian.PutUint64(header[0:8], bw.seq)
+	binary.BigEndian.PutUint32(header[8:12], 0)
+	if _, err := conn.Write(header); err != nil {
+		bw.fail(fmt.Errorf("connection %d/%d: %v", i+1, len(bw.conns), err))
+	}
+}
+
+// Write queues p as blocks; it fails once any connection has failed
+func (bw *blockWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > streamBlockSize {
+			n = streamBlockSize
+		}
+		data := make([]byte, n)
+		copy(data, p[:n])
+		select {
+		case bw.queue <- streamBlock{seq: bw.seq, data: data}:
+		case <-bw.failed:
+			return written, bw.err
+		}
+		bw.seq++
+		bw.tracker.Update(int64(n))
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close sends the remaining blocks and the end markers, then closes all connections
+func (bw *blockWriter) Close() error {
+	if bw.closed {
+		return bw.closeErr
+	}
+	bw.closed = true
+	close(bw.queue)
+	bw.wg.Wait()
+	bw.progress.stop()
+	closeConns(bw.conns)
+	bw.closeErr = bw.err
+	return bw.closeErr
+}
+
+// blockReader reassembles sequence-numbered blocks from several connections in order
+type blockReader struct {
+	conns    []net.Conn
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  map[uint64][]byte
+	next     uint64
+	cur      []byte
+	ended    int
+	total    uint64
+	err      error
+	window   int
+	tracker  *progress.ProgressTracker
+	progress *connProgress
+}
+
+func newBlockReader(conns []net.Conn, tracker *progress.ProgressTracker, logCtx *log.LogContext) *blockReader {
+	br := &blockReader{
+		conns:    conns,
+		pending:  make(map[uint64][]byte),
+		window:   blocksPerConnWindow * len(conns),
+		tracker:  tracker,
+		progress: newConnProgress(len(conns), "received", logCtx),
+	}
+	br.cond = sync.NewCond(&br.mu)
+	for i := range conns {
+		go br.receive(i)
+	}
+	return br
+}
+
+func (br *blockReader) fail(err error) {
+	br.mu.Lock()
+	if br.err == nil {
+		br.err = err
+	}
+	br.cond.Broadcast()
+	br.mu.Unlock()
+}
+
+// receive reads blocks from connection i until its end marker
+func (br *blockReader) receive(i int) {
+	conn := br.conns[i]
+	n := len(br.conns)
+	header := make([]byte, streamBlockHeaderSize)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			br.fail(fmt.Errorf("%w: connection %d/%d closed before end of stream: %v", ErrConnectionLost, i+1, n, err))
+			return
+		}
+		seq := binary.BigEndian.Uint64(header[0:8])
+		length := binary.BigEndian.Uint32(header[8:12])
+		if length == 0 {
+			br.mu.Lock()
+			if br.ended > 0 && br.total != seq {
+				if br.err == nil {
+					br.err = fmt.Errorf("%w: connections disagree on block count (%d vs %d)", ErrStreamCorrupt, br.total, seq)
+				}
+			}
+			br.total = seq
+			br.ended++
+			br.cond.Broadcast()
+			br.mu.Unlock()
+			return
+		}
+		if length > streamBlockSize {
+			br.fail(fmt.Errorf("%w: block too large (%d bytes) on connection %d/%d", ErrStreamCorrupt, length, i+1, n))
+			return
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			br.fail(fmt.Errorf("%w: connection %d/%d closed before end of stream: %v", ErrConnectionLost, i+1, n, err))
+			return
+		}
+		br.progress.add(i, len(data))
+
+		br.mu.Lock()
+		// Bound out-of-order buffering; the block the reader waits for is always accepted
+		for br.err == nil && seq != br.next && len(br.pending) >= br.window {
+			br.cond.Wait()
+		}
+		if br.err != nil {
+			br.mu.Unlock()
+			return
+		}
+		br.pending[seq] = data
+		br.cond.Broadcast()
+		br.mu.Unlock()
+	}
+}
+
+// Read returns the reassembled stream
+func (br *blockReader) Read(p []byte) (int, error) {
+	if len(br.cur) == 0 {
+		br.mu.Lock()
+		for {
+			if data, ok := br.pending[br.next]; ok {
+				delete(br.pending, br.next)
+				br.next++
+				br.cur = data
+				br.cond.Broadcast()
+				break
+			}
+			if br.err != nil {
+				br.mu.Unlock()
+				return 0, br.err
+			}
+			if br.ended == len(br.conns) {
+				var err error = io.EOF
+				if br.next != br.total {
+					err = fmt.Errorf("%w: received %d of %d blocks", ErrConnectionLost, br.next, br.total)
+					br.err = err
+				}
+				br.mu.Unlock()
+				return 0, err
+			}
+			br.cond.Wait()
+		}
+		br.mu.Unlock()
+	}
+	n := copy(p, br.cur)
+	br.cur = br.cur[n:]
+	br.tracker.Update(int64(n))
+	return n, nil
+}
+
+// Err returns the error that ended the stream, nil if it is still running or ended cleanly
+func (br *blockReader) Err() error {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	return br.err
+}
+
+// Close closes all connections and prints the per-connection summary
+func (br *blockReader) Close() error {
+	closeConns(br.conns)
+	br.fail(io.ErrClosedPipe)
+	br.progress.stop()
+	return nil
+}
+
+// closeBlockWriter flushes a block writer from a stream closer, reporting failures of the final blocks
+func closeBlockWriter(bw *blockWriter, logCtx *log.LogContext) {
+	if err := bw.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "[backup-helper] Transfer interrupted: %v\n", err)
+		if logCtx != nil {
+			logCtx.WriteLog("TCP", "Transfer interrupted: %v", err)
+		}
+		return
+	}
+	if logCtx != nil {
+		logCtx.WriteLog("TCP", "Transfer completed")
+	}
+}
+
+// closeBlockReader closes a block reader from a stream closer and logs how the transfer ended
+func closeBlockReader(br *blockReader, logCtx *log.LogContext) {
+	err := br.Err()
+	br.Close()
+	if logCtx != nil {
+		if err != nil {
+			logCtx.WriteLog("TCP", "Transfer interrupted: %v", err)
+		} else {
+			logCtx.WriteLog("TCP", "Transfer completed")
+		}
+	}
+}
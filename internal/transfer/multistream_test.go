@@ -0,0 +1,110 @@
+package transfer
+
+import (
+	"backup-helper/internal/progress"
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+// loopbackConns opens n TCP connections over loopback and returns both ends of each
+func loopbackConns(t *testing.T, n int) ([]net.Conn, []net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var clients, servers []net.Conn
+	for i := 0; i < n; i++ {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		server, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+		servers = append(servers, server)
+	}
+	t.Cleanup(func() {
+		closeConns(clients)
+		closeConns(servers)
+	})
+	return clients, servers
+}
+
+func TestBlockStreamPreservesOrder(t *testing.T) {
+	for _, n := range []int{2, 3, 5} {
+		clients, servers := loopbackConns(t, n)
+		data := make([]byte, 7*streamBlockSize+12345)
+		rand.New(rand.NewSource(int64(n))).Read(data)
+
+		received := make(chan []byte, 1)
+		go func() {
+			got, _ := io.ReadAll(newBlockReader(servers, progress.NewProgressTracker(0), nil))
+			received <- got
+		}()
+
+		bw := newBlockWriter(clients, progress.NewProgressTracker(0), nil)
+		// Uneven writes, so blocks do not line up with the writes
+		for rest, size := data, 1000; len(rest) > 0; size *= 3 {
+			if size > len(rest) {
+				size = len(rest)
+			}
+			if _, err := bw.Write(rest[:size]); err != nil {
+				t.Fatalf("%d connections: Write: %v", n, err)
+			}
+			rest = rest[size:]
+		}
+		if err := bw.Close(); err != nil {
+			t.Fatalf("%d connections: Close: %v", n, err)
+		}
+		if got := <-received; !bytes.Equal(got, data) {
+			t.Errorf("%d connections: received %d bytes differing from the %d sent", n, len(got), len(data))
+		}
+	}
+}
+
+func TestBlockWriterConnectionLost(t *testing.T) {
+	clients, servers := loopbackConns(t, 3)
+	// The receiver drains two connections and drops the third mid-stream
+	for _, conn := range servers[:2] {
+		go io.Copy(io.Discard, conn)
+	}
+	go func() {
+		io.CopyN(io.Discard, servers[2], streamBlockSize)
+		servers[2].Close()
+	}()
+
+	bw := newBlockWriter(clients, progress.NewProgressTracker(0), nil)
+	block := make([]byte, streamBlockSize)
+	for i := 0; i < 256; i++ {
+		if _, err := bw.Write(block); err != nil {
+			break
+		}
+	}
+	if err := bw.Close(); err == nil {
+		t.Fatal("Close after a lost connection returned nil")
+	}
+}
+
+func TestBlockReaderConnectionLost(t *testing.T) {
+	clients, servers := loopbackConns(t, 2)
+	bw := newBlockWriter(clients, progress.NewProgressTracker(0), nil)
+	go func() {
+		bw.Write(make([]byte, 4*streamBlockSize))
+		// Drop one connection before the end markers
+		clients[1].Close()
+		bw.Close()
+	}()
+
+	_, err := io.ReadAll(newBlockReader(servers, progress.NewProgressTracker(0), nil))
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("reading a stream with a dropped connection: %v, want %v", err, ErrConnectionLost)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"backup-helper/internal/progress"
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/gioco-play/easy-i18n/i18n"
@@ -16,6 +18,9 @@ import (
 type OssProgressListener struct{}
 
 func (listener *OssProgressListener) ProgressChanged(event *oss.ProgressEvent) {
+	if progress.JSONEvents() {
+		return // Part transfers are covered by the progress events of the tracker
+	}
 	switch event.EventType {
 	case oss.TransferStartedEvent:
 		i18n.Printf("Transfer Started, ConsumedBytes: %d, TotalBytes %d.\n", event.ConsumedBytes, event.TotalBytes)
@@ -31,12 +36,18 @@ func (listener *OssProgressListener) ProgressChanged(event *oss.ProgressEvent) {
 
 // UploadReaderToOSS supports fragmenting upload from io.Reader to OSS, objectName is passed by the caller
 func UploadReaderToOSS(cfg *config.Config, objectName string, reader io.Reader, totalSize int64, isCompressed bool, logCtx *log.LogContext) error {
-	var waitSender sync.WaitGroup
-
 	// Create progress tracker
 	tracker := progress.NewProgressTrackerWithCompression(totalSize, isCompressed)
 	defer tracker.Complete()
 
+	return UploadReaderToOSSWithTracker(cfg, objectName, reader, totalSize, tracker, logCtx)
+}
+
+// UploadReaderToOSSWithTracker is UploadReaderToOSS with a caller-owned progress tracker
+// (e.g. a quiet tracker when the upload is one sink of a fan-out)
+func UploadReaderToOSSWithTracker(cfg *config.Config, objectName string, reader io.Reader, totalSize int64, tracker *progress.ProgressTracker, logCtx *log.LogContext) error {
+	var waitSender sync.WaitGroup
+
 	if logCtx != nil {
 		logCtx.WriteLog("OSS", "Starting OSS upload")
 		logCtx.WriteLog("OSS", "Object name: %s", objectName)
@@ -46,14 +57,14 @@ func UploadReaderToOSS(cfg *config.Config, objectName string, reader io.Reader,
 	client, err := oss.New(cfg.Endpoint, cfg.AccessKeyId, cfg.AccessKeySecret)
 	if err != nil {
 		if logCtx != nil {
-			logCtx.WriteLog("OSS", "Failed to create OSS client: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "OSS", nil, "Failed to create OSS client: %v", err)
 		}
 		return err
 	}
 	bucket, err := client.Bucket(cfg.BucketName)
 	if err != nil {
 		if logCtx != nil {
-			logCtx.WriteLog("OSS", "Failed to get bucket: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "OSS", nil, "Failed to get bucket: %v", err)
 		}
 		return err
 	}
@@ -62,7 +73,7 @@ func UploadReaderToOSS(cfg *config.Config, objectName string, reader io.Reader,
 	imur, err := bucket.InitiateMultipartUpload(objectName, storageType)
 	if err != nil {
 		if logCtx != nil {
-			logCtx.WriteLog("OSS", "Failed to initiate multipart upload: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "OSS", nil, "Failed to initiate multipart upload: %v", err)
 		}
 		return err
 	}
@@ -80,17 +91,24 @@ func UploadReaderToOSS(cfg *config.Config, objectName string, reader io.Reader,
 
 	var parts []oss.UploadPart
 	index := 1
-	traffic := cfg.GetRateLimit() // Get actual rate limit value
+	traffic := cfg.GetRateLimitAt(time.Now()) // Get actual rate limit value
 	for {
 		p := make([]byte, bufferSize)
 		n, err := io.ReadFull(bufReader, p)
 		if n > 0 {
 			data := p[:n]
+			// Each part uses the limit of the ioLimitSchedule window it starts in
+			if next := cfg.GetRateLimitAt(time.Now()); next != traffic {
+				if logCtx != nil {
+					logCtx.WriteLog("OSS", "Traffic limit switched to %d bytes/s from part %d (0 = unlimited)", next, index)
+				}
+				traffic = next
+			}
 			waitSender.Add(1)
-			part, err := uploadPart(bucket, imur, data, index, traffic)
+			part, err := uploadPartWithRetry(bucket, imur, data, index, traffic, logCtx)
 			if err != nil {
 				if logCtx != nil {
-					logCtx.WriteLog("OSS", "Failed to upload part %d: %v", index, err)
+					logCtx.WriteLogLevel(log.LevelError, "OSS", nil, "Failed to upload part %d: %v", index, err)
 				}
 				bucket.AbortMultipartUpload(imur)
 				return err
@@ -115,7 +133,7 @@ func UploadReaderToOSS(cfg *config.Config, objectName string, reader io.Reader,
 	_, err = bucket.CompleteMultipartUpload(imur, parts, objectAcl)
 	if err != nil {
 		if logCtx != nil {
-			logCtx.WriteLog("OSS", "Failed to complete multipart upload: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "OSS", nil, "Failed to complete multipart upload: %v", err)
 		}
 		return err
 	}
@@ -126,6 +144,24 @@ func UploadReaderToOSS(cfg *config.Config, objectName string, reader io.Reader,
 	return nil
 }
 
+// ossPartAttempts is how often one part is tried before the upload fails (the part is kept in memory)
+const ossPartAttempts = 3
+
+// uploadPartWithRetry uploads one part, retrying after 1s and 2s; every retry is logged and reported as an event
+func uploadPartWithRetry(bucket *oss.Bucket, imur oss.InitiateMultipartUploadResult, data []byte, index int, traffic int64, logCtx *log.LogContext) (oss.UploadPart, error) {
+	for attempt := 1; ; attempt++ {
+		part, err := uploadPart(bucket, imur, data, index, traffic)
+		if err == nil || attempt == ossPartAttempts {
+			return part, err
+		}
+		if logCtx != nil {
+			logCtx.WriteLogLevel(log.LevelWarn, "OSS", nil, "Upload of part %d failed (attempt %d/%d), retrying: %v", index, attempt, ossPartAttempts, err)
+		}
+		progress.Retry("upload", fmt.Sprintf("part %d attempt %d failed: %v", index, attempt, err))
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
 func uploadPart(bucket *oss.Bucket, imur oss.InitiateMultipartUploadResult, data []byte, index int, traffic int64) (oss.UploadPart, error) {
 	reader := bytes.NewReader(data)
 	// If traffic is 0, don't apply rate limiting (unlimited)
@@ -0,0 +1,135 @@
+package transfer
+
+import (
+	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// resumeTailSize is the number of bytes before the resume offset that both sides hash
+// to make sure the receiver's partial output belongs to the file being sent
+const resumeTailSize = 1024 * 1024
+
+// ResumeState carries the resume point of a framed stream through the handshake.
+//
+// Before the handshake the receiver fills Offset and TailSHA256 from its partial output
+// (see NewReceiverResumeState) and the sender sets Source to its seekable input file.
+// After the handshake Offset holds the point agreed by both sides (0 = start from the
+// beginning) and the sender's Source has been positioned there.
+type ResumeState struct {
+	Source     *os.File // Sender only: seekable source; nil if the source cannot be resumed
+	Offset     int64    // Bytes the receiver already has, then the agreed resume offset
+	TailSHA256 string   // Receiver only: SHA-256 of the resumeTailSize bytes before Offset
+}
+
+// NewReceiverResumeState inspects a partial output file and returns the resume point it allows.
+// The file is synced first so that only durably written bytes are reported.
+// A missing file yields an empty state (start from the beginning).
+func NewReceiverResumeState(partialPath string) (*ResumeState, error) {
+	file, err := os.OpenFile(partialPath, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return &ResumeState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync %s: %v", partialPath, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	tail, err := tailSHA256(file, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", partialPath, err)
+	}
+	return &ResumeState{Offset: info.Size(), TailSHA256: tail}, nil
+}
+
+// tailSHA256 hashes the resumeTailSize bytes (or fewer at the start of the file) before offset
+func tailSHA256(r io.ReaderAt, offset int64) (string, error) {
+	start := offset - resumeTailSize
+	if start < 0 {
+		start = 0
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, start, offset-start)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// accept decides on the sender whether the receiver's requested resume point can be honoured.
+// Returns the accepted offset, 0 when the stream has to start from the beginning.
+func (rs *ResumeState) accept(receiver StreamInfo, logCtx *log.LogContext) int64 {
+	requested := receiver.ResumeOffset
+	if requested <= 0 {
+		return 0
+	}
+	reject := func(reason string) int64 {
+		if logCtx != nil {
+			logCtx.WriteLog("TCP", "Receiver asked to resume at offset %d, starting from the beginning: %s", requested, reason)
+		}
+		return 0
+	}
+	if rs == nil || rs.Source == nil {
+		return reject("source is not seekable")
+	}
+	info, err := rs.Source.Stat()
+	if err != nil {
+		return reject(err.Error())
+	}
+	if requested > info.Size() {
+		return reject(fmt.Sprintf("receiver has more bytes than the source (%d)", info.Size()))
+	}
+	tail, err := tailSHA256(rs.Source, requested)
+	if err != nil {
+		return reject(err.Error())
+	}
+	if tail != receiver.ResumeTailSHA256 {
+		return reject("partial output does not match the source")
+	}
+	return requested
+}
+
+// applyResume records the agreed resume point after the handshake, positions the sender's
+// source and adjusts the progress total to the remaining bytes
+func applyResume(opts StreamOptions, local StreamInfo, agreed int64, tracker *progress.ProgressTracker, totalSize int64, logCtx *log.LogContext) error {
+	if opts.Resume == nil {
+		return nil
+	}
+	if local.Role == RoleReceiver && agreed != 0 && agreed != local.ResumeOffset {
+		return fmt.Errorf("sender accepted resume offset %d, but %d bytes were requested", agreed, local.ResumeOffset)
+	}
+	requested := opts.Resume.Offset
+	opts.Resume.Offset = agreed
+
+	if local.Role == RoleSender && opts.Resume.Source != nil {
+		if _, err := opts.Resume.Source.Seek(agreed, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek source to offset %d: %v", agreed, err)
+		}
+	}
+	if agreed > 0 && totalSize > agreed {
+		tracker.SetTotalBytes(totalSize - agreed)
+	}
+
+	if agreed > 0 {
+		fmt.Fprintf(os.Stderr, "[backup-helper] Resuming stream at offset %d (%s)\n", agreed, progress.FormatBytes(agreed))
+		if logCtx != nil {
+			logCtx.WriteLog("TCP", "Resuming stream at offset %d (%s)", agreed, progress.FormatBytes(agreed))
+		}
+	} else if local.Role == RoleReceiver && requested > 0 {
+		fmt.Fprintf(os.Stderr, "[backup-helper] Resume of %s rejected by sender, starting from the beginning\n", progress.FormatBytes(requested))
+		if logCtx != nil {
+			logCtx.WriteLog("TCP", "Resume at offset %d rejected by sender, starting from the beginning", requested)
+		}
+	}
+	return nil
+}
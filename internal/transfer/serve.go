@@ -0,0 +1,151 @@
+package transfer
+
+import (
+	"backup-helper/internal/log"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// StreamSession is one authenticated incoming stream accepted by ServeStreams
+type StreamSession struct {
+	ID        int64
+	Conn      net.Conn
+	Peer      StreamInfo // Parameters announced by the sender (instance, compression, size, ...)
+	Effective StreamInfo // Negotiated parameters of this receiver
+}
+
+// ServeStreams listens on port and receives streams until the listener fails.
+// Every sender must pass the handshake; handle then runs in its own goroutine and may read the
+// session's connection, which is closed when handle returns. At most maxSessions streams are
+// received at once; a session slot is taken only after the sender has authenticated, so
+// further senders are rejected at the end of the handshake and unauthenticated clients
+// never hold a slot. With opts.IdleTimeout a session that receives no data for that long fails
+// its reads, so handle returns and the slot is released.
+func ServeStreams(port int, maxSessions int, opts StreamOptions, handle func(*StreamSession), logCtx *log.LogContext) error {
+	if !opts.EnableHandshake {
+		return fmt.Errorf("serving streams requires the handshake (--enable-handshake with --stream-key) to authenticate senders")
+	}
+	if opts.Connections > 1 {
+		return fmt.Errorf("serving streams does not support --stream-connections")
+	}
+	if maxSessions <= 0 {
+		maxSessions = 1
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		if logCtx != nil {
+			logCtx.WriteLogLevel(log.LevelError, "SERVE", nil, "Failed to listen on port %d: %v", port, err)
+		}
+		return fmt.Errorf("failed to listen on port %d: %v", port, err)
+	}
+	defer ln.Close()
+	actualPort := ln.Addr().(*net.TCPAddr).Port
+
+	localIP, err := GetLocalIP()
+	if err != nil {
+		localIP = "127.0.0.1" // fallback to localhost
+	}
+	fmt.Fprintf(os.Stderr, "[backup-helper] Listening on %s:%d\n", localIP, actualPort)
+	fmt.Fprintf(os.Stderr, "[backup-helper] Serving backup streams (max %d concurrent sessions)...\n", maxSessions)
+	if logCtx != nil {
+		logCtx.WriteLog("SERVE", "Listening on %s:%d, max %d concurrent sessions", localIP, actualPort, maxSessions)
+	}
+
+	slots := make(chan struct{}, maxSessions)
+	var lastID int64
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			if logCtx != nil {
+				logCtx.WriteLogLevel(log.LevelError, "SERVE", nil, "Failed to accept connection: %v", err)
+			}
+			return fmt.Errorf("failed to accept connection on port %d: %v", actualPort, err)
+		}
+		go serveConn(conn, slots, &lastID, opts, handle, logCtx)
+	}
+}
+
+// errServerBusy rejects an authenticated sender when every session slot is in use
+var errServerBusy = errors.New("server busy")
+
+// serveConn authenticates one sender and hands the session to handle.
+// TLS and the handshake both run under a deadline, so a client that never
+// authenticates only holds its own connection, and only for a short time.
+func serveConn(conn net.Conn, slots chan struct{}, lastID *int64, opts StreamOptions, handle func(*StreamSession), logCtx *log.LogContext) {
+	remote := conn.RemoteAddr()
+	conn, err := tlsServerConn(conn, opts.TLSConfig, logCtx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[backup-helper] %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	// Take a slot once the sender is authenticated; the handshake tells the sender why it was rejected
+	acquired := false
+	defer func() {
+		if acquired {
+			<-slots
+		}
+	}()
+	admit := func() error {
+		select {
+		case slots <- struct{}{}:
+			acquired = true
+			return nil
+		default:
+			return fmt.Errorf("%w: %d sessions already running", errServerBusy, cap(slots))
+		}
+	}
+
+	local := opts.streamInfo(RoleReceiver, 0)
+	effective, peer, err := serverHandshake(conn, opts, local, admit, logCtx)
+	if errors.Is(err, errServerBusy) {
+		fmt.Fprintf(os.Stderr, "[backup-helper] Rejected %s: %v\n", remote, err)
+		if logCtx != nil {
+			logCtx.WriteLog("SERVE", "Rejected %s: %v", remote, err)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[backup-helper] Handshake with %s failed: %v\n", remote, err)
+		if logCtx != nil {
+			logCtx.WriteLogLevel(log.LevelError, "SERVE", nil, "Handshake with %s failed: %v", remote, err)
+		}
+		return
+	}
+
+	// From here on a sender that stops sending must not hold its slot forever
+	if opts.IdleTimeout > 0 {
+		conn = &idleConn{Conn: conn, timeout: opts.IdleTimeout}
+	}
+	handle(&StreamSession{
+		ID:        atomic.AddInt64(lastID, 1),
+		Conn:      conn,
+		Peer:      peer,
+		Effective: effective,
+	})
+}
+
+// idleConn renews the read deadline before every read, so a read fails with a timeout
+// once the peer has sent nothing for timeout
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	n, err := c.Conn.Read(p)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		err = fmt.Errorf("no data from sender for %s: %w", c.timeout, err)
+	}
+	return n, err
+}
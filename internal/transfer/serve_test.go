@@ -0,0 +1,40 @@
+package transfer
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIdleConnTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := &idleConn{Conn: server, timeout: 100 * time.Millisecond}
+	defer conn.Close()
+
+	// Data that keeps coming renews the deadline
+	go func() {
+		for i := 0; i < 4; i++ {
+			time.Sleep(60 * time.Millisecond)
+			client.Write([]byte("x"))
+		}
+	}()
+	buf := make([]byte, 1)
+	for i := 0; i < 4; i++ {
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatalf("read %d: %v", i+1, err)
+		}
+	}
+
+	// A silent sender makes the next read time out
+	start := time.Now()
+	_, err := conn.Read(buf)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("read from a silent sender: %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("read timed out after %s, want about 100ms", elapsed)
+	}
+}
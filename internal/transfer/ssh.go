@@ -1,161 +1,402 @@
 package transfer
 
 import (
+	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
 	"backup-helper/internal/utils"
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// SSHOptions describes how to reach the remote host and which backup-helper to run there.
+// Empty values fall back to the system SSH config.
+type SSHOptions struct {
+	Host         string   // Remote host (may be user@host)
+	Port         int      // SSH port (0 = ssh default)
+	User         string   // SSH login user
+	IdentityFile string   // Private key file
+	RemoteBinary string   // backup-helper on the remote host (default: "backup-helper" from PATH)
+	RemoteArgs   []string // Extra receiver flags, e.g. --target-dir /data/restore --compress zstd
+}
+
+// RemoteReceiverOptions holds the stream settings passed to the remote receiver
+type RemoteReceiverOptions struct {
+	StreamPort      int // If > 0, use this port; if 0, auto-find
+	RemoteOutput    string
+	EstimatedSize   int64
+	EnableHandshake bool
+	HandshakeKey    string
+	Framed          bool
+	Connections     int  // Parallel stream connections (<= 1 = single connection)
+	Tunnel          bool // Carry the stream over the SSH channel (receiver stdin) instead of a TCP port
+}
+
+// RemoteReceiver is a backup-helper receiver started on a remote host via SSH.
+// It is tracked by its remote PID, so stopping it never touches other backup-helper processes.
+// Its output is parsed: progress and status lines go to the log, the final status is kept for Wait.
+type RemoteReceiver struct {
+	Port       int    // Port where the receiver is listening (0 with a tunnel)
+	OutputPath string // Remote output path ("" = auto-generated by the receiver)
+
+	ssh    SSHOptions
+	tunnel bool
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser // Tunnel only: the stream is written here
+	logCtx *log.LogContext
+	ready  chan error    // Receives the startup result once
+	done   chan struct{} // Closed when the local ssh process has exited
+	err    error         // ssh exit status, valid after done is closed
+
+	mu           sync.Mutex
+	pid          int // PID of the receiver on the remote host, 0 until it is reported
+	started      bool
+	expectedPort int
+	savedTo      string    // Final location reported by the receiver
+	progress     string    // Latest progress line of the receiver
+	lastProgress time.Time // When progress was last written to the log
+	tail         []string  // Last status lines, reported when the receiver fails
+}
+
+const (
+	// remotePIDPrefix is printed by the remote shell before it execs backup-helper,
+	// so the PID is the one of the receiver itself
+	remotePIDPrefix = "[backup-helper] Remote PID: "
+
+	remoteStartTimeout      = 10 * time.Second
+	remoteProgressLogPeriod = 10 * time.Second
+	remoteStatusTailLines   = 5
+)
+
+var (
+	listeningPattern = regexp.MustCompile(`Listening on [\d.]+:(\d+)`)
+	savedToPattern   = regexp.MustCompile(`(?:Download completed! Saved to|Extraction completed to): (.+)$`)
+)
+
+// sshArgs returns the ssh arguments that run remoteCmd on the remote host
+func sshArgs(opts SSHOptions, remoteCmd string) []string {
+	var args []string
+	if opts.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(opts.Port))
+	}
+	if opts.User != "" {
+		args = append(args, "-l", opts.User)
+	}
+	if opts.IdentityFile != "" {
+		args = append(args, "-i", opts.IdentityFile)
+	}
+	return append(args, opts.Host, remoteCmd)
+}
+
+// shellQuote quotes s for the remote POSIX shell
+func shellQuote(s string) string {
+	safe := s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || strings.ContainsRune("-_./=:,+@%", r))
+	}) < 0
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 // StartRemoteReceiverViaSSH starts backup-helper receiver on remote host via SSH
-// If port > 0, uses that port; if port == 0, auto-finds available port
-// Returns the port number where receiver is listening and the output path
-func StartRemoteReceiverViaSSH(
-	sshHost string,
-	port int, // If > 0, use this port; if 0, auto-find
-	remoteOutput string,
-	estimatedSize int64,
-	enableHandshake bool,
-	handshakeKey string,
-) (int, string, *exec.Cmd, func() error, error) {
+// If opts.StreamPort > 0, uses that port; if 0, auto-finds available port
+// With opts.Tunnel the receiver reads the stream from stdin instead (see TunnelWriter)
+// The returned receiver must be finished with Wait or Stop
+func StartRemoteReceiverViaSSH(sshOpts SSHOptions, opts RemoteReceiverOptions, logCtx *log.LogContext) (*RemoteReceiver, error) {
+	binary := sshOpts.RemoteBinary
+	if binary == "" {
+		binary = "backup-helper"
+	}
 
 	// Build remote backup-helper command
-	remoteCmd := []string{"backup-helper", "--download"}
+	remoteCmd := []string{binary, "--download"}
 
-	if port > 0 {
-		remoteCmd = append(remoteCmd, fmt.Sprintf("--stream-port=%d", port))
+	if opts.Tunnel {
+		// Data arrives on stdin; the SSH channel already authenticates and encrypts it
+		remoteCmd = append(remoteCmd, "--stdin")
 	} else {
-		remoteCmd = append(remoteCmd, "--stream-port=0") // Auto-find
+		if opts.StreamPort > 0 {
+			remoteCmd = append(remoteCmd, fmt.Sprintf("--stream-port=%d", opts.StreamPort))
+		} else {
+			remoteCmd = append(remoteCmd, "--stream-port=0") // Auto-find
+		}
+
+		if opts.EnableHandshake {
+			remoteCmd = append(remoteCmd, "--enable-handshake")
+			if opts.HandshakeKey != "" {
+				remoteCmd = append(remoteCmd, "--stream-key", opts.HandshakeKey)
+			}
+		}
+
+		if opts.Connections > 1 {
+			remoteCmd = append(remoteCmd, fmt.Sprintf("--stream-connections=%d", opts.Connections))
+		}
 	}
 
-	if remoteOutput != "" {
-		remoteCmd = append(remoteCmd, "--output", remoteOutput)
+	if opts.RemoteOutput != "" {
+		remoteCmd = append(remoteCmd, "--output", opts.RemoteOutput)
 	}
 
-	if estimatedSize > 0 {
-		remoteCmd = append(remoteCmd, "--estimated-size", utils.FormatBytes(estimatedSize))
+	if opts.EstimatedSize > 0 {
+		remoteCmd = append(remoteCmd, "--estimated-size", utils.FormatBytes(opts.EstimatedSize))
 	}
 
-	if enableHandshake {
-		remoteCmd = append(remoteCmd, "--enable-handshake")
-		if handshakeKey != "" {
-			remoteCmd = append(remoteCmd, "--stream-key", handshakeKey)
-		}
+	if opts.Framed {
+		remoteCmd = append(remoteCmd, "--framed")
 	}
 
 	// Disable rate limiting on remote receiver, rate limiting is handled on sender side
 	remoteCmd = append(remoteCmd, "--io-limit=-1")
 
-	// Execute SSH command (rely on system SSH config)
-	cmd := exec.Command("ssh", sshHost, strings.Join(remoteCmd, " "))
+	// The receiver has no terminal to answer prompts, and its messages are parsed in English
+	remoteCmd = append(remoteCmd, "--yes", "--lang=en")
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return 0, "", nil, nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	// Extra receiver flags, e.g. --target-dir or --compress
+	remoteCmd = append(remoteCmd, sshOpts.RemoteArgs...)
+
+	quoted := make([]string, len(remoteCmd))
+	for i, arg := range remoteCmd {
+		quoted[i] = shellQuote(arg)
 	}
+	// Report the shell PID, then exec so that the receiver keeps that PID
+	script := fmt.Sprintf("echo %s$$ >&2; exec %s", shellQuote(remotePIDPrefix), strings.Join(quoted, " "))
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return 0, "", nil, nil, fmt.Errorf("failed to create stderr pipe: %v", err)
+	r := &RemoteReceiver{
+		OutputPath:   opts.RemoteOutput,
+		ssh:          sshOpts,
+		tunnel:       opts.Tunnel,
+		cmd:          exec.Command("ssh", sshArgs(sshOpts, script)...),
+		logCtx:       logCtx,
+		ready:        make(chan error, 1),
+		done:         make(chan struct{}),
+		expectedPort: opts.StreamPort,
 	}
 
-	if err := cmd.Start(); err != nil {
-		return 0, "", nil, nil, fmt.Errorf("failed to start SSH: %v", err)
+	stdout, err := r.cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
 	}
 
-	// Parse output to find port and output path (from stderr)
-	actualPort, outputPath, err := parseReceiverInfo(stderr, port, remoteOutput)
+	stderr, err := r.cmd.StderrPipe()
 	if err != nil {
-		cmd.Process.Kill()
-		return 0, "", nil, nil, fmt.Errorf("failed to parse receiver info: %v", err)
+		return nil, fmt.Errorf("failed to create stderr pipe: %v", err)
 	}
 
-	// IMPORTANT: Start goroutines to consume stdout/stderr to prevent buffer blocking
-	// After parsing the port, the remote process will continue to output progress info
-	// to stderr. If we don't consume it, the buffer will fill up and block the remote process.
-	go func() {
-		// Discard stdout (remote backup-helper doesn't output to stdout in download mode)
-		io.Copy(io.Discard, stdout)
-	}()
+	if opts.Tunnel {
+		if r.stdin, err = r.cmd.StdinPipe(); err != nil {
+			return nil, fmt.Errorf("failed to create stdin pipe: %v", err)
+		}
+	}
+
+	if err := r.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start SSH: %v", err)
+	}
 
+	// IMPORTANT: Keep consuming stdout/stderr until the receiver exits, otherwise the pipe
+	// buffers fill up and block the remote process. Both carry progress and status lines
+	// (the receiver prints to stdout when saving to a file).
+	var wg sync.WaitGroup
+	for _, pipe := range []io.Reader{stdout, stderr} {
+		wg.Add(1)
+		go func(pipe io.Reader) {
+			defer wg.Done()
+			r.scanOutput(pipe)
+		}(pipe)
+	}
 	go func() {
-		// Discard stderr after port parsing (we don't need progress info in SSH mode)
-		// This prevents the stderr buffer from filling up and blocking the remote process
-		io.Copy(io.Discard, stderr)
+		wg.Wait()
+		r.err = r.cmd.Wait()
+		r.flushProgress()
+		close(r.done)
 	}()
 
-	cleanupFunc := func() error {
-		// Send SIGTERM to remote backup-helper process
-		killCmd := exec.Command("ssh", sshHost, "pkill -TERM backup-helper")
-		killCmd.Run()
-		return cmd.Process.Kill()
+	select {
+	case err = <-r.ready:
+	case <-r.done:
+		err = fmt.Errorf("remote receiver exited before it was ready%s", r.statusTail())
+	case <-time.After(remoteStartTimeout):
+		err = fmt.Errorf("timeout waiting for receiver to start")
 	}
+	if err != nil {
+		r.Stop()
+		return nil, fmt.Errorf("failed to parse receiver info: %v", err)
+	}
+	return r, nil
+}
 
-	return actualPort, outputPath, cmd, cleanupFunc, nil
+// TunnelWriter returns the writer of a tunnelled stream, with the same values as StartStreamClient.
+// Closing the writer ends the stream on the receiver.
+func (r *RemoteReceiver) TunnelWriter(totalSize int64, opts StreamOptions, logCtx *log.LogContext) (io.WriteCloser, *progress.ProgressTracker, func(), error) {
+	if !r.tunnel {
+		return nil, nil, nil, fmt.Errorf("remote receiver was not started with a tunnel")
+	}
+	if opts.Connections > 1 {
+		return nil, nil, nil, fmt.Errorf("--ssh-tunnel does not support --stream-connections")
+	}
+	if logCtx != nil {
+		logCtx.WriteLog("SSH", "Streaming through the SSH channel to remote PID %d", r.PID())
+	}
+	tracker := progress.NewProgressTrackerWithCompression(totalSize, opts.CompressType != "")
+	progressWriter := progress.NewProgressWriter(r.stdin, tracker)
+	closer := func() {
+		tracker.Complete()
+		r.stdin.Close()
+	}
+	return struct {
+		io.Writer
+		io.Closer
+	}{Writer: progressWriter, Closer: r.stdin}, tracker, closer, nil
 }
 
-// parseReceiverInfo parses both port and output path from backup-helper receiver output
-// Looks for: "[backup-helper] Listening on <IP>:<port>"
-// Note: "Saved to: <path>" comes after transfer completes, so we can't parse it here
-// We return the port and use remoteOutput if provided
-func parseReceiverInfo(reader io.Reader, expectedPort int, remoteOutput string) (int, string, error) {
-	scanner := bufio.NewScanner(reader)
-	portPattern := regexp.MustCompile(`Listening on [\d.]+:(\d+)`)
+// scanOutput parses one output pipe of the receiver line by line
+func (r *RemoteReceiver) scanOutput(pipe io.Reader) {
+	scanner := bufio.NewScanner(pipe)
+	scanner.Split(scanOutputLines)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			r.handleLine(line)
+		}
+	}
+	io.Copy(io.Discard, pipe) // Keep draining after an overlong line
+}
 
-	timeout := time.After(10 * time.Second)
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
+// scanOutputLines is bufio.ScanLines that also splits at \r (progress lines)
+func scanOutputLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
 
-	var actualPort int
+// handleLine records one output line of the receiver
+func (r *RemoteReceiver) handleLine(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	for {
-		select {
-		case <-timeout:
-			if actualPort == 0 {
-				return 0, "", fmt.Errorf("timeout waiting for receiver to start")
-			}
-			// We got the port, return it with remoteOutput (if provided)
-			return actualPort, remoteOutput, nil
-		case <-ticker.C:
-			if scanner.Scan() {
-				line := scanner.Text()
-				// Parse port
-				if matches := portPattern.FindStringSubmatch(line); matches != nil {
-					if port, err := strconv.Atoi(matches[1]); err == nil {
-						// If expectedPort was specified, validate it matches
-						if expectedPort > 0 && port != expectedPort {
-							return 0, "", fmt.Errorf("port mismatch: expected %d, got %d", expectedPort, port)
-						}
-						actualPort = port
-						// Got the port, return immediately
-						return actualPort, remoteOutput, nil
-					}
-				}
+	// Parse PID (printed by the remote shell before the receiver starts)
+	if strings.HasPrefix(line, remotePIDPrefix) {
+		r.pid, _ = strconv.Atoi(strings.TrimPrefix(line, remotePIDPrefix))
+		return
+	}
+	if !r.started {
+		if r.tunnel && line == stdinReadyMessage {
+			r.started = true
+			r.ready <- nil
+		} else if matches := listeningPattern.FindStringSubmatch(line); matches != nil && !r.tunnel {
+			r.started = true
+			port, _ := strconv.Atoi(matches[1])
+			// If expectedPort was specified, validate it matches
+			if r.expectedPort > 0 && port != r.expectedPort {
+				r.ready <- fmt.Errorf("port mismatch: expected %d, got %d", r.expectedPort, port)
 			} else {
-				if err := scanner.Err(); err != nil {
-					if actualPort == 0 {
-						return 0, "", err
-					}
-					// We got the port, return it with remoteOutput
-					return actualPort, remoteOutput, nil
-				}
-				// EOF, wait a bit more
-				time.Sleep(200 * time.Millisecond)
+				r.Port = port
+				r.ready <- nil
 			}
 		}
 	}
+
+	if strings.HasPrefix(line, "Progress:") {
+		r.progress = line
+		if time.Since(r.lastProgress) >= remoteProgressLogPeriod {
+			r.lastProgress = time.Now()
+			r.writeLog("Remote progress: %s", line)
+		}
+		return
+	}
+	if matches := savedToPattern.FindStringSubmatch(line); matches != nil {
+		r.savedTo = matches[1]
+	}
+	r.tail = append(r.tail, line)
+	if len(r.tail) > remoteStatusTailLines {
+		r.tail = r.tail[1:]
+	}
+	r.writeLog("Remote: %s", line)
+}
+
+// flushProgress logs the last progress line of the receiver
+func (r *RemoteReceiver) flushProgress() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.progress != "" {
+		r.writeLog("Remote progress: %s", r.progress)
+	}
+}
+
+func (r *RemoteReceiver) writeLog(format string, args ...interface{}) {
+	if r.logCtx != nil {
+		r.logCtx.WriteLog("SSH", format, args...)
+	}
+}
+
+// statusTail returns the last status lines of the receiver for an error message
+func (r *RemoteReceiver) statusTail() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.tail) == 0 {
+		return ""
+	}
+	return ": " + strings.Join(r.tail, " | ")
+}
+
+// PID returns the PID of the receiver on the remote host (0 until the remote shell has reported it)
+func (r *RemoteReceiver) PID() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pid
+}
+
+// SavedTo returns where the receiver reported saving the backup ("" if it did not report it)
+func (r *RemoteReceiver) SavedTo() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.savedTo
+}
+
+// Wait waits for the receiver to finish after the stream has been closed (it may still be
+// writing or extracting). If it does not exit within timeout it is stopped.
+// Returns an error with the receiver's last status lines if it failed or had to be stopped.
+func (r *RemoteReceiver) Wait(timeout time.Duration) error {
+	select {
+	case <-r.done:
+		if r.err != nil {
+			return fmt.Errorf("remote receiver (PID %d) failed: %v%s", r.PID(), r.err, r.statusTail())
+		}
+		return nil
+	case <-time.After(timeout):
+		r.Stop()
+		return fmt.Errorf("remote receiver (PID %d) did not exit within %s and was stopped", r.PID(), timeout)
+	}
 }
 
-// parseReceiverPort parses the port from backup-helper receiver output
-// Looks for: "[backup-helper] Listening on <IP>:<port>"
-// If expectedPort > 0, validates it matches
-// DEPRECATED: Use parseReceiverInfo instead
-func parseReceiverPort(reader io.Reader, expectedPort int) (int, error) {
-	port, _, err := parseReceiverInfo(reader, expectedPort, "")
-	return port, err
+// Stop sends SIGTERM to the remote receiver and ends the local ssh process
+func (r *RemoteReceiver) Stop() error {
+	select {
+	case <-r.done:
+		return nil // Already exited
+	default:
+	}
+	err := killRemote(r.ssh, r.PID())
+	r.cmd.Process.Kill()
+	<-r.done
+	return err
+}
+
+// killRemote sends SIGTERM to a single process on the remote host
+func killRemote(sshOpts SSHOptions, pid int) error {
+	if pid <= 0 {
+		return fmt.Errorf("remote PID unknown")
+	}
+	killCmd := exec.Command("ssh", sshArgs(sshOpts, fmt.Sprintf("kill -TERM %d", pid))...)
+	return killCmd.Run()
 }
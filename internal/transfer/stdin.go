@@ -0,0 +1,46 @@
+package transfer
+
+import (
+	"backup-helper/internal/log"
+	"backup-helper/internal/progress"
+	"fmt"
+	"io"
+	"os"
+)
+
+// stdinReadyMessage tells a tunnelling sender (see RemoteReceiver) that the receiver reads stdin
+const stdinReadyMessage = "[backup-helper] Reading backup stream from stdin"
+
+// StartStdinReceiver receives the stream from stdin instead of a TCP port, e.g. when the sender
+// tunnels it through SSH (--ssh-tunnel). The channel is already authenticated, so there is no handshake;
+// framing still applies. Returns the same values as StartStreamReceiver without the listen address.
+func StartStdinReceiver(totalSize int64, opts StreamOptions, logCtx *log.LogContext) (io.ReadCloser, *progress.ProgressTracker, func(), error) {
+	if opts.Connections > 1 {
+		return nil, nil, nil, fmt.Errorf("--stdin does not support --stream-connections")
+	}
+
+	fmt.Fprintln(os.Stderr, stdinReadyMessage)
+	if logCtx != nil {
+		logCtx.WriteLog("TCP", "Reading backup stream from stdin")
+		if opts.EnableHandshake {
+			logCtx.WriteLog("TCP", "Handshake skipped: stdin is not a network connection")
+		}
+	}
+
+	tracker := progress.NewDownloadProgressTrackerWithCompression(totalSize, opts.CompressType != "")
+	progressReader := progress.NewProgressReader(os.Stdin, tracker, 64*1024)
+	closer := func() {
+		tracker.Complete()
+		if logCtx != nil {
+			if err := progressReader.GetError(); err != nil && err != io.EOF {
+				logCtx.WriteLog("TCP", "Transfer interrupted: stdin closed unexpectedly: %v", err)
+			} else {
+				logCtx.WriteLog("TCP", "Transfer completed")
+			}
+		}
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{Reader: progressReader, Closer: os.Stdin}, tracker, closer, nil
+}
@@ -3,15 +3,50 @@ package transfer
 import (
 	"backup-helper/internal/log"
 	"backup-helper/internal/progress"
-	"bufio"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
 	"os"
-	"strings"
+	"strconv"
 	"time"
 )
 
+// StreamOptions holds the protocol settings of a stream endpoint
+type StreamOptions struct {
+	EnableHandshake bool          // Authenticate the peer with the challenge-response handshake
+	HandshakeKey    string        // Shared handshake key
+	CompressType    string        // Compression type of the stream data ("" = none / store as-is)
+	Framed          bool          // Framed protocol with end-of-stream trailer (see FrameWriter / FrameReader)
+	TLSConfig       *tls.Config   // nil = plain TCP (see NewServerTLSConfig / NewClientTLSConfig)
+	Resume          *ResumeState  // Resume point negotiated in the handshake (framed streams only), may be nil
+	Connections     int           // Number of parallel TCP connections (<= 1 = single connection, see multistream.go)
+	Instance        string        // Sender only: instance name announced in the handshake (see ServeStreams)
+	IdleTimeout     time.Duration // ServeStreams only: drop a session after this long without data (0 = never)
+}
+
+// streamInfo returns the handshake description of this endpoint
+func (o StreamOptions) streamInfo(role string, totalSize int64) StreamInfo {
+	info := StreamInfo{
+		Role:          role,
+		CompressType:  o.CompressType,
+		TLS:           o.TLSConfig != nil,
+		Framed:        o.Framed,
+		EstimatedSize: totalSize,
+	}
+	if o.Connections > 1 {
+		info.Connections = o.Connections
+	}
+	if role == RoleSender {
+		info.Instance = o.Instance
+	}
+	if role == RoleReceiver && o.Framed && o.Resume != nil {
+		info.ResumeOffset = o.Resume.Offset
+		info.ResumeTailSHA256 = o.Resume.TailSHA256
+	}
+	return info
+}
+
 // GetAvailablePort finds an available port by binding to port 0 and getting the assigned port
 func GetAvailablePort() (int, error) {
 	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
@@ -44,8 +79,9 @@ func GetLocalIP() (string, error) {
 // It accepts connections and returns a WriteCloser for writing data to the remote client.
 // If port is 0, it will automatically find an available port.
 // timeoutSeconds: connection timeout in seconds (0 means use default 60s, max 3600s)
+// opts.TLSConfig: if not nil, accepted connections are wrapped in TLS (see NewServerTLSConfig)
 // Returns the actual listening port and local IP for display.
-func StartStreamSender(port int, enableHandshake bool, handshakeKey string, totalSize int64, isCompressed bool, timeoutSeconds int, logCtx *log.LogContext) (io.WriteCloser, *progress.ProgressTracker, func(), int, string, error) {
+func StartStreamSender(port int, totalSize int64, timeoutSeconds int, opts StreamOptions, logCtx *log.LogContext) (io.WriteCloser, *progress.ProgressTracker, func(), int, string, error) {
 	var addr string
 	var actualPort int
 
@@ -55,7 +91,7 @@ func StartStreamSender(port int, enableHandshake bool, handshakeKey string, tota
 		actualPort, err = GetAvailablePort()
 		if err != nil {
 			if logCtx != nil {
-				logCtx.WriteLog("TCP", "Failed to find available port: %v", err)
+				logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to find available port: %v", err)
 			}
 			return nil, nil, nil, 0, "", fmt.Errorf("failed to find available port: %v", err)
 		}
@@ -68,7 +104,7 @@ func StartStreamSender(port int, enableHandshake bool, handshakeKey string, tota
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		if logCtx != nil {
-			logCtx.WriteLog("TCP", "Failed to listen on port %d: %v", actualPort, err)
+			logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to listen on port %d: %v", actualPort, err)
 		}
 		return nil, nil, nil, 0, "", fmt.Errorf("failed to listen on port %d: %v", actualPort, err)
 	}
@@ -97,86 +133,114 @@ func StartStreamSender(port int, enableHandshake bool, handshakeKey string, tota
 	ln.(*net.TCPListener).SetDeadline(time.Now().Add(timeout))
 
 	// Create progress tracker
-	tracker := progress.NewProgressTrackerWithCompression(totalSize, isCompressed)
+	tracker := progress.NewProgressTrackerWithCompression(totalSize, opts.CompressType != "")
 
-	if !enableHandshake {
-		conn, err := ln.Accept()
+	if opts.Connections > 1 {
+		conns, err := acceptStreamConns(ln, RoleSender, totalSize, opts, tracker, logCtx)
 		if err != nil {
 			ln.Close()
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, nil, nil, 0, "", fmt.Errorf("%v (port %d)", err, actualPort)
+		}
+		fmt.Printf("[backup-helper] All %d connections established, start streaming backup...\n", len(conns))
+		if logCtx != nil {
+			logCtx.WriteLog("TCP", "All %d connections established, transfer started", len(conns))
+		}
+		blockWriter := newBlockWriter(conns, tracker, logCtx)
+		closer := func() {
+			closeBlockWriter(blockWriter, logCtx)
+			tracker.Complete()
+			ln.Close()
+		}
+		return blockWriter, tracker, closer, actualPort, localIP, nil
+	}
+
+	if !opts.EnableHandshake {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				ln.Close()
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					if logCtx != nil {
+						logCtx.WriteLog("TCP", "Connection timeout after %ds: %v", timeoutSeconds, err)
+					}
+					return nil, nil, nil, 0, "", fmt.Errorf("connection timeout after %ds on port %d: %v", timeoutSeconds, actualPort, err)
+				}
 				if logCtx != nil {
-					logCtx.WriteLog("TCP", "Connection timeout after %ds: %v", timeoutSeconds, err)
+					logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to accept connection: %v", err)
 				}
-				return nil, nil, nil, 0, "", fmt.Errorf("connection timeout after %ds on port %d: %v", timeoutSeconds, actualPort, err)
+				return nil, nil, nil, 0, "", fmt.Errorf("failed to accept connection on port %d: %v", actualPort, err)
+			}
+			conn, err = tlsServerConn(conn, opts.TLSConfig, logCtx)
+			if err != nil {
+				// Reject this client (a port scanner, a plain TCP client, a wrong certificate) and keep waiting
+				continue
 			}
+			fmt.Println("[backup-helper] Remote client connected, no handshake required.")
 			if logCtx != nil {
-				logCtx.WriteLog("TCP", "Failed to accept connection: %v", err)
+				logCtx.WriteLog("TCP", "Remote client connected, no handshake required")
+				logCtx.WriteLog("TCP", "Transfer started")
 			}
-			return nil, nil, nil, 0, "", fmt.Errorf("failed to accept connection on port %d: %v", actualPort, err)
-		}
-		fmt.Println("[backup-helper] Remote client connected, no handshake required.")
-		if logCtx != nil {
-			logCtx.WriteLog("TCP", "Remote client connected, no handshake required")
-			logCtx.WriteLog("TCP", "Transfer started")
+			closer := func() { tracker.Complete(); conn.Close(); ln.Close() }
+			progressWriter := progress.NewProgressWriter(conn, tracker)
+			return struct {
+				io.Writer
+				io.Closer
+			}{Writer: progressWriter, Closer: conn}, tracker, closer, actualPort, localIP, nil
 		}
-		closer := func() { tracker.Complete(); conn.Close(); ln.Close() }
-		progressWriter := progress.NewProgressWriter(conn, tracker)
-		return struct {
-			io.Writer
-			io.Closer
-		}{Writer: progressWriter, Closer: conn}, tracker, closer, actualPort, localIP, nil
 	}
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			if logCtx != nil {
-				logCtx.WriteLog("TCP", "Failed to accept connection: %v", err)
+				logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to accept connection: %v", err)
 			}
 			ln.Close()
 			return nil, nil, nil, 0, "", fmt.Errorf("failed to accept connection on port %d: %v", actualPort, err)
 		}
+		conn, err = tlsServerConn(conn, opts.TLSConfig, logCtx)
+		if err != nil {
+			// Reject this client and keep waiting for a valid one
+			continue
+		}
 		fmt.Println("[backup-helper] Remote client connected, waiting for handshake...")
 		if logCtx != nil {
 			logCtx.WriteLog("TCP", "Remote client connected, waiting for handshake")
 		}
 
-		goAway := false
-		// set timeout to prevent from hanging
-		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-		reader := bufio.NewReader(conn)
-		line, err := reader.ReadString('\n')
+		local := opts.streamInfo(RoleSender, totalSize)
+		effective, _, err := serverHandshake(conn, opts, local, nil, logCtx)
 		if err != nil {
-			conn.Write([]byte("Please send handshake\n"))
-			goAway = true
-		} else {
-			line = strings.TrimSpace(line)
-			if line == handshakeKey {
-				conn.SetReadDeadline(time.Time{}) // cancel timeout
-				if logCtx != nil {
-					logCtx.WriteLog("TCP", "Handshake OK, transfer started")
-				}
-				closer := func() { tracker.Complete(); conn.Close(); ln.Close() }
-				progressWriter := progress.NewProgressWriter(conn, tracker)
-				return struct {
-					io.Writer
-					io.Closer
-				}{Writer: progressWriter, Closer: conn}, tracker, closer, actualPort, localIP, nil
-			} else {
-				conn.Write([]byte("Invalid handshake. Send the correct handshake to begin streaming.\n"))
-				goAway = true
+			// Reject this client and keep waiting for a valid one
+			fmt.Printf("[backup-helper] Handshake with %s failed: %v\n", conn.RemoteAddr(), err)
+			if logCtx != nil {
+				logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Handshake with %s failed: %v", conn.RemoteAddr(), err)
 			}
-		}
-		if goAway {
 			conn.Close()
 			continue
 		}
+		if err := applyResume(opts, local, effective.ResumeOffset, tracker, totalSize, logCtx); err != nil {
+			conn.Close()
+			ln.Close()
+			return nil, nil, nil, 0, "", err
+		}
+		fmt.Println("[backup-helper] Handshake OK, start streaming backup...")
+		if logCtx != nil {
+			logCtx.WriteLog("TCP", "Handshake OK, transfer started")
+		}
+		closer := func() { tracker.Complete(); conn.Close(); ln.Close() }
+		progressWriter := progress.NewProgressWriter(conn, tracker)
+		return struct {
+			io.Writer
+			io.Closer
+		}{Writer: progressWriter, Closer: conn}, tracker, closer, actualPort, localIP, nil
 	}
 }
 
 // StartStreamClient connects to a remote TCP server and returns a WriteCloser for pushing data.
 // Similar to `nc host port`, this function actively connects to the remote server.
+// opts.TLSConfig: if not nil, the connection is wrapped in TLS (see NewClientTLSConfig)
 // Returns the remote address for display.
-func StartStreamClient(host string, port int, enableHandshake bool, handshakeKey string, totalSize int64, isCompressed bool, logCtx *log.LogContext) (io.WriteCloser, *progress.ProgressTracker, func(), string, error) {
+func StartStreamClient(host string, port int, totalSize int64, opts StreamOptions, logCtx *log.LogContext) (io.WriteCloser, *progress.ProgressTracker, func(), string, error) {
 	if host == "" {
 		return nil, nil, nil, "", fmt.Errorf("stream-host cannot be empty")
 	}
@@ -184,29 +248,50 @@ func StartStreamClient(host string, port int, enableHandshake bool, handshakeKey
 		return nil, nil, nil, "", fmt.Errorf("stream-port must be specified when using --stream-host")
 	}
 
-	addr := fmt.Sprintf("%s:%d", host, port)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	fmt.Printf("[backup-helper] Connecting to %s...\n", addr)
 	if logCtx != nil {
 		logCtx.WriteLog("TCP", "Connecting to %s", addr)
 	}
 
 	// Create progress tracker
-	tracker := progress.NewProgressTrackerWithCompression(totalSize, isCompressed)
+	tracker := progress.NewProgressTrackerWithCompression(totalSize, opts.CompressType != "")
+
+	if opts.Connections > 1 {
+		conns, err := dialStreamConns(host, addr, RoleSender, totalSize, opts, tracker, logCtx)
+		if err != nil {
+			return nil, nil, nil, "", err
+		}
+		fmt.Printf("[backup-helper] All %d connections established, start streaming backup to %s...\n", len(conns), addr)
+		if logCtx != nil {
+			logCtx.WriteLog("TCP", "All %d connections established, transfer started", len(conns))
+		}
+		blockWriter := newBlockWriter(conns, tracker, logCtx)
+		closer := func() {
+			closeBlockWriter(blockWriter, logCtx)
+			tracker.Complete()
+		}
+		return blockWriter, tracker, closer, addr, nil
+	}
 
 	conn, err := net.DialTimeout("tcp", addr, 30*time.Second)
 	if err != nil {
 		if logCtx != nil {
-			logCtx.WriteLog("TCP", "Failed to connect to %s: %v", addr, err)
+			logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to connect to %s: %v", addr, err)
 		}
 		return nil, nil, nil, "", fmt.Errorf("failed to connect to %s: %v", addr, err)
 	}
+	conn, err = tlsClientConn(conn, host, opts.TLSConfig, logCtx)
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
 
 	fmt.Printf("[backup-helper] Connected to %s\n", addr)
 	if logCtx != nil {
 		logCtx.WriteLog("TCP", "Connected to %s", addr)
 	}
 
-	if !enableHandshake {
+	if !opts.EnableHandshake {
 		if logCtx != nil {
 			logCtx.WriteLog("TCP", "Transfer started (no handshake)")
 		}
@@ -224,39 +309,20 @@ func StartStreamClient(host string, port int, enableHandshake bool, handshakeKey
 		}{Writer: progressWriter, Closer: conn}, tracker, closer, addr, nil
 	}
 
-	// Send handshake
-	handshakeMsg := handshakeKey + "\n"
-	_, err = conn.Write([]byte(handshakeMsg))
+	local := opts.streamInfo(RoleSender, totalSize)
+	effective, err := clientHandshake(conn, opts, local, logCtx)
 	if err != nil {
 		conn.Close()
 		if logCtx != nil {
-			logCtx.WriteLog("TCP", "Failed to send handshake: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Handshake with %s failed: %v", addr, err)
 		}
-		return nil, nil, nil, "", fmt.Errorf("failed to send handshake to %s: %v", addr, err)
+		return nil, nil, nil, "", fmt.Errorf("handshake with %s failed: %v", addr, err)
 	}
-
-	// Wait for handshake response
-	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-	reader := bufio.NewReader(conn)
-	response, err := reader.ReadString('\n')
-	if err != nil {
+	if err := applyResume(opts, local, effective.ResumeOffset, tracker, totalSize, logCtx); err != nil {
 		conn.Close()
-		if logCtx != nil {
-			logCtx.WriteLog("TCP", "Failed to receive handshake response: %v", err)
-		}
-		return nil, nil, nil, "", fmt.Errorf("failed to receive handshake response from %s: %v", addr, err)
+		return nil, nil, nil, "", err
 	}
 
-	response = strings.TrimSpace(response)
-	if response != "OK" && !strings.Contains(response, "OK") {
-		conn.Close()
-		if logCtx != nil {
-			logCtx.WriteLog("TCP", "Handshake failed: received '%s'", response)
-		}
-		return nil, nil, nil, "", fmt.Errorf("handshake failed: received '%s' from %s", response, addr)
-	}
-
-	conn.SetReadDeadline(time.Time{}) // cancel timeout
 	fmt.Printf("[backup-helper] Handshake OK, start streaming backup to %s...\n", addr)
 	if logCtx != nil {
 		logCtx.WriteLog("TCP", "Handshake OK, transfer started")
@@ -278,9 +344,10 @@ func StartStreamClient(host string, port int, enableHandshake bool, handshakeKey
 
 // StartStreamClientReader starts a TCP client connection to the given host:port for reading data.
 // It actively connects to the remote server and returns a ReadCloser for reading data.
-// If handshake is enabled, it will wait for the server's handshake message and respond with "OK".
+// If handshake is enabled, it answers the server's challenge (see clientHandshake).
+// opts.TLSConfig: if not nil, the connection is wrapped in TLS (see NewClientTLSConfig)
 // Returns the remote address for display.
-func StartStreamClientReader(host string, port int, enableHandshake bool, handshakeKey string, totalSize int64, isCompressed bool, logCtx *log.LogContext) (io.ReadCloser, *progress.ProgressTracker, func(), string, error) {
+func StartStreamClientReader(host string, port int, totalSize int64, opts StreamOptions, logCtx *log.LogContext) (io.ReadCloser, *progress.ProgressTracker, func(), string, error) {
 	if host == "" {
 		return nil, nil, nil, "", fmt.Errorf("stream-host cannot be empty")
 	}
@@ -288,7 +355,7 @@ func StartStreamClientReader(host string, port int, enableHandshake bool, handsh
 		return nil, nil, nil, "", fmt.Errorf("stream-port must be specified when using --stream-host")
 	}
 
-	addr := fmt.Sprintf("%s:%d", host, port)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	fmt.Printf("[backup-helper] Connecting to %s...\n", addr)
 	if logCtx != nil {
 		logCtx.WriteLog("TCP", "Connecting to %s", addr)
@@ -297,20 +364,41 @@ func StartStreamClientReader(host string, port int, enableHandshake bool, handsh
 	// Create progress tracker
 	tracker := progress.NewDownloadProgressTracker(totalSize)
 
+	if opts.Connections > 1 {
+		conns, err := dialStreamConns(host, addr, RoleReceiver, totalSize, opts, tracker, logCtx)
+		if err != nil {
+			return nil, nil, nil, "", err
+		}
+		fmt.Fprintf(os.Stderr, "[backup-helper] All %d connections established, start receiving backup from %s...\n", len(conns), addr)
+		if logCtx != nil {
+			logCtx.WriteLog("TCP", "All %d connections established, transfer started", len(conns))
+		}
+		blockReader := newBlockReader(conns, tracker, logCtx)
+		closer := func() {
+			tracker.Complete()
+			closeBlockReader(blockReader, logCtx)
+		}
+		return blockReader, tracker, closer, addr, nil
+	}
+
 	conn, err := net.DialTimeout("tcp", addr, 30*time.Second)
 	if err != nil {
 		if logCtx != nil {
-			logCtx.WriteLog("TCP", "Failed to connect to %s: %v", addr, err)
+			logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to connect to %s: %v", addr, err)
 		}
 		return nil, nil, nil, "", fmt.Errorf("failed to connect to %s: %v", addr, err)
 	}
+	conn, err = tlsClientConn(conn, host, opts.TLSConfig, logCtx)
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
 
 	fmt.Printf("[backup-helper] Connected to %s\n", addr)
 	if logCtx != nil {
 		logCtx.WriteLog("TCP", "Connected to %s", addr)
 	}
 
-	if !enableHandshake {
+	if !opts.EnableHandshake {
 		if logCtx != nil {
 			logCtx.WriteLog("TCP", "Transfer started (no handshake)")
 		}
@@ -328,38 +416,21 @@ func StartStreamClientReader(host string, port int, enableHandshake bool, handsh
 		}{Reader: progressReader, Closer: conn}, tracker, closer, addr, nil
 	}
 
-	// Wait for handshake from server
-	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-	reader := bufio.NewReader(conn)
-	handshakeMsg, err := reader.ReadString('\n')
+	local := opts.streamInfo(RoleReceiver, totalSize)
+	effective, err := clientHandshake(conn, opts, local, logCtx)
 	if err != nil {
 		conn.Close()
 		if logCtx != nil {
-			logCtx.WriteLog("TCP", "Failed to receive handshake: %v", err)
+			logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Handshake with %s failed: %v", addr, err)
 		}
-		return nil, nil, nil, "", fmt.Errorf("failed to receive handshake from %s: %v", addr, err)
+		return nil, nil, nil, "", fmt.Errorf("handshake with %s failed: %v", addr, err)
 	}
-
-	handshakeMsg = strings.TrimSpace(handshakeMsg)
-	if handshakeMsg != handshakeKey {
+	tracker.SetTotalBytes(effective.EstimatedSize)
+	if err := applyResume(opts, local, effective.ResumeOffset, tracker, effective.EstimatedSize, logCtx); err != nil {
 		conn.Close()
-		if logCtx != nil {
-			logCtx.WriteLog("TCP", "Handshake failed: received '%s', expected '%s'", handshakeMsg, handshakeKey)
-		}
-		return nil, nil, nil, "", fmt.Errorf("handshake failed: received '%s' from %s, expected '%s'", handshakeMsg, addr, handshakeKey)
+		return nil, nil, nil, "", err
 	}
 
-	// Send OK response
-	_, err = conn.Write([]byte("OK\n"))
-	if err != nil {
-		conn.Close()
-		if logCtx != nil {
-			logCtx.WriteLog("TCP", "Failed to send handshake response: %v", err)
-		}
-		return nil, nil, nil, "", fmt.Errorf("failed to send handshake response to %s: %v", addr, err)
-	}
-
-	conn.SetReadDeadline(time.Time{}) // cancel timeout
 	fmt.Printf("[backup-helper] Handshake OK, start receiving backup from %s...\n", addr)
 	if logCtx != nil {
 		logCtx.WriteLog("TCP", "Handshake OK, transfer started")
@@ -383,8 +454,9 @@ func StartStreamClientReader(host string, port int, enableHandshake bool, handsh
 // It accepts connections and returns a ReadCloser for reading data from the remote client.
 // If port is 0, it will automatically find an available port.
 // timeoutSeconds: connection timeout in seconds (0 means use default 60s, max 3600s)
+// opts.TLSConfig: if not nil, accepted connections are wrapped in TLS (see NewServerTLSConfig)
 // Returns the actual listening port and local IP for display.
-func StartStreamReceiver(port int, enableHandshake bool, handshakeKey string, totalSize int64, isCompressed bool, timeoutSeconds int, logCtx *log.LogContext) (io.ReadCloser, *progress.ProgressTracker, func(), int, string, error) {
+func StartStreamReceiver(port int, totalSize int64, timeoutSeconds int, opts StreamOptions, logCtx *log.LogContext) (io.ReadCloser, *progress.ProgressTracker, func(), int, string, error) {
 	var addr string
 	var actualPort int
 
@@ -394,7 +466,7 @@ func StartStreamReceiver(port int, enableHandshake bool, handshakeKey string, to
 		actualPort, err = GetAvailablePort()
 		if err != nil {
 			if logCtx != nil {
-				logCtx.WriteLog("TCP", "Failed to find available port: %v", err)
+				logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to find available port: %v", err)
 			}
 			return nil, nil, nil, 0, "", fmt.Errorf("failed to find available port: %v", err)
 		}
@@ -407,7 +479,7 @@ func StartStreamReceiver(port int, enableHandshake bool, handshakeKey string, to
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		if logCtx != nil {
-			logCtx.WriteLog("TCP", "Failed to listen on port %d: %v", actualPort, err)
+			logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to listen on port %d: %v", actualPort, err)
 		}
 		return nil, nil, nil, 0, "", fmt.Errorf("failed to listen on port %d: %v", actualPort, err)
 	}
@@ -437,105 +509,130 @@ func StartStreamReceiver(port int, enableHandshake bool, handshakeKey string, to
 
 	// Create progress tracker for download mode
 	// Create progress tracker for download
-	tracker := progress.NewDownloadProgressTrackerWithCompression(totalSize, isCompressed)
+	tracker := progress.NewDownloadProgressTrackerWithCompression(totalSize, opts.CompressType != "")
 
-	if !enableHandshake {
-		conn, err := ln.Accept()
+	if opts.Connections > 1 {
+		conns, err := acceptStreamConns(ln, RoleReceiver, totalSize, opts, tracker, logCtx)
 		if err != nil {
 			ln.Close()
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				if logCtx != nil {
-					logCtx.WriteLog("TCP", "Connection timeout after %ds: %v", timeoutSeconds, err)
-				}
-				return nil, nil, nil, 0, "", fmt.Errorf("connection timeout after %ds on port %d: %v", timeoutSeconds, actualPort, err)
-			}
-			if logCtx != nil {
-				logCtx.WriteLog("TCP", "Failed to accept connection: %v", err)
-			}
-			return nil, nil, nil, 0, "", fmt.Errorf("failed to accept connection on port %d: %v", actualPort, err)
+			return nil, nil, nil, 0, "", fmt.Errorf("%v (port %d)", err, actualPort)
 		}
-		fmt.Fprintf(os.Stderr, "[backup-helper] Remote client connected, no handshake required.\n")
+		fmt.Fprintf(os.Stderr, "[backup-helper] All %d connections established, start receiving backup...\n", len(conns))
 		if logCtx != nil {
-			logCtx.WriteLog("TCP", "Remote client connected, no handshake required")
-			logCtx.WriteLog("TCP", "Transfer started")
+			logCtx.WriteLog("TCP", "All %d connections established, transfer started", len(conns))
 		}
-		progressReader := progress.NewProgressReader(conn, tracker, 64*1024)
+		blockReader := newBlockReader(conns, tracker, logCtx)
 		closer := func() {
 			tracker.Complete()
-			conn.Close()
+			closeBlockReader(blockReader, logCtx)
 			ln.Close()
+		}
+		return blockReader, tracker, closer, actualPort, localIP, nil
+	}
+
+	if !opts.EnableHandshake {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				ln.Close()
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					if logCtx != nil {
+						logCtx.WriteLog("TCP", "Connection timeout after %ds: %v", timeoutSeconds, err)
+					}
+					return nil, nil, nil, 0, "", fmt.Errorf("connection timeout after %ds on port %d: %v", timeoutSeconds, actualPort, err)
+				}
+				if logCtx != nil {
+					logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to accept connection: %v", err)
+				}
+				return nil, nil, nil, 0, "", fmt.Errorf("failed to accept connection on port %d: %v", actualPort, err)
+			}
+			conn, err = tlsServerConn(conn, opts.TLSConfig, logCtx)
+			if err != nil {
+				// Reject this client (a port scanner, a plain TCP client, a wrong certificate) and keep waiting
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "[backup-helper] Remote client connected, no handshake required.\n")
 			if logCtx != nil {
-				// Check if transfer completed normally (check if reader encountered EOF or error)
-				if err := progressReader.GetError(); err != nil && err != io.EOF {
-					logCtx.WriteLog("TCP", "Transfer interrupted: connection closed unexpectedly: %v", err)
-				} else {
-					logCtx.WriteLog("TCP", "Transfer completed")
+				logCtx.WriteLog("TCP", "Remote client connected, no handshake required")
+				logCtx.WriteLog("TCP", "Transfer started")
+			}
+			progressReader := progress.NewProgressReader(conn, tracker, 64*1024)
+			closer := func() {
+				tracker.Complete()
+				conn.Close()
+				ln.Close()
+				if logCtx != nil {
+					// Check if transfer completed normally (check if reader encountered EOF or error)
+					if err := progressReader.GetError(); err != nil && err != io.EOF {
+						logCtx.WriteLog("TCP", "Transfer interrupted: connection closed unexpectedly: %v", err)
+					} else {
+						logCtx.WriteLog("TCP", "Transfer completed")
+					}
 				}
 			}
+			return struct {
+				io.Reader
+				io.Closer
+			}{Reader: progressReader, Closer: conn}, tracker, closer, actualPort, localIP, nil
 		}
-		return struct {
-			io.Reader
-			io.Closer
-		}{Reader: progressReader, Closer: conn}, tracker, closer, actualPort, localIP, nil
 	}
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			if logCtx != nil {
-				logCtx.WriteLog("TCP", "Failed to accept connection: %v", err)
+				logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Failed to accept connection: %v", err)
 			}
 			ln.Close()
 			return nil, nil, nil, 0, "", fmt.Errorf("failed to accept connection on port %d: %v", actualPort, err)
 		}
+		conn, err = tlsServerConn(conn, opts.TLSConfig, logCtx)
+		if err != nil {
+			// Reject this client and keep waiting for a valid one
+			continue
+		}
 		fmt.Fprintf(os.Stderr, "[backup-helper] Remote client connected, waiting for handshake...\n")
 		if logCtx != nil {
 			logCtx.WriteLog("TCP", "Remote client connected, waiting for handshake")
 		}
 
-		goAway := false
-		// set timeout to prevent from hanging
-		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-		reader := bufio.NewReader(conn)
-		line, err := reader.ReadString('\n')
+		local := opts.streamInfo(RoleReceiver, totalSize)
+		effective, _, err := serverHandshake(conn, opts, local, nil, logCtx)
 		if err != nil {
-			conn.Write([]byte("Please send handshake\n"))
-			goAway = true
-		} else {
-			line = strings.TrimSpace(line)
-			if line == handshakeKey {
-				conn.SetReadDeadline(time.Time{}) // cancel timeout
-				// Send OK response for handshake
-				conn.Write([]byte("OK\n"))
-				fmt.Fprintf(os.Stderr, "[backup-helper] Handshake OK, start receiving backup...\n")
-				if logCtx != nil {
-					logCtx.WriteLog("TCP", "Handshake OK, transfer started")
-				}
-				progressReader := progress.NewProgressReader(conn, tracker, 64*1024)
-				closer := func() {
-					tracker.Complete()
-					conn.Close()
-					ln.Close()
-					if logCtx != nil {
-						// Check if transfer completed normally (check if reader encountered EOF or error)
-						if err := progressReader.GetError(); err != nil && err != io.EOF {
-							logCtx.WriteLog("TCP", "Transfer interrupted: connection closed unexpectedly: %v", err)
-						} else {
-							logCtx.WriteLog("TCP", "Transfer completed")
-						}
-					}
-				}
-				return struct {
-					io.Reader
-					io.Closer
-				}{Reader: progressReader, Closer: conn}, tracker, closer, actualPort, localIP, nil
-			} else {
-				conn.Write([]byte("Invalid handshake. Send the correct handshake to begin streaming.\n"))
-				goAway = true
+			// Reject this client and keep waiting for a valid one
+			fmt.Fprintf(os.Stderr, "[backup-helper] Handshake with %s failed: %v\n", conn.RemoteAddr(), err)
+			if logCtx != nil {
+				logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "Handshake with %s failed: %v", conn.RemoteAddr(), err)
 			}
-		}
-		if goAway {
 			conn.Close()
 			continue
 		}
+		tracker.SetTotalBytes(effective.EstimatedSize)
+		if err := applyResume(opts, local, effective.ResumeOffset, tracker, effective.EstimatedSize, logCtx); err != nil {
+			conn.Close()
+			ln.Close()
+			return nil, nil, nil, 0, "", err
+		}
+		fmt.Fprintf(os.Stderr, "[backup-helper] Handshake OK, start receiving backup...\n")
+		if logCtx != nil {
+			logCtx.WriteLog("TCP", "Handshake OK, transfer started")
+		}
+		progressReader := progress.NewProgressReader(conn, tracker, 64*1024)
+		closer := func() {
+			tracker.Complete()
+			conn.Close()
+			ln.Close()
+			if logCtx != nil {
+				// Check if transfer completed normally (check if reader encountered EOF or error)
+				if err := progressReader.GetError(); err != nil && err != io.EOF {
+					logCtx.WriteLog("TCP", "Transfer interrupted: connection closed unexpectedly: %v", err)
+				} else {
+					logCtx.WriteLog("TCP", "Transfer completed")
+				}
+			}
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{Reader: progressReader, Closer: conn}, tracker, closer, actualPort, localIP, nil
 	}
 }
@@ -0,0 +1,150 @@
+package transfer
+
+import (
+	"backup-helper/internal/config"
+	"backup-helper/internal/log"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds the TLS handshake on both sides of a stream connection
+const tlsHandshakeTimeout = 10 * time.Second
+
+// loadCAPool loads a PEM-encoded CA bundle into a certificate pool
+func loadCAPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS CA file %s: %v", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid certificates found in TLS CA file %s", caFile)
+	}
+	return pool, nil
+}
+
+// NewServerTLSConfig builds the TLS config for the listening side of a stream
+// (StartStreamSender / StartStreamReceiver). Returns nil if TLS is disabled.
+// When StreamTLSClientAuth is set, clients must present a certificate signed by StreamTLSCA.
+func NewServerTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if !cfg.StreamTLS {
+		return nil, nil
+	}
+	if cfg.StreamTLSCert == "" || cfg.StreamTLSKey == "" {
+		return nil, fmt.Errorf("--tls-cert and --tls-key are required on the listening side when TLS is enabled")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.StreamTLSCert, cfg.StreamTLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.StreamTLSClientAuth {
+		if cfg.StreamTLSCA == "" {
+			return nil, fmt.Errorf("--tls-ca is required to verify client certificates")
+		}
+		pool, err := loadCAPool(cfg.StreamTLSCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// NewClientTLSConfig builds the TLS config for the connecting side of a stream
+// (StartStreamClient / StartStreamClientReader). Returns nil if TLS is disabled.
+// The server certificate is verified against StreamTLSCA (or system roots) and
+// StreamTLSServerName (or the host being dialed when empty).
+func NewClientTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if !cfg.StreamTLS {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: cfg.StreamTLSServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.StreamTLSCA != "" {
+		pool, err := loadCAPool(cfg.StreamTLSCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	// Client certificate for mutual TLS (optional)
+	if cfg.StreamTLSCert != "" && cfg.StreamTLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.StreamTLSCert, cfg.StreamTLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// tlsServerConn runs the TLS server handshake on an accepted connection.
+// If tlsConfig is nil the connection is returned unchanged.
+func tlsServerConn(conn net.Conn, tlsConfig *tls.Config, logCtx *log.LogContext) (net.Conn, error) {
+	if tlsConfig == nil {
+		return conn, nil
+	}
+	tlsConn := tls.Server(conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		if logCtx != nil {
+			logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+		}
+		return nil, fmt.Errorf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+	logTLSState(tlsConn, logCtx)
+	return tlsConn, nil
+}
+
+// tlsClientConn runs the TLS client handshake on a dialed connection.
+// If tlsConfig is nil the connection is returned unchanged.
+func tlsClientConn(conn net.Conn, host string, tlsConfig *tls.Config, logCtx *log.LogContext) (net.Conn, error) {
+	if tlsConfig == nil {
+		return conn, nil
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		if logCtx != nil {
+			logCtx.WriteLogLevel(log.LevelError, "TCP", nil, "TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+		}
+		return nil, fmt.Errorf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+	logTLSState(tlsConn, logCtx)
+	return tlsConn, nil
+}
+
+// logTLSState logs the negotiated TLS parameters and the peer certificate subject
+func logTLSState(conn *tls.Conn, logCtx *log.LogContext) {
+	state := conn.ConnectionState()
+	peer := "none"
+	if len(state.PeerCertificates) > 0 {
+		peer = state.PeerCertificates[0].Subject.String()
+	}
+	fmt.Fprintf(os.Stderr, "[backup-helper] TLS established (%s, peer: %s)\n", tls.VersionName(state.Version), peer)
+	if logCtx != nil {
+		logCtx.WriteLog("TCP", "TLS established: version=%s cipher=%s peer=%s",
+			tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), peer)
+	}
+}
@@ -0,0 +1,240 @@
+package transfer
+
+import (
+	"backup-helper/internal/config"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testCA is a throwaway certificate authority for TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM certificate
+}
+
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	file := filepath.Join(dir, name+".pem")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue writes a certificate for localhost signed by the CA and returns its cert and key files
+func (ca *testCA) issue(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tlsPair builds the listening and connecting TLS configs: the server certificate is issued by ca,
+// client certificates are required (signed by ca) and the client presents one issued by clientCA
+func tlsPair(t *testing.T, ca, clientCA *testCA) (*tls.Config, *tls.Config) {
+	t.Helper()
+	dir := t.TempDir()
+	serverCert, serverKey := ca.issue(t, dir, "server")
+	clientCert, clientKey := clientCA.issue(t, dir, "client")
+	server, err := NewServerTLSConfig(&config.Config{StreamTLS: true, StreamTLSCert: serverCert, StreamTLSKey: serverKey,
+		StreamTLSCA: ca.file, StreamTLSClientAuth: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClientTLSConfig(&config.Config{StreamTLS: true, StreamTLSCert: clientCert, StreamTLSKey: clientKey,
+		StreamTLSCA: ca.file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+// tlsLoopback runs the TLS handshake of both sides over a loopback connection, sends one
+// message from the client and returns what the server read and the errors of both sides
+func tlsLoopback(t *testing.T, server, client *tls.Config) (string, error, error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type result struct {
+		data string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		conn, err = tlsServerConn(conn, server, nil)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer conn.Close()
+		data, err := io.ReadAll(conn)
+		done <- result{string(data), err}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, clientErr := tlsClientConn(conn, "localhost", client, nil)
+	if clientErr == nil {
+		_, clientErr = io.WriteString(conn, "backup data")
+		conn.Close()
+	}
+	r := <-done
+	return r.data, r.err, clientErr
+}
+
+func TestTLSLoopback(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	server, client := tlsPair(t, ca, ca)
+
+	data, serverErr, clientErr := tlsLoopback(t, server, client)
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("handshake failed: server %v, client %v", serverErr, clientErr)
+	}
+	if data != "backup data" {
+		t.Errorf("server read %q, want %q", data, "backup data")
+	}
+}
+
+func TestTLSClientCertificateFromOtherCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	other := newTestCA(t, dir, "other-ca")
+	server, client := tlsPair(t, ca, other)
+
+	_, serverErr, _ := tlsLoopback(t, server, client)
+	if serverErr == nil {
+		t.Fatal("server accepted a client certificate from another CA")
+	}
+}
+
+func TestStreamSenderKeepsAcceptingAfterFailedTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	server, client := tlsPair(t, ca, ca)
+	port, err := GetAvailablePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	received := make(chan string, 1)
+	go func() {
+		// A plain TCP client, then a TLS client without a client certificate, then the real one
+		var conn net.Conn
+		var err error
+		for i := 0; i < 100; i++ {
+			if conn, err = net.Dial("tcp", addr); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		conn.Close()
+
+		for _, cfg := range []*tls.Config{{InsecureSkipVerify: true}, client} {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				received <- err.Error()
+				return
+			}
+			tlsConn, err := tlsClientConn(conn, "localhost", cfg, nil)
+			if err != nil {
+				continue
+			}
+			data, _ := io.ReadAll(tlsConn)
+			tlsConn.Close()
+			if len(data) > 0 {
+				received <- string(data)
+				return
+			}
+		}
+		received <- "no data"
+	}()
+
+	writer, _, closer, _, _, err := StartStreamSender(port, 0, 10, StreamOptions{TLSConfig: server}, nil)
+	if err != nil {
+		t.Fatalf("StartStreamSender: %v", err)
+	}
+	io.WriteString(writer, "backup data")
+	writer.Close()
+	closer()
+	if got := <-received; got != "backup data" {
+		t.Errorf("client received %q, want %q", got, "backup data")
+	}
+}
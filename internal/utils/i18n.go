@@ -70,8 +70,12 @@ func InitEn() {
 	message.SetString(language.English, "zstd command not found. Please install zstd: https://github.com/facebook/zstd", "zstd command not found. Please install zstd: https://github.com/facebook/zstd")
 	message.SetString(language.English, "AI diagnosis failed: %v\n", "AI diagnosis failed: %v\n")
 	message.SetString(language.English, "AI diagnosis suggestion:\n", "AI diagnosis suggestion:\n")
+	message.SetString(language.English, "Known issues (%s):\n", "Known issues (%s):\n")
+	message.SetString(language.English, "No AI diagnosis available; see the known issues above\n", "No AI diagnosis available; see the known issues above\n")
+	message.SetString(language.English, "(no AI service configured)", "(no AI service configured)")
+	message.SetString(language.English, "AI diagnosis preview, nothing was sent. Destination: %s\n", "AI diagnosis preview, nothing was sent. Destination: %s\n")
+	message.SetString(language.English, "AI service unavailable (%v), using offline diagnosis rules\n", "AI service unavailable (%v), using offline diagnosis rules\n")
 	message.SetString(language.English, "Would you like to use AI diagnosis? (y/n): ", "Would you like to use AI diagnosis? (y/n): ")
-	message.SetString(language.English, "Qwen API Key is required for AI diagnosis. Please set it in config.\n", "Qwen API Key is required for AI diagnosis. Please set it in config.\n")
 	message.SetString(language.English, "AI diagnosis on backup failure: on/off. If not set, prompt interactively.", "AI diagnosis on backup failure: on/off. If not set, prompt interactively.")
 	message.SetString(language.English, "AI_DIAG_PROMPT", "You are a MySQL backup expert. Based on the provided log error information, give concise and clear repair suggestions in English. The output should be suitable for display in the command line, avoid using Markdown format, and use a clear text structure.\n\nSample output format:\nERROR: [Error keyword]\nCAUSE: [Brief analysis of the cause]\nFIX: [Specific repair steps]")
 	message.SetString(language.English, "AI_DIAG_PROMPT_BACKUP", "You are a MySQL backup expert specializing in xtrabackup backup operations. Based on the provided log error information, give concise and clear repair suggestions in English. Focus on xtrabackup backup failures, MySQL connection issues, lock timeouts, and backup-related errors. The output should be suitable for display in the command line, avoid using Markdown format, and use a clear text structure.\n\nSample output format:\nERROR: [Error keyword]\nCAUSE: [Brief analysis of the cause]\nFIX: [Specific repair steps]")
@@ -152,8 +156,12 @@ func InitEn() {
 	message.SetString(language.SimplifiedChinese, "zstd command not found. Please install zstd: https://github.com/facebook/zstd", "未找到zstd命令。请安装zstd: https://github.com/facebook/zstd")
 	message.SetString(language.SimplifiedChinese, "AI diagnosis failed: %v\n", "AI诊断失败: %v\n")
 	message.SetString(language.SimplifiedChinese, "AI diagnosis suggestion:\n", "AI诊断建议:\n")
+	message.SetString(language.SimplifiedChinese, "Known issues (%s):\n", "已知问题（%s）:\n")
+	message.SetString(language.SimplifiedChinese, "No AI diagnosis available; see the known issues above\n", "无可用的 AI 诊断，请参考上方的已知问题\n")
+	message.SetString(language.SimplifiedChinese, "(no AI service configured)", "（未配置 AI 服务）")
+	message.SetString(language.SimplifiedChinese, "AI diagnosis preview, nothing was sent. Destination: %s\n", "AI 诊断预览，未发送任何内容。目标: %s\n")
+	message.SetString(language.SimplifiedChinese, "AI service unavailable (%v), using offline diagnosis rules\n", "AI服务不可用（%v），使用离线诊断规则\n")
 	message.SetString(language.SimplifiedChinese, "Would you like to use AI diagnosis? (y/n): ", "是否使用AI诊断？(y/n): ")
-	message.SetString(language.SimplifiedChinese, "Qwen API Key is required for AI diagnosis. Please set it in config.\n", "AI诊断需要 Qwen API Key，请在配置文件中设置。\n")
 	message.SetString(language.SimplifiedChinese, "AI diagnosis on backup failure: on/off. If not set, prompt interactively.", "备份失败时AI诊断：on/off。不设置则交互式询问。")
 	message.SetString(language.SimplifiedChinese, "AI_DIAG_PROMPT", "你是MySQL备份专家。请根据提供的日志错误信息，给出简洁、明确的中文修复建议。输出内容应适合在命令行中展示，避免使用Markdown格式，使用清晰的文本结构。\n\n示例输出格式：\n错误: [错误关键词]\n原因: [简要分析原因]\n修复: [具体修复步骤]")
 	message.SetString(language.SimplifiedChinese, "AI_DIAG_PROMPT_BACKUP", "你是MySQL备份专家，专注于xtrabackup备份操作。请根据提供的日志错误信息，给出简洁、明确的中文修复建议。重点关注xtrabackup备份失败、MySQL连接问题、锁超时和备份相关错误。输出内容应适合在命令行中展示，避免使用Markdown格式，使用清晰的文本结构。\n\n示例输出格式：\n错误: [错误关键词]\n原因: [简要分析原因]\n修复: [具体修复步骤]")